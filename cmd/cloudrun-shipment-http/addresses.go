@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ValidateAddress validates and normalizes the address in the request body. An address
+// that can't be delivered to is not an error, the response lists its problems.
+func ValidateAddress(ctx *fasthttp.RequestCtx) {
+	// Continue the trace of the client that sent the request
+	tctx, span := tracing.StartKind(tracing.Extract(ctx, requestTraceContext(ctx)), "POST /addresses/validate", trace.SpanKindServer)
+
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	// Unmarshal the address to a struct
+	var a address.Address
+	if err = json.Unmarshal(ctx.Request.Body(), &a); err != nil {
+		err = fmt.Errorf("invalid address: %s", err.Error())
+		ErrorHandler(ctx, "ValidateAddress", "Unmarshal", err)
+		return
+	}
+
+	// Validate the address with the configured provider
+	res, err := addresses.Validate(tctx, a)
+	if err != nil {
+		ServerErrorHandler(ctx, "ValidateAddress", "Validate", err)
+		return
+	}
+
+	writeJSON(ctx, http.StatusOK, res)
+}
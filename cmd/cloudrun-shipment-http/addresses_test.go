@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+)
+
+func TestValidateAddress(t *testing.T) {
+	s := newTestService(t, pastClock{}, 10)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantValid  bool
+		wantZip    string
+	}{
+		{
+			name:       "normalized",
+			body:       `{"street":"1 main street","city":"palo alto","zip":"943011234","state":"California","country":"usa"}`,
+			wantStatus: http.StatusOK,
+			wantValid:  true,
+			wantZip:    "94301-1234",
+		},
+		{
+			name:       "invalid postal code",
+			body:       `{"street":"Damrak 1","city":"Amsterdam","zip":"0123 AB","country":"NL"}`,
+			wantStatus: http.StatusOK,
+			wantZip:    "0123 AB",
+		},
+		{name: "invalid JSON", body: `{"street":`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := s.do(t, http.MethodPost, "/addresses/validate", tt.body, nil)
+			if res.StatusCode() != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.StatusCode(), tt.wantStatus, res.Body())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got address.Result
+			if err := json.Unmarshal(res.Body(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Valid != tt.wantValid || got.Address.Zip != tt.wantZip {
+				t.Errorf("got valid %v and zip %s, want valid %v and zip %s", got.Valid, got.Address.Zip, tt.wantValid, tt.wantZip)
+			}
+			if !got.Valid && len(got.Problems) == 0 {
+				t.Error("got an invalid address without problems")
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	sentryfasthttp "github.com/getsentry/sentry-go/fasthttp"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// cancelRequest is the optional body of a request to cancel a shipment.
+type cancelRequest struct {
+	// Reason is why the shipment is cancelled.
+	Reason string `json:"reason"`
+}
+
+// CancelShipment cancels the shipment with the tracking number in the path, when the carrier
+// hasn't picked it up yet, and returns the ShipmentCancelled event, which is sent to the
+// order service too. A shipment that was picked up or cancelled before is a conflict.
+func CancelShipment(ctx *fasthttp.RequestCtx) {
+	trackingNumber, _ := ctx.UserValue("trackingNumber").(string)
+
+	// Continue the trace of the client that sent the request
+	tctx, span := tracing.StartKind(tracing.Extract(ctx, requestTraceContext(ctx)), "POST /ship/{trackingNumber}/cancel", trace.SpanKindServer)
+	defer span.End()
+
+	var req cancelRequest
+	if len(ctx.Request.Body()) > 0 {
+		if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+			ErrorHandler(ctx, "CancelShipment", "Unmarshal", fmt.Errorf("invalid cancel request: %s", err.Error()))
+			return
+		}
+	}
+
+	// Lock the shipment until the cancelled shipment is stored, so a delivery or scan at
+	// the same time doesn't overwrite it
+	defer lockShipment(trackingNumber)()
+	s, err := shipments.Get(trackingNumber)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.SetBodyString(err.Error())
+		return
+	default:
+		ServerErrorHandler(ctx, "CancelShipment", "Get", err)
+		return
+	}
+	tctx = workflow.WithShipment(tctx, s)
+
+	if err := shipper.ValidateCancellation(s.OrderNumber, trackingNumber, req.Reason); err != nil {
+		ErrorHandler(ctx, "CancelShipment", "Validate", fmt.Errorf("invalid cancel request: %s", err.Error()))
+		return
+	}
+
+	// Cancel the pickup with the carrier
+	cause := correlation.FromHeaders(func(key string) string {
+		return string(ctx.Request.Header.Peek(key))
+	})
+	s, evt, err := wf.Cancel(tctx, s, req.Reason, cause)
+	switch err {
+	case nil:
+	case shipper.ErrPickedUp, shipper.ErrCancelled:
+		ctx.SetStatusCode(http.StatusConflict)
+		ctx.SetBodyString(err.Error())
+		return
+	default:
+		ServerErrorHandler(ctx, "CancelShipment", "Cancel", err)
+		return
+	}
+
+	// Remove the delivery from the queue and store the cancelled shipment
+	if !deliveries.Cancel(trackingNumber) {
+		slog.WarnContext(tctx, "cancelled shipment had no queued delivery")
+	}
+
+	orderEvt, complete, err := saveShipment(tctx, s)
+	if err != nil {
+		ServerErrorHandler(ctx, "CancelShipment", "Save", err)
+		return
+	}
+
+	// Tell the order service the shipment is cancelled. The event is in the response too,
+	// so the response doesn't fail when it can't be sent.
+	events := []workflow.Event{evt}
+	if complete {
+		events = append(events, orderEvt)
+	}
+	if err := wf.Emit(tctx, events...); err != nil {
+		if hub := sentryfasthttp.GetHubFromContext(ctx); hub != nil {
+			hub.CaptureException(fmt.Errorf("error sending cancellation of shipment %s: %s", trackingNumber, err.Error()))
+		}
+	}
+
+	payload, err := evt.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "CancelShipment", "Marshal", err)
+		return
+	}
+
+	// Tell the client which event was created and how it is correlated
+	for k, v := range evt.IDs.Headers() {
+		ctx.Response.Header.Set(k, v)
+	}
+
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+)
+
+func TestCancelShipment(t *testing.T) {
+	// Shipments on the real clock aren't picked up during the test
+	s := newTestService(t, clock.Real{}, 10)
+
+	res := s.do(t, http.MethodPost, "/ship", shipmentRequested, map[string]string{correlation.CorrelationIDHeader: "correlation-1"})
+	sent, err := acmeserverless.UnmarshalShipmentSent(res.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/ship/" + sent.Data.TrackingNumber + "/cancel"
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"invalid body", path, `{"reason":`, http.StatusBadRequest},
+		{"unknown shipment", "/ship/unknown/cancel", "", http.StatusNotFound},
+		{"cancel", path, `{"reason":"customer request"}`, http.StatusOK},
+		{"cancelled before", path, "", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		res := s.do(t, http.MethodPost, tt.path, tt.body, nil)
+		if res.StatusCode() != tt.wantStatus {
+			t.Fatalf("%s: got status %d, want %d: %s", tt.name, res.StatusCode(), tt.wantStatus, res.Body())
+		}
+	}
+
+	// The cancellation is sent to the order service and the delivery is no longer queued
+	events := s.rec.Records()
+	if len(events) != 1 || events[0].Metadata.Type != workflow.ShipmentCancelledEventName || events[0].IDs.CorrelationID != "correlation-1" {
+		t.Fatalf("got events %+v, want a single ShipmentCancelled event with correlation-1", events)
+	}
+	if n := deliveries.Pending(); n != 0 {
+		t.Errorf("got %d pending deliveries, want none", n)
+	}
+
+	stored, err := shipments.Get(sent.Data.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != shipper.StatusCancelled || stored.CancelReason != "customer request" {
+		t.Errorf("got stored shipment with status %q and reason %q, want it cancelled for the customer", stored.Status, stored.CancelReason)
+	}
+}
+
+func TestCancelShipmentPickedUp(t *testing.T) {
+	s := newTestService(t, pastClock{}, 10)
+
+	res := s.do(t, http.MethodPost, "/ship", shipmentRequested, nil)
+	sent, err := acmeserverless.UnmarshalShipmentSent(res.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait until the shipment is delivered and stored
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if abandoned := deliveries.Shutdown(ctx); len(abandoned) > 0 {
+		t.Fatalf("got abandoned deliveries %+v, want none", abandoned)
+	}
+
+	res = s.do(t, http.MethodPost, "/ship/"+sent.Data.TrackingNumber+"/cancel", "", nil)
+	if res.StatusCode() != http.StatusConflict {
+		t.Fatalf("got status %d, want %d: %s", res.StatusCode(), http.StatusConflict, res.Body())
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/valyala/fasthttp"
+)
+
+func FuzzDecodeRequest(f *testing.F) {
+	f.Add([]byte(shipmentRequested), "request-1", "correlation-1")
+	f.Add([]byte(`{"metadata":{},"data":{"_id":"12345","delivery":"UPS/FedEx"}}`), "", "")
+	f.Add([]byte(`{"metadata":{"type":"ShipmentSent"},"data":{"_id":"1","delivery":"UPS"}}`), "", "")
+	f.Add([]byte(`{}`), "", "")
+	f.Add([]byte(`null`), "", "")
+	f.Add([]byte(``), "", "")
+
+	f.Fuzz(func(t *testing.T, body []byte, eventID string, correlationID string) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetBody(body)
+		ctx.Request.Header.Set(correlation.EventIDHeader, eventID)
+		ctx.Request.Header.Set(correlation.CorrelationIDHeader, correlationID)
+
+		req, cause, err := decodeRequest(ctx)
+		if err != nil {
+			return
+		}
+
+		if err := shipper.Validate(req.Data.ShipmentRequest); err != nil {
+			t.Fatalf("decoded request %+v is invalid: %s", req.Data, err.Error())
+		}
+		if cause.EventID == "" {
+			t.Fatal("request has no cause")
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/emitter"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// checkTimeout is the maximum time a single readiness check can take.
+	checkTimeout = 2 * time.Second
+)
+
+// commit is the commit the binary was built from, set at build time using
+// -ldflags "-X main.commit=<commit>".
+var commit = "unknown"
+
+// readiness is the response of the readiness route.
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// buildInfo is the response of the version route.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"goVersion"`
+	Emitter   string `json:"emitter"`
+}
+
+// HealthHandler reports that the server is alive.
+func HealthHandler(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.SetBodyString("ok")
+}
+
+// ReadyHandler reports whether the service can accept shipments, by checking that
+// the emitter can be reached, the store can be used and the delivery queue isn't
+// saturated.
+func ReadyHandler(ctx *fasthttp.RequestCtx) {
+	c, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	res := readiness{
+		Status: "ready",
+		Checks: map[string]string{
+			"emitter":    checkResult(checkEmitter(c, em)),
+			"store":      checkResult(checkStore(c, shipments)),
+			"deliveries": checkResult(checkDeliveries()),
+		},
+	}
+
+	status := http.StatusOK
+	for _, result := range res.Checks {
+		if result != "ok" {
+			res.Status = "not ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(ctx, status, res)
+}
+
+// VersionHandler reports the version of the service and how it was built.
+func VersionHandler(ctx *fasthttp.RequestCtx) {
+	writeJSON(ctx, http.StatusOK, buildInfo{
+		Version:   cfg.Version,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		Emitter:   cfg.Emitter,
+	})
+}
+
+// checkEmitter checks the emitter, if it supports checks.
+func checkEmitter(ctx context.Context, e emitter.EventEmitter) error {
+	if c, ok := e.(emitter.Checker); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+// checkStore checks the store, if it supports checks.
+func checkStore(ctx context.Context, s store.Store) error {
+	if c, ok := s.(store.Checker); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+// checkDeliveries checks that the service isn't shutting down and that the
+// delivery queue has room for new shipments.
+func checkDeliveries() error {
+	if deliveries.Closed() {
+		return fmt.Errorf("shutting down")
+	}
+
+	stats := deliveries.Stats()
+	if stats.Saturation() >= cfg.ReadyMaxSaturation {
+		return fmt.Errorf("delivery queue saturated (%d/%d)", stats.Depth(), stats.QueueSize)
+	}
+
+	return nil
+}
+
+// checkResult converts the result of a check to the text in the response.
+func checkResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// writeJSON writes the value as JSON response with the status code.
+func writeJSON(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		ServerErrorHandler(ctx, "writeJSON", "Marshal", err)
+		return
+	}
+
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(status)
+	ctx.Write(b)
+}
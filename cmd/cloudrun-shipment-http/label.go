@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/retgits/acme-serverless-shipment/internal/label"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/valyala/fasthttp"
+)
+
+// ShipmentLabel returns the shipping label of the shipment with the tracking number in
+// the path, in the format of the format query parameter, which is pdf by default.
+func ShipmentLabel(ctx *fasthttp.RequestCtx) {
+	trackingNumber, _ := ctx.UserValue("trackingNumber").(string)
+
+	format := string(ctx.QueryArgs().Peek("format"))
+	if format == "" {
+		format = label.FormatPDF
+	}
+	if format != label.FormatPDF && format != label.FormatZPL {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf("unknown label format %q, use %s or %s", format, label.FormatPDF, label.FormatZPL))
+		return
+	}
+
+	s, err := shipments.Get(trackingNumber)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.SetBodyString(err.Error())
+		return
+	default:
+		ServerErrorHandler(ctx, "ShipmentLabel", "Get", err)
+		return
+	}
+
+	b, err := label.Render(label.For(s, warehouses), format)
+	if err != nil {
+		ServerErrorHandler(ctx, "ShipmentLabel", "Render", err)
+		return
+	}
+
+	ctx.SetContentType(label.ContentType(format))
+	ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "label-"+trackingNumber+"."+format))
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(b)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+)
+
+func TestShipmentLabel(t *testing.T) {
+	s := newTestService(t, clock.Real{}, 10)
+
+	res := s.do(t, http.MethodPost, "/ship", shipmentRequested, nil)
+	sent, err := acmeserverless.UnmarshalShipmentSent(res.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		path            string
+		wantStatus      int
+		wantContentType string
+		wantPrefix      string
+	}{
+		{"default format", "/ship/" + sent.Data.TrackingNumber + "/label", http.StatusOK, "application/pdf", "%PDF-"},
+		{"pdf", "/ship/" + sent.Data.TrackingNumber + "/label?format=pdf", http.StatusOK, "application/pdf", "%PDF-"},
+		{"zpl", "/ship/" + sent.Data.TrackingNumber + "/label?format=zpl", http.StatusOK, "application/zpl", "^XA"},
+		{"unknown format", "/ship/" + sent.Data.TrackingNumber + "/label?format=png", http.StatusBadRequest, "", ""},
+		{"unknown shipment", "/ship/unknown/label", http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := s.do(t, http.MethodGet, tt.path, "", nil)
+			if res.StatusCode() != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.StatusCode(), tt.wantStatus, res.Body())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			if got := string(res.Header.ContentType()); got != tt.wantContentType {
+				t.Errorf("got content type %s, want %s", got, tt.wantContentType)
+			}
+			if !bytes.HasPrefix(res.Body(), []byte(tt.wantPrefix)) || !bytes.Contains(res.Body(), []byte(sent.Data.TrackingNumber)) {
+				t.Errorf("got a label without %s and the tracking number: %.40q", tt.wantPrefix, res.Body())
+			}
+		})
+	}
+}
@@ -1,15 +1,35 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/fasthttp/router"
 	"github.com/getsentry/sentry-go"
 	sentryfasthttp "github.com/getsentry/sentry-go/fasthttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/delivery"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	prommetrics "github.com/retgits/acme-serverless-shipment/internal/metrics/prometheus"
+	"github.com/retgits/acme-serverless-shipment/internal/ratetable"
+	"github.com/retgits/acme-serverless-shipment/internal/redact"
+	"github.com/retgits/acme-serverless-shipment/internal/setup"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/tracking"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
 	gcrwavefront "github.com/retgits/gcr-wavefront"
 	"github.com/valyala/fasthttp"
 )
@@ -18,6 +38,46 @@ const (
 	servicename = "shipment"
 )
 
+var (
+	// cfg is the configuration of the service, loaded once at startup.
+	cfg *config.Config
+
+	// shipments keeps track of all shipments the service created.
+	shipments store.Store
+
+	// deliveries waits for the delivery of shipments in the background.
+	deliveries *delivery.Scheduler
+
+	// em is the EventEmitter the delivered shipments are sent to.
+	em emitter.EventEmitter
+
+	// wf is the shipment workflow, which sends the delivered shipments.
+	wf *workflow.Workflow
+
+	// quoter quotes the price of shipping a package with every carrier.
+	quoter *shipper.Quoter
+
+	// addresses validates and normalizes the addresses shipments are delivered to.
+	addresses address.Provider
+
+	// warehouses are the warehouses orders are shipped from.
+	warehouses []warehouse.Warehouse
+
+	// partsMu makes sure only one delivery or cancellation at a time checks whether the
+	// parts of an order that was split are delivered.
+	partsMu sync.Mutex
+
+	// webhookSecrets are the secrets the carriers sign their tracking webhooks with, by carrier.
+	webhookSecrets map[string]string
+
+	// locksMu guards locks, which has a lock for every shipment that is being changed.
+	locksMu sync.Mutex
+	locks   = make(map[string]*shipmentLock)
+
+	// red removes personally identifiable information from logs and Sentry.
+	red *redact.Redactor
+)
+
 // CORSHandler sets CORS headers for the preflight request
 func CORSHandler(ctx *fasthttp.RequestCtx) {
 	ctx.Response.Header.Add("Access-Control-Allow-Credentials", "true")
@@ -30,56 +90,171 @@ func CORSHandler(ctx *fasthttp.RequestCtx) {
 
 // ErrorHandler takes the activity where the error occured and the error object and sends a message to sentry.
 func ErrorHandler(ctx *fasthttp.RequestCtx, function string, method string, err error) {
+	slog.Error("error handling request", "function", function, "method", method, logging.Err(err))
 	sentry.CaptureException(fmt.Errorf("error in %s::%s %s", function, method, err.Error()))
 	ctx.SetStatusCode(http.StatusBadRequest)
 	ctx.SetBodyString(err.Error())
 }
 
+// ServerErrorHandler is like ErrorHandler, but for errors that are not caused by the request.
+func ServerErrorHandler(ctx *fasthttp.RequestCtx, function string, method string, err error) {
+	ErrorHandler(ctx, function, method, err)
+	ctx.SetStatusCode(http.StatusInternalServerError)
+}
+
+// shipmentLock is the lock of a shipment and the number of callers that hold or wait for it.
+type shipmentLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lockShipment locks the shipment with the tracking number and returns the function that
+// unlocks it. Every delivery, scan, cancellation and return that loads, changes and stores
+// a shipment holds its lock, so they don't overwrite each other's changes. The lock of a
+// shipment is taken before partsMu.
+func lockShipment(trackingNumber string) (unlock func()) {
+	locksMu.Lock()
+	l, ok := locks[trackingNumber]
+	if !ok {
+		l = &shipmentLock{}
+		locks[trackingNumber] = l
+	}
+	l.refs++
+	locksMu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		locksMu.Lock()
+		defer locksMu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(locks, trackingNumber)
+		}
+	}
+}
+
+// resumeDeliveries schedules the delivery of every stored shipment that hasn't been
+// delivered or cancelled yet, like shipments that were outstanding when the service
+// last stopped. Shipments that don't fit in the queue are skipped, so the service
+// still starts, and are resumed when it starts again with room in the queue.
+func resumeDeliveries() error {
+	list, err := shipments.List()
+	if err != nil {
+		return err
+	}
+
+	resumed, skipped := 0, 0
+	for _, s := range list {
+		if shipper.Done(s) {
+			continue
+		}
+
+		switch err := deliveries.Schedule(s); err {
+		case nil:
+			resumed++
+		case delivery.ErrQueueFull:
+			slog.WarnContext(workflow.WithShipment(context.Background(), s), "delivery queue is full, not resuming delivery")
+			skipped++
+		default:
+			return err
+		}
+	}
+
+	if resumed > 0 {
+		slog.Info("resumed outstanding deliveries", "count", resumed)
+	}
+	if skipped > 0 {
+		slog.Warn("outstanding deliveries not resumed, the delivery queue is full", "count", skipped, "queueSize", deliveries.Stats().QueueSize)
+	}
+
+	return nil
+}
+
+// shutdown stops the server and waits, for at most the configured grace period,
+// for the outstanding deliveries. Deliveries that didn't complete are reported
+// as abandoned.
+func shutdown(server *fasthttp.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownGracePeriod)
+	defer cancel()
+
+	// Stop accepting new connections while the deliveries drain
+	go func() {
+		if err := server.Shutdown(); err != nil {
+			slog.Error("error shutting down server", logging.Err(err))
+		}
+	}()
+
+	pending := deliveries.Pending()
+	abandoned := deliveries.Shutdown(ctx)
+
+	slog.Info("drained outstanding deliveries", "drained", pending-len(abandoned), "pending", pending)
+
+	if len(abandoned) == 0 {
+		return
+	}
+
+	for _, s := range abandoned {
+		slog.WarnContext(workflow.WithShipment(ctx, s), "abandoned delivery")
+	}
+
+	if cfg.Store == "memory" {
+		slog.Warn("deliveries abandoned and lost, use the file store to resume them after a restart", "count", len(abandoned))
+		return
+	}
+
+	slog.Warn("deliveries abandoned, they will be resumed from the store after a restart", "count", len(abandoned), "store", cfg.Store)
+}
+
 func main() {
-	// Get the version or set a default to "dev"
-	version := os.Getenv("VERSION")
-	if version == "" {
-		version = "dev"
+	// Load the configuration and make sure all values the emitter needs are set
+	var err error
+	cfg, err = config.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Get the Wavefront server URL or set it to debug
-	wfServer := os.Getenv("WAVEFRONT_URL")
-	if wfServer == "" {
-		wfServer = gcrwavefront.DebugServerName
+	required, err := setup.EmitterRequires(cfg.Emitter)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Get the server port or set it to 8080
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if err := cfg.Validate("cloudrun-shipment-http", required...); err != nil {
+		log.Fatal(err)
 	}
 
-	// Get the service name
-	service := os.Getenv("K_SERVICE")
-	if service == "" {
-		service = servicename
+	// Configure the logger, every log record is written as JSON to Cloud Logging
+	// without the personally identifiable information of customers
+	red, err = setup.NewLogger(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Get the Wavefront server URL or set it to debug
+	wfServer := cfg.WavefrontURL
+	if wfServer == "" {
+		wfServer = gcrwavefront.DebugServerName
 	}
 
 	// Initialize a connection to Sentry to capture errors and traces
-	if err := sentry.Init(sentry.ClientOptions{
-		Dsn: os.Getenv("SENTRY_DSN"),
-		Transport: &sentry.HTTPSyncTransport{
-			Timeout: time.Second * 3,
-		},
-		ServerName:  service,
-		Release:     version,
-		Environment: os.Getenv("STAGE"),
-	}); err != nil {
-		log.Fatalf("error configuring sentry: %s", err.Error())
+	if err := setup.InitSentry(cfg, cfg.Service, red); err != nil {
+		logging.Fatal("error configuring sentry", logging.Err(err))
+	}
+
+	// Configure the exporter of the spans
+	tp, err := setup.NewTracing(cfg, cfg.Service)
+	if err != nil {
+		logging.Fatal("error configuring tracing", logging.Err(err))
 	}
 
 	// Create an instance of sentryfasthttp
 	sentryHandler := sentryfasthttp.New(sentryfasthttp.Options{})
 
 	// Configure the Wavefront wrapper
-	cfg := gcrwavefront.WavefrontConfig{
+	wfCfg := gcrwavefront.WavefrontConfig{
 		Server:        wfServer,
-		Token:         os.Getenv("WAVEFRONT_TOKEN"),
+		Token:         cfg.WavefrontToken,
 		BatchSize:     10000,
 		MaxBufferSize: 50000,
 		FlushInterval: 1,
@@ -88,19 +263,146 @@ func main() {
 		PointTags:     make(map[string]string),
 	}
 
-	if err := cfg.ConfigureSender(); err != nil {
-		log.Fatalf("error configuring wavefront: %s", err.Error())
+	if err := wfCfg.ConfigureSender(); err != nil {
+		logging.Fatal("error configuring wavefront", logging.Err(err))
 	}
 
+	// Configure the metrics of the shipment workflow, for both Prometheus and Wavefront
+	registry := prometheus.NewRegistry()
+	rec, closeRecorder, err := newRecorder(wfServer, registry)
+	if err != nil {
+		logging.Fatal("error configuring metrics", logging.Err(err))
+	}
+	defer closeRecorder()
+
 	// Wrap the sentryHandler with the Wavefront middleware to make sure all events
 	// are sent to sentry before sending data to Wavefront
 	router := router.New()
 	router.GlobalOPTIONS = CORSHandler
 
 	// Add routes to the router
-	router.POST("/ship", cfg.WrapFastHTTPRequest(sentryHandler.Handle(SendShipment)))
+	router.POST("/ship", wfCfg.WrapFastHTTPRequest(sentryHandler.Handle(SendShipment)))
+	router.GET("/ship/{trackingNumber}", wfCfg.WrapFastHTTPRequest(sentryHandler.Handle(TrackShipment)))
+	router.GET("/ship/{trackingNumber}/label", wfCfg.WrapFastHTTPRequest(sentryHandler.Handle(ShipmentLabel)))
+	router.POST("/ship/{trackingNumber}/cancel", wfCfg.WrapFastHTTPRequest(sentryHandler.Handle(CancelShipment)))
+	router.POST("/ship/{trackingNumber}/returns", wfCfg.WrapFastHTTPRequest(sentryHandler.Handle(RequestReturn)))
+	router.POST("/webhooks/carriers/{carrier}", wfCfg.WrapFastHTTPRequest(sentryHandler.Handle(CarrierWebhook)))
+	router.POST("/rates", wfCfg.WrapFastHTTPRequest(sentryHandler.Handle(QuoteRates)))
+	router.POST("/addresses/validate", wfCfg.WrapFastHTTPRequest(sentryHandler.Handle(ValidateAddress)))
+
+	// Add the health and metrics routes, which are not part of the request metrics
+	router.GET("/healthz", HealthHandler)
+	router.GET("/readyz", ReadyHandler)
+	router.GET("/version", VersionHandler)
+	router.GET("/metrics", prommetrics.Handler(registry))
+
+	// Create the store and resume the deliveries that were outstanding
+	shipments, err = setup.NewStore(cfg)
+	if err != nil {
+		logging.Fatal("error configuring store", logging.Err(err))
+	}
+
+	em, err = setup.NewEmitter(cfg, &http.Client{
+		Timeout: cfg.OrderTimeout,
+	})
+	if err != nil {
+		logging.Fatal("error configuring emitter", "emitter", cfg.Emitter, logging.Err(err))
+	}
+
+	// Validate the addresses, ship orders from the configured warehouses and cancel
+	// pickups with the configured carrier adapter
+	wf, err = setup.NewWorkflow(cfg, em, cfg.Emitter, rec)
+	if err != nil {
+		logging.Fatal("error configuring workflow", logging.Err(err))
+	}
+	addresses = wf.Addresses()
+	warehouses = wf.Warehouses()
+
+	// Accept the tracking webhooks of the carriers that have a secret
+	webhookSecrets, err = setup.NewWebhookSecrets(cfg)
+	if err != nil {
+		logging.Fatal("error configuring carrier webhooks", logging.Err(err))
+	}
+
+	// Poll the tracking APIs of the carriers that don't send webhooks
+	trackers, err := setup.NewTrackers(cfg, &http.Client{
+		Timeout: cfg.OrderTimeout,
+	})
+	if err != nil {
+		logging.Fatal("error configuring carrier tracking", logging.Err(err))
+	}
+
+	// Create the carriers that quote rates, and reload their rate tables when they change
+	var rateTables *ratetable.Reloader
+	quoter, rateTables, err = setup.NewQuoter(cfg)
+	if err != nil {
+		logging.Fatal("error configuring rate tables", logging.Err(err))
+	}
+
+	reloadCtx, stopReloading := context.WithCancel(context.Background())
+	defer stopReloading()
+	if rateTables != nil && cfg.RateTablesReloadInterval > 0 {
+		go rateTables.Watch(reloadCtx, cfg.RateTablesReloadInterval)
+	}
+
+	deliveries = delivery.New(handleDelivery, delivery.Options{
+		Workers:         cfg.DeliveryWorkers,
+		QueueSize:       cfg.DeliveryQueueSize,
+		RetryBackoff:    cfg.DeliveryRetryBackoff,
+		MaxRetryBackoff: cfg.DeliveryMaxRetryBackoff,
+	})
+	if err := resumeDeliveries(); err != nil {
+		logging.Fatal("error resuming deliveries", logging.Err(err))
+	}
+
+	// Ask the carriers for the scans of the shipments in flight
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	defer stopPolling()
+	if len(trackers) > 0 {
+		poller := tracking.New(trackers, shipments, pollShipment, tracking.Options{
+			MinInterval: cfg.TrackingPollInterval,
+			MaxInterval: cfg.TrackingMaxInterval,
+			Rate:        cfg.TrackingRate,
+			MaxBackoff:  cfg.TrackingMaxBackoff,
+		})
+		go poller.Run(pollCtx, cfg.TrackingPollInterval)
+	}
+
+	// Report the state of the delivery queue
+	stopReporting := make(chan struct{})
+	go reportDeliveryStats(rec, stopReporting)
+
+	server := &fasthttp.Server{
+		Handler: router.Handler,
+		Name:    servicename,
+	}
 
 	// Start the server
-	log.Printf("successfully started %s server", servicename)
-	log.Fatal(fasthttp.ListenAndServe(fmt.Sprintf(":%s", port), router.Handler))
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe(fmt.Sprintf(":%d", cfg.Port))
+	}()
+	slog.Info("successfully started server", "service", servicename, "port", cfg.Port)
+
+	// Wait for Cloud Run (SIGTERM) or the user (SIGINT) to stop the service
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	select {
+	case err := <-errs:
+		logging.Fatal("error running server", logging.Err(err))
+	case sig := <-signals:
+		slog.Info("shutting down server", "signal", sig.String(), "service", servicename)
+		close(stopReporting)
+		stopReloading()
+		stopPolling()
+		shutdown(server)
+	}
+
+	// Export the spans of the drained deliveries
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tp.Shutdown(ctx); err != nil {
+		slog.Error("error exporting spans", logging.Err(err))
+	}
 }
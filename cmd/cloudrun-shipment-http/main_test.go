@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+func TestResumeDeliveries(t *testing.T) {
+	newTestService(t, pastClock{}, 2)
+
+	// More outstanding shipments than fit in the queue, and one that is done
+	for i, status := range []string{shipper.StatusShipped, shipper.StatusShipped, shipper.StatusShipped, shipper.StatusDelivered} {
+		s := shipper.Shipment{Carrier: "UPS", DeliverAt: time.Now().Add(time.Hour)}
+		s.TrackingNumber = shipper.NewTrackingNumber("UPS")
+		s.OrderNumber = "order-" + string(rune('1'+i))
+		s.Status = status
+		if err := shipments.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The service starts with the deliveries that fit in the queue
+	if err := resumeDeliveries(); err != nil {
+		t.Fatalf("got error %v, want the deliveries that don't fit skipped", err)
+	}
+	if got := deliveries.Pending(); got != 2 {
+		t.Errorf("got %d pending deliveries, want 2", got)
+	}
+}
+
+func TestLockShipment(t *testing.T) {
+	unlock := lockShipment("1Z999AA10123456784")
+
+	// Another shipment can be locked while the first one is
+	lockShipment("1Z999AA10123456785")()
+
+	// A second lock of the same shipment waits until the first one is released
+	var locked int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer lockShipment("1Z999AA10123456784")()
+		atomic.StoreInt32(&locked, 1)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&locked) != 0 {
+		t.Fatal("got the shipment locked twice, want the second lock to wait")
+	}
+
+	unlock()
+	<-done
+
+	// The locks are removed when nobody holds or waits for them
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if len(locks) != 0 {
+		t.Errorf("got %d locks left, want none", len(locks))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/retgits/acme-serverless-shipment/internal/metrics"
+	prommetrics "github.com/retgits/acme-serverless-shipment/internal/metrics/prometheus"
+	wfmetrics "github.com/retgits/acme-serverless-shipment/internal/metrics/wavefront"
+	gcrwavefront "github.com/retgits/gcr-wavefront"
+	wavefront "github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+// newRecorder creates the Recorder for the metrics of the shipment workflow, which
+// records every metric in the Prometheus registry and sends it to Wavefront. When the
+// Wavefront server is set to debug, the metrics are printed to the log instead.
+func newRecorder(server string, reg *prometheus.Registry) (metrics.Recorder, func(), error) {
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	prom, err := prommetrics.New(reg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error registering prometheus metrics: %s", err.Error())
+	}
+
+	var sender wavefront.Sender
+	closeSender := func() {}
+	if server != gcrwavefront.DebugServerName {
+		sender, err = wavefront.NewDirectSender(&wavefront.DirectConfiguration{
+			Server:               server,
+			Token:                cfg.WavefrontToken,
+			BatchSize:            10000,
+			MaxBufferSize:        50000,
+			FlushIntervalSeconds: 1,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("error configuring wavefront: %s", err.Error())
+		}
+		closeSender = sender.Close
+	}
+
+	wf := wfmetrics.New(sender, fmt.Sprintf("acmeserverless.gcr.%s", servicename), "acmeserverless")
+
+	return metrics.Multi(prom, wf), closeSender, nil
+}
+
+// reportDeliveryStats records the depth of the delivery queue and the utilization of
+// the delivery workers every MetricsInterval, until stop is closed.
+func reportDeliveryStats(rec metrics.Recorder, stop <-chan struct{}) {
+	ticker := time.NewTicker(cfg.MetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			stats := deliveries.Stats()
+			rec.DeliveryQueue(stats.Depth(), stats.QueueSize, stats.Busy, stats.Workers)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// rates is the response of QuoteRates.
+type rates struct {
+	Quotes []shipper.Quote `json:"quotes"`
+}
+
+// QuoteRates returns the quotes of every registered carrier for the package in the
+// request body, from cheapest to most expensive.
+func QuoteRates(ctx *fasthttp.RequestCtx) {
+	// Continue the trace of the client that sent the request
+	tctx, span := tracing.StartKind(tracing.Extract(ctx, requestTraceContext(ctx)), "POST /rates", trace.SpanKindServer)
+
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	// Unmarshal the rate request to a struct
+	var req shipper.RateRequest
+	if err = json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		err = fmt.Errorf("invalid rate request: %s", err.Error())
+		ErrorHandler(ctx, "QuoteRates", "Unmarshal", err)
+		return
+	}
+
+	// Ask every carrier for a quote
+	quotes, err := quoter.Quote(tctx, req, wf.Now())
+	if err != nil {
+		err = fmt.Errorf("invalid rate request: %s", err.Error())
+		ErrorHandler(ctx, "QuoteRates", "Quote", err)
+		return
+	}
+
+	writeJSON(ctx, http.StatusOK, rates{Quotes: quotes})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestQuoteRates(t *testing.T) {
+	s := newTestService(t, pastClock{}, 10)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantQuotes int
+	}{
+		{
+			name:       "all service levels",
+			body:       `{"origin":{"country":"US","postalCode":"94105"},"destination":{"country":"US","postalCode":"10001"},"package":{"weightKg":2.2,"lengthCm":30,"widthCm":20,"heightCm":10}}`,
+			wantStatus: http.StatusOK,
+			wantQuotes: 6,
+		},
+		{
+			name:       "one service level",
+			body:       `{"origin":{"country":"US"},"destination":{"country":"NL"},"package":{"weightKg":1},"serviceLevel":"overnight"}`,
+			wantStatus: http.StatusOK,
+			wantQuotes: 2,
+		},
+		{
+			name:       "no carrier ships ground abroad",
+			body:       `{"origin":{"country":"US"},"destination":{"country":"NL"},"package":{"weightKg":1},"serviceLevel":"ground"}`,
+			wantStatus: http.StatusOK,
+		},
+		{name: "invalid JSON", body: `{"origin":`, wantStatus: http.StatusBadRequest},
+		{name: "no weight", body: `{"origin":{"country":"US"},"destination":{"country":"US"},"package":{}}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := s.do(t, http.MethodPost, "/rates", tt.body, nil)
+			if res.StatusCode() != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.StatusCode(), tt.wantStatus, res.Body())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got rates
+			if err := json.Unmarshal(res.Body(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Quotes) != tt.wantQuotes {
+				t.Fatalf("got %d quotes, want %d", len(got.Quotes), tt.wantQuotes)
+			}
+			for _, q := range got.Quotes {
+				if q.Price <= 0 || q.Currency == "" || !q.EstimatedDelivery.After(pastClock{}.Now().Add(-24*time.Hour)) {
+					t.Errorf("got incomplete quote %+v", q)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	sentryfasthttp "github.com/getsentry/sentry-go/fasthttp"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/delivery"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestReturn creates a return for the delivered shipment with the tracking number in the
+// path, with the reason and the items in the body, and responds with the return leg, which
+// has its own tracking number and label. The ReturnRequested event is sent to the other
+// services and the return leg is tracked like a shipment until it is received.
+func RequestReturn(ctx *fasthttp.RequestCtx) {
+	trackingNumber, _ := ctx.UserValue("trackingNumber").(string)
+
+	// Stop accepting new returns when the service is shutting down
+	if deliveries.Closed() {
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.SetBodyString(delivery.ErrClosed.Error())
+		return
+	}
+
+	// Continue the trace of the client that sent the request
+	tctx, span := tracing.StartKind(tracing.Extract(ctx, requestTraceContext(ctx)), "POST /ship/{trackingNumber}/returns", trace.SpanKindServer)
+	defer span.End()
+
+	var req shipper.ReturnRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		ErrorHandler(ctx, "RequestReturn", "Unmarshal", fmt.Errorf("invalid return: %s", err.Error()))
+		return
+	}
+
+	// Lock the shipment until the return is linked to it, so a scan or another return at
+	// the same time doesn't overwrite the link
+	defer lockShipment(trackingNumber)()
+	s, err := shipments.Get(trackingNumber)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.SetBodyString(err.Error())
+		return
+	default:
+		ServerErrorHandler(ctx, "RequestReturn", "Get", err)
+		return
+	}
+	tctx = workflow.WithShipment(tctx, s)
+
+	// Book the return leg with the carrier
+	cause := correlation.FromHeaders(func(key string) string {
+		return string(ctx.Request.Header.Peek(key))
+	})
+	leg, evt, err := wf.RequestReturn(tctx, s, req, cause)
+	var rerr *workflow.ReturnError
+	switch {
+	case err == nil:
+	case err == shipper.ErrNotDelivered:
+		ctx.SetStatusCode(http.StatusConflict)
+		ctx.SetBodyString(err.Error())
+		return
+	case errors.As(err, &rerr):
+		ErrorHandler(ctx, "RequestReturn", "Validate", err)
+		return
+	default:
+		ServerErrorHandler(ctx, "RequestReturn", "Return", err)
+		return
+	}
+
+	// Store the return leg and link it to the shipment, so its delivery can be resumed
+	// after a restart
+	if err := shipments.Save(leg); err != nil {
+		ServerErrorHandler(ctx, "RequestReturn", "Save", err)
+		return
+	}
+
+	linked := s
+	linked.Returns = append(append([]string(nil), s.Returns...), leg.TrackingNumber)
+	if err := shipments.Save(linked); err != nil {
+		deleteShipments(tctx, []shipper.Shipment{leg})
+		ServerErrorHandler(ctx, "RequestReturn", "Save", err)
+		return
+	}
+
+	// Queue the return leg, or tell the client to come back later when the queue is full.
+	// The return is removed again, so a retry of the client doesn't leave it behind.
+	if err := deliveries.Schedule(leg); err != nil {
+		deleteShipments(tctx, []shipper.Shipment{leg})
+		if _, serr := store.Update(shipments, trackingNumber, func(linked shipper.Shipment) (shipper.Shipment, error) {
+			var returns []string
+			for _, tn := range linked.Returns {
+				if tn != leg.TrackingNumber {
+					returns = append(returns, tn)
+				}
+			}
+			linked.Returns = returns
+			return linked, nil
+		}); serr != nil {
+			slog.ErrorContext(tctx, "error unlinking return of failed request", logging.Err(serr))
+		}
+
+		if err == delivery.ErrQueueFull {
+			slog.WarnContext(tctx, "delivery queue is full, rejecting return")
+			ctx.Response.Header.Set("Retry-After", strconv.Itoa(int(deliveries.RetryAfter().Seconds())))
+		}
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	// Tell the other services about the return. The return leg is in the response, so the
+	// response doesn't fail when the event can't be sent.
+	if err := wf.Emit(tctx, evt); err != nil {
+		if hub := sentryfasthttp.GetHubFromContext(ctx); hub != nil {
+			hub.CaptureException(fmt.Errorf("error sending return of shipment %s: %s", trackingNumber, err.Error()))
+		}
+	}
+
+	// Tell the client which event was created and how it is correlated
+	for k, v := range evt.IDs.Headers() {
+		ctx.Response.Header.Set(k, v)
+	}
+
+	ctx.Response.Header.Set("Location", "/ship/"+leg.TrackingNumber)
+	writeJSON(ctx, http.StatusCreated, leg)
+}
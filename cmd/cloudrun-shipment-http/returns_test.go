@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+)
+
+func TestRequestReturn(t *testing.T) {
+	s := newTestService(t, pastClock{}, 10)
+
+	delivered := shipper.Shipment{Carrier: "UPS", CorrelationID: "correlation-1", Items: []shipper.LineItem{{ID: "mat-1", Quantity: 2}}}
+	delivered.TrackingNumber = "1ZA1C3E50312345670"
+	delivered.OrderNumber = "order-1"
+	delivered.Status = shipper.StatusDelivered
+	shipped := delivered
+	shipped.TrackingNumber = "1ZA1C3E50312345689"
+	shipped.Status = shipper.StatusShipped
+	for _, sh := range []shipper.Shipment{delivered, shipped} {
+		if err := shipments.Save(sh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"invalid body", "/ship/" + delivered.TrackingNumber + "/returns", `{"reason":`, http.StatusBadRequest},
+		{"no reason", "/ship/" + delivered.TrackingNumber + "/returns", `{}`, http.StatusBadRequest},
+		{"item not shipped", "/ship/" + delivered.TrackingNumber + "/returns", `{"reason":"damaged","items":[{"id":"rack-1","quantity":1}]}`, http.StatusBadRequest},
+		{"unknown shipment", "/ship/unknown/returns", `{"reason":"damaged"}`, http.StatusNotFound},
+		{"not delivered", "/ship/" + shipped.TrackingNumber + "/returns", `{"reason":"damaged"}`, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		res := s.do(t, http.MethodPost, tt.path, tt.body, nil)
+		if res.StatusCode() != tt.wantStatus {
+			t.Fatalf("%s: got status %d, want %d: %s", tt.name, res.StatusCode(), tt.wantStatus, res.Body())
+		}
+	}
+
+	res := s.do(t, http.MethodPost, "/ship/"+delivered.TrackingNumber+"/returns", `{"reason":"damaged","items":[{"id":"mat-1","quantity":1}]}`, map[string]string{correlation.EventIDHeader: "request-1"})
+	if res.StatusCode() != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", res.StatusCode(), http.StatusCreated, res.Body())
+	}
+
+	var leg shipper.Shipment
+	if err := json.Unmarshal(res.Body(), &leg); err != nil {
+		t.Fatal(err)
+	}
+	if leg.Return == nil || leg.Return.Shipment != delivered.TrackingNumber || leg.Status != shipper.StatusReturnRequested {
+		t.Fatalf("got return leg %+v, want a requested return of %s", leg, delivered.TrackingNumber)
+	}
+	if got := string(res.Header.Peek("Location")); got != "/ship/"+leg.TrackingNumber {
+		t.Errorf("got location %q, want the return leg", got)
+	}
+
+	// The return leg is received in the background, and both events are sent
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, eventType := range []string{workflow.ReturnRequestedEventName, workflow.ReturnReceivedEventName} {
+		events, err := s.rec.WaitForType(ctx, eventType, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := events[0]; got.Data.TrackingNumber != leg.TrackingNumber || got.IDs.CausationID != "request-1" || got.IDs.CorrelationID != "correlation-1" {
+			t.Errorf("got %s event %+v, want %s caused by request-1", eventType, got, leg.TrackingNumber)
+		}
+	}
+
+	if abandoned := deliveries.Shutdown(ctx); len(abandoned) > 0 {
+		t.Fatalf("got abandoned deliveries %+v, want none", abandoned)
+	}
+
+	stored, err := shipments.Get(leg.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != shipper.StatusReturnReceived {
+		t.Errorf("got return leg with status %q, want %q", stored.Status, shipper.StatusReturnReceived)
+	}
+
+	original, err := shipments.Get(delivered.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(original.Returns) != 1 || original.Returns[0] != leg.TrackingNumber {
+		t.Errorf("got returns %v of the shipment, want %s", original.Returns, leg.TrackingNumber)
+	}
+}
+
+func TestRequestReturnQueueFull(t *testing.T) {
+	s := newTestService(t, clock.Real{}, 1)
+
+	delivered := shipper.Shipment{Carrier: "UPS"}
+	delivered.TrackingNumber = "1ZA1C3E50312345670"
+	delivered.OrderNumber = "order-1"
+	delivered.Status = shipper.StatusDelivered
+	if err := shipments.Save(delivered); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the queue with a delivery that isn't due during the test
+	queued := shipper.Shipment{DeliverAt: time.Now().Add(time.Hour)}
+	queued.TrackingNumber = "1ZA1C3E50312345689"
+	if err := deliveries.Schedule(queued); err != nil {
+		t.Fatal(err)
+	}
+
+	res := s.do(t, http.MethodPost, "/ship/"+delivered.TrackingNumber+"/returns", `{"reason":"damaged"}`, nil)
+	if res.StatusCode() != http.StatusServiceUnavailable || len(res.Header.Peek("Retry-After")) == 0 {
+		t.Fatalf("got status %d, want %d with Retry-After: %s", res.StatusCode(), http.StatusServiceUnavailable, res.Body())
+	}
+
+	// The rejected return isn't stored or linked to the shipment
+	list, err := shipments.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || len(list[0].Returns) != 0 {
+		t.Errorf("got stored shipments %+v, want only the delivered shipment without returns", list)
+	}
+}
@@ -1,56 +1,120 @@
 package main
 
 import (
-	"bytes"
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"os"
-	"time"
+	"strconv"
 
-	"github.com/getsentry/sentry-go"
-	acmeserverless "github.com/retgits/acme-serverless"
+	sentryfasthttp "github.com/getsentry/sentry-go/fasthttp"
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/delivery"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
 	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
 	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // SendShipment ...
 func SendShipment(ctx *fasthttp.RequestCtx) {
+	// Stop accepting new shipments when the service is shutting down
+	if deliveries.Closed() {
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.SetBodyString(delivery.ErrClosed.Error())
+		return
+	}
+
+	// Continue the trace of the client that sent the request
+	tctx, span := tracing.StartKind(tracing.Extract(ctx, requestTraceContext(ctx)), "POST /ship", trace.SpanKindServer)
+	defer span.End()
+
 	// Unmarshal the ShipmentRequested event to a struct
-	req, err := acmeserverless.UnmarshalShipmentRequested(ctx.Request.Body())
+	_, uspan := tracing.Start(tctx, "UnmarshalShipmentRequested")
+	req, cause, err := decodeRequest(ctx)
+	tracing.End(uspan, err)
 	if err != nil {
-		if err != nil {
-			ErrorHandler(ctx, "SendShipment", "UnmarshalShipmentRequested", err)
-			return
-		}
+		ErrorHandler(ctx, "SendShipment", "UnmarshalShipmentRequested", err)
+		return
 	}
 
-	// Send a breadcrumb to Sentry with the shipment request
-	sentry.AddBreadcrumb(&sentry.Breadcrumb{
-		Category:  acmeserverless.ShipmentRequestedEventName,
-		Timestamp: time.Now(),
-		Level:     sentry.LevelInfo,
-		Data:      acmeserverless.ToSentryMap(req.Data),
-	})
+	// Record the shipment request
+	wf.Requested(req)
 
-	// Send the shipment data
-	shipmentData := shipper.Sent(req.Data)
+	// Validate and normalize the address the shipment is delivered to
+	data, err := wf.ValidateAddress(tctx, req.Data)
+	var verr *address.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		ErrorHandler(ctx, "SendShipment", "ValidateAddress", err)
+		return
+	case err != nil:
+		ServerErrorHandler(ctx, "SendShipment", "ValidateAddress", err)
+		return
+	}
 
-	// Send a breadcrumb to Sentry with the shipment status
-	sentry.AddBreadcrumb(&sentry.Breadcrumb{
-		Category:  acmeserverless.ShipmentSentEventName,
-		Timestamp: time.Now(),
-		Level:     sentry.LevelInfo,
-		Data:      acmeserverless.ToSentryMap(shipmentData),
-	})
+	// Hand the shipments to the shipper, one for every warehouse the order is shipped from,
+	// and keep track of them so their deliveries can be resumed after a restart
+	parts, events, err := wf.ShipAndStore(tctx, shipments, data, cause)
+	var aerr *warehouse.AssignmentError
+	switch {
+	case errors.As(err, &aerr):
+		ErrorHandler(ctx, "SendShipment", "AssignWarehouses", err)
+		return
+	case err != nil:
+		ServerErrorHandler(ctx, "SendShipment", "Ship", err)
+		return
+	}
+
+	// The first event is the response: the ShipmentSent event of a single shipment, or
+	// the OrderSplit event of an order that was split
+	evt := events[0]
+	if len(parts) == 1 {
+		tctx = workflow.WithShipment(tctx, parts[0])
+	}
 
-	evt := acmeserverless.ShipmentSent{
-		Metadata: acmeserverless.Metadata{
-			Domain: acmeserverless.ShipmentDomain,
-			Source: "SendShipment",
-			Type:   acmeserverless.ShipmentSentEventName,
-			Status: acmeserverless.DefaultSuccessStatus,
-		},
-		Data: shipmentData,
+	// Tag the errors sent to Sentry with the causation and correlation ID
+	if hub := sentryfasthttp.GetHubFromContext(ctx); hub != nil {
+		hub.Scope().SetTag(correlation.CausationIDKey, evt.IDs.CausationID)
+		hub.Scope().SetTag(correlation.CorrelationIDKey, evt.IDs.CorrelationID)
+	}
+
+	// Queue the deliveries of all parts, or tell the client to come back later when they
+	// don't fit in the queue. The stored parts are removed, so a retry of the client
+	// doesn't leave them behind.
+	switch err := deliveries.Schedule(parts...); err {
+	case nil:
+	case delivery.ErrQueueFull:
+		slog.WarnContext(tctx, "delivery queue is full, rejecting shipment")
+		deleteShipments(tctx, parts)
+		ctx.Response.Header.Set("Retry-After", strconv.Itoa(int(deliveries.RetryAfter().Seconds())))
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.SetBodyString(err.Error())
+		return
+	default:
+		slog.WarnContext(tctx, "rejecting shipment", logging.Err(err))
+		deleteShipments(tctx, parts)
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	for _, shipment := range parts {
+		slog.DebugContext(workflow.WithShipment(tctx, shipment), "delivery scheduled", "deliverAt", shipment.DeliverAt)
+	}
+
+	// Send the ShipmentSent events of the parts of an order that was split. Their tracking
+	// numbers are in the response too, so the response doesn't fail when they can't be sent.
+	if err := wf.Emit(tctx, events[1:]...); err != nil {
+		if hub := sentryfasthttp.GetHubFromContext(ctx); hub != nil {
+			hub.CaptureException(fmt.Errorf("error sending parts of order %s: %s", data.OrderID, err.Error()))
+		}
 	}
 
 	payload, err := evt.Marshal()
@@ -59,51 +123,154 @@ func SendShipment(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	go handleDelivery(evt.Data)
+	// Tell the client which event was created and how it is correlated
+	for k, v := range evt.IDs.Headers() {
+		ctx.Response.Header.Set(k, v)
+	}
 
 	ctx.SetStatusCode(http.StatusOK)
 	ctx.Write(payload)
 }
 
-func handleDelivery(shipmentData acmeserverless.ShipmentData) {
-	// Wait for the delivery
-	shipmentData = shipper.Delivered(shipmentData)
-
-	// Create a new event with the new status of the shipment
-	evt := acmeserverless.ShipmentSent{
-		Metadata: acmeserverless.Metadata{
-			Domain: acmeserverless.ShipmentDomain,
-			Source: "SendShipment",
-			Type:   acmeserverless.ShipmentDeliveredEventName,
-			Status: acmeserverless.DefaultSuccessStatus,
-		},
-		Data: shipmentData,
-	}
-
-	// Send a breadcrumb to Sentry with the shipment status
-	sentry.AddBreadcrumb(&sentry.Breadcrumb{
-		Category:  acmeserverless.ShipmentDeliveredEventName,
-		Timestamp: time.Now(),
-		Level:     sentry.LevelInfo,
-		Data:      acmeserverless.ToSentryMap(evt.Data),
+// deleteShipments removes the stored shipments of a request that failed. A shipment that
+// can't be removed is logged, and is delivered when the service starts again.
+func deleteShipments(ctx context.Context, parts []shipper.Shipment) {
+	for _, s := range parts {
+		if err := shipments.Delete(s.TrackingNumber); err != nil {
+			slog.ErrorContext(workflow.WithShipment(ctx, s), "error removing shipment of failed request", logging.Err(err))
+		}
+	}
+}
+
+// decodeRequest decodes and validates the ShipmentRequested event in the body of the
+// request. The request causes the events of the shipment, its ID is the one the client
+// set as header or a new one.
+func decodeRequest(ctx *fasthttp.RequestCtx) (workflow.Request, correlation.IDs, error) {
+	req, err := workflow.DecodeRequest(ctx.Request.Body())
+	if err != nil {
+		return req, correlation.IDs{}, err
+	}
+
+	cause := correlation.FromHeaders(func(key string) string {
+		return string(ctx.Request.Header.Peek(key))
 	})
+	if cause.EventID == "" {
+		cause.EventID = correlation.NewID()
+	}
 
-	b, _ := evt.Marshal()
-	payload := bytes.NewReader(b)
+	return req, cause, nil
+}
 
-	req, err := http.NewRequest("POST", os.Getenv("ORDER_URL"), payload)
-	if err != nil {
-		log.Printf("error building http request for order status: %s", err.Error())
-		return
+// handleDelivery sends the new status of the shipment using the EventEmitter and
+// stores the delivered shipment. The shipment is only stored after the events were
+// sent, so an interrupted delivery is resumed after a restart. A shipment with
+// parcels that aren't delivered yet, or a return that isn't received yet, is
+// returned with its next step, which the scheduler queues.
+func handleDelivery(ctx context.Context, shipment shipper.Shipment) (next shipper.Shipment, more bool, err error) {
+	// Continue the trace of the request that created the shipment
+	ctx, span := tracing.Start(tracing.Extract(ctx, shipment.TraceContext), "handleDelivery", attribute.String("shipment.tracking_number", shipment.TrackingNumber))
+	ctx = workflow.WithShipment(ctx, shipment)
+	defer func() { tracing.End(span, err) }()
+
+	// Lock the shipment until it is stored, so a scan or cancellation at the same time
+	// doesn't overwrite the delivery, or the other way around
+	defer lockShipment(shipment.TrackingNumber)()
+
+	// Skip the delivery of a shipment that was cancelled, or delivered according to the
+	// carrier, while it was handed to a worker, and keep the scans the carrier reported
+	if stored, err := shipments.Get(shipment.TrackingNumber); err == nil {
+		if shipper.Done(stored) {
+			slog.InfoContext(ctx, "skipping delivery of "+stored.Status+" shipment")
+			return stored, false, nil
+		}
+		shipment = stored
 	}
 
-	req.Header.Add("content-type", "application/json")
-	req.Header.Add("host", os.Getenv("ORDER_HOST"))
+	// Create the events with the new status of the shipment and its parcels, and send them
+	// before storing it, with OrderDelivered when all parts of an order that was split are
+	// delivered. A delivery that fails is retried with the shipment that was stored before.
+	shipment, events := wf.Delivered(ctx, shipment)
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Printf("error sending order status: %s", err.Error())
-		return
+	if err := emitAndSave(ctx, shipment, events...); err != nil {
+		return shipment, false, err
+	}
+
+	// Queue the delivery of the next parcel, or the next step of a return
+	return shipment, !shipper.Done(shipment), nil
+}
+
+// saveShipment stores the shipment and, when it is the last part of an order that was
+// split to be delivered or cancelled, returns the OrderDelivered event. Parts are delivered
+// by more than one worker, so storing a part and checking the other parts is done by one
+// worker at a time, which makes sure only one of them sends the event.
+func saveShipment(ctx context.Context, shipment shipper.Shipment) (workflow.Event, bool, error) {
+	partsMu.Lock()
+	defer partsMu.Unlock()
+
+	if err := shipments.Save(shipment); err != nil {
+		return workflow.Event{}, false, fmt.Errorf("error storing shipment: %s", err.Error())
+	}
+
+	if !shipper.Done(shipment) || len(shipment.Parts) == 0 {
+		return workflow.Event{}, false, nil
+	}
+
+	parts := make([]shipper.Shipment, len(shipment.Parts))
+	for i, tn := range shipment.Parts {
+		p, err := shipments.Get(tn)
+		if err != nil {
+			return workflow.Event{}, false, fmt.Errorf("error loading part %s of order: %s", tn, err.Error())
+		}
+		parts[i] = p
+	}
+
+	evt, complete := wf.OrderDelivered(ctx, parts)
+	return evt, complete, nil
+}
+
+// emitAndSave sends the events of the changes to the shipment and then stores it, so the
+// changes are only stored once the other services know about them. When the shipment is
+// the last part of an order that was split to be delivered or cancelled, the OrderDelivered
+// event is sent with the events. Like saveShipment, the other parts are checked by one
+// worker at a time.
+func emitAndSave(ctx context.Context, shipment shipper.Shipment, events ...workflow.Event) error {
+	partsMu.Lock()
+	defer partsMu.Unlock()
+
+	if shipper.Done(shipment) && len(shipment.Parts) > 0 {
+		parts := make([]shipper.Shipment, len(shipment.Parts))
+		for i, tn := range shipment.Parts {
+			if tn == shipment.TrackingNumber {
+				parts[i] = shipment
+				continue
+			}
+			p, err := shipments.Get(tn)
+			if err != nil {
+				return fmt.Errorf("error loading part %s of order: %s", tn, err.Error())
+			}
+			parts[i] = p
+		}
+		if evt, complete := wf.OrderDelivered(ctx, parts); complete {
+			events = append(events, evt)
+		}
+	}
+
+	if err := wf.Emit(ctx, events...); err != nil {
+		return fmt.Errorf("error sending events: %s", err.Error())
+	}
+	if err := shipments.Save(shipment); err != nil {
+		return fmt.Errorf("error storing shipment: %s", err.Error())
+	}
+	return nil
+}
+
+// requestTraceContext returns the trace context sent as HTTP headers of the request.
+func requestTraceContext(ctx *fasthttp.RequestCtx) map[string]string {
+	carrier := make(map[string]string)
+	for _, key := range tracing.Fields() {
+		if value := ctx.Request.Header.Peek(key); len(value) > 0 {
+			carrier[key] = string(value)
+		}
 	}
-	log.Printf("order service accepted message with status: %s", res.Status)
+	return carrier
 }
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/router"
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/address/rules"
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/delivery"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/mock"
+	"github.com/retgits/acme-serverless-shipment/internal/ratetable"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store/memory"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+const shipmentRequested = `{"metadata":{"domain":"Order","source":"CreateOrder","type":"ShipmentRequested","status":"success"},` +
+	`"data":{"_id":"order-1","delivery":"UPS/FedEx"}}`
+
+// pastClock is a clock that stopped in the past, so every shipment is due for delivery
+// as soon as it is scheduled.
+type pastClock struct{}
+
+func (pastClock) Now() time.Time {
+	return time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func (pastClock) NewTimer(d time.Duration) *time.Timer {
+	return time.NewTimer(d)
+}
+
+// testService runs the routes of the service on an in-memory listener.
+type testService struct {
+	rec    *mock.Recorder
+	client *fasthttp.Client
+}
+
+// newTestService configures the service like main does, with a recording emitter and
+// the memory store, and serves its routes in memory.
+func newTestService(t *testing.T, c clock.Clock, queueSize int) *testService {
+	t.Helper()
+
+	cfg = &config.Config{Service: servicename, Emitter: "mock", Store: "memory"}
+	shipments = memory.New()
+
+	rec := mock.NewRecorder()
+	em = rec
+	wf = workflow.New(rec, "mock", nil)
+	wf.SetClock(c)
+
+	addresses = rules.New()
+	wf.SetAddressProvider(addresses, false)
+
+	var err error
+	if warehouses, err = warehouse.Default(); err != nil {
+		t.Fatal(err)
+	}
+	wf.SetWarehouses(warehouses)
+
+	deliveries = delivery.New(handleDelivery, delivery.Options{Workers: 1, QueueSize: queueSize, RetryBackoff: 10 * time.Millisecond})
+
+	tables, err := ratetable.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	quoter = shipper.NewQuoter(ratetable.Carriers(tables)...)
+
+	r := router.New()
+	r.POST("/ship", SendShipment)
+	r.GET("/ship/{trackingNumber}", TrackShipment)
+	r.GET("/ship/{trackingNumber}/label", ShipmentLabel)
+	r.POST("/ship/{trackingNumber}/cancel", CancelShipment)
+	r.POST("/ship/{trackingNumber}/returns", RequestReturn)
+	r.POST("/webhooks/carriers/{carrier}", CarrierWebhook)
+	r.POST("/rates", QuoteRates)
+	r.POST("/addresses/validate", ValidateAddress)
+
+	ln := fasthttputil.NewInmemoryListener()
+	server := &fasthttp.Server{Handler: r.Handler}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		deliveries.Shutdown(ctx)
+		ln.Close()
+	})
+
+	return &testService{
+		rec: rec,
+		client: &fasthttp.Client{
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		},
+	}
+}
+
+// do sends the request to the service and returns the response.
+func (s *testService) do(t *testing.T, method string, path string, body string, headers map[string]string) *fasthttp.Response {
+	t.Helper()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.Header.SetMethod(method)
+	req.SetRequestURI("http://shipment" + path)
+	req.SetBodyString(body)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res := &fasthttp.Response{}
+	if err := s.client.DoTimeout(req, res, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestSendShipment(t *testing.T) {
+	s := newTestService(t, pastClock{}, 10)
+
+	res := s.do(t, http.MethodPost, "/ship", shipmentRequested, map[string]string{
+		correlation.EventIDHeader:       "request-1",
+		correlation.CorrelationIDHeader: "correlation-1",
+	})
+
+	if res.StatusCode() != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", res.StatusCode(), http.StatusOK, res.Body())
+	}
+
+	sent, err := acmeserverless.UnmarshalShipmentSent(res.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"event type", sent.Metadata.Type, acmeserverless.ShipmentSentEventName},
+		{"order number", sent.Data.OrderNumber, "order-1"},
+		{"status", sent.Data.Status, shipper.StatusShipped},
+		{"causation header", string(res.Header.Peek(correlation.CausationIDHeader)), "request-1"},
+		{"correlation header", string(res.Header.Peek(correlation.CorrelationIDHeader)), "correlation-1"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %s %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	if len(res.Header.Peek(correlation.EventIDHeader)) == 0 {
+		t.Error("response has no event ID")
+	}
+
+	// The shipment is delivered in the background and the delivered event is emitted
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	delivered, err := s.rec.WaitForType(ctx, acmeserverless.ShipmentDeliveredEventName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := delivered[0]; got.Data.TrackingNumber != sent.Data.TrackingNumber || got.IDs.CorrelationID != "correlation-1" {
+		t.Errorf("got delivered event %+v for tracking number %s and correlation-1", got, sent.Data.TrackingNumber)
+	}
+}
+
+func TestSendShipmentRetriesDelivery(t *testing.T) {
+	s := newTestService(t, pastClock{}, 10)
+
+	// The first delivered event can't be sent, so the delivery is tried again
+	s.rec.FailOn(1, errors.New("order service unavailable"))
+
+	res := s.do(t, http.MethodPost, "/ship", shipmentRequested, nil)
+	if res.StatusCode() != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", res.StatusCode(), http.StatusOK, res.Body())
+	}
+
+	sent, err := acmeserverless.UnmarshalShipmentSent(res.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.rec.WaitForType(ctx, acmeserverless.ShipmentDeliveredEventName, 1); err != nil {
+		t.Fatal(err)
+	}
+	if abandoned := deliveries.Shutdown(ctx); len(abandoned) != 0 {
+		t.Fatalf("got abandoned deliveries %+v, want none", abandoned)
+	}
+
+	stored, err := shipments.Get(sent.Data.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != shipper.StatusDelivered || s.rec.Calls() != 2 {
+		t.Errorf("got shipment with status %q after %d sends, want it delivered after 2", stored.Status, s.rec.Calls())
+	}
+}
+
+func TestSendShipmentWithParcels(t *testing.T) {
+	s := newTestService(t, pastClock{}, 10)
+
+	res := s.do(t, http.MethodPost, "/ship", `{"metadata":{},"data":{"_id":"order-1","delivery":"UPS",`+
+		`"parcels":[{"weightKg":1},{"weightKg":2},{"weightKg":3}]}}`, nil)
+	if res.StatusCode() != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", res.StatusCode(), http.StatusOK, res.Body())
+	}
+
+	sent, err := acmeserverless.UnmarshalShipmentSent(res.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every parcel is delivered, one delivery at a time, before the shipment is delivered
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.rec.WaitForType(ctx, acmeserverless.ShipmentDeliveredEventName, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	parcels, err := s.rec.WaitForType(ctx, workflow.ParcelDeliveredEventName, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range parcels {
+		seen[p.Data.TrackingNumber] = true
+	}
+	if len(seen) != 3 || !seen[sent.Data.TrackingNumber] {
+		t.Errorf("got parcel events %+v, want three parcels including %s", parcels, sent.Data.TrackingNumber)
+	}
+
+	// Wait until the last delivery stored the shipment
+	if abandoned := deliveries.Shutdown(ctx); len(abandoned) > 0 {
+		t.Fatalf("got abandoned deliveries %+v, want none", abandoned)
+	}
+
+	stored, err := shipments.Get(sent.Data.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != shipper.StatusDelivered || shipper.ParcelStatus(stored.Parcels) != shipper.StatusDelivered {
+		t.Errorf("got stored shipment %+v, want all parcels delivered", stored)
+	}
+}
+
+func TestSendShipmentSplitsOrders(t *testing.T) {
+	s := newTestService(t, pastClock{}, 10)
+
+	res := s.do(t, http.MethodPost, "/ship", `{"metadata":{},"data":{"_id":"order-1","delivery":"UPS",`+
+		`"items":[{"id":"rack-1","quantity":1},{"id":"mat-1","quantity":2}]}}`, map[string]string{correlation.CorrelationIDHeader: "correlation-1"})
+	if res.StatusCode() != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", res.StatusCode(), http.StatusOK, res.Body())
+	}
+
+	// The response is the OrderSplit event with the tracking numbers of both parts
+	split, err := acmeserverless.UnmarshalShipmentSent(res.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(split.Data.TrackingNumber, ",")
+	if split.Metadata.Type != workflow.OrderSplitEventName || len(parts) != 2 {
+		t.Fatalf("got response %+v, want an OrderSplit event with two parts", split)
+	}
+
+	// Every part is sent and delivered, and then the order is delivered
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.rec.WaitForType(ctx, acmeserverless.ShipmentSentEventName, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.rec.WaitForType(ctx, acmeserverless.ShipmentDeliveredEventName, 2); err != nil {
+		t.Fatal(err)
+	}
+	delivered, err := s.rec.WaitForType(ctx, workflow.OrderDeliveredEventName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := delivered[0]; got.Data.TrackingNumber != split.Data.TrackingNumber || got.IDs.CorrelationID != "correlation-1" {
+		t.Errorf("got order delivered event %+v, want %s and correlation-1", got, split.Data.TrackingNumber)
+	}
+
+	for i, want := range []string{"rno", "sfo"} {
+		stored, err := shipments.Get(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Warehouse != want {
+			t.Errorf("got part %s from warehouse %q, want %q", parts[i], stored.Warehouse, want)
+		}
+	}
+}
+
+func TestDeliveryOfLastPartRetriesOrderDelivered(t *testing.T) {
+	s := newTestService(t, pastClock{}, 10)
+
+	// An order that was split in two shipments, of which the first was delivered
+	var parts []shipper.Shipment
+	for i := 0; i < 2; i++ {
+		p := shipper.Shipment{Carrier: "UPS", CorrelationID: "correlation-1"}
+		p.TrackingNumber = shipper.NewTrackingNumber("UPS")
+		p.OrderNumber = "order-1"
+		p.Status = shipper.StatusShipped
+		parts = append(parts, p)
+	}
+	parts[0].Status = shipper.StatusDelivered
+	parts[0].Parts = []string{parts[0].TrackingNumber, parts[1].TrackingNumber}
+	parts[1].Parts = parts[0].Parts
+	for _, p := range parts {
+		if err := shipments.Save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The OrderDelivered event can't be sent, so the last part isn't stored as delivered
+	// and its delivery is tried again
+	s.rec.FailOn(2, errors.New("order service unavailable"))
+	if _, _, err := handleDelivery(context.Background(), parts[1]); err == nil {
+		t.Fatal("got no error, want the error of the emitter")
+	}
+	stored, err := shipments.Get(parts[1].TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != shipper.StatusShipped {
+		t.Errorf("got part with status %q, want it stored as %q", stored.Status, shipper.StatusShipped)
+	}
+
+	if _, more, err := handleDelivery(context.Background(), parts[1]); err != nil || more {
+		t.Fatalf("got more %t and error %v, want the last part delivered", more, err)
+	}
+	stored, _ = shipments.Get(parts[1].TrackingNumber)
+	if stored.Status != shipper.StatusDelivered || len(s.rec.OfType(workflow.OrderDeliveredEventName)) != 1 {
+		t.Errorf("got part with status %q and events %+v, want it delivered with one OrderDelivered", stored.Status, s.rec.Records())
+	}
+}
+
+func TestSendShipmentRejectsRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		queueSize  int
+		queued     int
+		closed     bool
+		wantStatus int
+		retryAfter bool
+	}{
+		{name: "invalid JSON", body: `{"metadata":`, queueSize: 10, wantStatus: http.StatusBadRequest},
+		{name: "empty body", body: ``, queueSize: 10, wantStatus: http.StatusBadRequest},
+		{name: "invalid address", body: `{"metadata":{},"data":{"_id":"order-1","delivery":"UPS","address":{"street":"1 Main St","city":"Palo Alto","zip":"943","country":"US"}}}`, queueSize: 10, wantStatus: http.StatusBadRequest},
+		{name: "unknown warehouse", body: `{"metadata":{},"data":{"_id":"order-1","delivery":"UPS","items":[{"id":"mat-1","quantity":1,"warehouse":"lax"}]}}`, queueSize: 10, wantStatus: http.StatusBadRequest},
+		{name: "queue is full", body: shipmentRequested, queueSize: 1, queued: 1, wantStatus: http.StatusServiceUnavailable, retryAfter: true},
+		{name: "split order doesn't fit", body: `{"metadata":{},"data":{"_id":"order-2","delivery":"UPS","items":[{"id":"rack-1","quantity":1},{"id":"mat-1","quantity":2}]}}`, queueSize: 2, queued: 1, wantStatus: http.StatusServiceUnavailable, retryAfter: true},
+		{name: "shutting down", body: shipmentRequested, queueSize: 10, closed: true, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Deliveries on the real clock aren't due during the test, so they stay queued
+			s := newTestService(t, clock.Real{}, tt.queueSize)
+
+			for i := 0; i < tt.queued; i++ {
+				if res := s.do(t, http.MethodPost, "/ship", shipmentRequested, nil); res.StatusCode() != http.StatusOK {
+					t.Fatalf("got status %d for queued shipment %d: %s", res.StatusCode(), i, res.Body())
+				}
+			}
+			s.rec.Reset()
+
+			if tt.closed {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				deliveries.Shutdown(ctx)
+			}
+
+			res := s.do(t, http.MethodPost, "/ship", tt.body, nil)
+
+			if res.StatusCode() != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", res.StatusCode(), tt.wantStatus, res.Body())
+			}
+			if got := len(res.Header.Peek("Retry-After")) > 0; got != tt.retryAfter {
+				t.Errorf("got Retry-After header %v, want %v", got, tt.retryAfter)
+			}
+
+			list, err := shipments.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != tt.queued || deliveries.Pending() != tt.queued {
+				t.Errorf("got %d stored shipments and %d pending deliveries, want %d", len(list), deliveries.Pending(), tt.queued)
+			}
+		})
+	}
+}
+
+func TestTrackShipment(t *testing.T) {
+	s := newTestService(t, clock.Real{}, 10)
+
+	res := s.do(t, http.MethodPost, "/ship", shipmentRequested, nil)
+	sent, err := acmeserverless.UnmarshalShipmentSent(res.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		trackingNumber string
+		wantStatus     int
+	}{
+		{"known shipment", sent.Data.TrackingNumber, http.StatusOK},
+		{"unknown shipment", "unknown", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := s.do(t, http.MethodGet, "/ship/"+tt.trackingNumber, "", nil)
+			if res.StatusCode() != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.StatusCode(), tt.wantStatus, res.Body())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got shipper.Shipment
+			if err := json.Unmarshal(res.Body(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.TrackingNumber != tt.trackingNumber || got.Status != shipper.StatusShipped {
+				t.Errorf("got shipment %s with status %q, want %s with status %q", got.TrackingNumber, got.Status, tt.trackingNumber, shipper.StatusShipped)
+			}
+		})
+	}
+}
+
+func TestDeliveryNextStepDuringShutdown(t *testing.T) {
+	s := newTestService(t, clock.Real{}, 10)
+	s.rec.SetLatency(200 * time.Millisecond)
+
+	// A shipment of which the first parcel is due now and the second in an hour
+	now := time.Now()
+	sh := shipper.Shipment{Carrier: "UPS", DeliverAt: now}
+	sh.TrackingNumber = shipper.NewTrackingNumber("UPS")
+	sh.OrderNumber = "order-1"
+	sh.Status = shipper.StatusShipped
+	sh.Parcels = []shipper.Parcel{
+		{TrackingNumber: sh.TrackingNumber, Status: shipper.StatusShipped, DeliverAt: now},
+		{TrackingNumber: shipper.NewTrackingNumber("UPS"), Status: shipper.StatusShipped, DeliverAt: now.Add(time.Hour)},
+	}
+	if err := shipments.Save(sh); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliveries.Schedule(sh); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shut down while the first parcel is being delivered
+	for deliveries.Stats().Busy == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	abandoned := deliveries.Shutdown(ctx)
+
+	// The first parcel was delivered, the second is reported as abandoned
+	if len(abandoned) != 1 || abandoned[0].TrackingNumber != sh.TrackingNumber || abandoned[0].Parcels[0].Status != shipper.StatusDelivered {
+		t.Fatalf("got abandoned deliveries %+v, want the shipment with its second parcel underway", abandoned)
+	}
+	if ctx.Err() != nil {
+		t.Error("got shutdown after the grace period, want it as soon as the first parcel was delivered")
+	}
+}
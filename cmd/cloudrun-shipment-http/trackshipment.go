@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/valyala/fasthttp"
+)
+
+// TrackShipment returns the shipment with the tracking number in the path, including
+// its current status.
+func TrackShipment(ctx *fasthttp.RequestCtx) {
+	trackingNumber, _ := ctx.UserValue("trackingNumber").(string)
+
+	s, err := shipments.Get(trackingNumber)
+	switch err {
+	case nil:
+		writeJSON(ctx, http.StatusOK, s)
+	case store.ErrNotFound:
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.SetBodyString(err.Error())
+	default:
+		ServerErrorHandler(ctx, "TrackShipment", "Get", err)
+	}
+}
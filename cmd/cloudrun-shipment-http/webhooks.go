@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/webhook"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// webhookResponse is the response to a tracking webhook of a carrier.
+type webhookResponse struct {
+	// Applied is the number of scans that were applied to shipments.
+	Applied int `json:"applied"`
+
+	// Ignored is the number of scans that were applied before, or of shipments the service
+	// doesn't know or that the carrier doesn't ship.
+	Ignored int `json:"ignored"`
+}
+
+// CarrierWebhook applies the scans in the tracking webhook of the carrier in the path to
+// the shipments they belong to and sends the events of the shipments that changed, like
+// ShipmentDelivered when the carrier delivered a shipment. Only webhooks of carriers with
+// a secret are accepted, and their signature must match the body. Scans of shipments the
+// service doesn't know are ignored, so the carrier doesn't send them again.
+func CarrierWebhook(ctx *fasthttp.RequestCtx) {
+	name, _ := ctx.UserValue("carrier").(string)
+
+	// Continue the trace of the client that sent the request
+	tctx, span := tracing.StartKind(tracing.Extract(ctx, requestTraceContext(ctx)), "POST /webhooks/carriers/{carrier}", trace.SpanKindServer)
+	defer span.End()
+
+	// Only accept webhooks of carriers that share a secret with the service
+	parser, ok := webhook.Find(name)
+	if !ok || webhookSecrets[parser.Carrier()] == "" {
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.SetBodyString(fmt.Sprintf("no webhooks of carrier %s are accepted", name))
+		return
+	}
+	tctx = logging.With(tctx, logging.Carrier, parser.Carrier())
+
+	header := func(key string) string {
+		return string(ctx.Request.Header.Peek(key))
+	}
+	if err := parser.Verify(header, ctx.Request.Body(), webhookSecrets[parser.Carrier()]); err != nil {
+		slog.WarnContext(tctx, "rejecting carrier webhook", logging.Err(err))
+		ctx.SetStatusCode(http.StatusUnauthorized)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	scans, err := parser.Parse(ctx.Request.Body())
+	if err != nil {
+		ErrorHandler(ctx, "CarrierWebhook", "Parse", err)
+		return
+	}
+	for i, scan := range scans {
+		if err := shipper.ValidateScan(scan); err != nil {
+			ErrorHandler(ctx, "CarrierWebhook", "Validate", fmt.Errorf("invalid scan %d: %s", i, err.Error()))
+			return
+		}
+	}
+
+	// Apply the scans in the order of the webhook. The events of a scan are sent before it
+	// is stored, so when that fails the carrier sends the webhook again and the scans that
+	// weren't stored are applied then.
+	var res webhookResponse
+	for _, scan := range scans {
+		applied, err := applyScan(tctx, parser.Carrier(), scan)
+		if err != nil {
+			ServerErrorHandler(ctx, "CarrierWebhook", "Apply", err)
+			return
+		}
+		if !applied {
+			res.Ignored++
+			continue
+		}
+		res.Applied++
+	}
+
+	writeJSON(ctx, http.StatusOK, res)
+}
+
+// applyScan applies the scan of the carrier to the shipment it belongs to, sends the events
+// of the scan, stores the shipment and moves its delivery to the next one that is due. It
+// returns false when the scan is ignored.
+func applyScan(ctx context.Context, carrier string, scan shipper.Scan) (bool, error) {
+	// Ignore scans of shipments of other carriers, so a carrier can only change its own
+	tn, err := shipper.ParseTrackingNumber(scan.TrackingNumber)
+	if err != nil || tn.Carrier != carrier {
+		slog.WarnContext(ctx, "ignoring scan of shipment of other carrier", logging.TrackingNumber, scan.TrackingNumber)
+		return false, nil
+	}
+	scan.TrackingNumber = tn.Number
+
+	s, err := store.Find(shipments, tn.Number)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		slog.InfoContext(ctx, "ignoring scan of unknown shipment", logging.TrackingNumber, tn.Number)
+		return false, nil
+	default:
+		return false, err
+	}
+
+	applied, err := updateShipment(ctx, s.TrackingNumber, []shipper.Scan{scan}, time.Time{})
+	return applied > 0, err
+}
+
+// updateShipment applies the scans that weren't applied before to the stored shipment with
+// the tracking number, sets the moment the tracking poller asks the carrier about it again
+// when pollAt isn't zero, sends the events of the scans, stores the shipment and moves its
+// delivery to the next one that is due. It returns the number of scans that were applied.
+func updateShipment(ctx context.Context, trackingNumber string, scans []shipper.Scan, pollAt time.Time) (int, error) {
+	defer lockShipment(trackingNumber)()
+
+	s, err := shipments.Get(trackingNumber)
+	if err != nil {
+		return 0, err
+	}
+	ctx = workflow.WithShipment(ctx, s)
+
+	var events []workflow.Event
+	applied := 0
+	for _, scan := range scans {
+		if shipper.HasScan(s, scan) {
+			continue
+		}
+
+		var evts []workflow.Event
+		s, evts = wf.Scanned(ctx, s, scan)
+		events = append(events, evts...)
+		applied++
+	}
+	if !pollAt.IsZero() {
+		s.PollAt = pollAt
+	}
+
+	// Only store when the poll time moved, so the order of a shipment that is done isn't
+	// reported as delivered again
+	if applied == 0 {
+		if !pollAt.IsZero() {
+			if err := shipments.Save(s); err != nil {
+				return 0, fmt.Errorf("error storing shipment: %s", err.Error())
+			}
+		}
+		return 0, nil
+	}
+
+	if err := emitAndSave(ctx, s, events...); err != nil {
+		return 0, err
+	}
+
+	// Move the queued delivery to the next step in place, or remove it when the shipment is
+	// done. A delivery that is being handled picks up the scans from the store.
+	if shipper.Done(s) {
+		deliveries.Cancel(s.TrackingNumber)
+	} else {
+		deliveries.Reschedule(s)
+	}
+
+	return applied, nil
+}
+
+// pollShipment is the Update of the tracking poller, which applies the scans the carrier
+// reported for the shipment and sends the events of the changes before storing them.
+func pollShipment(ctx context.Context, trackingNumber string, scans []shipper.Scan, pollAt time.Time) error {
+	_, err := updateShipment(ctx, trackingNumber, scans, pollAt)
+	return err
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/webhook"
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+)
+
+// upsWebhook returns a UPS tracking webhook with the activity of the tracking number and
+// the headers with its signature.
+func upsWebhook(trackingNumber string, activityType string, secret string) (string, map[string]string) {
+	body := `{"trackingNumber":"` + trackingNumber + `","gmtActivityDate":"20200401","gmtActivityTime":"190000","activityLocation":{"city":"PALO ALTO","stateProvince":"CA"},"activityStatus":{"type":"` + activityType + `"}}`
+	return body, map[string]string{"X-UPS-Signature": hex.EncodeToString(webhook.Sign([]byte(body), secret))}
+}
+
+func TestCarrierWebhook(t *testing.T) {
+	s := newTestService(t, clock.Real{}, 10)
+	webhookSecrets = map[string]string{"UPS": "s3cr3t"}
+
+	// A shipment that is queued for delivery, and one with parcels
+	single := shipper.Shipment{Carrier: "UPS", CausationID: "request-1", CorrelationID: "correlation-1", DeliverAt: time.Now().Add(time.Hour)}
+	single.TrackingNumber = shipper.NewTrackingNumber("UPS")
+	single.OrderNumber = "order-1"
+	single.Status = shipper.StatusShipped
+
+	parcels := single
+	parcels.TrackingNumber = shipper.NewTrackingNumber("UPS")
+	parcels.Parcels = []shipper.Parcel{
+		{TrackingNumber: parcels.TrackingNumber, Status: shipper.StatusShipped, DeliverAt: parcels.DeliverAt},
+		{TrackingNumber: shipper.NewTrackingNumber("UPS"), Status: shipper.StatusShipped, DeliverAt: parcels.DeliverAt},
+	}
+
+	for _, sh := range []shipper.Shipment{single, parcels} {
+		if err := shipments.Save(sh); err != nil {
+			t.Fatal(err)
+		}
+		if err := deliveries.Schedule(sh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	delivered, headers := upsWebhook(single.TrackingNumber, "D", "s3cr3t")
+	_, otherSecretHeaders := upsWebhook(single.TrackingNumber, "D", "other")
+	parcel, parcelHeaders := upsWebhook(parcels.Parcels[1].TrackingNumber, "D", "s3cr3t")
+	unknown, unknownHeaders := upsWebhook(shipper.NewTrackingNumber("UPS"), "D", "s3cr3t")
+	other, otherHeaders := upsWebhook(shipper.NewTrackingNumber("FedEx"), "D", "s3cr3t")
+	invalid, invalidHeaders := upsWebhook(single.TrackingNumber, "Q", "s3cr3t")
+
+	tests := []struct {
+		name        string
+		carrier     string
+		body        string
+		headers     map[string]string
+		wantStatus  int
+		wantApplied int
+	}{
+		{name: "unknown carrier", carrier: "acme", body: delivered, headers: headers, wantStatus: http.StatusNotFound},
+		{name: "carrier without secret", carrier: "fedex", body: delivered, headers: headers, wantStatus: http.StatusNotFound},
+		{name: "invalid signature", carrier: "ups", body: delivered, headers: otherSecretHeaders, wantStatus: http.StatusUnauthorized},
+		{name: "invalid payload", carrier: "ups", body: invalid, headers: invalidHeaders, wantStatus: http.StatusBadRequest},
+		{name: "unknown shipment", carrier: "ups", body: unknown, headers: unknownHeaders, wantStatus: http.StatusOK},
+		{name: "other carrier", carrier: "ups", body: other, headers: otherHeaders, wantStatus: http.StatusOK},
+		{name: "delivered", carrier: "ups", body: delivered, headers: headers, wantStatus: http.StatusOK, wantApplied: 1},
+		{name: "delivered again", carrier: "ups", body: delivered, headers: headers, wantStatus: http.StatusOK},
+		{name: "parcel delivered", carrier: "UPS", body: parcel, headers: parcelHeaders, wantStatus: http.StatusOK, wantApplied: 1},
+	}
+
+	for _, tt := range tests {
+		res := s.do(t, http.MethodPost, "/webhooks/carriers/"+tt.carrier, tt.body, tt.headers)
+		if res.StatusCode() != tt.wantStatus {
+			t.Fatalf("%s: got status %d, want %d: %s", tt.name, res.StatusCode(), tt.wantStatus, res.Body())
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var got webhookResponse
+		if err := json.Unmarshal(res.Body(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Applied != tt.wantApplied || got.Applied+got.Ignored != 1 {
+			t.Errorf("%s: got response %+v, want %d applied of 1", tt.name, got, tt.wantApplied)
+		}
+	}
+
+	// The shipments changed in the store and the events of the changes were sent
+	stored, err := shipments.Get(single.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != shipper.StatusDelivered || len(stored.Scans) != 1 || stored.Scans[0].Location != "PALO ALTO, CA" {
+		t.Errorf("got shipment with status %q and scans %+v, want the delivered scan", stored.Status, stored.Scans)
+	}
+
+	var got []string
+	for _, evt := range s.rec.Records() {
+		got = append(got, evt.Metadata.Type+" "+evt.Data.TrackingNumber)
+		if evt.IDs.CausationID != "request-1" || evt.IDs.CorrelationID != "correlation-1" {
+			t.Errorf("got event %+v, want the IDs of the shipment", evt)
+		}
+	}
+	want := []string{
+		acmeserverless.ShipmentDeliveredEventName + " " + single.TrackingNumber,
+		workflow.ParcelDeliveredEventName + " " + parcels.Parcels[1].TrackingNumber,
+		workflow.ShipmentPartiallyDeliveredEventName + " " + parcels.TrackingNumber,
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+
+	// The delivered shipment left the queue, the one with a parcel left is still in it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	abandoned := deliveries.Shutdown(ctx)
+	if len(abandoned) != 1 || abandoned[0].TrackingNumber != parcels.TrackingNumber || len(abandoned[0].Scans) != 1 {
+		t.Errorf("got queued deliveries %+v, want the shipment with parcels and its scan", abandoned)
+	}
+}
+
+func TestCarrierWebhookEmitFails(t *testing.T) {
+	s := newTestService(t, clock.Real{}, 10)
+	webhookSecrets = map[string]string{"UPS": "s3cr3t"}
+
+	sh := shipper.Shipment{Carrier: "UPS", DeliverAt: time.Now().Add(time.Hour)}
+	sh.TrackingNumber = shipper.NewTrackingNumber("UPS")
+	sh.OrderNumber = "order-1"
+	sh.Status = shipper.StatusShipped
+	if err := shipments.Save(sh); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliveries.Schedule(sh); err != nil {
+		t.Fatal(err)
+	}
+
+	// The scan isn't stored when its events can't be sent, so the carrier sends it again
+	s.rec.FailOn(1, errors.New("emitter down"))
+	body, headers := upsWebhook(sh.TrackingNumber, "D", "s3cr3t")
+	if res := s.do(t, http.MethodPost, "/webhooks/carriers/ups", body, headers); res.StatusCode() != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d: %s", res.StatusCode(), http.StatusInternalServerError, res.Body())
+	}
+	stored, err := shipments.Get(sh.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != shipper.StatusShipped || len(stored.Scans) != 0 {
+		t.Errorf("got shipment with status %q and scans %+v, want it unchanged", stored.Status, stored.Scans)
+	}
+
+	res := s.do(t, http.MethodPost, "/webhooks/carriers/ups", body, headers)
+	if res.StatusCode() != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", res.StatusCode(), http.StatusOK, res.Body())
+	}
+	var got webhookResponse
+	if err := json.Unmarshal(res.Body(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Applied != 1 {
+		t.Errorf("got response %+v, want the scan applied", got)
+	}
+	if records := s.rec.OfType(acmeserverless.ShipmentDeliveredEventName); len(records) != 1 {
+		t.Errorf("got %d ShipmentDelivered events, want 1", len(records))
+	}
+}
+
+func TestPollShipment(t *testing.T) {
+	s := newTestService(t, clock.Real{}, 10)
+
+	sh := shipper.Shipment{Carrier: "USPS", CausationID: "request-1", CorrelationID: "correlation-1", DeliverAt: time.Now().Add(time.Hour)}
+	sh.TrackingNumber = shipper.NewTrackingNumber("USPS")
+	sh.OrderNumber = "order-1"
+	sh.Status = shipper.StatusShipped
+	if err := shipments.Save(sh); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliveries.Schedule(sh); err != nil {
+		t.Fatal(err)
+	}
+
+	// A poll without scans only moves the next poll
+	pollAt := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	if err := pollShipment(context.Background(), sh.TrackingNumber, nil, pollAt); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := shipments.Get(sh.TrackingNumber)
+	if !stored.PollAt.Equal(pollAt) || stored.Status != shipper.StatusShipped || len(s.rec.Records()) != 0 {
+		t.Errorf("got shipment polled at %s with status %q and %d events, want only the poll moved", stored.PollAt, stored.Status, len(s.rec.Records()))
+	}
+
+	// A poll with the delivery delivers the shipment, also when it is reported twice
+	scan := shipper.Scan{TrackingNumber: sh.TrackingNumber, Event: shipper.ScanDelivered, At: pollAt}
+	for i := 0; i < 2; i++ {
+		if err := pollShipment(context.Background(), sh.TrackingNumber, []shipper.Scan{scan}, pollAt.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored, _ = shipments.Get(sh.TrackingNumber)
+	if stored.Status != shipper.StatusDelivered || len(stored.Scans) != 1 || !stored.PollAt.Equal(pollAt.Add(time.Minute)) {
+		t.Errorf("got shipment with status %q, scans %+v polled at %s, want it delivered", stored.Status, stored.Scans, stored.PollAt)
+	}
+
+	records := s.rec.Records()
+	if len(records) != 1 || records[0].Metadata.Type != acmeserverless.ShipmentDeliveredEventName {
+		t.Errorf("got events %+v, want one ShipmentDelivered", records)
+	}
+
+	// The delivered shipment left the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if abandoned := deliveries.Shutdown(ctx); len(abandoned) != 0 {
+		t.Errorf("got queued deliveries %+v, want none", abandoned)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+)
+
+func FuzzDecodeEvent(f *testing.F) {
+	// The recorded events are the seed corpus
+	for _, name := range []string{"envelope.json", "detail.json", "invalid.json"} {
+		f.Add([]byte(loadEvent(f, name)))
+	}
+	f.Add([]byte(`{"id":"1","detail-type":"ShipmentRequested","detail":null}`))
+	f.Add([]byte(`{"detail":{"correlation":{"eventId":1}},"data":{"_id":"1","delivery":"UPS"}}`))
+	f.Add([]byte(`{"traceContext":"x","correlation":[],"data":{"_id":"1","delivery":"UPS"}}`))
+
+	f.Fuzz(func(t *testing.T, request []byte) {
+		// The same steps the handler takes before the shipment is handed to the shipper
+		detail, eventID := unwrap(json.RawMessage(request))
+		fields := detailFields(detail)
+		stringMap(fields[tracing.ContextField])
+
+		req, cause, err := decodeDetail(detail, eventID, fields)
+		if err != nil {
+			return
+		}
+
+		if err := shipper.Validate(req.Data.ShipmentRequest); err != nil {
+			t.Fatalf("decoded request %+v is invalid: %s", req.Data, err.Error())
+		}
+		if eventID != "" && cause.EventID == "" {
+			t.Fatalf("event %s has no cause", eventID)
+		}
+	})
+}
@@ -6,116 +6,247 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"time"
+	"log/slog"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/getsentry/sentry-go"
 	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
 	"github.com/retgits/acme-serverless-shipment/internal/emitter/eventbridge"
-	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	wfmetrics "github.com/retgits/acme-serverless-shipment/internal/metrics/wflambda"
+	"github.com/retgits/acme-serverless-shipment/internal/redact"
+	"github.com/retgits/acme-serverless-shipment/internal/setup"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
 	wflambda "github.com/wavefronthq/wavefront-lambda-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// handler handles the EventBridge events and returns an error if anything goes wrong.
-// The resulting event, if no error is thrown, is sent to an EventBridge bus.
-func handler(request json.RawMessage) error {
-	// Initiialize a connection to Sentry to capture errors and traces
-	sentry.Init(sentry.ClientOptions{
-		Dsn: os.Getenv("SENTRY_DSN"),
-		Transport: &sentry.HTTPSyncTransport{
-			Timeout: time.Second * 3,
-		},
-		ServerName:  os.Getenv("FUNCTION_NAME"),
-		Release:     os.Getenv("VERSION"),
-		Environment: os.Getenv("STAGE"),
-	})
+const (
+	// metricPrefix is the prefix of the metrics of the shipment workflow.
+	metricPrefix = "acmeserverless.lambda.shipment"
+)
 
-	// Unmarshal the ShipmentRequested event to a struct
-	req, err := acmeserverless.UnmarshalShipmentRequested(request)
-	if err != nil {
-		return handleError("unmarshaling shipment", err)
+var (
+	// cfg is the configuration of the function, loaded once at startup.
+	cfg *config.Config
+
+	// wf is the shipment workflow, which sends the resulting events.
+	wf *workflow.Workflow
+
+	// shipments keeps track of the shipments, so an OrderCancelled event can cancel
+	// the shipments another invocation is delivering.
+	shipments store.Store
+
+	// tp exports the spans at the end of every invocation.
+	tp *tracing.Provider
+
+	// red removes personally identifiable information from logs and Sentry.
+	red *redact.Redactor
+)
+
+// handler handles the EventBridge events, which are ShipmentRequested or OrderCancelled
+// events, and returns an error if anything goes wrong. The resulting event, if no error
+// is thrown, is sent to an EventBridge bus.
+func handler(ctx context.Context, request json.RawMessage) (err error) {
+	// Initiialize a connection to Sentry to capture errors and traces
+	if err := setup.InitSentry(cfg, cfg.FunctionName, red); err != nil {
+		slog.ErrorContext(ctx, "error configuring sentry", logging.Err(err))
 	}
 
-	// Send a breadcrumb to Sentry with the shipment request
-	sentry.AddBreadcrumb(&sentry.Breadcrumb{
-		Category:  acmeserverless.ShipmentRequestedEventName,
-		Timestamp: time.Now(),
-		Level:     sentry.LevelInfo,
-		Data:      acmeserverless.ToSentryMap(req.Data),
-	})
+	// Tag every log record of this invocation with the Lambda request ID
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		ctx = logging.With(ctx, logging.RequestID, lc.AwsRequestID)
+	}
 
-	shipmentData := shipper.Sent(req.Data)
+	// Continue the trace of the service that sent the request
+	detail, eventID := unwrap(request)
+	fields := detailFields(detail)
+	ctx = tracing.Extract(ctx, stringMap(fields[tracing.ContextField]))
 
-	evt := acmeserverless.ShipmentSent{
-		Metadata: acmeserverless.Metadata{
-			Domain: acmeserverless.ShipmentDomain,
-			Source: "SendShipment",
-			Type:   acmeserverless.ShipmentSentEventName,
-			Status: acmeserverless.DefaultSuccessStatus,
-		},
-		Data: shipmentData,
+	eventType := workflow.EventType(detail)
+	if eventType != workflow.OrderCancelledEventName {
+		eventType = acmeserverless.ShipmentRequestedEventName
 	}
 
-	// Send a breadcrumb to Sentry with the shipment status
-	sentry.AddBreadcrumb(&sentry.Breadcrumb{
-		Category:  acmeserverless.ShipmentSentEventName,
-		Timestamp: time.Now(),
-		Level:     sentry.LevelInfo,
-		Data:      acmeserverless.ToSentryMap(evt.Data),
-	})
+	ctx, span := tracing.StartKind(ctx, eventType, trace.SpanKindConsumer, attribute.String("messaging.system", "aws_eventbridge"))
+	defer func() {
+		tracing.End(span, err)
+		if ferr := tp.Flush(context.Background()); ferr != nil {
+			slog.ErrorContext(ctx, "error flushing spans", logging.Err(ferr))
+		}
+	}()
+
+	if eventType == workflow.OrderCancelledEventName {
+		return handleCancellation(ctx, detail, eventID, fields)
+	}
 
-	// Create a new EventBridgee EventEmitter and send the event
-	em := eventbridge.New()
-	err = em.Send(evt)
+	// Unmarshal the ShipmentRequested event to a struct
+	_, uspan := tracing.Start(ctx, "UnmarshalShipmentRequested")
+	req, cause, err := decodeDetail(detail, eventID, fields)
+	tracing.End(uspan, err)
 	if err != nil {
-		return handleError("sending event", err)
+		return handleError(ctx, "unmarshaling shipment", err)
 	}
+	ctx = logging.With(ctx, logging.OrderNumber, req.Data.OrderID, logging.Carrier, req.Data.Delivery)
 
-	// Wait for the delivery
-	shipmentData = shipper.Delivered(shipmentData)
+	// Hand the shipments of the order to the shipper, keep track of them, so they can be
+	// cancelled until they are picked up, and send the events
+	parts, err := wf.ShipStoredOrder(ctx, shipments, req, cause)
+	if err != nil {
+		return handleError(ctx, "shipping order", err)
+	}
+	tagCorrelation(correlation.IDs{CausationID: parts[0].CausationID, CorrelationID: parts[0].CorrelationID})
 
-	// Create a new event with the new status of the shipment
-	evt = acmeserverless.ShipmentSent{
-		Metadata: acmeserverless.Metadata{
-			Domain: acmeserverless.ShipmentDomain,
-			Source: "SendShipment",
-			Type:   acmeserverless.ShipmentDeliveredEventName,
-			Status: acmeserverless.DefaultSuccessStatus,
-		},
-		Data: shipmentData,
+	// Wait for the deliveries and send the events with the new status of the shipments
+	if err := wf.DeliverOrder(ctx, shipments, parts); err != nil {
+		return handleError(ctx, "delivering order", err)
 	}
 
-	// Send a breadcrumb to Sentry with the shipment status
-	sentry.AddBreadcrumb(&sentry.Breadcrumb{
-		Category:  acmeserverless.ShipmentDeliveredEventName,
-		Timestamp: time.Now(),
-		Level:     sentry.LevelInfo,
-		Data:      acmeserverless.ToSentryMap(evt.Data),
-	})
+	sentry.CaptureMessage(fmt.Sprintf("order %s successfully delivered", req.Data.OrderID))
 
-	err = em.Send(evt)
+	return nil
+}
+
+// handleCancellation handles an OrderCancelled event, which cancels the shipments of the
+// order that weren't picked up yet, and returns an error if anything goes wrong.
+func handleCancellation(ctx context.Context, detail json.RawMessage, eventID string, fields map[string]json.RawMessage) error {
+	c, err := workflow.DecodeCancellation(detail)
 	if err != nil {
-		return handleError("sending event", err)
+		return handleError(ctx, "unmarshaling cancellation", err)
 	}
+	ctx = logging.With(ctx, logging.OrderNumber, c.Data.OrderID)
 
-	sentry.CaptureMessage(fmt.Sprintf("order %s successfully delivered", req.Data.OrderID))
+	cause := correlation.FromAttributes(stringMap(fields[correlation.Field]))
+	if cause.EventID == "" {
+		cause.EventID = eventID
+	}
+
+	if err := wf.CancelStoredOrder(ctx, shipments, c, cause); err != nil {
+		return handleError(ctx, "cancelling order", err)
+	}
 
 	return nil
 }
 
 // handleError takes the activity where the error occured and the error object and sends a message to sentry.
 // The original error is returned so it can be thrown.
-func handleError(activity string, err error) error {
-	log.Printf("error %s: %s", activity, err.Error())
+func handleError(ctx context.Context, activity string, err error) error {
+	slog.ErrorContext(ctx, "error "+activity, logging.Err(err))
 	sentry.CaptureException(fmt.Errorf("error %s: %s", activity, err.Error()))
 	return err
 }
 
+// tagCorrelation adds the causation and correlation ID to the errors and messages sent to Sentry.
+func tagCorrelation(ids correlation.IDs) {
+	sentry.ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetTag(correlation.CausationIDKey, ids.CausationID)
+		scope.SetTag(correlation.CorrelationIDKey, ids.CorrelationID)
+	})
+}
+
+// unwrap returns the detail of the EventBridge event and the ID of the event. The Lambda
+// function receives either the detail itself or, when the rule passes the full EventBridge
+// envelope, the envelope with the detail and the ID.
+func unwrap(request json.RawMessage) (json.RawMessage, string) {
+	var envelope struct {
+		ID         string          `json:"id"`
+		DetailType string          `json:"detail-type"`
+		Detail     json.RawMessage `json:"detail"`
+	}
+	if err := json.Unmarshal(request, &envelope); err != nil || len(envelope.Detail) == 0 || envelope.DetailType == "" {
+		return request, ""
+	}
+
+	return envelope.Detail, envelope.ID
+}
+
+// detailFields returns the fields of the detail of the EventBridge event, like the trace
+// context. A detail that can't be decoded has no fields.
+func detailFields(detail json.RawMessage) map[string]json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(detail, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// decodeDetail decodes and validates the ShipmentRequested event in the detail. The
+// event causes the events of the shipment, its ID is the one the sender set in the
+// detail or the ID EventBridge gave it.
+func decodeDetail(detail json.RawMessage, eventID string, fields map[string]json.RawMessage) (workflow.Request, correlation.IDs, error) {
+	req, err := workflow.DecodeRequest(detail)
+	if err != nil {
+		return req, correlation.IDs{}, err
+	}
+
+	cause := correlation.FromAttributes(stringMap(fields[correlation.Field]))
+	if cause.EventID == "" {
+		cause.EventID = eventID
+	}
+
+	return req, cause, nil
+}
+
+// stringMap decodes a field with string values, like the trace context. A field that is
+// missing or can't be decoded returns nil.
+func stringMap(field json.RawMessage) map[string]string {
+	var m map[string]string
+	if err := json.Unmarshal(field, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
 // The main method is executed by AWS Lambda and points to the handler
 func main() {
+	// Load the configuration and make sure all required values are set
+	var err error
+	cfg, err = config.LoadFor("lambda-shipment-eventbridge", "REGION", "EVENTBUS", "WAVEFRONT_URL", "WAVEFRONT_API_TOKEN")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Configure the logger, every log record is written as JSON to CloudWatch Logs
+	// without the personally identifiable information of customers
+	red, err = setup.NewLogger(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create the EventBridge EventEmitter used by every invocation
+	em, err := eventbridge.New(cfg.Region, cfg.EventBus)
+	if err != nil {
+		logging.Fatal("error creating EventBridge emitter", logging.Err(err))
+	}
+
+	// Configure the exporter of the spans
+	tp, err = setup.NewTracing(cfg, cfg.FunctionName)
+	if err != nil {
+		logging.Fatal("error configuring tracing", logging.Err(err))
+	}
+
+	// Create the workflow with the address provider, warehouses and carrier adapter of the
+	// configuration
+	wf, err = setup.NewWorkflow(cfg, em, "eventbridge", wfmetrics.New(metricPrefix))
+	if err != nil {
+		logging.Fatal("error configuring workflow", logging.Err(err))
+	}
+
+	// Create the store that keeps track of the shipments of every invocation
+	shipments, err = setup.NewStore(cfg)
+	if err != nil {
+		logging.Fatal("error configuring store", logging.Err(err))
+	}
+
 	lambda.Start(wflambda.Wrapper(handler))
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/mock"
+	"github.com/retgits/acme-serverless-shipment/internal/redact"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store/memory"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+)
+
+// setupFunction configures the function like main does, with a recording emitter and a
+// clock that makes the simulated deliveries take microseconds.
+func setupFunction(t *testing.T) *mock.Recorder {
+	t.Helper()
+
+	cfg = &config.Config{FunctionName: "lambda-shipment-eventbridge"}
+	red = redact.New(redact.DefaultRules, "test-key")
+
+	var err error
+	if tp, err = tracing.Init(tracing.Options{Service: cfg.FunctionName}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := mock.NewRecorder()
+	wf = workflow.New(rec, "mock", nil)
+	wf.SetClock(clock.Scaled(1e6))
+	shipments = memory.New()
+
+	return rec
+}
+
+// loadEvent reads a recorded EventBridge event from the testdata directory.
+func loadEvent(t testing.TB, name string) json.RawMessage {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		event         string
+		order         string
+		causationID   string
+		correlationID string
+	}{
+		{
+			name:          "full envelope",
+			event:         "envelope.json",
+			order:         "order-1",
+			causationID:   "3d8a2c1e-4f6b-4b7a-9a43-0e2f7f0a9c11",
+			correlationID: "9f1b6a3e-2c4d-4e8f-a1b2-c3d4e5f6a7b8",
+		},
+		{
+			name:  "detail only",
+			event: "detail.json",
+			order: "order-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := setupFunction(t)
+
+			if err := handler(context.Background(), loadEvent(t, tt.event)); err != nil {
+				t.Fatal(err)
+			}
+
+			records := rec.Records()
+			if len(records) != 2 {
+				t.Fatalf("got %d events, want 2", len(records))
+			}
+
+			want := []struct {
+				eventType string
+				status    string
+			}{
+				{acmeserverless.ShipmentSentEventName, shipper.StatusShipped},
+				{acmeserverless.ShipmentDeliveredEventName, shipper.StatusDelivered},
+			}
+
+			for i, r := range records {
+				if r.Metadata.Type != want[i].eventType || r.Data.Status != want[i].status {
+					t.Errorf("event %d is %s with status %q, want %s with status %q", i, r.Metadata.Type, r.Data.Status, want[i].eventType, want[i].status)
+				}
+				if r.Data.OrderNumber != tt.order {
+					t.Errorf("event %d is for order %q, want %q", i, r.Data.OrderNumber, tt.order)
+				}
+				if tt.causationID != "" && r.IDs.CausationID != tt.causationID {
+					t.Errorf("event %d is caused by %q, want %q", i, r.IDs.CausationID, tt.causationID)
+				}
+				if tt.correlationID != "" && r.IDs.CorrelationID != tt.correlationID {
+					t.Errorf("event %d has correlation ID %q, want %q", i, r.IDs.CorrelationID, tt.correlationID)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerContinuesTrace(t *testing.T) {
+	rec := setupFunction(t)
+
+	if err := handler(context.Background(), loadEvent(t, "envelope.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range rec.Records() {
+		if tp := r.TraceContext["traceparent"]; !strings.HasPrefix(tp, "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+			t.Errorf("%s event has trace context %q, want trace 4bf92f3577b34da6a3ce929d0e0e4736", r.Metadata.Type, tp)
+		}
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	errUnavailable := errors.New("bus unavailable")
+
+	tests := []struct {
+		name    string
+		event   string
+		failOn  int
+		wantErr error
+		events  int
+	}{
+		{name: "invalid detail", event: "invalid.json", events: 0},
+		{name: "shipment sent fails", event: "envelope.json", failOn: 1, wantErr: errUnavailable, events: 0},
+		{name: "shipment delivered fails", event: "envelope.json", failOn: 2, wantErr: errUnavailable, events: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := setupFunction(t)
+			if tt.failOn > 0 {
+				rec.FailOn(tt.failOn, tt.wantErr)
+			}
+
+			err := handler(context.Background(), loadEvent(t, tt.event))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.wantErr != nil && !strings.Contains(err.Error(), tt.wantErr.Error()) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got := len(rec.Records()); got != tt.events {
+				t.Errorf("got %d events, want %d", got, tt.events)
+			}
+		})
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    string
+		wantDetail string
+		wantID     string
+	}{
+		{"envelope", `{"id":"1","detail-type":"ShipmentRequested","detail":{"data":{}}}`, `{"data":{}}`, "1"},
+		{"detail", `{"metadata":{},"data":{}}`, `{"metadata":{},"data":{}}`, ""},
+		{"detail with a detail field", `{"detail":{"a":1},"data":{}}`, `{"detail":{"a":1},"data":{}}`, ""},
+		{"invalid JSON", `{`, `{`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detail, id := unwrap(json.RawMessage(tt.request))
+			if string(detail) != tt.wantDetail {
+				t.Errorf("got detail %s, want %s", detail, tt.wantDetail)
+			}
+			if id != tt.wantID {
+				t.Errorf("got ID %q, want %q", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestHandlerCancelsOrders(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus string
+		wantEvents int
+		wantErr    bool
+	}{
+		{name: "before pickup", body: `{"metadata":{"type":"OrderCancelled"},"data":{"_id":"order-1","reason":"customer request"}}`, wantStatus: shipper.StatusCancelled, wantEvents: 1},
+		{name: "other order", body: `{"metadata":{"type":"OrderCancelled"},"data":{"_id":"order-2"}}`, wantStatus: shipper.StatusShipped},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := setupFunction(t)
+
+			s := shipper.Shipment{PickupAt: wf.Now().Add(time.Hour), CorrelationID: "correlation-1"}
+			s.TrackingNumber = "1Z999AA10123456784"
+			s.OrderNumber = "order-1"
+			s.Status = shipper.StatusShipped
+			if err := shipments.Save(s); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler(context.Background(), json.RawMessage(tt.body)); (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+
+			stored, err := shipments.Get(s.TrackingNumber)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.wantStatus {
+				t.Errorf("got status %q, want %q", stored.Status, tt.wantStatus)
+			}
+
+			got := rec.OfType(workflow.ShipmentCancelledEventName)
+			if len(got) != tt.wantEvents {
+				t.Fatalf("got %d ShipmentCancelled events, want %d", len(got), tt.wantEvents)
+			}
+			if len(got) > 0 && got[0].IDs.CorrelationID != "correlation-1" {
+				t.Errorf("got correlation ID %q, want the one of the shipment", got[0].IDs.CorrelationID)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+// Package main is the tracking poller of the shipment service, which asks the carriers that
+// don't send tracking webhooks for the scans of the shipments that are in flight.
+//
+// The Shipping service is part of the [ACME Fitness Serverless Shop](https://github.com/retgits/acme-serverless).
+// The function runs on a schedule, polls the shipments that are due and sends the events of
+// the shipments that changed, like ShipmentDelivered when the carrier delivered a shipment,
+// to an EventBridge bus.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/getsentry/sentry-go"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/eventbridge"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	wfmetrics "github.com/retgits/acme-serverless-shipment/internal/metrics/wflambda"
+	"github.com/retgits/acme-serverless-shipment/internal/redact"
+	"github.com/retgits/acme-serverless-shipment/internal/setup"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/tracking"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+	wflambda "github.com/wavefronthq/wavefront-lambda-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// metricPrefix is the prefix of the metrics of the shipment workflow.
+	metricPrefix = "acmeserverless.lambda.shipment"
+)
+
+var (
+	// cfg is the configuration of the function, loaded once at startup.
+	cfg *config.Config
+
+	// wf is the shipment workflow, which sends the resulting events.
+	wf *workflow.Workflow
+
+	// shipments keeps track of the shipments the other functions created.
+	shipments store.Store
+
+	// poller asks the carriers for the scans of the shipments. It lives as long as the
+	// function instance, so the pacing and backoff of a carrier carry over to the next
+	// invocation the instance handles.
+	poller *tracking.Poller
+
+	// tp exports the spans at the end of every invocation.
+	tp *tracing.Provider
+
+	// red removes personally identifiable information from logs and Sentry.
+	red *redact.Redactor
+)
+
+// handler handles the scheduled event, which polls the carriers for the scans of the
+// shipments that are due, and returns an error if anything goes wrong. The events of the
+// shipments that changed are sent to an EventBridge bus.
+func handler(ctx context.Context, request json.RawMessage) (err error) {
+	// Initiialize a connection to Sentry to capture errors and traces
+	if err := setup.InitSentry(cfg, cfg.FunctionName, red); err != nil {
+		slog.ErrorContext(ctx, "error configuring sentry", logging.Err(err))
+	}
+
+	// Tag every log record of this invocation with the Lambda request ID
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		ctx = logging.With(ctx, logging.RequestID, lc.AwsRequestID)
+	}
+
+	ctx, span := tracing.StartKind(ctx, "PollCarriers", trace.SpanKindConsumer, attribute.String("messaging.system", "aws_eventbridge"))
+	defer func() {
+		tracing.End(span, err)
+		if ferr := tp.Flush(context.Background()); ferr != nil {
+			slog.ErrorContext(ctx, "error flushing spans", logging.Err(ferr))
+		}
+	}()
+
+	// Poll the shipments that are due, the ones that are left when the invocation times out
+	// are polled by the next one
+	res, err := poller.Poll(ctx)
+	slog.InfoContext(ctx, "carriers polled", "polled", res.Polled, "updated", res.Updated, "skipped", res.Skipped, "failed", res.Failed)
+	if err != nil {
+		return handleError(ctx, "polling carriers", err)
+	}
+
+	return nil
+}
+
+// update is the Update of the poller, which applies the scans the carrier reported to the
+// stored shipment, sends the events of the changes and then stores the shipment with the
+// moment it is polled again, so the scans are only stored once the other services know
+// about them. When another function stores the shipment at the same time, the scans are
+// applied to the shipment it stored.
+func update(ctx context.Context, trackingNumber string, scans []shipper.Scan, pollAt time.Time) error {
+	_, err := store.Update(shipments, trackingNumber, func(s shipper.Shipment) (shipper.Shipment, error) {
+		sctx := workflow.WithShipment(ctx, s)
+
+		var events []workflow.Event
+		for _, scan := range scans {
+			if shipper.HasScan(s, scan) {
+				continue
+			}
+
+			var evts []workflow.Event
+			s, evts = wf.Scanned(sctx, s, scan)
+			events = append(events, evts...)
+		}
+		s.PollAt = pollAt
+
+		// Tell the order service all parts of an order that was split are delivered
+		if len(events) > 0 && shipper.Done(s) && len(s.Parts) > 0 {
+			parts := make([]shipper.Shipment, len(s.Parts))
+			for i, tn := range s.Parts {
+				if tn == s.TrackingNumber {
+					parts[i] = s
+					continue
+				}
+				p, err := shipments.Get(tn)
+				if err != nil {
+					return s, fmt.Errorf("error loading part %s of order: %s", tn, err.Error())
+				}
+				parts[i] = p
+			}
+			if evt, ok := wf.OrderDelivered(sctx, parts); ok {
+				events = append(events, evt)
+			}
+		}
+
+		if err := wf.Emit(sctx, events...); err != nil {
+			return s, fmt.Errorf("error sending event: %s", err.Error())
+		}
+		return s, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating shipment: %s", err.Error())
+	}
+
+	return nil
+}
+
+// newPoller creates the poller of the Trackers with the intervals and limits of cfg.
+func newPoller(trackers []carrier.Tracker) *tracking.Poller {
+	return tracking.New(trackers, shipments, update, tracking.Options{
+		MinInterval: cfg.TrackingPollInterval,
+		MaxInterval: cfg.TrackingMaxInterval,
+		Rate:        cfg.TrackingRate,
+		MaxBackoff:  cfg.TrackingMaxBackoff,
+	})
+}
+
+// handleError takes the activity where the error occured and the error object and sends a message to sentry.
+// The original error is returned so it can be thrown.
+func handleError(ctx context.Context, activity string, err error) error {
+	slog.ErrorContext(ctx, "error "+activity, logging.Err(err))
+	sentry.CaptureException(fmt.Errorf("error %s: %s", activity, err.Error()))
+	return err
+}
+
+// The main method is executed by AWS Lambda and points to the handler
+func main() {
+	// Load the configuration and make sure all required values are set
+	var err error
+	cfg, err = config.LoadFor("lambda-shipment-poller", "REGION", "EVENTBUS", "STORE_TABLE", "CARRIER_TRACKING_URLS", "WAVEFRONT_URL", "WAVEFRONT_API_TOKEN")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Configure the logger, every log record is written as JSON to CloudWatch Logs
+	// without the personally identifiable information of customers
+	red, err = setup.NewLogger(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create the EventBridge EventEmitter used by every invocation
+	em, err := eventbridge.New(cfg.Region, cfg.EventBus)
+	if err != nil {
+		logging.Fatal("error creating EventBridge emitter", logging.Err(err))
+	}
+
+	// Configure the exporter of the spans
+	tp, err = setup.NewTracing(cfg, cfg.FunctionName)
+	if err != nil {
+		logging.Fatal("error configuring tracing", logging.Err(err))
+	}
+
+	wf = workflow.New(em, "eventbridge", wfmetrics.New(metricPrefix))
+
+	// Create the store with the shipments the other functions created, which is only
+	// shared with them by the dynamodb store
+	if cfg.Store != "dynamodb" {
+		logging.Fatal("the poller only sees the shipments of the other functions in a shared store, set STORE to dynamodb", "store", cfg.Store)
+	}
+	shipments, err = setup.NewStore(cfg)
+	if err != nil {
+		logging.Fatal("error configuring store", logging.Err(err))
+	}
+
+	// Poll the tracking APIs of the carriers
+	trackers, err := setup.NewTrackers(cfg, &http.Client{
+		Timeout: cfg.OrderTimeout,
+	})
+	if err != nil {
+		logging.Fatal("error configuring carrier tracking", logging.Err(err))
+	}
+	poller = newPoller(trackers)
+
+	lambda.Start(wflambda.Wrapper(handler))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/pull"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/webhook"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/mock"
+	"github.com/retgits/acme-serverless-shipment/internal/redact"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store/memory"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+)
+
+// setupFunction configures the function like main does, with a recording emitter and a
+// fake tracking API of USPS that reports the events of the tracking numbers.
+func setupFunction(t *testing.T, events map[string]string) *mock.Recorder {
+	t.Helper()
+
+	cfg = &config.Config{
+		FunctionName:         "lambda-shipment-poller",
+		TrackingPollInterval: time.Minute,
+		TrackingMaxInterval:  time.Hour,
+		TrackingRate:         100,
+		TrackingMaxBackoff:   15 * time.Minute,
+	}
+	red = redact.New(redact.DefaultRules, "test-key")
+
+	var err error
+	if tp, err = tracing.Init(tracing.Options{Service: cfg.FunctionName}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := mock.NewRecorder()
+	wf = workflow.New(rec, "mock", nil)
+	shipments = memory.New()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tn := strings.TrimPrefix(r.URL.Path, "/")
+		if events[tn] == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"trackingNumber":"` + tn + `","trackingEvents":[` + events[tn] + `]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	p, _ := webhook.Find("usps")
+	tr, err := pull.New(p, server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	poller = newPoller([]carrier.Tracker{tr})
+
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	// An order that was split in two shipments, of which the first was delivered
+	var parts []shipper.Shipment
+	for i := 0; i < 3; i++ {
+		s := shipper.Shipment{Carrier: "USPS", CreatedAt: time.Now().Add(-time.Hour), CausationID: "request-1", CorrelationID: "correlation-1"}
+		s.TrackingNumber = shipper.NewTrackingNumber("USPS")
+		s.OrderNumber = "order-1"
+		s.Status = shipper.StatusShipped
+		parts = append(parts, s)
+	}
+	parts[0].Status = shipper.StatusDelivered
+	parts[0].Parts = []string{parts[0].TrackingNumber, parts[1].TrackingNumber}
+	parts[1].Parts = parts[0].Parts
+	parts[2].OrderNumber = "order-2"
+
+	delivered := `{"eventCode":"01","eventTimestamp":"` + time.Now().UTC().Format(time.RFC3339) + `"}`
+	rec := setupFunction(t, map[string]string{parts[1].TrackingNumber: delivered})
+	for _, s := range parts {
+		if err := shipments.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second invocation finds the same scan, which isn't applied again
+	for i := 0; i < 2; i++ {
+		if err := handler(context.Background(), []byte(`{"source":"aws.events","detail-type":"Scheduled Event","detail":{}}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for _, evt := range rec.Records() {
+		got = append(got, evt.Metadata.Type+" "+evt.Data.TrackingNumber)
+		if evt.IDs.CorrelationID != "correlation-1" {
+			t.Errorf("got event %+v, want the IDs of the shipment", evt)
+		}
+	}
+	want := []string{
+		acmeserverless.ShipmentDeliveredEventName + " " + parts[1].TrackingNumber,
+		workflow.OrderDeliveredEventName + " " + parts[0].TrackingNumber + "," + parts[1].TrackingNumber,
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+
+	// The shipments that were polled are polled again later, the one that was delivered
+	// before isn't polled
+	for i, s := range parts {
+		stored, err := shipments.Get(s.TrackingNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if polled := !stored.PollAt.IsZero(); polled != (i > 0) {
+			t.Errorf("got shipment %d polled at %s, want it polled %t", i, stored.PollAt, i > 0)
+		}
+	}
+}
+
+func TestUpdateEmitFails(t *testing.T) {
+	rec := setupFunction(t, nil)
+
+	s := shipper.Shipment{Carrier: "USPS", CreatedAt: time.Now().Add(-time.Hour)}
+	s.TrackingNumber = shipper.NewTrackingNumber("USPS")
+	s.OrderNumber = "order-1"
+	s.Status = shipper.StatusShipped
+	if err := shipments.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	// The scan isn't stored when its events can't be sent, so it is applied at the next poll
+	rec.FailOn(1, errors.New("emitter down"))
+	scan := shipper.Scan{TrackingNumber: s.TrackingNumber, Event: shipper.ScanDelivered, At: time.Now()}
+	pollAt := time.Now().Add(time.Minute)
+	if err := update(context.Background(), s.TrackingNumber, []shipper.Scan{scan}, pollAt); err == nil {
+		t.Fatal("got no error, want the error of the emitter")
+	}
+	stored, err := shipments.Get(s.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != shipper.StatusShipped || len(stored.Scans) != 0 || !stored.PollAt.IsZero() {
+		t.Errorf("got shipment with status %q, scans %+v polled at %s, want it unchanged", stored.Status, stored.Scans, stored.PollAt)
+	}
+
+	if err := update(context.Background(), s.TrackingNumber, []shipper.Scan{scan}, pollAt); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = shipments.Get(s.TrackingNumber)
+	if stored.Status != shipper.StatusDelivered || len(rec.OfType(acmeserverless.ShipmentDeliveredEventName)) != 1 {
+		t.Errorf("got shipment with status %q and events %+v, want it delivered once", stored.Status, rec.Records())
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+func FuzzDecodeMessage(f *testing.F) {
+	// The recorded messages are the seed corpus
+	for _, name := range []string{"batch.json", "invalid.json"} {
+		for _, msg := range loadEvent(f, name).Records {
+			attrs := stringAttributes(msg.MessageAttributes)
+			f.Add(msg.Body, msg.MessageId, attrs[correlation.EventIDKey], attrs[correlation.CorrelationIDKey])
+		}
+	}
+	f.Add(`{}`, "", "", "")
+	f.Add(`null`, "message-1", "", "")
+
+	f.Fuzz(func(t *testing.T, body string, messageID string, eventID string, correlationID string) {
+		attrs := map[string]string{
+			correlation.EventIDKey:       eventID,
+			correlation.CorrelationIDKey: correlationID,
+		}
+
+		req, cause, err := decodeMessage(body, messageID, attrs)
+		if err != nil {
+			return
+		}
+
+		if err := shipper.Validate(req.Data.ShipmentRequest); err != nil {
+			t.Fatalf("decoded request %+v is invalid: %s", req.Data, err.Error())
+		}
+
+		want := eventID
+		if want == "" {
+			want = messageID
+		}
+		if cause.EventID != want {
+			t.Fatalf("got cause %q, want %q", cause.EventID, want)
+		}
+	})
+}
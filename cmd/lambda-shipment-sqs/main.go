@@ -6,116 +6,277 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"os"
-	"time"
+	"log/slog"
+	"sync"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/getsentry/sentry-go"
-	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
 	"github.com/retgits/acme-serverless-shipment/internal/emitter/sqs"
-	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	wfmetrics "github.com/retgits/acme-serverless-shipment/internal/metrics/wflambda"
+	"github.com/retgits/acme-serverless-shipment/internal/redact"
+	"github.com/retgits/acme-serverless-shipment/internal/setup"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
 	wflambda "github.com/wavefronthq/wavefront-lambda-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// handler handles the SQS events and returns an error if anything goes wrong.
-// The resulting event, if no error is thrown, is sent to an SQS queue.
-func handler(request events.SQSEvent) error {
+const (
+	// metricPrefix is the prefix of the metrics of the shipment workflow.
+	metricPrefix = "acmeserverless.lambda.shipment"
+)
+
+var (
+	// cfg is the configuration of the function, loaded once at startup.
+	cfg *config.Config
+
+	// wf is the shipment workflow, which sends the resulting events.
+	wf *workflow.Workflow
+
+	// shipments keeps track of the shipments, so an OrderCancelled message can cancel
+	// the shipments another message is delivering.
+	shipments store.Store
+
+	// tp exports the spans at the end of every invocation.
+	tp *tracing.Provider
+
+	// red removes personally identifiable information from logs and Sentry.
+	red *redact.Redactor
+)
+
+// handler handles the SQS events and reports the messages that couldn't be handled.
+// Every message of the batch is handled, at the same time, because each of them
+// waits for the delivery of its shipment. The messages are ShipmentRequested or
+// OrderCancelled events. The resulting events, if no error is thrown, are sent to
+// an SQS queue. Only the messages that failed are returned to the queue, so the
+// shipments of the others aren't shipped again.
+func handler(ctx context.Context, request events.SQSEvent) (events.SQSEventResponse, error) {
 	// Initiialize a connection to Sentry to capture errors and traces
-	sentry.Init(sentry.ClientOptions{
-		Dsn: os.Getenv("SENTRY_DSN"),
-		Transport: &sentry.HTTPSyncTransport{
-			Timeout: time.Second * 3,
-		},
-		ServerName:  os.Getenv("FUNCTION_NAME"),
-		Release:     os.Getenv("VERSION"),
-		Environment: os.Getenv("STAGE"),
-	})
+	if err := setup.InitSentry(cfg, cfg.FunctionName, red); err != nil {
+		slog.ErrorContext(ctx, "error configuring sentry", logging.Err(err))
+	}
 
-	// Unmarshal the ShipmentRequested event to a struct
-	req, err := acmeserverless.UnmarshalShipmentRequested([]byte(request.Records[0].Body))
-	if err != nil {
-		return handleError("unmarshaling shipment", err)
+	// Tag every log record of this invocation with the Lambda request ID
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		ctx = logging.With(ctx, logging.RequestID, lc.AwsRequestID)
 	}
 
-	// Send a breadcrumb to Sentry with the shipment request
-	sentry.AddBreadcrumb(&sentry.Breadcrumb{
-		Category:  acmeserverless.ShipmentRequestedEventName,
-		Timestamp: time.Now(),
-		Level:     sentry.LevelInfo,
-		Data:      acmeserverless.ToSentryMap(req.Data),
-	})
+	// Export the spans of all messages at the end of the invocation
+	defer func() {
+		if err := tp.Flush(context.Background()); err != nil {
+			slog.ErrorContext(ctx, "error flushing spans", logging.Err(err))
+		}
+	}()
 
-	shipmentData := shipper.Sent(req.Data)
+	errs := make([]error, len(request.Records))
 
-	evt := acmeserverless.ShipmentSent{
-		Metadata: acmeserverless.Metadata{
-			Domain: acmeserverless.ShipmentDomain,
-			Source: "SendShipment",
-			Type:   acmeserverless.ShipmentSentEventName,
-			Status: acmeserverless.DefaultSuccessStatus,
-		},
-		Data: shipmentData,
+	var wg sync.WaitGroup
+	for i := range request.Records {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = handleMessage(ctx, request.Records[i])
+		}(i)
 	}
+	wg.Wait()
 
-	// Send a breadcrumb to Sentry with the shipment status
-	sentry.AddBreadcrumb(&sentry.Breadcrumb{
-		Category:  acmeserverless.ShipmentSentEventName,
-		Timestamp: time.Now(),
-		Level:     sentry.LevelInfo,
-		Data:      acmeserverless.ToSentryMap(evt.Data),
-	})
+	failures := batchItemFailures(request.Records, errs)
+	if len(failures) > 0 {
+		slog.WarnContext(ctx, "messages returned to the queue", "failed", len(failures), "messages", len(request.Records))
+	}
+
+	return events.SQSEventResponse{BatchItemFailures: failures}, nil
+}
+
+// handleMessage handles a single ShipmentRequested message and returns an error if
+// anything goes wrong. An OrderCancelled message is handled by handleCancellation.
+func handleMessage(ctx context.Context, msg events.SQSMessage) (err error) {
+	// Every message has its own Sentry hub, so the tags of messages don't mix
+	ctx = sentry.SetHubOnContext(ctx, sentry.CurrentHub().Clone())
 
-	// Create a new SQS EventEmitter and send the event
-	em := sqs.New()
-	err = em.Send(evt)
+	// Continue the trace of the service that sent the request
+	attrs := stringAttributes(msg.MessageAttributes)
+	ctx = tracing.Extract(ctx, attrs)
+
+	if workflow.EventType([]byte(msg.Body)) == workflow.OrderCancelledEventName {
+		return handleCancellation(ctx, msg, attrs)
+	}
+
+	ctx, span := tracing.StartKind(ctx, "ShipmentRequested", trace.SpanKindConsumer,
+		attribute.String("messaging.system", "aws_sqs"),
+		attribute.String("messaging.message_id", msg.MessageId),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// Unmarshal the ShipmentRequested event to a struct
+	_, uspan := tracing.Start(ctx, "UnmarshalShipmentRequested")
+	req, cause, err := decodeMessage(msg.Body, msg.MessageId, attrs)
+	tracing.End(uspan, err)
 	if err != nil {
-		return handleError("sending event", err)
+		return handleError(ctx, "unmarshaling shipment", err)
 	}
+	ctx = logging.With(ctx, logging.OrderNumber, req.Data.OrderID, logging.Carrier, req.Data.Delivery)
 
-	// Wait for the delivery
-	shipmentData = shipper.Delivered(shipmentData)
+	// Hand the shipments of the order to the shipper, keep track of them, so they can be
+	// cancelled until they are picked up, and send the events
+	parts, err := wf.ShipStoredOrder(ctx, shipments, req, cause)
+	if err != nil {
+		return handleError(ctx, "shipping order", err)
+	}
+	tagCorrelation(ctx, correlation.IDs{CausationID: parts[0].CausationID, CorrelationID: parts[0].CorrelationID})
 
-	// Create a new event with the new status of the shipment
-	evt = acmeserverless.ShipmentSent{
-		Metadata: acmeserverless.Metadata{
-			Domain: acmeserverless.ShipmentDomain,
-			Source: "SendShipment",
-			Type:   acmeserverless.ShipmentDeliveredEventName,
-			Status: acmeserverless.DefaultSuccessStatus,
-		},
-		Data: shipmentData,
+	// Wait for the deliveries and send the events with the new status of the shipments
+	if err := wf.DeliverOrder(ctx, shipments, parts); err != nil {
+		return handleError(ctx, "delivering order", err)
 	}
 
-	// Send a breadcrumb to Sentry with the shipment status
-	sentry.AddBreadcrumb(&sentry.Breadcrumb{
-		Category:  acmeserverless.ShipmentDeliveredEventName,
-		Timestamp: time.Now(),
-		Level:     sentry.LevelInfo,
-		Data:      acmeserverless.ToSentryMap(evt.Data),
-	})
+	hub(ctx).CaptureMessage(fmt.Sprintf("order %s successfully delivered", req.Data.OrderID))
 
-	err = em.Send(evt)
+	return nil
+}
+
+// handleCancellation handles a single OrderCancelled message, which cancels the shipments
+// of the order that weren't picked up yet, and returns an error if anything goes wrong.
+func handleCancellation(ctx context.Context, msg events.SQSMessage, attrs map[string]string) (err error) {
+	ctx, span := tracing.StartKind(ctx, "OrderCancelled", trace.SpanKindConsumer,
+		attribute.String("messaging.system", "aws_sqs"),
+		attribute.String("messaging.message_id", msg.MessageId),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	c, err := workflow.DecodeCancellation([]byte(msg.Body))
 	if err != nil {
-		return handleError("sending event", err)
+		return handleError(ctx, "unmarshaling cancellation", err)
+	}
+	ctx = logging.With(ctx, logging.OrderNumber, c.Data.OrderID)
+
+	cause := correlation.FromAttributes(attrs)
+	if cause.EventID == "" {
+		cause.EventID = msg.MessageId
 	}
 
-	sentry.CaptureMessage(fmt.Sprintf("order %s successfully delivered", req.Data.OrderID))
+	if err := wf.CancelStoredOrder(ctx, shipments, c, cause); err != nil {
+		return handleError(ctx, "cancelling order", err)
+	}
 
 	return nil
 }
 
+// decodeMessage decodes and validates the ShipmentRequested event in the body of the
+// message. The message causes the events of the shipment, its ID is the one the sender
+// set as message attribute or the ID SQS gave it.
+func decodeMessage(body string, messageID string, attrs map[string]string) (workflow.Request, correlation.IDs, error) {
+	req, err := workflow.DecodeRequest([]byte(body))
+	if err != nil {
+		return req, correlation.IDs{}, err
+	}
+
+	cause := correlation.FromAttributes(attrs)
+	if cause.EventID == "" {
+		cause.EventID = messageID
+	}
+
+	return req, cause, nil
+}
+
+// batchItemFailures returns the IDs of the messages that couldn't be handled, which SQS
+// makes visible again when the event source mapping reports batch item failures.
+func batchItemFailures(records []events.SQSMessage, errs []error) []events.SQSBatchItemFailure {
+	var failures []events.SQSBatchItemFailure
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: records[i].MessageId})
+		}
+	}
+	return failures
+}
+
 // handleError takes the activity where the error occured and the error object and sends a message to sentry.
 // The original error is returned so it can be thrown.
-func handleError(activity string, err error) error {
-	log.Printf("error %s: %s", activity, err.Error())
-	sentry.CaptureException(fmt.Errorf("error %s: %s", activity, err.Error()))
+func handleError(ctx context.Context, activity string, err error) error {
+	slog.ErrorContext(ctx, "error "+activity, logging.Err(err))
+	hub(ctx).CaptureException(fmt.Errorf("error %s: %s", activity, err.Error()))
 	return err
 }
 
+// tagCorrelation adds the causation and correlation ID to the errors and messages sent to Sentry.
+func tagCorrelation(ctx context.Context, ids correlation.IDs) {
+	hub(ctx).ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetTag(correlation.CausationIDKey, ids.CausationID)
+		scope.SetTag(correlation.CorrelationIDKey, ids.CorrelationID)
+	})
+}
+
+// hub returns the Sentry hub of the message that is handled.
+func hub(ctx context.Context) *sentry.Hub {
+	if h := sentry.GetHubFromContext(ctx); h != nil {
+		return h
+	}
+	return sentry.CurrentHub()
+}
+
+// stringAttributes returns the message attributes of the SQS message that have a string
+// value, like the trace context and the correlation IDs.
+func stringAttributes(attrs map[string]events.SQSMessageAttribute) map[string]string {
+	values := make(map[string]string, len(attrs))
+	for key, attr := range attrs {
+		if attr.StringValue != nil {
+			values[key] = *attr.StringValue
+		}
+	}
+	return values
+}
+
 // The main method is executed by AWS Lambda and points to the handler
 func main() {
+	// Load the configuration and make sure all required values are set
+	var err error
+	cfg, err = config.LoadFor("lambda-shipment-sqs", "REGION", "RESPONSEQUEUE", "WAVEFRONT_URL", "WAVEFRONT_API_TOKEN")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Configure the logger, every log record is written as JSON to CloudWatch Logs
+	// without the personally identifiable information of customers
+	red, err = setup.NewLogger(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create the SQS EventEmitter used by every invocation
+	em, err := sqs.New(cfg.Region, cfg.ResponseQueue)
+	if err != nil {
+		logging.Fatal("error creating SQS emitter", logging.Err(err))
+	}
+
+	// Configure the exporter of the spans
+	tp, err = setup.NewTracing(cfg, cfg.FunctionName)
+	if err != nil {
+		logging.Fatal("error configuring tracing", logging.Err(err))
+	}
+
+	// Create the workflow with the address provider, warehouses and carrier adapter of the
+	// configuration
+	wf, err = setup.NewWorkflow(cfg, em, "sqs", wfmetrics.New(metricPrefix))
+	if err != nil {
+		logging.Fatal("error configuring workflow", logging.Err(err))
+	}
+
+	// Create the store that keeps track of the shipments of every invocation
+	shipments, err = setup.NewStore(cfg)
+	if err != nil {
+		logging.Fatal("error configuring store", logging.Err(err))
+	}
+
 	lambda.Start(wflambda.Wrapper(handler))
 }
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/mock"
+	"github.com/retgits/acme-serverless-shipment/internal/redact"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store/memory"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+)
+
+// setupFunction configures the function like main does, with a recording emitter and a clock
+// that makes the simulated deliveries take microseconds.
+func setupFunction(t *testing.T) *mock.Recorder {
+	t.Helper()
+
+	cfg = &config.Config{FunctionName: "lambda-shipment-sqs"}
+	red = redact.New(redact.DefaultRules, "test-key")
+
+	var err error
+	if tp, err = tracing.Init(tracing.Options{Service: cfg.FunctionName}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := mock.NewRecorder()
+	wf = workflow.New(rec, "mock", nil)
+	wf.SetClock(clock.Scaled(1e6))
+	shipments = memory.New()
+
+	return rec
+}
+
+// loadEvent reads a recorded SQS event from the testdata directory.
+func loadEvent(t testing.TB, name string) events.SQSEvent {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var evt events.SQSEvent
+	if err := json.Unmarshal(b, &evt); err != nil {
+		t.Fatal(err)
+	}
+	return evt
+}
+
+// orderNumbers returns the sorted order numbers of the records.
+func orderNumbers(records []mock.Record) []string {
+	orders := make([]string, 0, len(records))
+	for _, r := range records {
+		orders = append(orders, r.Data.OrderNumber)
+	}
+	sort.Strings(orders)
+	return orders
+}
+
+func TestHandlerHandlesAllRecords(t *testing.T) {
+	rec := setupFunction(t)
+	request := loadEvent(t, "batch.json")
+
+	resp, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("got failed messages %v, want none", resp.BatchItemFailures)
+	}
+
+	tests := []struct {
+		eventType string
+		status    string
+	}{
+		{acmeserverless.ShipmentSentEventName, shipper.StatusShipped},
+		{acmeserverless.ShipmentDeliveredEventName, shipper.StatusDelivered},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.eventType, func(t *testing.T) {
+			got := rec.OfType(tt.eventType)
+			if orders := orderNumbers(got); strings.Join(orders, ",") != "order-1,order-2" {
+				t.Fatalf("got events for orders %v, want order-1 and order-2", orders)
+			}
+			for _, r := range got {
+				if r.Data.Status != tt.status {
+					t.Errorf("order %s has status %q, want %q", r.Data.OrderNumber, r.Data.Status, tt.status)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerCorrelatesEvents(t *testing.T) {
+	rec := setupFunction(t)
+	request := loadEvent(t, "batch.json")
+
+	resp, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("got failed messages %v, want none", resp.BatchItemFailures)
+	}
+
+	tests := []struct {
+		order         string
+		causationID   string
+		correlationID string
+	}{
+		// The sender set the event and correlation ID as message attributes
+		{"order-1", "3d8a2c1e-4f6b-4b7a-9a43-0e2f7f0a9c11", "9f1b6a3e-2c4d-4e8f-a1b2-c3d4e5f6a7b8"},
+		// The sender didn't, so the ID SQS gave the message causes the events
+		{"order-2", "2e1424d4-f796-459a-8184-9c92662be6da", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			var sent, delivered *mock.Record
+			for _, r := range rec.Records() {
+				r := r
+				if r.Data.OrderNumber != tt.order {
+					continue
+				}
+				switch r.Metadata.Type {
+				case acmeserverless.ShipmentSentEventName:
+					sent = &r
+				case acmeserverless.ShipmentDeliveredEventName:
+					delivered = &r
+				}
+			}
+			if sent == nil || delivered == nil {
+				t.Fatalf("missing events for %s", tt.order)
+			}
+
+			if sent.IDs.CausationID != tt.causationID {
+				t.Errorf("got causation ID %q, want %q", sent.IDs.CausationID, tt.causationID)
+			}
+			if tt.correlationID != "" && sent.IDs.CorrelationID != tt.correlationID {
+				t.Errorf("got correlation ID %q, want %q", sent.IDs.CorrelationID, tt.correlationID)
+			}
+			if delivered.IDs.CorrelationID != sent.IDs.CorrelationID {
+				t.Errorf("delivered event has correlation ID %q, want %q", delivered.IDs.CorrelationID, sent.IDs.CorrelationID)
+			}
+			if delivered.IDs.CausationID != tt.causationID {
+				t.Errorf("delivered event is caused by %q, want %q", delivered.IDs.CausationID, tt.causationID)
+			}
+		})
+	}
+}
+
+func TestHandlerReportsFailedRecords(t *testing.T) {
+	rec := setupFunction(t)
+	request := loadEvent(t, "invalid.json")
+
+	resp, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the invalid message is returned to the queue
+	want := []events.SQSBatchItemFailure{{ItemIdentifier: "c0ffee00-1234-4abc-8def-000000000bad"}}
+	if !reflect.DeepEqual(resp.BatchItemFailures, want) {
+		t.Errorf("got failed messages %v, want %v", resp.BatchItemFailures, want)
+	}
+
+	// The valid message of the batch is still handled
+	if orders := orderNumbers(rec.OfType(acmeserverless.ShipmentDeliveredEventName)); strings.Join(orders, ",") != "order-3" {
+		t.Errorf("got deliveries for orders %v, want order-3", orders)
+	}
+}
+
+func TestHandlerEmitErrors(t *testing.T) {
+	errUnavailable := errors.New("queue unavailable")
+
+	tests := []struct {
+		name      string
+		failOn    int
+		wantTypes []string
+	}{
+		{"shipment sent", 1, nil},
+		{"shipment delivered", 2, []string{acmeserverless.ShipmentSentEventName}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := setupFunction(t)
+			rec.FailOn(tt.failOn, errUnavailable)
+
+			request := loadEvent(t, "batch.json")
+			request.Records = request.Records[:1]
+
+			resp, err := handler(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.BatchItemFailures) != 1 || resp.BatchItemFailures[0].ItemIdentifier != request.Records[0].MessageId {
+				t.Fatalf("got failed messages %v, want %s", resp.BatchItemFailures, request.Records[0].MessageId)
+			}
+
+			var types []string
+			for _, r := range rec.Records() {
+				types = append(types, r.Metadata.Type)
+			}
+			if strings.Join(types, ",") != strings.Join(tt.wantTypes, ",") {
+				t.Errorf("got events %v, want %v", types, tt.wantTypes)
+			}
+		})
+	}
+}
+
+func TestBatchItemFailures(t *testing.T) {
+	records := []events.SQSMessage{{MessageId: "1"}, {MessageId: "2"}, {MessageId: "3"}}
+
+	tests := []struct {
+		name string
+		errs []error
+		want []string
+	}{
+		{"no errors", make([]error, 3), nil},
+		{"one error", []error{nil, errors.New("broken"), nil}, []string{"2"}},
+		{"all errors", []error{errors.New("a"), errors.New("b"), errors.New("c")}, []string{"1", "2", "3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range batchItemFailures(records, tt.errs) {
+				got = append(got, f.ItemIdentifier)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got failed messages %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringAttributes(t *testing.T) {
+	value := "value"
+	attrs := map[string]events.SQSMessageAttribute{
+		"string": {DataType: "String", StringValue: &value},
+		"binary": {DataType: "Binary", BinaryValue: []byte("value")},
+	}
+
+	got := stringAttributes(attrs)
+	if len(got) != 1 || got["string"] != value {
+		t.Errorf("got %v, want only the string attribute", got)
+	}
+
+	if ids := correlation.FromAttributes(stringAttributes(nil)); ids != (correlation.IDs{}) {
+		t.Errorf("got IDs %+v from no attributes", ids)
+	}
+}
+
+func TestHandlerCancelsOrders(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus string
+		wantEvents int
+		wantFailed bool
+	}{
+		{name: "before pickup", body: `{"metadata":{"type":"OrderCancelled"},"data":{"_id":"order-1","reason":"customer request"}}`, wantStatus: shipper.StatusCancelled, wantEvents: 1},
+		{name: "other order", body: `{"metadata":{"type":"OrderCancelled"},"data":{"_id":"order-2"}}`, wantStatus: shipper.StatusShipped},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := setupFunction(t)
+
+			s := shipper.Shipment{PickupAt: wf.Now().Add(time.Hour), CorrelationID: "correlation-1"}
+			s.TrackingNumber = "1Z999AA10123456784"
+			s.OrderNumber = "order-1"
+			s.Status = shipper.StatusShipped
+			if err := shipments.Save(s); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := handler(context.Background(), events.SQSEvent{Records: []events.SQSMessage{{MessageId: "message-1", Body: tt.body}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if failed := len(resp.BatchItemFailures) > 0; failed != tt.wantFailed {
+				t.Fatalf("got failed messages %v, want a failure %t", resp.BatchItemFailures, tt.wantFailed)
+			}
+
+			stored, err := shipments.Get(s.TrackingNumber)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.wantStatus {
+				t.Errorf("got status %q, want %q", stored.Status, tt.wantStatus)
+			}
+
+			got := rec.OfType(workflow.ShipmentCancelledEventName)
+			if len(got) != tt.wantEvents {
+				t.Fatalf("got %d ShipmentCancelled events, want %d", len(got), tt.wantEvents)
+			}
+			if len(got) > 0 && got[0].IDs.CorrelationID != "correlation-1" {
+				t.Errorf("got correlation ID %q, want the one of the shipment", got[0].IDs.CorrelationID)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/label"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+	"github.com/valyala/fasthttp"
+)
+
+// queued is the response of SendShipment.
+type queued struct {
+	MessageID string `json:"messageId"`
+}
+
+// SendShipment puts the ShipmentRequested or OrderCancelled event in the request body on
+// the request queue, like the order service does with SQS. The trace context and
+// correlation IDs of the request are sent as message attributes.
+func SendShipment(ctx *fasthttp.RequestCtx) {
+	// Reject invalid events, addresses and items right away, instead of only logging them in the worker
+	if err := validateMessage(ctx, ctx.Request.Body()); err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	attrs := correlation.FromHeaders(func(key string) string {
+		return string(ctx.Request.Header.Peek(key))
+	}).Attributes()
+	for _, key := range tracing.Fields() {
+		if value := ctx.Request.Header.Peek(key); len(value) > 0 {
+			attrs[key] = string(value)
+		}
+	}
+
+	m := message{
+		ID:         correlation.NewID(),
+		Body:       append([]byte(nil), ctx.Request.Body()...),
+		Attributes: attrs,
+	}
+
+	switch err := requests.Send(m); err {
+	case nil:
+	case errQueueFull, errQueueClosed:
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.SetBodyString(err.Error())
+		return
+	default:
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	writeJSON(ctx, http.StatusAccepted, queued{MessageID: m.ID})
+}
+
+// validateMessage checks the ShipmentRequested or OrderCancelled event in the body, and
+// the address and items of a ShipmentRequested event.
+func validateMessage(ctx context.Context, body []byte) error {
+	if workflow.EventType(body) == workflow.OrderCancelledEventName {
+		_, err := workflow.DecodeCancellation(body)
+		return err
+	}
+
+	req, err := workflow.DecodeRequest(body)
+	if err != nil {
+		return err
+	}
+
+	var verr *address.ValidationError
+	if _, err := wf.ValidateAddress(ctx, req.Data); errors.As(err, &verr) {
+		return err
+	}
+
+	if len(req.Data.Items) > 0 {
+		if _, err := warehouse.Assign(warehouses, req.Data.Items, req.Data.Parcels); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cancelRequest is the optional body of CancelShipment.
+type cancelRequest struct {
+	// Reason is why the shipment is cancelled.
+	Reason string `json:"reason"`
+}
+
+// CancelShipment cancels the shipment with the tracking number in the path, when the carrier
+// hasn't picked it up yet on the simulated clock, and returns the ShipmentCancelled event,
+// which is sent to the order service too.
+func CancelShipment(ctx *fasthttp.RequestCtx) {
+	trackingNumber, _ := ctx.UserValue("trackingNumber").(string)
+
+	var req cancelRequest
+	if len(ctx.Request.Body()) > 0 {
+		if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+			ctx.SetStatusCode(http.StatusBadRequest)
+			ctx.SetBodyString(fmt.Sprintf("invalid cancel request: %s", err.Error()))
+			return
+		}
+	}
+
+	s, err := shipments.Get(trackingNumber)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.SetBodyString(err.Error())
+		return
+	default:
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	if err := shipper.ValidateCancellation(s.OrderNumber, trackingNumber, req.Reason); err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf("invalid cancel request: %s", err.Error()))
+		return
+	}
+
+	cause := correlation.FromHeaders(func(key string) string {
+		return string(ctx.Request.Header.Peek(key))
+	})
+	s, evt, err := wf.Cancel(ctx, s, req.Reason, cause)
+	switch err {
+	case nil:
+	case shipper.ErrPickedUp, shipper.ErrCancelled:
+		ctx.SetStatusCode(http.StatusConflict)
+		ctx.SetBodyString(err.Error())
+		return
+	default:
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	// The worker that waits for the delivery skips the cancelled shipment
+	if err := shipments.Save(s); err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	if err := wf.Emit(ctx, evt); err != nil {
+		slog.ErrorContext(ctx, "error sending cancellation", logging.Err(err))
+	}
+
+	writeJSON(ctx, http.StatusOK, evt.ShipmentSent)
+}
+
+// statusRequest is the body of SetShipmentStatus.
+type statusRequest struct {
+	// Status is the status the shipment is forced to.
+	Status string `json:"status"`
+
+	// Emit sends the event for the new status before the shipment is stored.
+	Emit bool `json:"emit"`
+}
+
+// SetShipmentStatus forces the status of the shipment with the tracking number in the path
+// and returns the stored shipment. With emit in the request body, the event for the new
+// status is sent to the order service first. shipmentctl set-status uses it, so the status
+// is changed by the service that owns the store instead of behind its back.
+func SetShipmentStatus(ctx *fasthttp.RequestCtx) {
+	trackingNumber, _ := ctx.UserValue("trackingNumber").(string)
+
+	var req statusRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf("invalid status request: %s", err.Error()))
+		return
+	}
+	if !isStatus(req.Status) {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf("invalid status %q, use %q", req.Status, shipper.Statuses))
+		return
+	}
+	if req.Emit {
+		var probe shipper.Shipment
+		probe.Status = req.Status
+		if _, err := workflow.EventFor(probe); err != nil {
+			ctx.SetStatusCode(http.StatusBadRequest)
+			ctx.SetBodyString(err.Error())
+			return
+		}
+	}
+
+	s, err := store.Update(shipments, trackingNumber, func(s shipper.Shipment) (shipper.Shipment, error) {
+		s.Status = req.Status
+		if !req.Emit {
+			return s, nil
+		}
+
+		evt, err := workflow.EventFor(s)
+		if err != nil {
+			return s, err
+		}
+		if err := wf.Emit(ctx, evt); err != nil {
+			return s, fmt.Errorf("error sending %s event: %s", evt.Metadata.Type, err.Error())
+		}
+		return s, nil
+	})
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.SetBodyString(err.Error())
+		return
+	default:
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	slog.InfoContext(ctx, "forced status of shipment", logging.TrackingNumber, trackingNumber, "status", req.Status, "emitted", req.Emit)
+	writeJSON(ctx, http.StatusOK, s)
+}
+
+// isStatus returns whether the status is one of the statuses of a shipment.
+func isStatus(status string) bool {
+	for _, s := range shipper.Statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ListShipments returns all shipments, in the order they were created.
+func ListShipments(ctx *fasthttp.RequestCtx) {
+	list, err := shipments.List()
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	writeJSON(ctx, http.StatusOK, list)
+}
+
+// GetShipment returns the shipment with the tracking number in the path.
+func GetShipment(ctx *fasthttp.RequestCtx) {
+	trackingNumber, _ := ctx.UserValue("trackingNumber").(string)
+
+	s, err := shipments.Get(trackingNumber)
+	switch err {
+	case nil:
+		writeJSON(ctx, http.StatusOK, s)
+	case store.ErrNotFound:
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.SetBodyString(err.Error())
+	default:
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetBodyString(err.Error())
+	}
+}
+
+// ShipmentLabel returns the shipping label of the shipment with the tracking number in
+// the path, as PDF or, with format=zpl, as ZPL.
+func ShipmentLabel(ctx *fasthttp.RequestCtx) {
+	trackingNumber, _ := ctx.UserValue("trackingNumber").(string)
+
+	format := string(ctx.QueryArgs().Peek("format"))
+	if format == "" {
+		format = label.FormatPDF
+	}
+
+	s, err := shipments.Get(trackingNumber)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.SetBodyString(err.Error())
+		return
+	default:
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	b, err := label.Render(label.For(s, warehouses), format)
+	if err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	ctx.SetContentType(label.ContentType(format))
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(b)
+}
+
+// rates is the response of QuoteRates.
+type rates struct {
+	Quotes []shipper.Quote `json:"quotes"`
+}
+
+// QuoteRates returns the quotes of every registered carrier for the package in the
+// request body, with delivery dates on the simulated clock.
+func QuoteRates(ctx *fasthttp.RequestCtx) {
+	var req shipper.RateRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf("invalid rate request: %s", err.Error()))
+		return
+	}
+
+	quotes, err := quoter.Quote(ctx, req, wf.Now())
+	if err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf("invalid rate request: %s", err.Error()))
+		return
+	}
+
+	writeJSON(ctx, http.StatusOK, rates{Quotes: quotes})
+}
+
+// ValidateAddress validates and normalizes the address in the request body, and lists
+// the problems of an address that can't be delivered to.
+func ValidateAddress(ctx *fasthttp.RequestCtx) {
+	var a address.Address
+	if err := json.Unmarshal(ctx.Request.Body(), &a); err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf("invalid address: %s", err.Error()))
+		return
+	}
+
+	res, err := addresses.Validate(ctx, a)
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	writeJSON(ctx, http.StatusOK, res)
+}
+
+// HealthHandler reports that the server is alive.
+func HealthHandler(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.SetBodyString("ok")
+}
+
+// writeJSON writes the value as a JSON response with the status code.
+func writeJSON(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(status)
+	ctx.Write(b)
+}
@@ -0,0 +1,204 @@
+// Package main is an all-in-one version of the Shipment service for local development.
+//
+// It runs an HTTP API that puts ShipmentRequested events on an in-memory request queue,
+// which feeds the same shipment workflow as the Lambda functions. Events are sent with
+// the mock emitter, which logs them, or with the file emitter, which appends them to a
+// file. The simulated delivery runs on a clock that is faster than real time, so the
+// whole lifecycle of a shipment can be exercised offline in seconds.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/fasthttp/router"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	prommetrics "github.com/retgits/acme-serverless-shipment/internal/metrics/prometheus"
+	"github.com/retgits/acme-serverless-shipment/internal/ratetable"
+	"github.com/retgits/acme-serverless-shipment/internal/setup"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	servicename = "shipment-local"
+)
+
+// localDefaults are the defaults of shipment-local that differ from the other binaries,
+// so it runs offline without any configuration.
+var localDefaults = map[string]string{
+	"EMITTER":    "mock",
+	"LOG_FORMAT": "text",
+}
+
+var (
+	// cfg is the configuration of the service, loaded once at startup.
+	cfg *config.Config
+
+	// shipments keeps track of all shipments the service created.
+	shipments store.Store
+
+	// requests is the in-memory request queue, which plays the role of SQS.
+	requests *queue
+
+	// wf is the shipment workflow, which sends the resulting events.
+	wf *workflow.Workflow
+
+	// quoter quotes the price of shipping a package with every carrier.
+	quoter *shipper.Quoter
+
+	// addresses validates and normalizes the addresses shipments are delivered to.
+	addresses address.Provider
+
+	// warehouses are the warehouses orders are shipped from.
+	warehouses []warehouse.Warehouse
+)
+
+// routes returns the router with the HTTP API of the service, with the metrics in the
+// registry.
+func routes(registry *prometheus.Registry) *router.Router {
+	r := router.New()
+	r.POST("/ship", SendShipment)
+	r.GET("/shipments", ListShipments)
+	r.GET("/shipments/{trackingNumber}", GetShipment)
+	r.POST("/shipments/{trackingNumber}/status", SetShipmentStatus)
+	r.GET("/ship/{trackingNumber}", GetShipment)
+	r.GET("/ship/{trackingNumber}/label", ShipmentLabel)
+	r.POST("/ship/{trackingNumber}/cancel", CancelShipment)
+	r.POST("/rates", QuoteRates)
+	r.POST("/addresses/validate", ValidateAddress)
+	r.GET("/healthz", HealthHandler)
+	r.GET("/metrics", prommetrics.Handler(registry))
+	return r
+}
+
+func main() {
+	// Load the configuration and make sure all values the emitter needs are set
+	var err error
+	cfg, err = config.LoadWithDefaults(localDefaults)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	required, err := setup.EmitterRequires(cfg.Emitter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := cfg.Validate(servicename, required...); err != nil {
+		log.Fatal(err)
+	}
+
+	// Configure the logger without the personally identifiable information of customers
+	if _, err := setup.NewLogger(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	// Configure the exporter of the spans
+	tp, err := setup.NewTracing(cfg, servicename)
+	if err != nil {
+		logging.Fatal("error configuring tracing", logging.Err(err))
+	}
+
+	// Configure the metrics of the shipment workflow
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector())
+	rec, err := prommetrics.New(registry)
+	if err != nil {
+		logging.Fatal("error configuring metrics", logging.Err(err))
+	}
+
+	// Create the store, the emitter and the workflow on the simulated clock
+	shipments, err = setup.NewStore(cfg)
+	if err != nil {
+		logging.Fatal("error configuring store", logging.Err(err))
+	}
+
+	em, err := setup.NewEmitter(cfg, &http.Client{
+		Timeout: cfg.OrderTimeout,
+	})
+	if err != nil {
+		logging.Fatal("error configuring emitter", "emitter", cfg.Emitter, logging.Err(err))
+	}
+
+	wf, err = setup.NewWorkflow(cfg, em, cfg.Emitter, rec)
+	if err != nil {
+		logging.Fatal("error configuring workflow", logging.Err(err))
+	}
+	wf.SetClock(clock.Scaled(cfg.ClockSpeed))
+	addresses = wf.Addresses()
+	warehouses = wf.Warehouses()
+
+	// Start the workers of the request queue
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Create the carriers that quote rates, and reload their rate tables when they change
+	var rateTables *ratetable.Reloader
+	quoter, rateTables, err = setup.NewQuoter(cfg)
+	if err != nil {
+		logging.Fatal("error configuring rate tables", logging.Err(err))
+	}
+	if rateTables != nil && cfg.RateTablesReloadInterval > 0 {
+		go rateTables.Watch(ctx, cfg.RateTablesReloadInterval)
+	}
+
+	requests = newQueue(cfg.DeliveryQueueSize)
+	requests.Start(ctx, cfg.DeliveryWorkers, handleMessage)
+
+	server := &fasthttp.Server{
+		Handler: routes(registry).Handler,
+		Name:    servicename,
+	}
+
+	// Start the server
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe(fmt.Sprintf(":%d", cfg.Port))
+	}()
+	slog.Info("successfully started server", "service", servicename, "port", cfg.Port, "emitter", cfg.Emitter, "store", cfg.Store, "clockSpeed", cfg.ClockSpeed)
+
+	// Wait for the user to stop the service
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	select {
+	case err := <-errs:
+		logging.Fatal("error running server", logging.Err(err))
+	case sig := <-signals:
+		slog.Info("shutting down server", "signal", sig.String(), "service", servicename)
+	}
+
+	// Stop accepting requests and give the queued shipments the grace period to be delivered
+	requests.Close()
+	if err := server.Shutdown(); err != nil {
+		slog.Error("error shutting down server", logging.Err(err))
+	}
+
+	grace, cancelGrace := context.WithTimeout(context.Background(), cfg.ShutdownGracePeriod)
+	defer cancelGrace()
+
+	if !requests.Wait(grace) {
+		slog.Warn("shipments abandoned, they were not delivered within the grace period", "queued", requests.Len())
+		cancel()
+		requests.Wait(context.Background())
+	}
+
+	if err := tp.Shutdown(context.Background()); err != nil {
+		slog.Error("error exporting spans", logging.Err(err))
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/address/rules"
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/mock"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store/memory"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+const shipmentRequested = `{"metadata":{"domain":"Order","source":"CreateOrder","type":"ShipmentRequested","status":"success"},` +
+	`"data":{"_id":"order-1","delivery":"UPS/FedEx"}}`
+
+// testService runs the routes of the service on an in-memory listener.
+type testService struct {
+	rec    *mock.Recorder
+	client *fasthttp.Client
+}
+
+// newTestService configures the service like main does, with a recording emitter, the
+// memory store and a clock that runs fast enough to deliver a shipment in milliseconds,
+// and serves its routes in memory.
+func newTestService(t *testing.T) *testService {
+	t.Helper()
+
+	cfg = &config.Config{Emitter: "mock", Store: "memory"}
+	shipments = memory.New()
+
+	rec := mock.NewRecorder()
+	wf = workflow.New(rec, "mock", nil)
+	wf.SetClock(clock.Scaled(1e8))
+
+	addresses = rules.New()
+	wf.SetAddressProvider(addresses, false)
+
+	var err error
+	if warehouses, err = warehouse.Default(); err != nil {
+		t.Fatal(err)
+	}
+	wf.SetWarehouses(warehouses)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	requests = newQueue(10)
+	requests.Start(ctx, 1, handleMessage)
+
+	ln := fasthttputil.NewInmemoryListener()
+	server := &fasthttp.Server{Handler: routes(prometheus.NewRegistry()).Handler}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		requests.Close()
+		requests.Wait(context.Background())
+		cancel()
+		ln.Close()
+	})
+
+	return &testService{
+		rec: rec,
+		client: &fasthttp.Client{
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		},
+	}
+}
+
+// do sends the request to the service and returns the response.
+func (s *testService) do(t *testing.T, method string, path string, body string) *fasthttp.Response {
+	t.Helper()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.Header.SetMethod(method)
+	req.SetRequestURI("http://shipment-local" + path)
+	req.SetBodyString(body)
+
+	res := &fasthttp.Response{}
+	if err := s.client.DoTimeout(req, res, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestSendShipment(t *testing.T) {
+	s := newTestService(t)
+
+	res := s.do(t, http.MethodPost, "/ship", shipmentRequested)
+	if res.StatusCode() != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", res.StatusCode(), http.StatusAccepted, res.Body())
+	}
+
+	// The worker ships and delivers the shipment on the fast clock
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	delivered, err := s.rec.WaitForType(ctx, acmeserverless.ShipmentDeliveredEventName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trackingNumber := delivered[0].Data.TrackingNumber
+
+	res = s.do(t, http.MethodGet, "/shipments/"+trackingNumber, "")
+	var got shipper.Shipment
+	if err := json.Unmarshal(res.Body(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.OrderNumber != "order-1" || got.Status != shipper.StatusDelivered {
+		t.Errorf("got shipment %+v, want order-1 delivered", got)
+	}
+
+	res = s.do(t, http.MethodGet, "/shipments", "")
+	var list []shipper.Shipment
+	if err := json.Unmarshal(res.Body(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].TrackingNumber != trackingNumber {
+		t.Errorf("got shipments %+v, want %s", list, trackingNumber)
+	}
+
+	if res := s.do(t, http.MethodPost, "/ship", `{"metadata":{}`); res.StatusCode() != http.StatusBadRequest {
+		t.Errorf("got status %d for an invalid event, want %d", res.StatusCode(), http.StatusBadRequest)
+	}
+}
+
+func TestSetShipmentStatus(t *testing.T) {
+	s := newTestService(t)
+
+	sh := shipper.Shipment{Carrier: "UPS", CorrelationID: "correlation-1"}
+	sh.TrackingNumber = shipper.NewTrackingNumber("UPS")
+	sh.OrderNumber = "order-1"
+	sh.Status = shipper.StatusShipped
+	if err := shipments.Save(sh); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		failEmit   bool
+		wantStatus int
+		wantStored string
+		wantEvents int
+	}{
+		{name: "status", body: `{"status":"` + shipper.StatusDelivered + `"}`, wantStatus: http.StatusOK, wantStored: shipper.StatusDelivered},
+		{name: "status with event", body: `{"status":"` + shipper.StatusCancelled + `","emit":true}`, wantStatus: http.StatusOK, wantStored: shipper.StatusCancelled, wantEvents: 1},
+		{name: "event can't be sent", body: `{"status":"` + shipper.StatusShipped + `","emit":true}`, failEmit: true, wantStatus: http.StatusInternalServerError, wantStored: shipper.StatusCancelled, wantEvents: 1},
+		{name: "status without event", body: `{"status":"` + shipper.StatusReturnInTransit + `","emit":true}`, wantStatus: http.StatusBadRequest, wantStored: shipper.StatusCancelled, wantEvents: 1},
+		{name: "unknown status", body: `{"status":"lost"}`, wantStatus: http.StatusBadRequest, wantStored: shipper.StatusCancelled, wantEvents: 1},
+		{name: "invalid body", body: `{`, wantStatus: http.StatusBadRequest, wantStored: shipper.StatusCancelled, wantEvents: 1},
+		{name: "unknown shipment", path: "/shipments/1Z0000/status", body: `{"status":"` + shipper.StatusDelivered + `"}`, wantStatus: http.StatusNotFound, wantStored: shipper.StatusCancelled, wantEvents: 1},
+	}
+
+	for _, tt := range tests {
+		if tt.failEmit {
+			s.rec.FailOn(s.rec.Calls()+1, errors.New("order service unavailable"))
+		}
+		path := tt.path
+		if path == "" {
+			path = "/shipments/" + sh.TrackingNumber + "/status"
+		}
+
+		res := s.do(t, http.MethodPost, path, tt.body)
+		if res.StatusCode() != tt.wantStatus {
+			t.Fatalf("%s: got status %d, want %d: %s", tt.name, res.StatusCode(), tt.wantStatus, res.Body())
+		}
+
+		stored, err := shipments.Get(sh.TrackingNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != tt.wantStored {
+			t.Errorf("%s: got stored status %q, want %q", tt.name, stored.Status, tt.wantStored)
+		}
+		if got := len(s.rec.Records()); got != tt.wantEvents {
+			t.Errorf("%s: got %d events, want %d", tt.name, got, tt.wantEvents)
+		}
+	}
+
+	if records := s.rec.Records(); len(records) > 0 && (records[0].Metadata.Type != workflow.ShipmentCancelledEventName || records[0].IDs.CorrelationID != "correlation-1") {
+		t.Errorf("got event %+v, want ShipmentCancelled with the IDs of the shipment", records[0])
+	}
+}
+
+func TestQueue(t *testing.T) {
+	q := newQueue(1)
+
+	if err := q.Send(message{ID: "message-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Send(message{ID: "message-2"}); err != errQueueFull {
+		t.Errorf("got error %v, want %v", err, errQueueFull)
+	}
+
+	// The message that is queued is handled after the queue is closed
+	var handled []string
+	q.Start(context.Background(), 1, func(ctx context.Context, m message) {
+		handled = append(handled, m.ID)
+	})
+	q.Close()
+	if err := q.Send(message{ID: "message-3"}); err != errQueueClosed {
+		t.Errorf("got error %v, want %v", err, errQueueClosed)
+	}
+	if !q.Wait(context.Background()) || len(handled) != 1 || handled[0] != "message-1" {
+		t.Errorf("got handled messages %v, want message-1", handled)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// handleMessage handles a message from the request queue and logs the error, if any.
+func handleMessage(ctx context.Context, m message) {
+	ctx = logging.With(ctx, logging.RequestID, m.ID)
+
+	if err := process(ctx, m); err != nil {
+		slog.ErrorContext(ctx, "error handling shipment request", logging.Err(err))
+	}
+}
+
+// process runs the shipment workflow for a ShipmentRequested message, in the same
+// steps as the Lambda functions. The shipment is stored after every step, so its
+// status can be followed using the HTTP API. An OrderCancelled message is handled
+// by processCancellation.
+func process(ctx context.Context, m message) (err error) {
+	// Continue the trace of the client that sent the request
+	ctx = tracing.Extract(ctx, m.Attributes)
+
+	if workflow.EventType(m.Body) == workflow.OrderCancelledEventName {
+		return processCancellation(ctx, m)
+	}
+
+	ctx, span := tracing.StartKind(ctx, "ShipmentRequested", trace.SpanKindConsumer, attribute.String("messaging.system", "memory"))
+	defer func() { tracing.End(span, err) }()
+
+	// Unmarshal the ShipmentRequested event to a struct
+	req, err := workflow.DecodeRequest(m.Body)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling shipment: %s", err.Error())
+	}
+	ctx = logging.With(ctx, logging.OrderNumber, req.Data.OrderID, logging.Carrier, req.Data.Delivery)
+
+	// The message that requested the shipment causes the events
+	cause := correlation.FromAttributes(m.Attributes)
+	if cause.EventID == "" {
+		cause.EventID = m.ID
+	}
+
+	parts, err := wf.ShipStoredOrder(ctx, shipments, req, cause)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the deliveries on the simulated clock
+	return wf.DeliverOrder(ctx, shipments, parts)
+}
+
+// processCancellation cancels the shipments of the order in an OrderCancelled message that
+// weren't picked up yet, like the Lambda functions do.
+func processCancellation(ctx context.Context, m message) (err error) {
+	ctx, span := tracing.StartKind(ctx, "OrderCancelled", trace.SpanKindConsumer, attribute.String("messaging.system", "memory"))
+	defer func() { tracing.End(span, err) }()
+
+	c, err := workflow.DecodeCancellation(m.Body)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling cancellation: %s", err.Error())
+	}
+	ctx = logging.With(ctx, logging.OrderNumber, c.Data.OrderID)
+
+	cause := correlation.FromAttributes(m.Attributes)
+	if cause.EventID == "" {
+		cause.EventID = m.ID
+	}
+
+	return wf.CancelStoredOrder(ctx, shipments, c, cause)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// errQueueFull is returned when a message is sent while the queue holds the
+	// maximum number of messages.
+	errQueueFull = errors.New("request queue is full")
+
+	// errQueueClosed is returned when a message is sent after the queue was closed.
+	errQueueClosed = errors.New("request queue is closed")
+)
+
+// message is a ShipmentRequested event waiting in the request queue, like an SQS
+// message waiting for the Lambda function.
+type message struct {
+	// ID is the ID of the message.
+	ID string
+
+	// Body is the ShipmentRequested event.
+	Body []byte
+
+	// Attributes are the message attributes, like the trace context and the
+	// correlation IDs.
+	Attributes map[string]string
+}
+
+// queue is an in-memory request queue that hands messages to a fixed number of
+// workers, which play the role of the Lambda function.
+type queue struct {
+	messages chan message
+
+	mu     sync.RWMutex
+	closed bool
+
+	wg sync.WaitGroup
+}
+
+// newQueue creates a queue that holds at most size messages.
+func newQueue(size int) *queue {
+	return &queue{
+		messages: make(chan message, size),
+	}
+}
+
+// Send adds the message to the queue. It returns errQueueFull when the queue is
+// full and errQueueClosed when the queue was closed.
+func (q *queue) Send(m message) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	select {
+	case q.messages <- m:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Len returns the number of messages waiting in the queue.
+func (q *queue) Len() int {
+	return len(q.messages)
+}
+
+// Start starts the workers, which call handle for every message until the queue is
+// closed and empty. The context is passed to handle and cancels the messages that are
+// being handled.
+func (q *queue) Start(ctx context.Context, workers int, handle func(context.Context, message)) {
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer q.wg.Done()
+			for m := range q.messages {
+				handle(ctx, m)
+			}
+		}()
+	}
+}
+
+// Close stops accepting new messages. The workers handle the messages that are left.
+func (q *queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.messages)
+	}
+}
+
+// Wait waits until the workers handled all messages or the context is done, and
+// returns whether all messages were handled.
+func (q *queue) Wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,142 @@
+// Package main is shipmentctl, a command-line tool to send, inspect and replay the
+// shipments of the Shipment service.
+//
+// shipmentctl uses the same configuration as the other binaries, from environment
+// variables and the file referenced by CONFIG_FILE, to find the store, the emitter
+// and the AWS resources. Flags of the commands override the configuration.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+)
+
+// command is a subcommand of shipmentctl.
+type command struct {
+	// usage describes the arguments of the command.
+	usage string
+
+	// summary describes what the command does in a single line.
+	summary string
+
+	// run runs the command with the arguments that follow its name, and prints its result
+	// to out.
+	run func(cfg *config.Config, args []string, out io.Writer) error
+}
+
+// commands are all subcommands of shipmentctl, by name. They are set in init, because
+// the commands use the map to print their usage.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"send": {
+			usage:   "send [-transport http|local|sqs|eventbridge] [-order id] [-delivery carrier] [-file event.json]",
+			summary: "build and send a ShipmentRequested event",
+			run:     runSend,
+		},
+		"track": {
+			usage:   "track [-url base-url] <trackingNumber>",
+			summary: "show the status of a shipment, from the store or an HTTP service",
+			run:     runTrack,
+		},
+		"list": {
+			usage:   "list [-url base-url] [-status status]",
+			summary: "list the shipments in the store or of shipment-local",
+			run:     runList,
+		},
+		"set-status": {
+			usage:   "set-status [-url base-url] [-emit] <trackingNumber> <status>",
+			summary: "force the status of a shipment through shipment-local",
+			run:     runSetStatus,
+		},
+		"replay": {
+			usage:   "replay [-emitter name] [-events file] <trackingNumber|eventId>",
+			summary: "send the event of a stored shipment, or from an events file, again",
+			run:     runReplay,
+		},
+	}
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "shipmentctl: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// run loads the configuration and runs the command named by the first argument, which
+// prints its result to out.
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(out)
+		return nil
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage(os.Stderr)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	return cmd.run(cfg, args[1:], out)
+}
+
+// usage prints the commands of shipmentctl.
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Usage: shipmentctl <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-12s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run shipmentctl <command> -h for the flags of a command.")
+}
+
+// newFlagSet creates the flag set of the command, which prints its usage on errors.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: shipmentctl %s\n", commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// printJSON prints the value as indented JSON to out.
+func printJSON(out io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(b))
+	return err
+}
+
+// oneOf returns an error when the value isn't one of the allowed values.
+func oneOf(name string, value string, allowed ...string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid %s %q, use %q", name, value, allowed)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// newShipment returns a shipment of UPS with the status.
+func newShipment(status string) shipper.Shipment {
+	var s shipper.Shipment
+	s.TrackingNumber = shipper.NewTrackingNumber("UPS")
+	s.OrderNumber = "order-" + s.TrackingNumber
+	s.Status = status
+	return s
+}
+
+// newService serves the shipments like shipment-local, and records the bodies of the
+// status requests it received by tracking number.
+func newService(t *testing.T, list ...shipper.Shipment) (*httptest.Server, map[string]string) {
+	t.Helper()
+
+	statuses := make(map[string]string)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shipments", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(list)
+	})
+	mux.HandleFunc("/ship/", func(w http.ResponseWriter, r *http.Request) {
+		for _, s := range list {
+			if r.URL.Path == "/ship/"+s.TrackingNumber {
+				json.NewEncoder(w).Encode(s)
+				return
+			}
+		}
+		http.Error(w, "shipment not found", http.StatusNotFound)
+	})
+	mux.HandleFunc("/shipments/", func(w http.ResponseWriter, r *http.Request) {
+		for _, s := range list {
+			if r.Method == http.MethodPost && r.URL.Path == "/shipments/"+s.TrackingNumber+"/status" {
+				b, _ := ioutil.ReadAll(r.Body)
+				statuses[s.TrackingNumber] = string(b)
+
+				var req statusBody
+				json.Unmarshal(b, &req)
+				s.Status = req.Status
+				json.NewEncoder(w).Encode(s)
+				return
+			}
+		}
+		http.Error(w, "shipment not found", http.StatusNotFound)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, statuses
+}
+
+// statusBody is the body of the status request set-status sends.
+type statusBody struct {
+	Status string `json:"status"`
+	Emit   bool   `json:"emit"`
+}
+
+func TestRunUsage(t *testing.T) {
+	var out bytes.Buffer
+	if err := run(nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	for name := range commands {
+		if !strings.Contains(out.String(), name) {
+			t.Errorf("got usage %q, want command %s in it", out.String(), name)
+		}
+	}
+
+	if err := run([]string{"unknown"}, &out); err == nil {
+		t.Error("got no error, want an error for the unknown command")
+	}
+}
+
+func TestTrackAndList(t *testing.T) {
+	shipped, delivered := newShipment(shipper.StatusShipped), newShipment(shipper.StatusDelivered)
+	server, _ := newService(t, shipped, delivered)
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "track", args: []string{"track", "-url", server.URL, shipped.TrackingNumber}, want: []string{shipped.TrackingNumber}},
+		{name: "track unknown", args: []string{"track", "-url", server.URL, "1Z0000"}, wantErr: true},
+		{name: "track without tracking number", args: []string{"track", "-url", server.URL}, wantErr: true},
+		{name: "list", args: []string{"list", "-url", server.URL}, want: []string{shipped.TrackingNumber, delivered.TrackingNumber}},
+		{name: "list with status", args: []string{"list", "-url", server.URL, "-status", shipper.StatusDelivered}, want: []string{delivered.TrackingNumber}},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		err := run(tt.args, &out)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: got error %v, want error %t", tt.name, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+
+		var got []string
+		var list []shipper.Shipment
+		if json.Unmarshal(out.Bytes(), &list) != nil {
+			var s shipper.Shipment
+			if err := json.Unmarshal(out.Bytes(), &s); err != nil {
+				t.Fatalf("%s: got output %q, want a shipment: %s", tt.name, out.String(), err.Error())
+			}
+			list = []shipper.Shipment{s}
+		}
+		for _, s := range list {
+			got = append(got, s.TrackingNumber)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got shipments %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	s := newShipment(shipper.StatusShipped)
+	server, statuses := newService(t, s)
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantBody statusBody
+		wantErr  bool
+	}{
+		{name: "status", args: []string{"set-status", "-url", server.URL, s.TrackingNumber, shipper.StatusDelivered}, wantBody: statusBody{Status: shipper.StatusDelivered}},
+		{name: "status with event", args: []string{"set-status", "-url", server.URL, "-emit", s.TrackingNumber, shipper.StatusDelivered}, wantBody: statusBody{Status: shipper.StatusDelivered, Emit: true}},
+		{name: "invalid status", args: []string{"set-status", "-url", server.URL, s.TrackingNumber, "lost"}, wantErr: true},
+		{name: "unknown shipment", args: []string{"set-status", "-url", server.URL, "1Z0000", shipper.StatusDelivered}, wantErr: true},
+		{name: "missing status", args: []string{"set-status", "-url", server.URL, s.TrackingNumber}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		delete(statuses, s.TrackingNumber)
+
+		var out bytes.Buffer
+		err := run(tt.args, &out)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: got error %v, want error %t", tt.name, err, tt.wantErr)
+		}
+		if err != nil {
+			if _, ok := statuses[s.TrackingNumber]; ok {
+				t.Errorf("%s: got the status changed by the service, want it unchanged", tt.name)
+			}
+			continue
+		}
+
+		var body statusBody
+		if err := json.Unmarshal([]byte(statuses[s.TrackingNumber]), &body); err != nil {
+			t.Fatalf("%s: got request body %q: %s", tt.name, statuses[s.TrackingNumber], err.Error())
+		}
+		if body != tt.wantBody {
+			t.Errorf("%s: got request %+v, want %+v", tt.name, body, tt.wantBody)
+		}
+
+		var got shipper.Shipment
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil || got.Status != tt.wantBody.Status {
+			t.Errorf("%s: got output %q, want the shipment with the new status", tt.name, out.String())
+		}
+	}
+}
+
+func TestSendHTTP(t *testing.T) {
+	var headers http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"messageId":"message-1"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	var out bytes.Buffer
+	if err := run([]string{"send", "-url", server.URL + "/ship", "-order", "order-1", "-correlation-id", "correlation-1"}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if headers.Get(correlation.CorrelationIDHeader) != "correlation-1" || headers.Get(correlation.EventIDHeader) == "" {
+		t.Errorf("got headers %v, want the correlation IDs", headers)
+	}
+	if !strings.Contains(string(body), `"_id":"order-1"`) {
+		t.Errorf("got body %s, want the ShipmentRequested event of order-1", body)
+	}
+	if strings.TrimSpace(out.String()) != `{"messageId":"message-1"}` {
+		t.Errorf("got output %q, want the response", out.String())
+	}
+}
+
+func TestFindEvent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	lines := `{"metadata":{"type":"ShipmentSent"},"data":{"trackingNumber":"1Z1"},"eventId":"event-1"}
+{"metadata":{"type":"ShipmentDelivered"},"data":{"trackingNumber":"1Z1"},"eventId":"event-2"}
+`
+	if err := ioutil.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{id: "event-1", want: "event-1"},
+		{id: "1Z1", want: "event-2"},
+		{id: "1Z2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		evt, err := findEvent(path, tt.id)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: got error %v, want error %t", tt.id, err, tt.wantErr)
+		}
+		if err == nil && evt.IDs.EventID != tt.want {
+			t.Errorf("%s: got event %s, want %s", tt.id, evt.IDs.EventID, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/setup"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+)
+
+// runReplay sends an event again with an EventEmitter. The event is either built from
+// the current status of a shipment in the store, with a new event ID, or read, with its
+// original IDs, from a file written by the file emitter.
+func runReplay(cfg *config.Config, args []string, out io.Writer) error {
+	fs := newFlagSet("replay")
+	emitterName := fs.String("emitter", cfg.Emitter, "the emitter to send the event with: webhook, sqs, eventbridge, mock or file")
+	events := fs.String("events", "", "a file written by the file emitter to read the event from, instead of the store")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("replay needs a tracking number, or an event ID with -events")
+	}
+	id := fs.Arg(0)
+
+	var evt workflow.Event
+	if *events != "" {
+		e, err := findEvent(*events, id)
+		if err != nil {
+			return err
+		}
+		evt = e
+	} else {
+		st, err := openStore(cfg)
+		if err != nil {
+			return err
+		}
+
+		s, err := st.Get(id)
+		if err != nil {
+			return fmt.Errorf("error getting shipment %s: %s", id, err.Error())
+		}
+
+		if evt, err = workflow.EventFor(s); err != nil {
+			return err
+		}
+	}
+
+	if err := emitEvent(cfg, *emitterName, evt); err != nil {
+		return err
+	}
+
+	// Print the event like the file emitter writes it
+	return printJSON(out, struct {
+		acmeserverless.ShipmentSent
+		correlation.IDs
+	}{evt.ShipmentSent, evt.IDs})
+}
+
+// findEvent returns the event with the ID from a file written by the file emitter. When
+// no event has the ID, the last event of the shipment with that tracking number is used.
+func findEvent(path string, id string) (workflow.Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return workflow.Event{}, err
+	}
+	defer f.Close()
+
+	var last *workflow.Event
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var evt workflow.Event
+		if err := json.Unmarshal(scanner.Bytes(), &evt.ShipmentSent); err != nil {
+			return workflow.Event{}, fmt.Errorf("invalid event on line %d of %s: %s", line, path, err.Error())
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &evt.IDs); err != nil {
+			return workflow.Event{}, fmt.Errorf("invalid event on line %d of %s: %s", line, path, err.Error())
+		}
+
+		if evt.IDs.EventID == id {
+			return evt, nil
+		}
+		if evt.Data.TrackingNumber == id {
+			last = &evt
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return workflow.Event{}, err
+	}
+
+	if last == nil {
+		return workflow.Event{}, fmt.Errorf("no event with ID or tracking number %s in %s", id, path)
+	}
+	return *last, nil
+}
+
+// emitEvent sends the event, with its IDs, using the EventEmitter with the name, which
+// is configured like in the other binaries.
+func emitEvent(cfg *config.Config, name string, evt workflow.Event) error {
+	c := *cfg
+	c.Emitter = name
+
+	required, err := setup.EmitterRequires(name)
+	if err != nil {
+		return err
+	}
+	if err := c.Validate("shipmentctl", required...); err != nil {
+		return err
+	}
+
+	em, err := setup.NewEmitter(&c, nil)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	if err := workflow.New(em, name, nil).Emit(ctx, evt); err != nil {
+		return fmt.Errorf("error sending %s event %s: %s", evt.Metadata.Type, evt.IDs.EventID, err.Error())
+	}
+
+	fmt.Fprintf(os.Stderr, "sent %s event %s (correlation %s) using the %s emitter\n", evt.Metadata.Type, evt.IDs.EventID, correlationOf(evt.IDs), name)
+	return nil
+}
+
+// correlationOf returns the correlation ID, or none when it isn't set.
+func correlationOf(ids correlation.IDs) string {
+	if ids.CorrelationID == "" {
+		return "none"
+	}
+	return ids.CorrelationID
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	sqsemitter "github.com/retgits/acme-serverless-shipment/internal/emitter/sqs"
+)
+
+const (
+	// source is the source of the events shipmentctl sends.
+	source = "shipmentctl"
+
+	// sendTimeout is the maximum time sending a single event can take.
+	sendTimeout = 30 * time.Second
+)
+
+// runSend builds a ShipmentRequested event and sends it with the selected transport.
+func runSend(cfg *config.Config, args []string, out io.Writer) error {
+	fs := newFlagSet("send")
+	transport := fs.String("transport", "http", "how the event is sent: http (the Cloud Run service), local (shipment-local), sqs or eventbridge")
+	url := fs.String("url", fmt.Sprintf("http://localhost:%d/ship", cfg.Port), "the URL of /ship for the http and local transports")
+	queue := fs.String("queue", "", "the ARN or URL of the request queue for the sqs transport")
+	bus := fs.String("bus", cfg.EventBus, "the name of the EventBridge bus for the eventbridge transport")
+	region := fs.String("region", cfg.Region, "the AWS region of the queue or bus")
+	order := fs.String("order", "", "the ID of the order (a new ID when empty)")
+	delivery := fs.String("delivery", "UPS/FedEx", "the carrier of the shipment")
+	file := fs.String("file", "", "a file with the ShipmentRequested event to send instead, or - for stdin")
+	correlationID := fs.String("correlation-id", "", "the correlation ID of the order (a new ID when empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := oneOf("transport", *transport, "http", "local", "sqs", "eventbridge"); err != nil {
+		return err
+	}
+
+	payload, err := requestPayload(*file, *order, *delivery)
+	if err != nil {
+		return err
+	}
+
+	ids := correlation.IDs{EventID: correlation.NewID(), CorrelationID: *correlationID}
+	if ids.CorrelationID == "" {
+		ids.CorrelationID = correlation.NewID()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	switch *transport {
+	case "sqs":
+		err = sendSQS(ctx, out, *region, *queue, payload, ids)
+	case "eventbridge":
+		err = sendEventBridge(ctx, out, *region, *bus, payload, ids)
+	default:
+		err = sendHTTP(ctx, out, *url, payload, ids)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "sent ShipmentRequested event %s (correlation %s) using %s\n", ids.EventID, ids.CorrelationID, *transport)
+	return nil
+}
+
+// requestPayload returns the ShipmentRequested event from the file or, when there is no
+// file, builds it for the order and carrier.
+func requestPayload(file string, order string, delivery string) ([]byte, error) {
+	switch file {
+	case "":
+	case "-":
+		return ioutil.ReadAll(os.Stdin)
+	default:
+		return ioutil.ReadFile(file)
+	}
+
+	if order == "" {
+		order = correlation.NewID()
+	}
+
+	req := acmeserverless.ShipmentRequested{
+		Metadata: acmeserverless.Metadata{
+			Domain: acmeserverless.OrderDomain,
+			Source: source,
+			Type:   acmeserverless.ShipmentRequestedEventName,
+			Status: acmeserverless.DefaultSuccessStatus,
+		},
+		Data: acmeserverless.ShipmentRequest{
+			OrderID:  order,
+			Delivery: delivery,
+		},
+	}
+
+	return req.Marshal()
+}
+
+// sendHTTP posts the event to the URL and prints the response to out.
+func sendHTTP(ctx context.Context, out io.Writer, url string, payload []byte, ids correlation.IDs) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("content-type", "application/json")
+	for k, v := range ids.Headers() {
+		req.Header.Set(k, v)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s: %s", url, res.Status, strings.TrimSpace(string(body)))
+	}
+
+	for _, h := range []string{correlation.EventIDHeader, correlation.CausationIDHeader, correlation.CorrelationIDHeader} {
+		if v := res.Header.Get(h); v != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", h, v)
+		}
+	}
+
+	fmt.Fprintln(out, strings.TrimSpace(string(body)))
+	return nil
+}
+
+// sendSQS sends the event to the request queue, with the correlation IDs as message
+// attributes, like the order service does, and prints the ID of the message to out.
+func sendSQS(ctx context.Context, out io.Writer, region string, queue string, payload []byte, ids correlation.IDs) error {
+	if queue == "" {
+		return fmt.Errorf("the sqs transport needs the -queue flag")
+	}
+
+	queueURL := queue
+	if strings.HasPrefix(queue, "arn:") {
+		u, err := sqsemitter.QueueURL(queue)
+		if err != nil {
+			return err
+		}
+		queueURL = u
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return err
+	}
+
+	attrs := make(map[string]*sqs.MessageAttributeValue)
+	for k, v := range ids.Attributes() {
+		attrs[k] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+	}
+
+	res, err := sqs.New(sess).SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(queueURL),
+		MessageBody:       aws.String(string(payload)),
+		MessageAttributes: attrs,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "{\"messageId\":%q}\n", aws.StringValue(res.MessageId))
+	return nil
+}
+
+// sendEventBridge puts the event on the bus, with the correlation IDs in the detail, and
+// prints the ID of the event to out.
+func sendEventBridge(ctx context.Context, out io.Writer, region string, bus string, payload []byte, ids correlation.IDs) error {
+	if bus == "" {
+		return fmt.Errorf("the eventbridge transport needs the -bus flag or EVENTBUS")
+	}
+
+	detail, err := withCorrelation(payload, ids)
+	if err != nil {
+		return err
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return err
+	}
+
+	res, err := eventbridge.New(sess).PutEventsWithContext(ctx, &eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{{
+			Detail:       aws.String(string(detail)),
+			DetailType:   aws.String(acmeserverless.ShipmentRequestedEventName),
+			EventBusName: aws.String(bus),
+			Source:       aws.String(source),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if aws.Int64Value(res.FailedEntryCount) > 0 && len(res.Entries) > 0 {
+		return fmt.Errorf("EventBridge rejected the event: %s", aws.StringValue(res.Entries[0].ErrorMessage))
+	}
+
+	if len(res.Entries) > 0 {
+		fmt.Fprintf(out, "{\"eventId\":%q}\n", aws.StringValue(res.Entries[0].EventId))
+	}
+	return nil
+}
+
+// withCorrelation adds the correlation IDs to the detail of the event, where the
+// EventBridge Lambda function looks for them.
+func withCorrelation(payload []byte, ids correlation.IDs) ([]byte, error) {
+	detail := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(payload, &detail); err != nil {
+		return nil, fmt.Errorf("invalid ShipmentRequested event: %s", err.Error())
+	}
+
+	b, err := json.Marshal(ids.Attributes())
+	if err != nil {
+		return nil, err
+	}
+	detail[correlation.Field] = b
+
+	return json.Marshal(detail)
+}
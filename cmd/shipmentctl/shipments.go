@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/setup"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+)
+
+// runTrack prints a shipment, with its status, from the store or from the HTTP service
+// at the URL.
+func runTrack(cfg *config.Config, args []string, out io.Writer) error {
+	fs := newFlagSet("track")
+	base := fs.String("url", "", "the base URL of the Cloud Run service or shipment-local, instead of the store")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("track needs a tracking number")
+	}
+	trackingNumber := fs.Arg(0)
+
+	if *base != "" {
+		var s shipper.Shipment
+		if err := getJSON(*base, "/ship/"+url.PathEscape(trackingNumber), &s); err != nil {
+			return err
+		}
+		return printJSON(out, s)
+	}
+
+	st, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	s, err := st.Get(trackingNumber)
+	if err != nil {
+		return fmt.Errorf("error getting shipment %s: %s", trackingNumber, err.Error())
+	}
+
+	return printJSON(out, s)
+}
+
+// runList prints the shipments in the store, or of shipment-local at the URL, optionally
+// only the ones with a status.
+func runList(cfg *config.Config, args []string, out io.Writer) error {
+	fs := newFlagSet("list")
+	base := fs.String("url", "", "the base URL of shipment-local, instead of the store")
+	status := fs.String("status", "", "only list shipments with this status")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var list []shipper.Shipment
+	if *base != "" {
+		if err := getJSON(*base, "/shipments", &list); err != nil {
+			return err
+		}
+	} else {
+		st, err := openStore(cfg)
+		if err != nil {
+			return err
+		}
+		if list, err = st.List(); err != nil {
+			return fmt.Errorf("error listing shipments: %s", err.Error())
+		}
+	}
+
+	filtered := make([]shipper.Shipment, 0, len(list))
+	for _, s := range list {
+		if *status == "" || s.Status == *status {
+			filtered = append(filtered, s)
+		}
+	}
+
+	return printJSON(out, filtered)
+}
+
+// runSetStatus forces the status of a shipment through the status endpoint of shipment-local
+// at the URL, which stores it and, optionally, sends the event for the new status first.
+// The status isn't written to the store directly, so the service that owns the store
+// doesn't overwrite it with the copy it holds.
+func runSetStatus(cfg *config.Config, args []string, out io.Writer) error {
+	fs := newFlagSet("set-status")
+	base := fs.String("url", fmt.Sprintf("http://localhost:%d", cfg.Port), "the base URL of shipment-local")
+	emit := fs.Bool("emit", false, "send the event for the new status with the emitter of the service")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("set-status needs a tracking number and a status")
+	}
+	trackingNumber, status := fs.Arg(0), fs.Arg(1)
+
+	if err := oneOf("status", status, shipper.Statuses...); err != nil {
+		return err
+	}
+
+	req := struct {
+		Status string `json:"status"`
+		Emit   bool   `json:"emit"`
+	}{status, *emit}
+
+	var s shipper.Shipment
+	if err := requestJSON(http.MethodPost, *base, "/shipments/"+url.PathEscape(trackingNumber)+"/status", req, &s); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "changed status of shipment %s to %q\n", trackingNumber, status)
+
+	return printJSON(out, s)
+}
+
+// openStore opens the configured store. The memory store is empty in every process,
+// so only the file store is useful to inspect shipments of other binaries.
+func openStore(cfg *config.Config) (store.Store, error) {
+	if cfg.Store == "memory" {
+		return nil, fmt.Errorf("the memory store only lives in the process that created it, set STORE=file and STORE_PATH, or use -url")
+	}
+
+	return setup.NewStore(cfg)
+}
+
+// getJSON gets the path from the base URL and decodes the JSON response into v.
+func getJSON(base string, path string, v interface{}) error {
+	return requestJSON(http.MethodGet, base, path, nil, v)
+}
+
+// requestJSON sends a request with the method and, when it isn't nil, the body as JSON to
+// the path of the base URL and decodes the JSON response into v.
+func requestJSON(method string, base string, path string, body interface{}, v interface{}) error {
+	u := strings.TrimRight(base, "/") + path
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, payload)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s: %s", u, res.Status, strings.TrimSpace(string(b)))
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,85 @@
+// Package address contains the interfaces that the Shipment service
+// in the ACME Serverless Fitness Shop uses to validate and normalize
+// the addresses shipments are delivered to. In order to add a new
+// address validator, like one of a carrier, the Provider interface
+// needs to be implemented.
+package address
+
+import (
+	"context"
+	"strings"
+)
+
+// Address is the address a shipment is delivered to. The fields have the
+// same names as the address of an order in the ACME Serverless Fitness Shop.
+type Address struct {
+	// Street is the street and house number.
+	Street string `json:"street"`
+
+	// City is the city name.
+	City string `json:"city"`
+
+	// Zip is the zip or postal code.
+	Zip string `json:"zip"`
+
+	// State is the state or province.
+	State string `json:"state,omitempty"`
+
+	// Country is the ISO 3166-1 alpha-2 code of the country, like US.
+	Country string `json:"country"`
+}
+
+// Problem is a reason an address can't be delivered to.
+type Problem struct {
+	// Field is the field of the address the problem is about, like zip.
+	Field string `json:"field"`
+
+	// Message explains what is wrong with the field.
+	Message string `json:"message"`
+}
+
+// Result is the outcome of validating an address.
+type Result struct {
+	// Valid is set when the address can be delivered to.
+	Valid bool `json:"valid"`
+
+	// Address is the normalized address, like with the standard abbreviations
+	// and the postal code in the format of the country.
+	Address Address `json:"address"`
+
+	// Problems are the reasons the address can't be delivered to.
+	Problems []Problem `json:"problems,omitempty"`
+}
+
+// Provider is the interface that describes the methods an address validator
+// needs to implement to be able to work with the Shipment service.
+type Provider interface {
+	// Validate checks whether the address can be delivered to and normalizes it.
+	// An address that can't be delivered to is not an error, but a Result with
+	// the problems. An error means the address couldn't be validated at all.
+	Validate(ctx context.Context, a Address) (Result, error)
+}
+
+// ValidationError is returned when a shipment is requested for an address that
+// can't be delivered to.
+type ValidationError struct {
+	// Problems are the reasons the address can't be delivered to.
+	Problems []Problem
+}
+
+// Error returns a message that lists all problems of the address.
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		parts = append(parts, p.Field+" "+p.Message)
+	}
+	return "invalid address: " + strings.Join(parts, "; ")
+}
+
+// Nop is a Provider that accepts every address as it is.
+type Nop struct{}
+
+// Validate returns the address as valid.
+func (Nop) Validate(ctx context.Context, a Address) (Result, error) {
+	return Result{Valid: true, Address: a}, nil
+}
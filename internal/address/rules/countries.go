@@ -0,0 +1,164 @@
+package rules
+
+import (
+	"regexp"
+	"strings"
+)
+
+// countries are the rules of the countries the built-in rules know, keyed by country code.
+var countries = map[string]country{
+	"US": {
+		zip:        regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+		zipExample: "94105 or 94105-1234",
+		normalizeZip: func(zip string) string {
+			zip = removeSpaces(zip)
+			if len(zip) == 9 && !strings.Contains(zip, "-") {
+				return zip[:5] + "-" + zip[5:]
+			}
+			return zip
+		},
+		states:        usStates,
+		stateRequired: true,
+		abbreviate:    true,
+	},
+	"CA": {
+		zip:           regexp.MustCompile(`^[ABCEGHJ-NPRSTVXY]\d[ABCEGHJ-NPRSTV-Z] \d[ABCEGHJ-NPRSTV-Z]\d$`),
+		zipExample:    "K1A 0B1",
+		normalizeZip:  spaceAt(3),
+		states:        caProvinces,
+		stateRequired: true,
+	},
+	"AU": {
+		zip:           regexp.MustCompile(`^\d{4}$`),
+		zipExample:    "2000",
+		normalizeZip:  removeSpaces,
+		states:        auStates,
+		stateRequired: true,
+	},
+	"GB": {
+		zip:          regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}$`),
+		zipExample:   "SW1A 1AA",
+		normalizeZip: spaceAt(3),
+	},
+	"NL": {
+		zip:          regexp.MustCompile(`^[1-9]\d{3} [A-Z]{2}$`),
+		zipExample:   "1011 AB",
+		normalizeZip: spaceAt(-4),
+	},
+	"BE": {zip: regexp.MustCompile(`^\d{4}$`), zipExample: "1000", normalizeZip: removeSpaces},
+	"DE": {zip: regexp.MustCompile(`^\d{5}$`), zipExample: "10115", normalizeZip: removeSpaces},
+	"FR": {zip: regexp.MustCompile(`^\d{5}$`), zipExample: "75001", normalizeZip: removeSpaces},
+	"ES": {zip: regexp.MustCompile(`^\d{5}$`), zipExample: "28001", normalizeZip: removeSpaces},
+	"IT": {zip: regexp.MustCompile(`^\d{5}$`), zipExample: "00118", normalizeZip: removeSpaces},
+	"MX": {zip: regexp.MustCompile(`^\d{5}$`), zipExample: "06000", normalizeZip: removeSpaces},
+	"JP": {
+		zip:        regexp.MustCompile(`^\d{3}-\d{4}$`),
+		zipExample: "100-0001",
+		normalizeZip: func(zip string) string {
+			zip = removeSpaces(zip)
+			if len(zip) == 7 && !strings.Contains(zip, "-") {
+				return zip[:3] + "-" + zip[3:]
+			}
+			return zip
+		},
+	},
+}
+
+// countryNames are the codes of countries that are often written out, keyed by
+// their name in lower case.
+var countryNames = map[string]string{
+	"usa":                      "US",
+	"u.s.":                     "US",
+	"u.s.a.":                   "US",
+	"united states":            "US",
+	"united states of america": "US",
+	"canada":                   "CA",
+	"australia":                "AU",
+	"uk":                       "GB",
+	"united kingdom":           "GB",
+	"great britain":            "GB",
+	"england":                  "GB",
+	"scotland":                 "GB",
+	"wales":                    "GB",
+	"netherlands":              "NL",
+	"the netherlands":          "NL",
+	"holland":                  "NL",
+	"belgium":                  "BE",
+	"germany":                  "DE",
+	"deutschland":              "DE",
+	"france":                   "FR",
+	"spain":                    "ES",
+	"italy":                    "IT",
+	"mexico":                   "MX",
+	"méxico":                   "MX",
+	"japan":                    "JP",
+}
+
+// isoCountryCodes are the ISO 3166-1 alpha-2 codes of all countries.
+var isoCountryCodes = set(`
+	AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ
+	BR BS BT BV BW BY BZ CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM
+	DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS
+	GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE JM JO JP KE KG KH KI KM KN
+	KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK ML MM MN MO MP MQ
+	MR MS MT MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM
+	PN PR PS PT PW PY QA RE RO RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV
+	SX SY SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG UM US UY UZ VA VC VE VG VI
+	VN VU WF WS YE YT ZA ZM ZW`)
+
+// usStates are the codes of the states, districts and territories of the US, and
+// the military state codes.
+var usStates = map[string]string{
+	"alabama": "AL", "alaska": "AK", "arizona": "AZ", "arkansas": "AR", "california": "CA",
+	"colorado": "CO", "connecticut": "CT", "delaware": "DE", "district of columbia": "DC",
+	"florida": "FL", "georgia": "GA", "hawaii": "HI", "idaho": "ID", "illinois": "IL",
+	"indiana": "IN", "iowa": "IA", "kansas": "KS", "kentucky": "KY", "louisiana": "LA",
+	"maine": "ME", "maryland": "MD", "massachusetts": "MA", "michigan": "MI", "minnesota": "MN",
+	"mississippi": "MS", "missouri": "MO", "montana": "MT", "nebraska": "NE", "nevada": "NV",
+	"new hampshire": "NH", "new jersey": "NJ", "new mexico": "NM", "new york": "NY",
+	"north carolina": "NC", "north dakota": "ND", "ohio": "OH", "oklahoma": "OK", "oregon": "OR",
+	"pennsylvania": "PA", "rhode island": "RI", "south carolina": "SC", "south dakota": "SD",
+	"tennessee": "TN", "texas": "TX", "utah": "UT", "vermont": "VT", "virginia": "VA",
+	"washington": "WA", "west virginia": "WV", "wisconsin": "WI", "wyoming": "WY",
+	"american samoa": "AS", "guam": "GU", "northern mariana islands": "MP", "puerto rico": "PR",
+	"u.s. virgin islands": "VI", "armed forces americas": "AA", "armed forces europe": "AE",
+	"armed forces pacific": "AP",
+}
+
+// caProvinces are the codes of the provinces and territories of Canada.
+var caProvinces = map[string]string{
+	"alberta": "AB", "british columbia": "BC", "manitoba": "MB", "new brunswick": "NB",
+	"newfoundland and labrador": "NL", "nova scotia": "NS", "northwest territories": "NT",
+	"nunavut": "NU", "ontario": "ON", "prince edward island": "PE", "quebec": "QC",
+	"québec": "QC", "saskatchewan": "SK", "yukon": "YT",
+}
+
+// auStates are the codes of the states and territories of Australia.
+var auStates = map[string]string{
+	"australian capital territory": "ACT", "new south wales": "NSW", "northern territory": "NT",
+	"queensland": "QLD", "south australia": "SA", "tasmania": "TAS", "victoria": "VIC",
+	"western australia": "WA",
+}
+
+// streetAbbreviations are the standard abbreviations of the USPS for the words of
+// a street, keyed by the word in lower case.
+var streetAbbreviations = map[string]string{
+	"avenue": "Ave", "boulevard": "Blvd", "circle": "Cir", "court": "Ct", "drive": "Dr",
+	"expressway": "Expy", "freeway": "Fwy", "highway": "Hwy", "lane": "Ln", "parkway": "Pkwy",
+	"place": "Pl", "road": "Rd", "square": "Sq", "street": "St", "terrace": "Ter", "trail": "Trl",
+	"apartment": "Apt", "building": "Bldg", "floor": "Fl", "suite": "Ste", "room": "Rm",
+	"north": "N", "south": "S", "east": "E", "west": "W",
+	"northeast": "NE", "northwest": "NW", "southeast": "SE", "southwest": "SW",
+	"ave": "Ave", "blvd": "Blvd", "st": "St", "rd": "Rd", "dr": "Dr", "ln": "Ln", "ct": "Ct",
+	"apt": "Apt", "ste": "Ste", "ne": "NE", "nw": "NW", "se": "SE", "sw": "SW",
+}
+
+// set returns the set of the space-separated words in s.
+func set(s string) map[string]bool {
+	words := strings.Fields(s)
+	m := make(map[string]bool, len(words))
+	for _, w := range words {
+		m[w] = true
+	}
+	return m
+}
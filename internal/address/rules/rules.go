@@ -0,0 +1,225 @@
+// Package rules contains an address Provider that validates and normalizes
+// addresses with built-in rules for every country: the components an address
+// needs, the format of the postal code and the codes of the states or provinces.
+// It doesn't know whether an address exists, for that a Provider of a real
+// address validator is needed.
+package rules
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+)
+
+const (
+	// maxLength is the maximum length, in bytes, of a component of an address.
+	maxLength = 256
+)
+
+// country are the rules of the addresses of a country.
+type country struct {
+	// zip is the pattern of a normalized postal code, or nil when the country
+	// doesn't use postal codes for all addresses.
+	zip *regexp.Regexp
+
+	// zipExample is a postal code in the format of the country, used in messages.
+	zipExample string
+
+	// normalizeZip puts the postal code in the format of the country.
+	normalizeZip func(zip string) string
+
+	// states are the codes of the states or provinces, keyed by their name in
+	// lower case, or nil when the state isn't used for addresses.
+	states map[string]string
+
+	// stateRequired is set when the address needs a state.
+	stateRequired bool
+
+	// abbreviate is set when the street is written with the standard abbreviations,
+	// like St for Street.
+	abbreviate bool
+}
+
+// provider is an address.Provider that validates addresses with the built-in rules.
+type provider struct{}
+
+// New creates an address.Provider that validates and normalizes addresses with the
+// built-in rules.
+func New() address.Provider {
+	return provider{}
+}
+
+// Validate normalizes the address and checks it against the rules of its country. Addresses
+// in countries without rules only need a street, a city and a country.
+func (provider) Validate(ctx context.Context, a address.Address) (address.Result, error) {
+	var problems []address.Problem
+	problem := func(field string, format string, args ...interface{}) {
+		problems = append(problems, address.Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"street", &a.Street},
+		{"city", &a.City},
+		{"zip", &a.Zip},
+		{"state", &a.State},
+		{"country", &a.Country},
+	}
+
+	for _, f := range fields {
+		*f.value = strings.Join(strings.Fields(*f.value), " ")
+		switch {
+		case len(*f.value) > maxLength:
+			problem(f.name, "is longer than %d bytes", maxLength)
+		case !utf8.ValidString(*f.value):
+			problem(f.name, "is not valid UTF-8")
+		case strings.IndexFunc(*f.value, unicode.IsControl) >= 0:
+			problem(f.name, "contains control characters")
+		}
+	}
+	if len(problems) > 0 {
+		return address.Result{Address: a, Problems: problems}, nil
+	}
+
+	code, ok := countryCode(a.Country)
+	switch {
+	case a.Country == "":
+		problem("country", "is required")
+	case !ok:
+		problem("country", "must be a two-letter country code, like US")
+	default:
+		a.Country = code
+	}
+
+	a.Street = titleCase(a.Street)
+	a.City = titleCase(a.City)
+
+	if a.Street == "" {
+		problem("street", "is required")
+	}
+	if a.City == "" {
+		problem("city", "is required")
+	}
+
+	c, ok := countries[a.Country]
+	if !ok {
+		return result(a, problems), nil
+	}
+
+	if c.abbreviate {
+		a.Street = abbreviate(a.Street)
+	}
+
+	if c.normalizeZip != nil {
+		a.Zip = c.normalizeZip(a.Zip)
+	}
+	switch {
+	case c.zip == nil:
+	case a.Zip == "":
+		problem("zip", "is required in %s", a.Country)
+	case !c.zip.MatchString(a.Zip):
+		problem("zip", "must be a postal code of %s, like %s", a.Country, c.zipExample)
+	}
+
+	if c.states != nil {
+		state, ok := stateCode(c.states, a.State)
+		switch {
+		case a.State == "" && c.stateRequired:
+			problem("state", "is required in %s", a.Country)
+		case a.State == "":
+		case !ok:
+			problem("state", "must be a state or province of %s", a.Country)
+		default:
+			a.State = state
+		}
+	}
+
+	return result(a, problems), nil
+}
+
+// result creates the Result of the address with the problems.
+func result(a address.Address, problems []address.Problem) address.Result {
+	return address.Result{Valid: len(problems) == 0, Address: a, Problems: problems}
+}
+
+// countryCode returns the ISO 3166-1 alpha-2 code of the country, which can be
+// a code or a common name of the country.
+func countryCode(name string) (string, bool) {
+	if code, ok := countryNames[strings.ToLower(name)]; ok {
+		return code, true
+	}
+
+	code := strings.ToUpper(name)
+	return code, isoCountryCodes[code]
+}
+
+// stateCode returns the code of the state, which can be a code or a name.
+func stateCode(states map[string]string, state string) (string, bool) {
+	if code, ok := states[strings.ToLower(state)]; ok {
+		return code, true
+	}
+
+	code := strings.ToUpper(strings.TrimSuffix(state, "."))
+	for _, c := range states {
+		if c == code {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// titleCase capitalizes every word of a value that is written in only upper or
+// only lower case letters. Values with mixed case, like McAllister Street, are
+// kept as they are.
+func titleCase(s string) string {
+	if s != strings.ToUpper(s) && s != strings.ToLower(s) {
+		return s
+	}
+
+	words := strings.Fields(strings.ToLower(s))
+	for i, w := range words {
+		r, size := utf8.DecodeRuneInString(w)
+		words[i] = string(unicode.ToUpper(r)) + w[size:]
+	}
+	return strings.Join(words, " ")
+}
+
+// abbreviate replaces the words of the street that have a standard abbreviation.
+func abbreviate(street string) string {
+	words := strings.Fields(street)
+	for i, w := range words {
+		if abbr, ok := streetAbbreviations[strings.ToLower(strings.TrimSuffix(w, "."))]; ok {
+			words[i] = abbr
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// removeSpaces returns the postal code in upper case without spaces.
+func removeSpaces(zip string) string {
+	return strings.ToUpper(strings.ReplaceAll(zip, " ", ""))
+}
+
+// spaceAt returns a func that puts a space in the postal code before the last
+// n characters, like in the UK, or after the first n characters when n is
+// negative, like in the Netherlands.
+func spaceAt(n int) func(string) string {
+	return func(zip string) string {
+		zip = removeSpaces(zip)
+		i := len(zip) - n
+		if n < 0 {
+			i = -n
+		}
+		if i <= 0 || i >= len(zip) {
+			return zip
+		}
+		return zip[:i] + " " + zip[i:]
+	}
+}
@@ -0,0 +1,107 @@
+package rules
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		address      address.Address
+		want         address.Address
+		wantProblems string
+	}{
+		{
+			name:    "US normalized",
+			address: address.Address{Street: "  1 NORTH MAIN STREET ", City: "palo alto", Zip: "943011234", State: "california", Country: "United States"},
+			want:    address.Address{Street: "1 N Main St", City: "Palo Alto", Zip: "94301-1234", State: "CA", Country: "US"},
+		},
+		{
+			name:    "US mixed case kept",
+			address: address.Address{Street: "100 McAllister Ave.", City: "San Francisco", Zip: "94102", State: "ca", Country: "us"},
+			want:    address.Address{Street: "100 McAllister Ave", City: "San Francisco", Zip: "94102", State: "CA", Country: "US"},
+		},
+		{
+			name:         "US without state",
+			address:      address.Address{Street: "1 Main St", City: "Palo Alto", Zip: "94301", Country: "US"},
+			wantProblems: "state",
+		},
+		{
+			name:         "US unknown state and short zip",
+			address:      address.Address{Street: "1 Main St", City: "Palo Alto", Zip: "9430", State: "XX", Country: "US"},
+			wantProblems: "zip state",
+		},
+		{
+			name:    "Canada",
+			address: address.Address{Street: "24 Sussex Drive", City: "Ottawa", Zip: "k1m1m4", State: "Ontario", Country: "canada"},
+			want:    address.Address{Street: "24 Sussex Drive", City: "Ottawa", Zip: "K1M 1M4", State: "ON", Country: "CA"},
+		},
+		{
+			name:    "Netherlands",
+			address: address.Address{Street: "Damrak 1", City: "Amsterdam", Zip: "1012lg", Country: "Holland"},
+			want:    address.Address{Street: "Damrak 1", City: "Amsterdam", Zip: "1012 LG", Country: "NL"},
+		},
+		{
+			name:    "United Kingdom",
+			address: address.Address{Street: "10 Downing Street", City: "London", Zip: "sw1a2aa", Country: "UK"},
+			want:    address.Address{Street: "10 Downing Street", City: "London", Zip: "SW1A 2AA", Country: "GB"},
+		},
+		{
+			name:         "Germany without zip",
+			address:      address.Address{Street: "Unter den Linden 1", City: "Berlin", Country: "DE"},
+			wantProblems: "zip",
+		},
+		{
+			name:    "country without rules",
+			address: address.Address{Street: "Rua Augusta 1", City: "Lisboa", Country: "pt"},
+			want:    address.Address{Street: "Rua Augusta 1", City: "Lisboa", Country: "PT"},
+		},
+		{
+			name:         "unknown country",
+			address:      address.Address{Street: "1 Main St", City: "Springfield", Country: "Atlantis"},
+			wantProblems: "country",
+		},
+		{
+			name:         "empty",
+			wantProblems: "country street city",
+		},
+		{
+			name:         "control characters",
+			address:      address.Address{Street: "1 Main St\x00", City: "Palo Alto", Zip: "94301", State: "CA", Country: "US"},
+			wantProblems: "street",
+		},
+		{
+			name:         "too long",
+			address:      address.Address{Street: strings.Repeat("a", maxLength+1), City: "Palo Alto", Zip: "94301", State: "CA", Country: "US"},
+			wantProblems: "street",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New().Validate(context.Background(), tt.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fields []string
+			for _, p := range got.Problems {
+				fields = append(fields, p.Field)
+			}
+			if strings.Join(fields, " ") != tt.wantProblems {
+				t.Fatalf("got problems %+v, want problems with %s", got.Problems, tt.wantProblems)
+			}
+			if got.Valid != (tt.wantProblems == "") {
+				t.Errorf("got valid %v with problems %+v", got.Valid, got.Problems)
+			}
+			if got.Valid && !reflect.DeepEqual(got.Address, tt.want) {
+				t.Errorf("got address %+v, want %+v", got.Address, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Package carrier contains the interfaces that the Shipment service in
+// the ACME Serverless Fitness Shop uses to talk to the carriers that pick
+// up and deliver the shipments, after they were handed to the shipper. In
+// order to add a new carrier, the Adapter interface needs to be implemented,
+// and the Tracker interface for carriers that don't send tracking webhooks.
+package carrier
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// Adapter is the interface that describes the methods a carrier integration
+// needs to implement to be able to work with the Shipment service.
+type Adapter interface {
+	// Name returns the name of the adapter, like in logs and errors.
+	Name() string
+
+	// Cancel cancels the pickup of the shipment with the carrier. It is only called
+	// for shipments that haven't been picked up yet.
+	Cancel(ctx context.Context, s shipper.Shipment) error
+
+	// Return books the return leg of the delivered shipment with the carrier, which
+	// picks it up at the customer and brings it back to the warehouse, and returns the
+	// tracking number of the return leg.
+	Return(ctx context.Context, s shipper.Shipment) (string, error)
+}
+
+// Tracker is the interface of the integrations of carriers that only offer an API to
+// pull the status of shipments, which the Shipment service polls instead of receiving
+// their tracking webhooks.
+type Tracker interface {
+	// Carrier returns the name of the carrier, like in its tracking numbers.
+	Carrier() string
+
+	// Track returns the scans the carrier has of the shipment or parcel with the tracking
+	// number, which are all scans it knows, not only the new ones. A carrier that asks to
+	// send fewer requests returns a *RateLimitError.
+	Track(ctx context.Context, trackingNumber string) ([]shipper.Scan, error)
+}
+
+// RateLimitError is returned by a Tracker when the carrier rejected a request because too
+// many requests were sent.
+type RateLimitError struct {
+	// RetryAfter is how long the carrier asks to wait before the next request, or zero
+	// when it didn't say.
+	RetryAfter time.Duration
+}
+
+// Error returns a message with how long to wait, if known.
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter <= 0 {
+		return "rate limited by carrier"
+	}
+	return fmt.Sprintf("rate limited by carrier, retry after %s", e.RetryAfter)
+}
+
+// Nop is an Adapter that doesn't talk to any carrier.
+type Nop struct{}
+
+// Name returns none.
+func (Nop) Name() string {
+	return "none"
+}
+
+// Cancel does nothing.
+func (Nop) Cancel(ctx context.Context, s shipper.Shipment) error {
+	return nil
+}
+
+// Return returns a new tracking number in the format of the carrier of the shipment.
+func (Nop) Return(ctx context.Context, s shipper.Shipment) (string, error) {
+	return shipper.NewTrackingNumber(s.Carrier), nil
+}
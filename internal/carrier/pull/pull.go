@@ -0,0 +1,145 @@
+// Package pull contains a carrier Tracker that asks the tracking API of a carrier for the
+// scans of a shipment, for carriers that don't send tracking webhooks. The tracking API
+// responds to GET requests of the URL of the carrier followed by the tracking number with
+// the same payload as the webhooks of the carrier, which is parsed with its webhook Parser.
+package pull
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/carrier"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/webhook"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+)
+
+const (
+	// maxResponseSize is the maximum size, in bytes, of a response of a tracking API.
+	maxResponseSize = 1 << 20
+)
+
+// tracker is a struct that implements the methods of the Tracker interface.
+type tracker struct {
+	parser webhook.Parser
+	url    *url.URL
+	client *http.Client
+}
+
+// New creates a new Tracker that asks the tracking API at the target for the scans of the
+// shipments of the carrier with the Parser. The client determines the timeout of each
+// call. The method returns an error if the target isn't a valid HTTP URL.
+func New(parser webhook.Parser, target string, client *http.Client) (carrier.Tracker, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid tracking URL %q of %s, expected an absolute http or https URL", target, parser.Carrier())
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return tracker{
+		parser: parser,
+		url:    u,
+		client: client,
+	}, nil
+}
+
+// Carrier returns the name of the carrier of the Parser.
+func (t tracker) Carrier() string {
+	return t.parser.Carrier()
+}
+
+// Track asks the tracking API for the scans of the tracking number. A tracking number the
+// carrier doesn't know yet has no scans, and a response with status 429 returns a
+// *carrier.RateLimitError with the Retry-After of the response.
+func (t tracker) Track(ctx context.Context, trackingNumber string) ([]shipper.Scan, error) {
+	target := *t.url
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + trackingNumber
+	target.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, "GET", target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building http request: %s", err.Error())
+	}
+
+	req.Header.Add("accept", "application/json")
+	for k, v := range tracing.Inject(ctx) {
+		req.Header.Set(k, v)
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case res.StatusCode == http.StatusTooManyRequests:
+		return nil, &carrier.RateLimitError{RetryAfter: retryAfter(res.Header.Get("Retry-After"))}
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return nil, fmt.Errorf("tracking API of %s responded with status: %s", t.Carrier(), res.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response of tracking API of %s: %s", t.Carrier(), err.Error())
+	}
+
+	return t.parser.Parse(body)
+}
+
+// retryAfter returns the duration of the Retry-After header, which is a number of seconds,
+// or zero when it has none.
+func retryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// NewTrackers creates the Trackers of the tracking APIs written as a comma separated list
+// of carrier:url pairs, like "usps:https://tracking.example.com/usps,dhl:https://...".
+func NewTrackers(spec string, client *http.Client) ([]carrier.Tracker, error) {
+	var trackers []carrier.Tracker
+	seen := make(map[string]bool)
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid tracking URL %q, expected carrier:url", pair)
+		}
+
+		p, ok := webhook.Find(strings.TrimSpace(parts[0]))
+		if !ok {
+			return nil, fmt.Errorf("unknown carrier %q in tracking URLs, use %s", parts[0], strings.Join(webhook.Carriers(), ", "))
+		}
+		if seen[p.Carrier()] {
+			return nil, fmt.Errorf("carrier %s has more than one tracking URL", p.Carrier())
+		}
+		seen[p.Carrier()] = true
+
+		t, err := New(p, strings.TrimSpace(parts[1]), client)
+		if err != nil {
+			return nil, err
+		}
+		trackers = append(trackers, t)
+	}
+
+	return trackers, nil
+}
@@ -0,0 +1,109 @@
+package pull
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/carrier"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/webhook"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+func TestTrack(t *testing.T) {
+	// A fake tracking API of USPS that knows one tracking number
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch strings.TrimPrefix(r.URL.Path, "/usps/") {
+		case "9400111899223197428497":
+			w.Write([]byte(`{"trackingNumber":"9400111899223197428497","trackingEvents":[{"eventCode":"03","eventType":"USPS picked up item","eventTimestamp":"2020-04-01T12:00:00Z","eventCity":"RENO","eventState":"NV"}]}`))
+		case "busy":
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "broken":
+			w.WriteHeader(http.StatusBadGateway)
+		case "garbage":
+			w.Write([]byte(`<html>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, _ := webhook.Find("usps")
+	tr, err := New(p, server.URL+"/usps/", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scans, err := tr.Track(context.Background(), "9400111899223197428497")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scans) != 1 || scans[0].Event != shipper.ScanPickedUp || scans[0].Location != "RENO, NV" {
+		t.Errorf("got scans %+v, want the pickup in Reno", scans)
+	}
+	if paths[0] != "/usps/9400111899223197428497" {
+		t.Errorf("got request of %s, want the tracking number after the URL", paths[0])
+	}
+
+	// A tracking number the carrier doesn't know yet has no scans
+	if scans, err := tr.Track(context.Background(), "9400111899223197428480"); err != nil || len(scans) != 0 {
+		t.Errorf("got scans %+v and error %v for an unknown tracking number, want none", scans, err)
+	}
+
+	var rerr *carrier.RateLimitError
+	if _, err := tr.Track(context.Background(), "busy"); !errors.As(err, &rerr) || rerr.RetryAfter != 30*time.Second {
+		t.Errorf("got error %v when rate limited, want a *RateLimitError to retry after 30s", err)
+	}
+
+	for _, tn := range []string{"broken", "garbage"} {
+		if _, err := tr.Track(context.Background(), tn); err == nil || errors.As(err, &rerr) {
+			t.Errorf("got error %v for %s, want an error", err, tn)
+		}
+	}
+}
+
+func TestNewTrackers(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []string
+		wantErr string
+	}{
+		{name: "empty", spec: ""},
+		{name: "carriers", spec: "usps:https://tracking.example.com/usps, DHL:http://localhost:8081", want: []string{"USPS", "DHL"}},
+		{name: "no url", spec: "usps", wantErr: "expected carrier:url"},
+		{name: "unknown carrier", spec: "acme:https://tracking.example.com", wantErr: `unknown carrier "acme"`},
+		{name: "twice", spec: "usps:https://a.example.com,usps:https://b.example.com", wantErr: "more than one tracking URL"},
+		{name: "relative url", spec: "usps:/usps", wantErr: "invalid tracking URL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trackers, err := NewTrackers(tt.spec, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, tr := range trackers {
+				got = append(got, tr.Carrier())
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got trackers of %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// Package simulated contains a carrier Adapter for the simulated carriers of the
+// Shipment service, which are the carriers of the rate tables. It doesn't talk to
+// any carrier, but logs what it would ask of them.
+package simulated
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/retgits/acme-serverless-shipment/internal/carrier"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// adapter is the Adapter of the simulated carriers.
+type adapter struct{}
+
+// New creates a new carrier Adapter for the simulated carriers.
+func New() carrier.Adapter {
+	return adapter{}
+}
+
+// Name returns simulated.
+func (adapter) Name() string {
+	return "simulated"
+}
+
+// Cancel logs that the pickup of the shipment is cancelled.
+func (adapter) Cancel(ctx context.Context, s shipper.Shipment) error {
+	ctx = logging.With(ctx, logging.Carrier, s.Carrier, logging.TrackingNumber, s.TrackingNumber)
+	slog.InfoContext(ctx, "pickup cancelled with carrier", "pickupAt", s.PickupAt)
+	return nil
+}
+
+// Return logs that the return leg of the shipment is booked and returns a new tracking
+// number in the format of the carrier of the shipment.
+func (adapter) Return(ctx context.Context, s shipper.Shipment) (string, error) {
+	trackingNumber := shipper.NewTrackingNumber(s.Carrier)
+
+	ctx = logging.With(ctx, logging.Carrier, s.Carrier, logging.TrackingNumber, s.TrackingNumber)
+	slog.InfoContext(ctx, "return booked with carrier", "returnTrackingNumber", trackingNumber)
+
+	return trackingNumber, nil
+}
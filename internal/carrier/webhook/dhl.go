@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// dhlSignatureHeader is the header with the base64 encoded signature of DHL webhooks.
+const dhlSignatureHeader = "X-DHL-Signature"
+
+// dhlEvents maps the status codes of DHL onto the scans of the shipper. DHL reports the
+// pickup as a transit event, and pre-transit events are left out.
+var dhlEvents = map[string]string{
+	"transit":   shipper.ScanInTransit,
+	"delivered": shipper.ScanDelivered,
+	"failure":   shipper.ScanException,
+}
+
+// dhlNotification is a DHL tracking webhook, which has the events of one or more shipments.
+type dhlNotification struct {
+	Shipments []struct {
+		ID     string `json:"id"`
+		Events []struct {
+			Timestamp string `json:"timestamp"`
+			Location  struct {
+				Address struct {
+					AddressLocality string `json:"addressLocality"`
+				} `json:"address"`
+			} `json:"location"`
+			StatusCode  string `json:"statusCode"`
+			Description string `json:"description"`
+		} `json:"events"`
+	} `json:"shipments"`
+}
+
+// dhl parses the webhooks of DHL.
+type dhl struct{}
+
+// Carrier returns DHL.
+func (dhl) Carrier() string {
+	return "DHL"
+}
+
+// Verify checks the base64 encoded signature in the X-DHL-Signature header.
+func (dhl) Verify(header func(key string) string, body []byte, secret string) error {
+	signature, err := base64.StdEncoding.DecodeString(header(dhlSignatureHeader))
+	if err != nil {
+		return ErrSignature
+	}
+	return verify(signature, body, secret)
+}
+
+// Parse returns the scans of the events of all shipments, in the order of the webhook.
+func (dhl) Parse(body []byte) ([]shipper.Scan, error) {
+	var n dhlNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, fmt.Errorf("error parsing DHL webhook: %s", err.Error())
+	}
+
+	var scans []shipper.Scan
+	for i, s := range n.Shipments {
+		for j, e := range s.Events {
+			event, ok := dhlEvents[e.StatusCode]
+			if !ok {
+				continue
+			}
+
+			at, err := time.Parse(time.RFC3339, e.Timestamp)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing DHL webhook: invalid timestamp %q of shipments[%d].events[%d]", e.Timestamp, i, j)
+			}
+
+			scans = append(scans, shipper.Scan{
+				TrackingNumber: s.ID,
+				Event:          event,
+				At:             at,
+				Location:       location(e.Location.Address.AddressLocality),
+				Description:    e.Description,
+			})
+		}
+	}
+
+	return scans, nil
+}
@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// fedexSignatureHeader is the header with the base64 encoded signature of FedEx webhooks.
+const fedexSignatureHeader = "X-FedEx-Signature"
+
+// fedexEvents maps the event types of FedEx onto the scans of the shipper. Other event
+// types, like OC for a shipment that was created, are left out.
+var fedexEvents = map[string]string{
+	"PU": shipper.ScanPickedUp,
+	"AR": shipper.ScanInTransit,
+	"AF": shipper.ScanInTransit,
+	"DP": shipper.ScanInTransit,
+	"IT": shipper.ScanInTransit,
+	"OD": shipper.ScanOutForDelivery,
+	"DL": shipper.ScanDelivered,
+	"DE": shipper.ScanException,
+	"SE": shipper.ScanException,
+}
+
+// fedexNotification is a FedEx tracking webhook, which has the scan events of a package.
+type fedexNotification struct {
+	TrackingNumber string `json:"trackingNumber"`
+	ScanEvents     []struct {
+		Date             string `json:"date"`
+		EventType        string `json:"eventType"`
+		EventDescription string `json:"eventDescription"`
+		ScanLocation     struct {
+			City                string `json:"city"`
+			StateOrProvinceCode string `json:"stateOrProvinceCode"`
+			CountryCode         string `json:"countryCode"`
+		} `json:"scanLocation"`
+	} `json:"scanEvents"`
+}
+
+// fedex parses the webhooks of FedEx.
+type fedex struct{}
+
+// Carrier returns FedEx.
+func (fedex) Carrier() string {
+	return "FedEx"
+}
+
+// Verify checks the base64 encoded signature in the X-FedEx-Signature header.
+func (fedex) Verify(header func(key string) string, body []byte, secret string) error {
+	signature, err := base64.StdEncoding.DecodeString(header(fedexSignatureHeader))
+	if err != nil {
+		return ErrSignature
+	}
+	return verify(signature, body, secret)
+}
+
+// Parse returns the scans of the scan events, in the order of the webhook.
+func (fedex) Parse(body []byte) ([]shipper.Scan, error) {
+	var n fedexNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, fmt.Errorf("error parsing FedEx webhook: %s", err.Error())
+	}
+
+	var scans []shipper.Scan
+	for i, e := range n.ScanEvents {
+		event, ok := fedexEvents[e.EventType]
+		if !ok {
+			continue
+		}
+
+		at, err := time.Parse(time.RFC3339, e.Date)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing FedEx webhook: invalid date %q of scanEvents[%d]", e.Date, i)
+		}
+
+		scans = append(scans, shipper.Scan{
+			TrackingNumber: n.TrackingNumber,
+			Event:          event,
+			At:             at,
+			Location:       location(e.ScanLocation.City, e.ScanLocation.StateOrProvinceCode, e.ScanLocation.CountryCode),
+			Description:    e.EventDescription,
+		})
+	}
+
+	return scans, nil
+}
@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// upsSignatureHeader is the header with the hex encoded signature of UPS webhooks.
+const upsSignatureHeader = "X-UPS-Signature"
+
+// upsEvent is a UPS tracking webhook, which has a single activity of a package.
+type upsEvent struct {
+	TrackingNumber   string `json:"trackingNumber"`
+	GMTActivityDate  string `json:"gmtActivityDate"`
+	GMTActivityTime  string `json:"gmtActivityTime"`
+	ActivityLocation struct {
+		City          string `json:"city"`
+		StateProvince string `json:"stateProvince"`
+		Country       string `json:"country"`
+	} `json:"activityLocation"`
+	ActivityStatus struct {
+		Type        string `json:"type"`
+		Code        string `json:"code"`
+		Description string `json:"description"`
+	} `json:"activityStatus"`
+}
+
+// ups parses the webhooks of UPS.
+type ups struct{}
+
+// Carrier returns UPS.
+func (ups) Carrier() string {
+	return "UPS"
+}
+
+// Verify checks the hex encoded signature in the X-UPS-Signature header.
+func (ups) Verify(header func(key string) string, body []byte, secret string) error {
+	signature, err := hex.DecodeString(header(upsSignatureHeader))
+	if err != nil {
+		return ErrSignature
+	}
+	return verify(signature, body, secret)
+}
+
+// Parse returns the scan of the activity, which is left out when the type of the activity
+// is M, the shipment being manifested.
+func (ups) Parse(body []byte) ([]shipper.Scan, error) {
+	var e upsEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, fmt.Errorf("error parsing UPS webhook: %s", err.Error())
+	}
+
+	var event string
+	switch e.ActivityStatus.Type {
+	case "M":
+		return nil, nil
+	case "P":
+		event = shipper.ScanPickedUp
+	case "I":
+		event = shipper.ScanInTransit
+		if e.ActivityStatus.Code == "OT" {
+			event = shipper.ScanOutForDelivery
+		}
+	case "D":
+		event = shipper.ScanDelivered
+	case "X":
+		event = shipper.ScanException
+	default:
+		return nil, fmt.Errorf("error parsing UPS webhook: unknown activity type %q", e.ActivityStatus.Type)
+	}
+
+	at, err := time.Parse("20060102150405", e.GMTActivityDate+e.GMTActivityTime)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing UPS webhook: invalid activity date %q and time %q", e.GMTActivityDate, e.GMTActivityTime)
+	}
+
+	return []shipper.Scan{{
+		TrackingNumber: e.TrackingNumber,
+		Event:          event,
+		At:             at,
+		Location:       location(e.ActivityLocation.City, e.ActivityLocation.StateProvince, e.ActivityLocation.Country),
+		Description:    e.ActivityStatus.Description,
+	}}, nil
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// uspsSignatureHeader is the header with the signature of USPS webhooks, which is hex
+// encoded after a sha256= prefix.
+const uspsSignatureHeader = "X-USPS-Signature"
+
+// uspsEvents maps the event codes of USPS onto the scans of the shipper. Other event
+// codes, like the ones of a shipping label being created, are left out.
+var uspsEvents = map[string]string{
+	"03": shipper.ScanPickedUp,
+	"07": shipper.ScanInTransit,
+	"10": shipper.ScanInTransit,
+	"OF": shipper.ScanOutForDelivery,
+	"01": shipper.ScanDelivered,
+	"02": shipper.ScanException,
+	"04": shipper.ScanException,
+	"05": shipper.ScanException,
+}
+
+// uspsNotification is a USPS tracking webhook, which has the tracking events of a package.
+type uspsNotification struct {
+	TrackingNumber string `json:"trackingNumber"`
+	TrackingEvents []struct {
+		EventCode      string `json:"eventCode"`
+		EventType      string `json:"eventType"`
+		EventTimestamp string `json:"eventTimestamp"`
+		EventCity      string `json:"eventCity"`
+		EventState     string `json:"eventState"`
+		EventCountry   string `json:"eventCountry"`
+	} `json:"trackingEvents"`
+}
+
+// usps parses the webhooks of USPS.
+type usps struct{}
+
+// Carrier returns USPS.
+func (usps) Carrier() string {
+	return "USPS"
+}
+
+// Verify checks the signature in the X-USPS-Signature header, like sha256=0a1b2c.
+func (usps) Verify(header func(key string) string, body []byte, secret string) error {
+	value := header(uspsSignatureHeader)
+	if !strings.HasPrefix(value, "sha256=") {
+		return ErrSignature
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(value, "sha256="))
+	if err != nil {
+		return ErrSignature
+	}
+	return verify(signature, body, secret)
+}
+
+// Parse returns the scans of the tracking events, in the order of the webhook.
+func (usps) Parse(body []byte) ([]shipper.Scan, error) {
+	var n uspsNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, fmt.Errorf("error parsing USPS webhook: %s", err.Error())
+	}
+
+	var scans []shipper.Scan
+	for i, e := range n.TrackingEvents {
+		event, ok := uspsEvents[e.EventCode]
+		if !ok {
+			continue
+		}
+
+		at, err := time.Parse(time.RFC3339, e.EventTimestamp)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing USPS webhook: invalid eventTimestamp %q of trackingEvents[%d]", e.EventTimestamp, i)
+		}
+
+		scans = append(scans, shipper.Scan{
+			TrackingNumber: n.TrackingNumber,
+			Event:          event,
+			At:             at,
+			Location:       location(e.EventCity, e.EventState, e.EventCountry),
+			Description:    e.EventType,
+		})
+	}
+
+	return scans, nil
+}
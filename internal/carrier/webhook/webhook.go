@@ -0,0 +1,112 @@
+// Package webhook contains the parsers of the tracking webhooks that carriers send to the
+// Shipment service in the ACME Serverless Fitness Shop. Every carrier has its own payload,
+// which is parsed into the scans of the shipper, and signs it with a secret it shares with
+// the service, in its own header. In order to accept the webhooks of a new carrier, the
+// Parser interface needs to be implemented and added to the parsers.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// ErrSignature is returned when the signature of a webhook is missing or doesn't match
+// the body.
+var ErrSignature = errors.New("the signature of the webhook is missing or invalid")
+
+// Parser verifies and parses the tracking webhooks of a carrier.
+type Parser interface {
+	// Carrier returns the name of the carrier, like in its tracking numbers.
+	Carrier() string
+
+	// Verify checks the signature of the body with the secret the service shares with the
+	// carrier, reading the headers of the request with header. It returns ErrSignature
+	// when the signature is missing or doesn't match.
+	Verify(header func(key string) string, body []byte, secret string) error
+
+	// Parse returns the scans in the body. Events of the carrier that aren't scans of a
+	// shipment, like the label being printed, are left out.
+	Parse(body []byte) ([]shipper.Scan, error)
+}
+
+// parsers are the Parsers of all carriers that send webhooks.
+var parsers = []Parser{ups{}, fedex{}, usps{}, dhl{}}
+
+// Find returns the Parser of the carrier, ignoring case.
+func Find(carrier string) (Parser, bool) {
+	for _, p := range parsers {
+		if strings.EqualFold(p.Carrier(), carrier) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Carriers returns the names of the carriers that send webhooks.
+func Carriers() []string {
+	names := make([]string, len(parsers))
+	for i, p := range parsers {
+		names[i] = p.Carrier()
+	}
+	return names
+}
+
+// ParseSecrets parses the secrets of the carriers written as a comma separated list of
+// carrier:secret pairs, like "ups:s3cr3t,fedex:t0ps3cr3t", and returns them by the name of
+// the carrier.
+func ParseSecrets(spec string) (map[string]string, error) {
+	secrets := make(map[string]string)
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid webhook secret for %q, expected carrier:secret", parts[0])
+		}
+
+		p, ok := Find(strings.TrimSpace(parts[0]))
+		if !ok {
+			return nil, fmt.Errorf("unknown carrier %q in webhook secrets, use %s", parts[0], strings.Join(Carriers(), ", "))
+		}
+		secrets[p.Carrier()] = strings.TrimSpace(parts[1])
+	}
+
+	return secrets, nil
+}
+
+// Sign returns the HMAC-SHA256 of the body with the secret, which every carrier sends in
+// its own encoding.
+func Sign(body []byte, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// verify returns ErrSignature unless the signature is the one of the body with the secret.
+func verify(signature []byte, body []byte, secret string) error {
+	if len(signature) == 0 || secret == "" || !hmac.Equal(signature, Sign(body, secret)) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// location joins the parts of the location of a scan that are known, like the city and
+// the state.
+func location(parts ...string) string {
+	var known []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			known = append(known, p)
+		}
+	}
+	return strings.Join(known, ", ")
+}
@@ -0,0 +1,150 @@
+package webhook
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// webhooks are tracking webhooks like the ones the carriers send, with the scans in them.
+var webhooks = []struct {
+	carrier   string
+	payload   string
+	header    string
+	signature func(b []byte) string
+	want      []string
+}{
+	{
+		carrier:   "UPS",
+		payload:   `{"trackingNumber":"1Z999AA10123456784","gmtActivityDate":"20200401","gmtActivityTime":"190000","activityLocation":{"city":"RENO","stateProvince":"NV","country":"US"},"activityStatus":{"type":"I","code":"OT","description":"Out For Delivery Today"}}`,
+		header:    "X-UPS-Signature",
+		signature: hex.EncodeToString,
+		want:      []string{"1Z999AA10123456784 out for delivery 2020-04-01T19:00:00Z RENO, NV, US"},
+	},
+	{
+		carrier:   "FedEx",
+		payload:   `{"trackingNumber":"123456789012","scanEvents":[{"date":"2020-04-01T12:00:00-07:00","eventType":"PU","eventDescription":"Picked up","scanLocation":{"city":"Reno","stateOrProvinceCode":"NV","countryCode":"US"}},{"date":"2020-04-01T11:00:00-07:00","eventType":"OC","eventDescription":"Shipment information sent to FedEx"},{"date":"2020-04-02T09:30:00-07:00","eventType":"DL","eventDescription":"Delivered","scanLocation":{"city":"Palo Alto","stateOrProvinceCode":"CA"}}]}`,
+		header:    "X-FedEx-Signature",
+		signature: base64.StdEncoding.EncodeToString,
+		want:      []string{"123456789012 picked up 2020-04-01T19:00:00Z Reno, NV, US", "123456789012 delivered 2020-04-02T16:30:00Z Palo Alto, CA"},
+	},
+	{
+		carrier:   "USPS",
+		payload:   `{"trackingNumber":"9400111899223197428497","trackingEvents":[{"eventCode":"GX","eventType":"Shipping Label Created","eventTimestamp":"2020-04-01T08:00:00Z"},{"eventCode":"05","eventType":"Undeliverable as Addressed","eventTimestamp":"2020-04-02T17:00:00Z","eventCity":"PALO ALTO","eventState":"CA"}]}`,
+		header:    "X-USPS-Signature",
+		signature: func(b []byte) string { return "sha256=" + hex.EncodeToString(b) },
+		want:      []string{"9400111899223197428497 exception 2020-04-02T17:00:00Z PALO ALTO, CA"},
+	},
+	{
+		carrier:   "DHL",
+		payload:   `{"shipments":[{"id":"1234567891","events":[{"timestamp":"2020-04-01T12:00:00Z","location":{"address":{"addressLocality":"Reno, NV, US"}},"statusCode":"transit","description":"Shipment picked up"},{"timestamp":"2020-04-01T10:00:00Z","statusCode":"pre-transit","description":"Shipment information received"}]}]}`,
+		header:    "X-DHL-Signature",
+		signature: base64.StdEncoding.EncodeToString,
+		want:      []string{"1234567891 in transit 2020-04-01T12:00:00Z Reno, NV, US"},
+	},
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range webhooks {
+		t.Run(tt.carrier, func(t *testing.T) {
+			p, ok := Find(strings.ToLower(tt.carrier))
+			if !ok {
+				t.Fatalf("got no parser for %s", tt.carrier)
+			}
+
+			scans, err := p.Parse([]byte(tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, s := range scans {
+				if err := shipper.ValidateScan(s); err != nil {
+					t.Errorf("got invalid scan %+v: %s", s, err.Error())
+				}
+				got = append(got, s.TrackingNumber+" "+s.Event+" "+s.At.UTC().Format(time.RFC3339)+" "+s.Location)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got scans %q, want %q", got, tt.want)
+			}
+
+			// Payloads of other carriers, or that aren't JSON, aren't scans
+			if _, err := p.Parse([]byte(`{"trackingNumber":`)); err == nil {
+				t.Errorf("got no error for a truncated payload")
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, tt := range webhooks {
+		t.Run(tt.carrier, func(t *testing.T) {
+			p, _ := Find(tt.carrier)
+			body := []byte(tt.payload)
+
+			tests := []struct {
+				name    string
+				value   string
+				body    []byte
+				secret  string
+				wantErr error
+			}{
+				{name: "signed", value: tt.signature(Sign(body, "s3cr3t")), body: body, secret: "s3cr3t"},
+				{name: "other secret", value: tt.signature(Sign(body, "other")), body: body, secret: "s3cr3t", wantErr: ErrSignature},
+				{name: "changed body", value: tt.signature(Sign(body, "s3cr3t")), body: append([]byte(" "), body...), secret: "s3cr3t", wantErr: ErrSignature},
+				{name: "no signature", body: body, secret: "s3cr3t", wantErr: ErrSignature},
+				{name: "not encoded", value: "not a signature!", body: body, secret: "s3cr3t", wantErr: ErrSignature},
+				{name: "no secret", value: tt.signature(Sign(body, "")), body: body, wantErr: ErrSignature},
+			}
+
+			for _, v := range tests {
+				header := func(key string) string {
+					if key == tt.header {
+						return v.value
+					}
+					return ""
+				}
+				if err := p.Verify(header, v.body, v.secret); err != v.wantErr {
+					t.Errorf("%s: got error %v, want %v", v.name, err, v.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "empty", spec: "", want: map[string]string{}},
+		{name: "carriers", spec: "ups:s3cr3t, FEDEX:a:b", want: map[string]string{"UPS": "s3cr3t", "FedEx": "a:b"}},
+		{name: "no secret", spec: "ups:", wantErr: "expected carrier:secret"},
+		{name: "unknown carrier", spec: "acme:s3cr3t", wantErr: `unknown carrier "acme"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSecrets(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got secrets %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
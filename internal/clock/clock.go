@@ -0,0 +1,63 @@
+// Package clock abstracts the passing of time in the shipment workflow, so the simulated
+// delivery of a shipment, which takes minutes in real time, can run faster when the
+// whole lifecycle of a shipment is exercised on a developer machine.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for it to pass.
+type Clock interface {
+	// Now returns the current time of the clock.
+	Now() time.Time
+
+	// NewTimer creates a timer that fires after the duration has passed on the clock.
+	NewTimer(d time.Duration) *time.Timer
+}
+
+// Real is the clock of the system.
+type Real struct{}
+
+// Now returns the current time of the system.
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer creates a timer that fires after the duration has passed.
+func (Real) NewTimer(d time.Duration) *time.Timer {
+	return time.NewTimer(d)
+}
+
+// scaled is a clock that runs a number of times faster than the clock of the system.
+type scaled struct {
+	speed float64
+
+	once  sync.Once
+	start time.Time
+}
+
+// Scaled creates a clock that starts at the current time and runs speed times faster
+// than the clock of the system, so with a speed of 60 a delivery that takes a minute
+// is done in a second. A speed of 1 or less returns the real clock.
+func Scaled(speed float64) Clock {
+	if speed <= 1 {
+		return Real{}
+	}
+
+	return &scaled{speed: speed}
+}
+
+// Now returns the simulated time.
+func (c *scaled) Now() time.Time {
+	c.once.Do(func() { c.start = time.Now() })
+
+	elapsed := time.Since(c.start)
+	return c.start.Add(time.Duration(float64(elapsed) * c.speed))
+}
+
+// NewTimer creates a timer that fires after the duration has passed on the simulated clock.
+func (c *scaled) NewTimer(d time.Duration) *time.Timer {
+	return time.NewTimer(time.Duration(float64(d) / c.speed))
+}
@@ -0,0 +1,420 @@
+// Package config contains the configuration of the Shipment service in the ACME Serverless
+// Fitness Shop. The configuration is loaded from an optional YAML or JSON file, referenced by
+// the environment variable CONFIG_FILE, and from environment variables. Environment variables
+// take precedence over the file, which takes precedence over the defaults.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FileEnvVar is the name of the environment variable that points to the
+	// optional configuration file.
+	FileEnvVar = "CONFIG_FILE"
+)
+
+// Config is the configuration for all binaries of the Shipment service. Every field
+// is tagged with the name of the environment variable (env), the key in the configuration
+// file (key), an optional default value (default) and a description (desc) that is used
+// to build actionable error messages.
+type Config struct {
+	// Version is the version of the service that is running.
+	Version string `env:"VERSION" key:"version" default:"dev" desc:"the version of the service that is running"`
+
+	// Stage is the environment in which the service is running.
+	Stage string `env:"STAGE" key:"stage" desc:"the environment in which the service is running"`
+
+	// SentryDSN is the DSN to connect to Sentry.
+	SentryDSN string `env:"SENTRY_DSN" key:"sentryDsn" desc:"the DSN to connect to Sentry"`
+
+	// FunctionName is the name of the Lambda function.
+	FunctionName string `env:"FUNCTION_NAME" key:"functionName" desc:"the name of the Lambda function"`
+
+	// Region is the AWS region in which the SQS queue or EventBridge bus lives.
+	Region string `env:"REGION" key:"region" desc:"the AWS region in which the SQS queue or EventBridge bus lives"`
+
+	// EventBus is the name of the EventBridge bus to send events to.
+	EventBus string `env:"EVENTBUS" key:"eventBus" desc:"the name of the EventBridge bus to send events to"`
+
+	// ResponseQueue is the ARN of the SQS queue to send events to.
+	ResponseQueue string `env:"RESPONSEQUEUE" key:"responseQueue" desc:"the ARN of the SQS queue to send events to (like arn:aws:sqs:us-west-2:123456789012:queue)"`
+
+	// Emitter is the messaging layer the HTTP service sends events with (webhook, sqs, eventbridge or mock).
+	Emitter string `env:"EMITTER" key:"emitter" default:"webhook" desc:"the messaging layer the HTTP service sends events with (webhook, sqs, eventbridge, mock or file)"`
+
+	// EmitterPath is the file the file emitter appends events to.
+	EmitterPath string `env:"EMITTER_PATH" key:"emitterPath" default:"events.jsonl" desc:"the file the file emitter appends events to"`
+
+	// OrderURL is the URL of the order service that receives shipment updates.
+	OrderURL string `env:"ORDER_URL" key:"orderUrl" desc:"the URL of the order service that receives shipment updates"`
+
+	// OrderHost is the value of the host header sent to the order service.
+	OrderHost string `env:"ORDER_HOST" key:"orderHost" desc:"the value of the host header sent to the order service"`
+
+	// Port is the port number the HTTP server listens on.
+	Port int `env:"PORT" key:"port" default:"8080" desc:"the port number the HTTP server listens on"`
+
+	// Service is the name of the service.
+	Service string `env:"K_SERVICE" key:"service" default:"shipment" desc:"the name of the service"`
+
+	// OrderTimeout is the timeout of a single call to the order service.
+	OrderTimeout time.Duration `env:"ORDER_TIMEOUT" key:"orderTimeout" default:"10s" desc:"the timeout of a single call to the order service (like 10s)"`
+
+	// DeliveryWorkers is the number of deliveries the HTTP service handles at the same time.
+	DeliveryWorkers int `env:"DELIVERY_WORKERS" key:"deliveryWorkers" default:"4" desc:"the number of deliveries the HTTP service handles at the same time"`
+
+	// DeliveryQueueSize is the maximum number of outstanding deliveries in the HTTP service.
+	DeliveryQueueSize int `env:"DELIVERY_QUEUE_SIZE" key:"deliveryQueueSize" default:"1000" desc:"the maximum number of outstanding deliveries in the HTTP service"`
+
+	// DeliveryRetryBackoff is how long a delivery that failed waits before the HTTP service tries it again.
+	DeliveryRetryBackoff time.Duration `env:"DELIVERY_RETRY_BACKOFF" key:"deliveryRetryBackoff" default:"5s" desc:"how long a delivery that failed waits before the HTTP service tries it again, doubled after every next failure (like 5s)"`
+
+	// DeliveryMaxRetryBackoff is the longest a delivery that failed waits before the HTTP service tries it again.
+	DeliveryMaxRetryBackoff time.Duration `env:"DELIVERY_MAX_RETRY_BACKOFF" key:"deliveryMaxRetryBackoff" default:"5m" desc:"the longest a delivery that failed waits before the HTTP service tries it again (like 5m)"`
+
+	// MetricsInterval is how often the HTTP service reports the state of the delivery queue.
+	MetricsInterval time.Duration `env:"METRICS_INTERVAL" key:"metricsInterval" default:"10s" desc:"how often the HTTP service reports the state of the delivery queue (like 10s)"`
+
+	// ReadyMaxSaturation is the fraction of the delivery queue above which the HTTP service reports it isn't ready.
+	ReadyMaxSaturation float64 `env:"READY_MAX_SATURATION" key:"readyMaxSaturation" default:"0.9" desc:"the fraction of the delivery queue above which the HTTP service reports it isn't ready (like 0.9)"`
+
+	// Store is the storage layer used to keep track of shipments (memory, file or dynamodb).
+	Store string `env:"STORE" key:"store" default:"memory" desc:"the storage layer used to keep track of shipments (memory, file or dynamodb)"`
+
+	// StorePath is the path of the file used by the file storage layer.
+	StorePath string `env:"STORE_PATH" key:"storePath" default:"shipments.json" desc:"the path of the file used by the file storage layer"`
+
+	// StoreTable is the name of the DynamoDB table used by the dynamodb storage layer.
+	StoreTable string `env:"STORE_TABLE" key:"storeTable" desc:"the name of the DynamoDB table used by the dynamodb storage layer"`
+
+	// ShutdownGracePeriod is how long the HTTP service waits for outstanding deliveries when it stops.
+	ShutdownGracePeriod time.Duration `env:"SHUTDOWN_GRACE_PERIOD" key:"shutdownGracePeriod" default:"9s" desc:"how long the HTTP service waits for outstanding deliveries when it stops (like 9s)"`
+
+	// ClockSpeed is how many times faster than real time the simulated clock of shipment-local runs.
+	ClockSpeed float64 `env:"CLOCK_SPEED" key:"clockSpeed" default:"60" desc:"how many times faster than real time the simulated clock of shipment-local runs (like 60)"`
+
+	// RateTables is the directory with the rate tables of the simulated carriers.
+	RateTables string `env:"RATE_TABLES" key:"rateTables" desc:"the directory with the YAML, JSON and CSV rate tables of the simulated carriers (the built-in tables are used when empty)"`
+
+	// RateTablesReloadInterval is how often the HTTP service checks the rate tables for changes.
+	RateTablesReloadInterval time.Duration `env:"RATE_TABLES_RELOAD_INTERVAL" key:"rateTablesReloadInterval" default:"30s" desc:"how often the HTTP service checks the rate tables for changes, 0 to never reload them (like 30s)"`
+
+	// AddressProvider is the name of the provider that validates the addresses of shipments.
+	AddressProvider string `env:"ADDRESS_PROVIDER" key:"addressProvider" default:"rules" desc:"the provider that validates the addresses of shipments (rules or none)"`
+
+	// AddressRequired is set when shipments can only be requested with an address.
+	AddressRequired bool `env:"ADDRESS_REQUIRED" key:"addressRequired" default:"false" desc:"whether shipments can only be requested with an address"`
+
+	// CarrierAdapter is the name of the adapter that talks to the carriers of shipments.
+	CarrierAdapter string `env:"CARRIER_ADAPTER" key:"carrierAdapter" default:"simulated" desc:"the adapter that talks to the carriers of shipments, like to cancel a pickup (simulated or none)"`
+
+	// CarrierWebhookSecrets are the secrets the carriers sign their tracking webhooks with, as carrier:secret pairs.
+	CarrierWebhookSecrets string `env:"CARRIER_WEBHOOK_SECRETS" key:"carrierWebhookSecrets" desc:"the secrets the carriers sign their tracking webhooks with, as carrier:secret pairs with UPS, FedEx, USPS or DHL (webhooks of carriers without a secret are rejected)"`
+
+	// CarrierTrackingURLs are the tracking APIs of the carriers that are polled for scans, as carrier:url pairs.
+	CarrierTrackingURLs string `env:"CARRIER_TRACKING_URLS" key:"carrierTrackingUrls" desc:"the tracking APIs of the carriers that are polled for the scans of shipments, as carrier:url pairs with UPS, FedEx, USPS or DHL (no carrier is polled when empty)"`
+
+	// TrackingPollInterval is how often the tracking poller looks for shipments that are due, and the shortest time between two polls of a shipment.
+	TrackingPollInterval time.Duration `env:"TRACKING_POLL_INTERVAL" key:"trackingPollInterval" default:"1m" desc:"how often the tracking poller looks for shipments that are due, and the shortest time between two polls of a shipment (like 1m)"`
+
+	// TrackingMaxInterval is the longest time between two polls of a shipment.
+	TrackingMaxInterval time.Duration `env:"TRACKING_MAX_INTERVAL" key:"trackingMaxInterval" default:"1h" desc:"the longest time between two polls of a shipment by the tracking poller (like 1h)"`
+
+	// TrackingRate is the maximum number of requests per second the tracking poller sends to a carrier.
+	TrackingRate float64 `env:"TRACKING_RATE" key:"trackingRate" default:"1" desc:"the maximum number of requests per second the tracking poller sends to a carrier (like 1)"`
+
+	// TrackingMaxBackoff is the longest the tracking poller leaves a carrier alone after it failed.
+	TrackingMaxBackoff time.Duration `env:"TRACKING_MAX_BACKOFF" key:"trackingMaxBackoff" default:"15m" desc:"the longest the tracking poller leaves a carrier alone after it failed or rate limited the poller (like 15m)"`
+
+	// Warehouses is the file with the warehouses orders are shipped from and their stock rules.
+	Warehouses string `env:"WAREHOUSES" key:"warehouses" desc:"the YAML or JSON file with the warehouses orders are shipped from and their stock rules (the built-in warehouses are used when empty)"`
+
+	// LogLevel is the minimum level of the log records (debug, info, warn or error).
+	LogLevel string `env:"LOG_LEVEL" key:"logLevel" default:"info" desc:"the minimum level of the log records (debug, info, warn or error)"`
+
+	// LogFormat is the format of the log records (json or text).
+	LogFormat string `env:"LOG_FORMAT" key:"logFormat" default:"json" desc:"the format of the log records (json or text)"`
+
+	// RedactRules are the redaction rules, as field:action pairs, applied on top of the default rules.
+	RedactRules string `env:"REDACT_RULES" key:"redactRules" desc:"the redaction rules applied on top of the default rules, as field:action pairs with drop, hash or mask (like address:drop,email:hash)"`
+
+	// RedactKey is the key of the hashes that replace redacted values.
+	RedactKey string `env:"REDACT_KEY" key:"redactKey" desc:"the key of the hashes that replace redacted values"`
+
+	// TracingExporter is the exporter of the OpenTelemetry spans (none, stdout or otlp).
+	TracingExporter string `env:"TRACING_EXPORTER" key:"tracingExporter" default:"none" desc:"the exporter of the OpenTelemetry spans (none, stdout or otlp)"`
+
+	// OTLPEndpoint is the URL of the OTLP/HTTP collector the spans are exported to.
+	OTLPEndpoint string `env:"OTLP_ENDPOINT" key:"otlpEndpoint" desc:"the URL of the OTLP/HTTP collector the spans are exported to (like http://localhost:4318)"`
+
+	// WavefrontURL is the URL to connect to Wavefront.
+	WavefrontURL string `env:"WAVEFRONT_URL" key:"wavefrontUrl" desc:"the URL to connect to Wavefront (use debug to log metrics instead)"`
+
+	// WavefrontToken is the token the HTTP service uses to connect to Wavefront.
+	WavefrontToken string `env:"WAVEFRONT_TOKEN" key:"wavefrontToken" desc:"the token the HTTP service uses to connect to Wavefront"`
+
+	// WavefrontAPIToken is the token the Lambda functions use to connect to Wavefront.
+	WavefrontAPIToken string `env:"WAVEFRONT_API_TOKEN" key:"wavefrontApiToken" desc:"the token the Lambda functions use to connect to Wavefront"`
+}
+
+// Field describes a single configuration value.
+type Field struct {
+	// Env is the name of the environment variable.
+	Env string
+
+	// Key is the name of the key in the configuration file.
+	Key string
+
+	// Description explains what the value is used for.
+	Description string
+}
+
+// ValidationError is returned when required configuration values are missing.
+type ValidationError struct {
+	// Binary is the name of the binary that was validated.
+	Binary string
+
+	// Missing contains the fields that have no value.
+	Missing []Field
+}
+
+// Error returns a message that lists all missing values and how to set them.
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "missing required configuration for %s:\n", e.Binary)
+	for _, f := range e.Missing {
+		fmt.Fprintf(&b, "  - %s (key %q in %s): %s\n", f.Env, f.Key, FileEnvVar, f.Description)
+	}
+	fmt.Fprintf(&b, "set them as environment variables or in the YAML or JSON file referenced by %s", FileEnvVar)
+	return b.String()
+}
+
+// Load creates a new Config from the defaults, the file referenced by CONFIG_FILE
+// (if set) and the environment variables, in that order of precedence.
+func Load() (*Config, error) {
+	return LoadWithDefaults(nil)
+}
+
+// LoadWithDefaults is like Load, but replaces the defaults of the values with the
+// environment variable names in defaults, for binaries that need other defaults,
+// like a mock emitter for local development.
+func LoadWithDefaults(defaults map[string]string) (*Config, error) {
+	c := &Config{}
+
+	if err := c.apply(func(f reflect.StructField) (string, bool) {
+		if v, ok := defaults[f.Tag.Get("env")]; ok {
+			return v, true
+		}
+		v, ok := f.Tag.Lookup("default")
+		return v, ok
+	}, "default"); err != nil {
+		return nil, err
+	}
+
+	if file := os.Getenv(FileEnvVar); file != "" {
+		values, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := c.apply(func(f reflect.StructField) (string, bool) {
+			v, ok := values[f.Tag.Get("key")]
+			if !ok || v == nil {
+				return "", false
+			}
+			return fmt.Sprint(v), true
+		}, file); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.apply(func(f reflect.StructField) (string, bool) {
+		v, ok := os.LookupEnv(f.Tag.Get("env"))
+		return v, ok && v != ""
+	}, "environment"); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Validate checks that the values for the given environment variable names are set and
+// returns a *ValidationError, naming the binary, listing every missing value.
+func (c *Config) Validate(binary string, required ...string) error {
+	fields := fieldsByEnv()
+	v := reflect.ValueOf(c).Elem()
+
+	var missing []Field
+	for _, name := range required {
+		f, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("unknown configuration value %s", name)
+		}
+		if v.FieldByIndex(f.index).IsZero() {
+			missing = append(missing, f.Field)
+		}
+	}
+
+	if len(missing) > 0 {
+		return &ValidationError{Binary: binary, Missing: missing}
+	}
+
+	return nil
+}
+
+// LoadFor loads the configuration and validates that the values the binary requires are
+// set. The returned error is meant to be printed as-is at startup.
+func LoadFor(binary string, required ...string) (*Config, error) {
+	c, err := Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading configuration for %s: %s", binary, err.Error())
+	}
+
+	if err := c.Validate(binary, required...); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// field is a Field with the index of the struct field it belongs to.
+type field struct {
+	Field
+	index []int
+}
+
+// fieldsByEnv returns all configurable fields of Config, keyed by the name of the
+// environment variable.
+func fieldsByEnv() map[string]field {
+	t := reflect.TypeOf(Config{})
+	fields := make(map[string]field, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		env, ok := f.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		fields[env] = field{
+			Field: Field{
+				Env:         env,
+				Key:         f.Tag.Get("key"),
+				Description: f.Tag.Get("desc"),
+			},
+			index: f.Index,
+		}
+	}
+
+	return fields
+}
+
+// apply sets every field for which lookup returns a value. The source is
+// used in error messages to tell where an invalid value came from.
+func (c *Config) apply(lookup func(reflect.StructField) (string, bool), source string) error {
+	v := reflect.ValueOf(c).Elem()
+	fields := fieldsByEnv()
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := fields[name]
+		sf := v.Type().FieldByIndex(f.index)
+
+		raw, ok := lookup(sf)
+		if !ok {
+			continue
+		}
+
+		if err := set(v.FieldByIndex(f.index), raw); err != nil {
+			return fmt.Errorf("invalid value %q for %s (from %s): %s", raw, f.Env, source, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// set parses the raw string into the type of the field.
+func set(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// readFile reads a YAML or JSON configuration file, based on the extension
+// of the file, into a map of keys and values.
+func readFile(name string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("error reading configuration file: %s", err.Error())
+	}
+
+	values := make(map[string]interface{})
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		err = dec.Decode(&values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &values)
+	default:
+		return nil, fmt.Errorf("unsupported configuration file %s: use a .yaml, .yml or .json file", name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error parsing configuration file %s: %s", name, err.Error())
+	}
+
+	return values, nil
+}
@@ -0,0 +1,128 @@
+// Package correlation ties the events of the Shipment service to the messages that
+// caused them. Every event gets its own event ID, carries the ID of the inbound message
+// as causation ID and shares a correlation ID with all other events of the same order.
+// The schema of the events of the ACME Serverless Fitness Shop has no room for these
+// IDs, so they travel next to the event, like the trace context: as SQS message
+// attributes, in a field of the EventBridge detail or as HTTP headers.
+package correlation
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// Field is the name of the field that carries the IDs in the JSON payload of
+	// events that have no headers, like the EventBridge detail.
+	Field = "correlation"
+
+	// EventIDKey is the name of the message attribute with the event ID.
+	EventIDKey = "eventId"
+
+	// CausationIDKey is the name of the message attribute with the causation ID.
+	CausationIDKey = "causationId"
+
+	// CorrelationIDKey is the name of the message attribute with the correlation ID.
+	CorrelationIDKey = "correlationId"
+
+	// EventIDHeader is the HTTP header with the event ID.
+	EventIDHeader = "X-Event-Id"
+
+	// CausationIDHeader is the HTTP header with the causation ID.
+	CausationIDHeader = "X-Causation-Id"
+
+	// CorrelationIDHeader is the HTTP header with the correlation ID.
+	CorrelationIDHeader = "X-Correlation-Id"
+)
+
+// IDs identify an event and the messages it is related to.
+type IDs struct {
+	// EventID is the unique ID of the event.
+	EventID string `json:"eventId,omitempty"`
+
+	// CausationID is the ID of the message that caused the event.
+	CausationID string `json:"causationId,omitempty"`
+
+	// CorrelationID is the ID shared by all messages of the same order.
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+// NewID returns a new random ID.
+func NewID() string {
+	return uuid.Must(uuid.NewV4()).String()
+}
+
+// Caused returns the IDs of a new event caused by the message with these IDs. The
+// new event keeps the correlation ID of the message, or starts a new correlation
+// when the message has none.
+func (ids IDs) Caused() IDs {
+	correlationID := ids.CorrelationID
+	if correlationID == "" {
+		correlationID = NewID()
+	}
+
+	return IDs{
+		EventID:       NewID(),
+		CausationID:   ids.EventID,
+		CorrelationID: correlationID,
+	}
+}
+
+// Attributes returns the IDs that are set as a map keyed by the message attribute
+// names, like eventId.
+func (ids IDs) Attributes() map[string]string {
+	return ids.toMap(EventIDKey, CausationIDKey, CorrelationIDKey)
+}
+
+// Headers returns the IDs that are set as a map keyed by the HTTP header names,
+// like X-Event-Id.
+func (ids IDs) Headers() map[string]string {
+	return ids.toMap(EventIDHeader, CausationIDHeader, CorrelationIDHeader)
+}
+
+// FromAttributes returns the IDs from a map keyed by the message attribute names.
+func FromAttributes(m map[string]string) IDs {
+	return IDs{
+		EventID:       m[EventIDKey],
+		CausationID:   m[CausationIDKey],
+		CorrelationID: m[CorrelationIDKey],
+	}
+}
+
+// FromHeaders returns the IDs from the HTTP headers, using get to look up a header.
+func FromHeaders(get func(key string) string) IDs {
+	return IDs{
+		EventID:       get(EventIDHeader),
+		CausationID:   get(CausationIDHeader),
+		CorrelationID: get(CorrelationIDHeader),
+	}
+}
+
+func (ids IDs) toMap(eventID, causationID, correlationID string) map[string]string {
+	m := make(map[string]string, 3)
+	if ids.EventID != "" {
+		m[eventID] = ids.EventID
+	}
+	if ids.CausationID != "" {
+		m[causationID] = ids.CausationID
+	}
+	if ids.CorrelationID != "" {
+		m[correlationID] = ids.CorrelationID
+	}
+	return m
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries the IDs of the event being sent.
+func NewContext(ctx context.Context, ids IDs) context.Context {
+	return context.WithValue(ctx, contextKey{}, ids)
+}
+
+// FromContext returns the IDs of the event being sent, or empty IDs when ctx
+// carries none.
+func FromContext(ctx context.Context) IDs {
+	ids, _ := ctx.Value(contextKey{}).(IDs)
+	return ids
+}
@@ -0,0 +1,492 @@
+// Package delivery schedules the simulated delivery of shipments in long running
+// services, like the Cloud Run service, where the delivery happens in the background
+// after the shipment has been sent. Shipments wait in a bounded queue until they are
+// due and are then handed to a fixed number of workers, so the number of goroutines
+// doesn't grow with the number of shipments. A delivery that fails is tried again
+// later, and the scheduler keeps track of every outstanding delivery so the service
+// can drain them when it shuts down.
+package delivery
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+var (
+	// ErrClosed is returned when a delivery is scheduled after the scheduler
+	// started shutting down.
+	ErrClosed = errors.New("delivery scheduler is shutting down")
+
+	// ErrQueueFull is returned when a delivery is scheduled while the queue
+	// already holds the maximum number of outstanding deliveries.
+	ErrQueueFull = errors.New("delivery queue is full")
+)
+
+const (
+	// DefaultWorkers is the number of workers used when Options.Workers is not set.
+	DefaultWorkers = 4
+
+	// DefaultQueueSize is the queue size used when Options.QueueSize is not set.
+	DefaultQueueSize = 1000
+
+	// DefaultRetryBackoff is the retry backoff used when Options.RetryBackoff is not set.
+	DefaultRetryBackoff = 5 * time.Second
+
+	// DefaultMaxRetryBackoff is the maximum retry backoff used when Options.MaxRetryBackoff
+	// is not set.
+	DefaultMaxRetryBackoff = 5 * time.Minute
+)
+
+// Func is called when the delivery of a shipment is due. It returns the shipment with its
+// next step and true when the shipment isn't done yet, like when parcels of the shipment
+// are still underway, which the scheduler queues until the next step is due. A delivery
+// that returns an error is tried again later. The context is canceled when the grace
+// period of the shutdown has passed.
+type Func func(ctx context.Context, s shipper.Shipment) (next shipper.Shipment, more bool, err error)
+
+// Options configure the size of the scheduler.
+type Options struct {
+	// Workers is the number of deliveries that are handled at the same time.
+	Workers int
+
+	// QueueSize is the maximum number of outstanding deliveries, both the ones
+	// waiting to be due and the ones handled by a worker.
+	QueueSize int
+
+	// RetryBackoff is how long a delivery that failed waits before it is tried again.
+	// It doubles after every failure of the same delivery.
+	RetryBackoff time.Duration
+
+	// MaxRetryBackoff is the longest a delivery that failed waits before it is tried again.
+	MaxRetryBackoff time.Duration
+}
+
+// Stats describe the current state of the scheduler.
+type Stats struct {
+	// Queued is the number of deliveries waiting to be due.
+	Queued int
+
+	// InFlight is the number of deliveries that are due and handed to a worker.
+	InFlight int
+
+	// QueueSize is the maximum number of outstanding deliveries.
+	QueueSize int
+
+	// Workers is the number of workers.
+	Workers int
+
+	// Busy is the number of workers handling a delivery.
+	Busy int
+}
+
+// Depth returns the number of outstanding deliveries.
+func (s Stats) Depth() int {
+	return s.Queued + s.InFlight
+}
+
+// Utilization returns the fraction of workers that are busy.
+func (s Stats) Utilization() float64 {
+	if s.Workers == 0 {
+		return 0
+	}
+	return float64(s.Busy) / float64(s.Workers)
+}
+
+// Saturation returns the fraction of the queue that is in use.
+func (s Stats) Saturation() float64 {
+	if s.QueueSize == 0 {
+		return 0
+	}
+	return float64(s.Depth()) / float64(s.QueueSize)
+}
+
+// Scheduler waits for the delivery of shipments in the background.
+type Scheduler struct {
+	deliver         Func
+	workers         int
+	queueSize       int
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	closed   bool
+	queue    itemHeap
+	inFlight map[uint64]item
+	seq      uint64
+	busy     int
+	drained  chan struct{}
+
+	// abandoned are the shipments of deliveries that failed, or have a next step, while
+	// the scheduler was shutting down, which aren't queued again.
+	abandoned []shipper.Shipment
+
+	wake  chan struct{}
+	ready chan item
+	wg    sync.WaitGroup
+}
+
+// item is a delivery in the queue of the scheduler.
+type item struct {
+	// id identifies the item while it is handed to a worker, so a shipment that is
+	// scheduled again before its delivery completed doesn't take its place.
+	id uint64
+
+	shipment shipper.Shipment
+
+	// due is the moment the delivery is handed to a worker, which is later than the
+	// moment the shipment is delivered when an earlier attempt failed.
+	due time.Time
+
+	// attempts is the number of times the delivery failed.
+	attempts int
+}
+
+// New creates a new Scheduler that calls deliver for each shipment when it is due and
+// starts its workers.
+func New(deliver Func, o Options) *Scheduler {
+	if o.Workers <= 0 {
+		o.Workers = DefaultWorkers
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = DefaultQueueSize
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = DefaultRetryBackoff
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = DefaultMaxRetryBackoff
+	}
+	if o.MaxRetryBackoff < o.RetryBackoff {
+		o.MaxRetryBackoff = o.RetryBackoff
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Scheduler{
+		deliver:         deliver,
+		workers:         o.Workers,
+		queueSize:       o.QueueSize,
+		retryBackoff:    o.RetryBackoff,
+		maxRetryBackoff: o.MaxRetryBackoff,
+		ctx:             ctx,
+		cancel:          cancel,
+		inFlight:        make(map[uint64]item),
+		wake:            make(chan struct{}, 1),
+		ready:           make(chan item),
+	}
+
+	s.wg.Add(1 + o.Workers)
+	go s.dispatch()
+	for i := 0; i < o.Workers; i++ {
+		go s.work()
+	}
+
+	return s
+}
+
+// Schedule queues the shipments until their delivery is due, either all of them or,
+// when one of them can't be queued, none. It returns ErrClosed if the scheduler is
+// shutting down and ErrQueueFull if the queue has no room for all shipments.
+func (s *Scheduler) Schedule(shipments ...shipper.Shipment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if len(s.queue)+len(s.inFlight)+len(shipments) > s.queueSize {
+		return ErrQueueFull
+	}
+
+	for _, sh := range shipments {
+		s.push(item{shipment: sh, due: sh.DeliverAt})
+	}
+
+	return nil
+}
+
+// push queues the item and wakes the dispatcher in case it is due before the one it
+// waits for. It must be called with mu held.
+func (s *Scheduler) push(it item) {
+	s.seq++
+	it.id = s.seq
+	heap.Push(&s.queue, it)
+	s.notify()
+}
+
+// notify wakes the dispatcher, unless it was woken already.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Reschedule replaces the queued delivery of the shipment with the shipment, which is due
+// at its DeliverAt, like when a scan of the carrier moved it to its next step, and returns
+// whether it was queued. The shipment takes the place of its queued delivery, so unlike
+// Schedule it never fails. A delivery that is already handed to a worker isn't changed.
+func (s *Scheduler) Reschedule(sh shipper.Shipment) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, it := range s.queue {
+		if it.shipment.TrackingNumber != sh.TrackingNumber {
+			continue
+		}
+
+		s.queue[i].shipment = sh
+		s.queue[i].due = sh.DeliverAt
+		heap.Fix(&s.queue, i)
+		s.notify()
+		return true
+	}
+
+	return false
+}
+
+// Cancel removes the queued delivery of the shipment with the tracking number, and returns
+// whether it was queued. A delivery that is already handed to a worker isn't stopped.
+func (s *Scheduler) Cancel(trackingNumber string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, it := range s.queue {
+		if it.shipment.TrackingNumber != trackingNumber {
+			continue
+		}
+
+		heap.Remove(&s.queue, i)
+		if s.closed && len(s.queue)+len(s.inFlight) == 0 {
+			close(s.drained)
+		}
+		return true
+	}
+
+	return false
+}
+
+// Closed returns true when the scheduler no longer accepts new deliveries.
+func (s *Scheduler) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// Pending returns the number of deliveries that haven't completed yet.
+func (s *Scheduler) Pending() int {
+	return s.Stats().Depth()
+}
+
+// Stats returns the current state of the scheduler.
+func (s *Scheduler) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Stats{
+		Queued:    len(s.queue),
+		InFlight:  len(s.inFlight),
+		QueueSize: s.queueSize,
+		Workers:   s.workers,
+		Busy:      s.busy,
+	}
+}
+
+// RetryAfter returns how long a client should wait before scheduling a new delivery
+// when the queue is full, which is the time until the next queued delivery is due.
+func (s *Scheduler) RetryAfter() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := time.Second
+	if len(s.queue) > 0 {
+		if until := time.Until(s.queue[0].due); until > d {
+			d = until
+		}
+	}
+
+	return d.Round(time.Second)
+}
+
+// Shutdown stops accepting new deliveries and waits for the outstanding deliveries
+// to complete. When the context is done before that, the remaining deliveries are
+// canceled and returned so the caller can report or persist them.
+func (s *Scheduler) Shutdown(ctx context.Context) []shipper.Shipment {
+	s.mu.Lock()
+	s.closed = true
+	s.drained = make(chan struct{})
+	if len(s.queue)+len(s.inFlight) == 0 {
+		close(s.drained)
+	}
+	drained := s.drained
+	s.mu.Unlock()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+
+	s.cancel()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	abandoned := make([]shipper.Shipment, 0, len(s.queue)+len(s.inFlight)+len(s.abandoned))
+	abandoned = append(abandoned, s.abandoned...)
+	for _, it := range s.queue {
+		abandoned = append(abandoned, it.shipment)
+	}
+	for _, it := range s.inFlight {
+		abandoned = append(abandoned, it.shipment)
+	}
+
+	return abandoned
+}
+
+// dispatch waits until the first queued delivery is due and hands it to a worker.
+func (s *Scheduler) dispatch() {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		var next *item
+		wait := time.Hour
+		if len(s.queue) > 0 {
+			if wait = time.Until(s.queue[0].due); wait <= 0 {
+				it := heap.Pop(&s.queue).(item)
+				s.inFlight[it.id] = it
+				next = &it
+			}
+		}
+		s.mu.Unlock()
+
+		if next != nil {
+			select {
+			case s.ready <- *next:
+			case <-s.ctx.Done():
+				return
+			}
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// work handles the deliveries that are due. A shipment stays outstanding when the
+// scheduler is canceled before the delivery completed.
+func (s *Scheduler) work() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case it := <-s.ready:
+			s.handle(it)
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// handle delivers a single shipment, and queues it again when the delivery failed or the
+// shipment has a next step. The next step takes the place of the delivery in the queue,
+// so it is queued also when the queue is full.
+func (s *Scheduler) handle(it item) {
+	s.mu.Lock()
+	s.busy++
+	s.mu.Unlock()
+
+	next, more, err := s.deliver(s.ctx, it.shipment)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy--
+
+	// A delivery that was canceled by the shutdown stays outstanding
+	if err != nil && s.ctx.Err() != nil {
+		return
+	}
+	delete(s.inFlight, it.id)
+
+	// Try again later, or queue the next step, unless the scheduler is shutting down,
+	// which reports the shipment as abandoned
+	ctx := logging.With(s.ctx, logging.OrderNumber, it.shipment.OrderNumber, logging.TrackingNumber, it.shipment.TrackingNumber)
+	switch {
+	case err != nil && s.closed:
+		slog.ErrorContext(ctx, "error delivering shipment, abandoning it", "attempts", it.attempts+1, logging.Err(err))
+		s.abandoned = append(s.abandoned, it.shipment)
+	case err != nil:
+		it.attempts++
+		backoff := s.backoff(it.attempts)
+		slog.ErrorContext(ctx, "error delivering shipment, retrying", "attempts", it.attempts, "retryIn", backoff.String(), logging.Err(err))
+		it.due = time.Now().Add(backoff)
+		s.push(it)
+	case more && s.closed:
+		slog.WarnContext(ctx, "scheduler shutting down, abandoning next step of shipment", "deliverAt", next.DeliverAt)
+		s.abandoned = append(s.abandoned, next)
+	case more:
+		s.push(item{shipment: next, due: next.DeliverAt})
+	}
+
+	if s.closed && len(s.queue)+len(s.inFlight) == 0 {
+		close(s.drained)
+	}
+}
+
+// backoff returns how long a delivery that failed the number of attempts waits before it
+// is tried again: RetryBackoff, doubled after every next failure up to MaxRetryBackoff.
+func (s *Scheduler) backoff(attempts int) time.Duration {
+	d := s.retryBackoff
+	for i := 1; i < attempts && d < s.maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > s.maxRetryBackoff {
+		d = s.maxRetryBackoff
+	}
+	return d
+}
+
+// itemHeap orders the items by the moment they are due, implementing heap.Interface.
+type itemHeap []item
+
+func (h itemHeap) Len() int            { return len(h) }
+func (h itemHeap) Less(i, j int) bool  { return h[i].due.Before(h[j].due) }
+func (h itemHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *itemHeap) Push(x interface{}) { *h = append(*h, x.(item)) }
+
+func (h *itemHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,419 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// newShipment returns a shipment with the tracking number that is delivered after d.
+func newShipment(trackingNumber string, d time.Duration) shipper.Shipment {
+	var s shipper.Shipment
+	s.TrackingNumber = trackingNumber
+	s.Status = shipper.StatusShipped
+	s.DeliverAt = time.Now().Add(d)
+	return s
+}
+
+// recorder is a Func that records the shipments it delivered and sends them on a channel.
+type recorder struct {
+	mu        sync.Mutex
+	delivered []shipper.Shipment
+	done      chan shipper.Shipment
+}
+
+func newRecorder() *recorder {
+	return &recorder{done: make(chan shipper.Shipment, 100)}
+}
+
+func (r *recorder) deliver(ctx context.Context, s shipper.Shipment) (shipper.Shipment, bool, error) {
+	r.mu.Lock()
+	r.delivered = append(r.delivered, s)
+	r.mu.Unlock()
+
+	r.done <- s
+	return s, false, nil
+}
+
+// wait returns the next delivered shipment, or fails the test when none is delivered in time.
+func (r *recorder) wait(t *testing.T) shipper.Shipment {
+	t.Helper()
+
+	select {
+	case s := <-r.done:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("no shipment delivered")
+		return shipper.Shipment{}
+	}
+}
+
+// shutdown shuts the scheduler down without waiting for the outstanding deliveries.
+func shutdown(s *Scheduler) []shipper.Shipment {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return s.Shutdown(ctx)
+}
+
+func TestScheduleDeliversInOrder(t *testing.T) {
+	r := newRecorder()
+	s := New(r.deliver, Options{Workers: 1})
+	defer shutdown(s)
+
+	if err := s.Schedule(newShipment("late", 40*time.Millisecond), newShipment("early", 20*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"early", "late"} {
+		if got := r.wait(t); got.TrackingNumber != want {
+			t.Errorf("got delivery of %s, want %s", got.TrackingNumber, want)
+		}
+	}
+}
+
+func TestScheduleQueueFull(t *testing.T) {
+	r := newRecorder()
+	s := New(r.deliver, Options{Workers: 1, QueueSize: 2})
+	defer shutdown(s)
+
+	// An empty queue asks the client to come back in a second
+	if got := s.RetryAfter(); got != time.Second {
+		t.Errorf("got retry after %s, want 1s", got)
+	}
+
+	// None of the shipments are queued when they don't all fit
+	if err := s.Schedule(newShipment("a", time.Hour), newShipment("b", time.Hour), newShipment("c", time.Hour)); err != ErrQueueFull {
+		t.Fatalf("got error %v, want %v", err, ErrQueueFull)
+	}
+	if got := s.Pending(); got != 0 {
+		t.Fatalf("got %d pending deliveries, want 0", got)
+	}
+
+	if err := s.Schedule(newShipment("a", time.Hour), newShipment("b", 2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Schedule(newShipment("c", time.Hour)); err != ErrQueueFull {
+		t.Fatalf("got error %v, want %v", err, ErrQueueFull)
+	}
+
+	// The client is asked to come back when the first delivery is due
+	if got := s.RetryAfter(); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("got retry after %s, want about an hour", got)
+	}
+
+	stats := s.Stats()
+	if stats.Queued != 2 || stats.InFlight != 0 || stats.Saturation() != 1 {
+		t.Errorf("got stats %+v, want a full queue", stats)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	deliver := func(ctx context.Context, sh shipper.Shipment) (shipper.Shipment, bool, error) {
+		if sh.TrackingNumber == "in-flight" {
+			close(started)
+			<-release
+		}
+		return sh, false, nil
+	}
+
+	s := New(deliver, Options{Workers: 1})
+	defer shutdown(s)
+
+	if err := s.Schedule(newShipment("queued", time.Hour), newShipment("in-flight", 0)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// The queued delivery is removed, the one that is handed to a worker isn't stopped
+	if !s.Cancel("queued") {
+		t.Error("got queued delivery not cancelled, want it cancelled")
+	}
+	if s.Cancel("in-flight") {
+		t.Error("got delivery handed to a worker cancelled, want it left alone")
+	}
+	if s.Cancel("unknown") {
+		t.Error("got unknown delivery cancelled, want false")
+	}
+
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if abandoned := s.Shutdown(ctx); len(abandoned) != 0 {
+		t.Errorf("got abandoned deliveries %+v, want none", abandoned)
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r := newRecorder()
+	deliver := func(ctx context.Context, sh shipper.Shipment) (shipper.Shipment, bool, error) {
+		if sh.TrackingNumber == "in-flight" {
+			close(started)
+			<-release
+		}
+		return r.deliver(ctx, sh)
+	}
+
+	s := New(deliver, Options{Workers: 2, QueueSize: 2})
+	defer shutdown(s)
+
+	if err := s.Schedule(newShipment("queued", time.Hour), newShipment("in-flight", 0)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// The queued delivery takes the new shipment and due moment, also when the queue is full
+	next := newShipment("queued", 10*time.Millisecond)
+	next.Status = shipper.StatusPartiallyDelivered
+	if !s.Reschedule(next) {
+		t.Fatal("got queued delivery not rescheduled, want it rescheduled")
+	}
+	if got := r.wait(t); got.TrackingNumber != "queued" || got.Status != shipper.StatusPartiallyDelivered {
+		t.Errorf("got delivery of %s with status %q, want the rescheduled shipment", got.TrackingNumber, got.Status)
+	}
+
+	// Deliveries that aren't queued aren't rescheduled
+	if s.Reschedule(newShipment("in-flight", 0)) {
+		t.Error("got delivery handed to a worker rescheduled, want it left alone")
+	}
+	if s.Reschedule(newShipment("unknown", 0)) {
+		t.Error("got unknown delivery rescheduled, want false")
+	}
+
+	close(release)
+	if got := r.wait(t); got.TrackingNumber != "in-flight" {
+		t.Errorf("got delivery of %s, want in-flight", got.TrackingNumber)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []time.Time
+	done := make(chan struct{})
+	deliver := func(ctx context.Context, sh shipper.Shipment) (shipper.Shipment, bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts = append(attempts, time.Now())
+		if len(attempts) < 3 {
+			return sh, false, errors.New("order service unavailable")
+		}
+		close(done)
+		return sh, false, nil
+	}
+
+	s := New(deliver, Options{Workers: 1, RetryBackoff: 20 * time.Millisecond, MaxRetryBackoff: time.Second})
+	defer shutdown(s)
+
+	if err := s.Schedule(newShipment("retried", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery not retried")
+	}
+
+	// The backoff doubles after every failure
+	mu.Lock()
+	defer mu.Unlock()
+	for i, want := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		if got := attempts[i+1].Sub(attempts[i]); got < want {
+			t.Errorf("got retry %d after %s, want at least %s", i+1, got, want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s := &Scheduler{retryBackoff: time.Second, maxRetryBackoff: 5 * time.Second}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := s.backoff(tt.attempts); got != tt.want {
+			t.Errorf("attempt %d: got backoff %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestNextStep(t *testing.T) {
+	r := newRecorder()
+	deliver := func(ctx context.Context, sh shipper.Shipment) (shipper.Shipment, bool, error) {
+		r.deliver(ctx, sh)
+
+		// The first parcel is delivered, the second one is due next
+		if sh.Status == shipper.StatusShipped {
+			next := newShipment(sh.TrackingNumber, 10*time.Millisecond)
+			next.Status = shipper.StatusPartiallyDelivered
+			return next, true, nil
+		}
+		return sh, false, nil
+	}
+
+	// The next step is queued, also when the queue is full
+	s := New(deliver, Options{Workers: 1, QueueSize: 1})
+	defer shutdown(s)
+
+	if err := s.Schedule(newShipment("parcels", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{shipper.StatusShipped, shipper.StatusPartiallyDelivered} {
+		if got := r.wait(t); got.Status != want {
+			t.Errorf("got delivery with status %q, want %q", got.Status, want)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if abandoned := s.Shutdown(ctx); len(abandoned) != 0 {
+		t.Errorf("got abandoned deliveries %+v, want none", abandoned)
+	}
+}
+
+func TestShutdownDrains(t *testing.T) {
+	r := newRecorder()
+	s := New(r.deliver, Options{Workers: 2})
+
+	if err := s.Schedule(newShipment("a", 10*time.Millisecond), newShipment("b", 20*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The outstanding deliveries complete within the grace period
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if abandoned := s.Shutdown(ctx); len(abandoned) != 0 {
+		t.Errorf("got abandoned deliveries %+v, want none", abandoned)
+	}
+
+	r.mu.Lock()
+	delivered := len(r.delivered)
+	r.mu.Unlock()
+	if delivered != 2 {
+		t.Errorf("got %d deliveries, want 2", delivered)
+	}
+
+	// No deliveries are accepted anymore
+	if !s.Closed() {
+		t.Error("got scheduler open, want it closed")
+	}
+	if err := s.Schedule(newShipment("c", 0)); err != ErrClosed {
+		t.Errorf("got error %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestShutdownAbandons(t *testing.T) {
+	started := make(chan struct{})
+	deliver := func(ctx context.Context, sh shipper.Shipment) (shipper.Shipment, bool, error) {
+		close(started)
+		<-ctx.Done()
+		return sh, false, ctx.Err()
+	}
+
+	s := New(deliver, Options{Workers: 1})
+
+	if err := s.Schedule(newShipment("queued", time.Hour), newShipment("in-flight", 0)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// The grace period passes, so both the queued delivery and the one that is canceled
+	// are returned
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	got := make(map[string]bool)
+	for _, sh := range s.Shutdown(ctx) {
+		got[sh.TrackingNumber] = true
+	}
+	if len(got) != 2 || !got["queued"] || !got["in-flight"] {
+		t.Errorf("got abandoned deliveries %v, want queued and in-flight", got)
+	}
+}
+
+func TestShutdownAbandonsRetriesAndNextSteps(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	deliver := func(ctx context.Context, sh shipper.Shipment) (shipper.Shipment, bool, error) {
+		started <- struct{}{}
+		<-release
+
+		if sh.TrackingNumber == "failed" {
+			return sh, false, errors.New("order service unavailable")
+		}
+		next := sh
+		next.Status = shipper.StatusPartiallyDelivered
+		return next, true, nil
+	}
+
+	s := New(deliver, Options{Workers: 2})
+
+	if err := s.Schedule(newShipment("failed", 0), newShipment("parcels", 0)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	<-started
+
+	// The deliveries complete while the scheduler is shutting down, so the failed one
+	// isn't tried again and the next step isn't queued
+	abandoned := make(chan []shipper.Shipment)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		abandoned <- s.Shutdown(ctx)
+	}()
+	for !s.Closed() {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	got := make(map[string]string)
+	for _, sh := range <-abandoned {
+		got[sh.TrackingNumber] = sh.Status
+	}
+	if len(got) != 2 || got["failed"] != shipper.StatusShipped || got["parcels"] != shipper.StatusPartiallyDelivered {
+		t.Errorf("got abandoned deliveries %v, want the failed shipment and the next step", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		stats           Stats
+		wantDepth       int
+		wantUtilization float64
+		wantSaturation  float64
+	}{
+		{Stats{}, 0, 0, 0},
+		{Stats{Queued: 3, InFlight: 1, QueueSize: 8, Workers: 2, Busy: 1}, 4, 0.5, 0.5},
+		{Stats{Queued: 10, QueueSize: 10, Workers: 4}, 10, 0, 1},
+	}
+
+	for _, tt := range tests {
+		if got := tt.stats.Depth(); got != tt.wantDepth {
+			t.Errorf("%+v: got depth %d, want %d", tt.stats, got, tt.wantDepth)
+		}
+		if got := tt.stats.Utilization(); got != tt.wantUtilization {
+			t.Errorf("%+v: got utilization %f, want %f", tt.stats, got, tt.wantUtilization)
+		}
+		if got := tt.stats.Saturation(); got != tt.wantSaturation {
+			t.Errorf("%+v: got saturation %f, want %f", tt.stats, got, tt.wantSaturation)
+		}
+	}
+}
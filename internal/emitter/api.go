@@ -4,11 +4,23 @@
 // needs to be implemented.
 package emitter
 
-import acmeserverless "github.com/retgits/acme-serverless"
+import (
+	"context"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+)
 
 // EventEmitter is the interface that describes the methods the
 // eventing service needs to implement to be able to work with
 // the ACME Serverless Fitness Shop.
 type EventEmitter interface {
-	Send(e acmeserverless.ShipmentSent) error
+	Send(ctx context.Context, e acmeserverless.ShipmentSent) error
+}
+
+// Checker is the interface that EventEmitters can implement to
+// report whether the eventing service can be reached, like for
+// readiness checks. EventEmitters that don't implement it are
+// considered to be always reachable.
+type Checker interface {
+	Check(ctx context.Context) error
 }
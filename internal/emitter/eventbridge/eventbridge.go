@@ -4,46 +4,81 @@
 package eventbridge
 
 import (
-	"os"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/eventbridge"
 	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
 	"github.com/retgits/acme-serverless-shipment/internal/emitter"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
 )
 
-// responder is an empty struct that implements the methods of the
+// responder is a struct that implements the methods of the
 // EventEmitter interface
-type responder struct{}
+type responder struct {
+	svc *eventbridge.EventBridge
+	bus string
+}
 
 // New creates a new instance of the EventEmitter with EventBridge
-// as the messaging layer.
-func New() emitter.EventEmitter {
-	return responder{}
+// as the messaging layer. The region determines the AWS region
+// this code looks in to find the bus and bus is the name of the
+// EventBridge bus events are sent to. The method returns an error
+// if the AWS session can't be created.
+func New(region string, bus string) (emitter.EventEmitter, error) {
+	return NewWithConfig(&aws.Config{
+		Region: aws.String(region),
+	}, bus)
 }
 
-// Send sends the event to an EventBridge bus. The bus is determined
-// by the environment variable EVENTBUS. The AWS region this code
-// looks in to find the queue is determined by the environment
-// variable REGION. The method returns an error if anything goes wrong.
-func (r responder) Send(e acmeserverless.ShipmentSent) error {
+// NewWithConfig is like New, but creates the AWS session with the
+// configuration, like to send events to another endpoint than the
+// one of the region.
+func NewWithConfig(awsConfig *aws.Config, bus string) (emitter.EventEmitter, error) {
+	if bus == "" {
+		return nil, fmt.Errorf("the name of the EventBridge bus can't be empty")
+	}
+
+	awsSession, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %s", err.Error())
+	}
+
+	return responder{
+		svc: eventbridge.New(awsSession),
+		bus: bus,
+	}, nil
+}
+
+// Send sends the event to the EventBridge bus the responder was
+// created with. The trace context and the correlation IDs of ctx are
+// added to the detail of the event. The method returns an error if
+// anything goes wrong.
+func (r responder) Send(ctx context.Context, e acmeserverless.ShipmentSent) error {
 	payload, err := e.Marshal()
 	if err != nil {
 		return err
 	}
 
-	awsSession := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(os.Getenv("REGION")),
-	}))
-
-	svc := eventbridge.New(awsSession)
+	payload, err = withFields(payload, map[string]map[string]string{
+		tracing.ContextField: tracing.Inject(ctx),
+		correlation.Field:    correlation.FromContext(ctx).Attributes(),
+	})
+	if err != nil {
+		return err
+	}
 
 	entries := make([]*eventbridge.PutEventsRequestEntry, 1)
 
 	entries[0] = &eventbridge.PutEventsRequestEntry{
 		Detail:       aws.String(string(payload)),
-		EventBusName: aws.String(os.Getenv("EVENTBUS")),
+		DetailType:   aws.String(e.Metadata.Type),
+		EventBusName: aws.String(r.bus),
 		Source:       aws.String(e.Metadata.Source),
 	}
 
@@ -51,10 +86,60 @@ func (r responder) Send(e acmeserverless.ShipmentSent) error {
 		Entries: entries,
 	}
 
-	_, err = svc.PutEvents(event)
+	out, err := r.svc.PutEventsWithContext(ctx, event)
 	if err != nil {
 		return err
 	}
 
+	// EventBridge accepts the request even when it rejects the event, the
+	// rejected entries have an error code instead of an event ID
+	if aws.Int64Value(out.FailedEntryCount) > 0 {
+		for _, entry := range out.Entries {
+			if entry != nil && entry.ErrorCode != nil {
+				return fmt.Errorf("EventBridge rejected the event: %s: %s", aws.StringValue(entry.ErrorCode), aws.StringValue(entry.ErrorMessage))
+			}
+		}
+		return fmt.Errorf("EventBridge rejected %d events", aws.Int64Value(out.FailedEntryCount))
+	}
+
+	var eventID string
+	if len(out.Entries) > 0 && out.Entries[0] != nil {
+		eventID = aws.StringValue(out.Entries[0].EventId)
+	}
+
+	slog.DebugContext(ctx, "event put on EventBridge bus", "bus", r.bus, "eventBridgeId", eventID)
+
 	return nil
 }
+
+// withFields adds the non-empty fields, like the trace context, to the JSON payload, so
+// the rules and targets of the bus can continue the trace and correlate the event.
+// EventBridge events have no headers or attributes, so they are sent in the detail.
+func withFields(payload []byte, fields map[string]map[string]string) ([]byte, error) {
+	detail := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(payload, &detail); err != nil {
+		return nil, err
+	}
+
+	for name, value := range fields {
+		if len(value) == 0 {
+			continue
+		}
+
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		detail[name] = b
+	}
+
+	return json.Marshal(detail)
+}
+
+// Check verifies that the EventBridge bus exists and can be reached.
+func (r responder) Check(ctx context.Context) error {
+	_, err := r.svc.DescribeEventBusWithContext(ctx, &eventbridge.DescribeEventBusInput{
+		Name: aws.String(r.bus),
+	})
+	return err
+}
@@ -0,0 +1,243 @@
+package eventbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+)
+
+// entry is an entry of a PutEvents request.
+type entry struct {
+	Detail       string
+	DetailType   string
+	EventBusName string
+	Source       string
+}
+
+// eventBridgeAPI is a stand-in of the JSON API of EventBridge that keeps the entries
+// it receives. Entries are rejected when reject is set, like EventBridge does with
+// entries that are invalid.
+type eventBridgeAPI struct {
+	mu      sync.Mutex
+	entries []entry
+	fail    bool
+	reject  bool
+}
+
+func (a *eventBridgeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+	if a.fail {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"__type":"ResourceNotFoundException","message":"Event bus shipments does not exist."}`)
+		return
+	}
+
+	switch r.Header.Get("X-Amz-Target") {
+	case "AWSEvents.PutEvents":
+		var in struct {
+			Entries []entry
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		a.mu.Lock()
+		a.entries = append(a.entries, in.Entries...)
+		a.mu.Unlock()
+
+		if a.reject {
+			fmt.Fprintf(w, `{"FailedEntryCount":%d,"Entries":[{"ErrorCode":"InternalFailure","ErrorMessage":"the entry could not be put"}]}`, len(in.Entries))
+			return
+		}
+		fmt.Fprint(w, `{"FailedEntryCount":0,"Entries":[{"EventId":"event-1"}]}`)
+	case "AWSEvents.DescribeEventBus":
+		fmt.Fprint(w, `{"Name":"shipments","Arn":"arn:aws:events:us-west-2:123456789012:event-bus/shipments"}`)
+	default:
+		http.Error(w, "unknown target "+r.Header.Get("X-Amz-Target"), http.StatusBadRequest)
+	}
+}
+
+func (a *eventBridgeAPI) last(t *testing.T) entry {
+	t.Helper()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.entries) == 0 {
+		t.Fatal("EventBridge received no entries")
+	}
+	return a.entries[len(a.entries)-1]
+}
+
+func newEmitter(t *testing.T, api *eventBridgeAPI) responder {
+	t.Helper()
+
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	em, err := NewWithConfig(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}, "shipments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return em.(responder)
+}
+
+func shipmentSent() acmeserverless.ShipmentSent {
+	return acmeserverless.ShipmentSent{
+		Metadata: acmeserverless.Metadata{
+			Domain: acmeserverless.ShipmentDomain,
+			Source: "SendShipment",
+			Type:   acmeserverless.ShipmentDeliveredEventName,
+			Status: acmeserverless.DefaultSuccessStatus,
+		},
+		Data: acmeserverless.ShipmentData{
+			TrackingNumber: "tracking-1",
+			OrderNumber:    "order-1",
+			Status:         "delivered",
+		},
+	}
+}
+
+func TestNewRequiresBus(t *testing.T) {
+	if _, err := New("us-west-2", ""); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestSend(t *testing.T) {
+	api := &eventBridgeAPI{}
+	em := newEmitter(t, api)
+
+	ids := correlation.IDs{EventID: "event-1", CausationID: "cause-1", CorrelationID: "correlation-1"}
+	evt := shipmentSent()
+
+	if err := em.Send(correlation.NewContext(context.Background(), ids), evt); err != nil {
+		t.Fatal(err)
+	}
+
+	got := api.last(t)
+
+	tests := []struct {
+		field string
+		got   string
+		want  string
+	}{
+		{"EventBusName", got.EventBusName, "shipments"},
+		{"DetailType", got.DetailType, acmeserverless.ShipmentDeliveredEventName},
+		{"Source", got.Source, "SendShipment"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %s %q, want %q", tt.field, tt.got, tt.want)
+		}
+	}
+
+	var detail struct {
+		acmeserverless.ShipmentSent
+		Correlation map[string]string `json:"correlation"`
+	}
+	if err := json.Unmarshal([]byte(got.Detail), &detail); err != nil {
+		t.Fatal(err)
+	}
+
+	if detail.Data != evt.Data {
+		t.Errorf("got data %+v, want %+v", detail.Data, evt.Data)
+	}
+	if c := correlation.FromAttributes(detail.Correlation); c != ids {
+		t.Errorf("got correlation %+v, want %+v", c, ids)
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		api  *eventBridgeAPI
+	}{
+		{"bus doesn't exist", &eventBridgeAPI{fail: true}},
+		{"entry is rejected", &eventBridgeAPI{reject: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			em := newEmitter(t, tt.api)
+
+			if err := em.Send(context.Background(), shipmentSent()); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	payload := []byte(`{"metadata":{"type":"ShipmentSent"},"data":{"trackingNumber":"1"}}`)
+
+	tests := []struct {
+		name   string
+		fields map[string]map[string]string
+		want   string
+	}{
+		{
+			name: "no fields",
+			want: `{"data":{"trackingNumber":"1"},"metadata":{"type":"ShipmentSent"}}`,
+		},
+		{
+			name:   "empty fields are left out",
+			fields: map[string]map[string]string{"traceContext": {}},
+			want:   `{"data":{"trackingNumber":"1"},"metadata":{"type":"ShipmentSent"}}`,
+		},
+		{
+			name:   "fields are added",
+			fields: map[string]map[string]string{"traceContext": {"traceparent": "00-1-2-01"}},
+			want:   `{"data":{"trackingNumber":"1"},"metadata":{"type":"ShipmentSent"},"traceContext":{"traceparent":"00-1-2-01"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := withFields(payload, tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    bool
+		wantErr bool
+	}{
+		{name: "bus exists"},
+		{name: "bus doesn't exist", fail: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			em := newEmitter(t, &eventBridgeAPI{fail: tt.fail})
+
+			if err := em.Check(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
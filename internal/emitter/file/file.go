@@ -0,0 +1,82 @@
+// Package file appends all events to a file, one JSON object per line, together
+// with the IDs that correlate them. This is useful for local development, where
+// the file can be followed or inspected after a run, but doesn't send any events
+// to other services.
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter"
+)
+
+// responder is a struct that implements the methods of the
+// EventEmitter interface.
+type responder struct {
+	path string
+	mu   sync.Mutex
+}
+
+// record is a single line in the file.
+type record struct {
+	acmeserverless.ShipmentSent
+	correlation.IDs
+}
+
+// New creates a new instance of the EventEmitter with a file at
+// the path as the messaging layer.
+func New(path string) (emitter.EventEmitter, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file emitter needs a path")
+	}
+
+	return &responder{path: path}, nil
+}
+
+// Send appends the event and the correlation IDs of ctx to the file
+// and returns an error if anything goes wrong.
+func (r *responder) Send(ctx context.Context, e acmeserverless.ShipmentSent) error {
+	b, err := json.Marshal(record{ShipmentSent: e, IDs: correlation.FromContext(ctx)})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %s", r.path, err.Error())
+	}
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing to %s: %s", r.path, err.Error())
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing to %s: %s", r.path, err.Error())
+	}
+
+	slog.DebugContext(ctx, "event appended to file", "path", r.path)
+
+	return nil
+}
+
+// Check verifies that the directory of the file is writable.
+func (r *responder) Check(ctx context.Context) error {
+	f, err := os.CreateTemp(filepath.Dir(r.path), ".check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
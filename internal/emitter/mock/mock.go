@@ -2,13 +2,23 @@
 // This is useful for testing, but doesn't send any events to other
 // services. That means if you use this in a non-testing scenario
 // the event flow will stop here.
+//
+// The Recorder keeps all events it receives, so tests can assert
+// what was emitted. It can also fail calls and add latency, to test
+// how handlers deal with a misbehaving messaging layer.
 package mock
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
 
 	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
 	"github.com/retgits/acme-serverless-shipment/internal/emitter"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
 )
 
 // responder is an empty struct that implements the methods of the
@@ -23,13 +33,183 @@ func New() emitter.EventEmitter {
 
 // Send logs the message to the log file of the service
 // and returns an error if anything goes wrong.
-func (r responder) Send(e acmeserverless.ShipmentSent) error {
+func (r responder) Send(ctx context.Context, e acmeserverless.ShipmentSent) error {
 	payload, err := e.Marshal()
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Payload: %s", payload)
+	ids := correlation.FromContext(ctx)
+	slog.InfoContext(ctx, "mock emitter received event", "payload", string(payload), "eventId", ids.EventID, "causationId", ids.CausationID)
 
 	return nil
 }
+
+// Record is an event the Recorder received, with the IDs and trace
+// context that came with it.
+type Record struct {
+	acmeserverless.ShipmentSent
+
+	// IDs are the event, causation and correlation IDs of the event.
+	IDs correlation.IDs
+
+	// TraceContext is the trace context that was propagated with the event.
+	TraceContext map[string]string
+}
+
+// Recorder is an EventEmitter that keeps all events it receives. It is
+// safe to use from multiple goroutines.
+type Recorder struct {
+	mu       sync.Mutex
+	records  []Record
+	calls    int
+	failures map[int]error
+	latency  time.Duration
+
+	// changed is closed, and replaced, whenever a record is added, to wake
+	// up the goroutines that wait for records.
+	changed chan struct{}
+}
+
+// NewRecorder creates a Recorder without any records, which succeeds
+// immediately on every call.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		failures: make(map[int]error),
+		changed:  make(chan struct{}),
+	}
+}
+
+// Send records the event, after the latency of the Recorder. The event
+// isn't recorded when the call is set to fail, or when the context is
+// done before the latency has passed.
+func (r *Recorder) Send(ctx context.Context, e acmeserverless.ShipmentSent) error {
+	r.mu.Lock()
+	r.calls++
+	call := r.calls
+	latency := r.latency
+	err, fail := r.failures[call]
+	r.mu.Unlock()
+
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+
+	if fail {
+		return err
+	}
+
+	rec := Record{
+		ShipmentSent: e,
+		IDs:          correlation.FromContext(ctx),
+		TraceContext: tracing.Inject(ctx),
+	}
+
+	r.mu.Lock()
+	r.records = append(r.records, rec)
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.mu.Unlock()
+
+	return nil
+}
+
+// FailOn makes the nth call of Send, counting from 1, return the error
+// instead of recording the event.
+func (r *Recorder) FailOn(n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[n] = err
+}
+
+// SetLatency makes every call of Send take at least d.
+func (r *Recorder) SetLatency(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latency = d
+}
+
+// Calls returns how often Send was called, including the failed calls.
+func (r *Recorder) Calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+// Records returns a copy of all recorded events, in the order they were
+// received.
+func (r *Recorder) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.records...)
+}
+
+// OfType returns the recorded events with the type, like ShipmentSent
+// or ShipmentDelivered, in the order they were received.
+func (r *Recorder) OfType(eventType string) []Record {
+	return ofType(r.Records(), eventType)
+}
+
+// Wait waits until at least n events are recorded and returns them. It
+// returns an error when the context is done first.
+func (r *Recorder) Wait(ctx context.Context, n int) ([]Record, error) {
+	return r.wait(ctx, n, func(records []Record) []Record {
+		return records
+	})
+}
+
+// WaitForType waits until at least n events with the type are recorded
+// and returns them. It returns an error when the context is done first.
+func (r *Recorder) WaitForType(ctx context.Context, eventType string, n int) ([]Record, error) {
+	return r.wait(ctx, n, func(records []Record) []Record {
+		return ofType(records, eventType)
+	})
+}
+
+// Reset removes all records, failures and latency and sets the number
+// of calls back to zero.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = nil
+	r.calls = 0
+	r.failures = make(map[int]error)
+	r.latency = 0
+}
+
+// wait waits until the filter returns at least n records.
+func (r *Recorder) wait(ctx context.Context, n int, filter func([]Record) []Record) ([]Record, error) {
+	for {
+		r.mu.Lock()
+		records := filter(append([]Record(nil), r.records...))
+		changed := r.changed
+		r.mu.Unlock()
+
+		if len(records) >= n {
+			return records, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return records, fmt.Errorf("received %d of %d events: %s", len(records), n, ctx.Err().Error())
+		}
+	}
+}
+
+// ofType returns the records with the event type.
+func ofType(records []Record, eventType string) []Record {
+	var matches []Record
+	for _, rec := range records {
+		if rec.Metadata.Type == eventType {
+			matches = append(matches, rec)
+		}
+	}
+	return matches
+}
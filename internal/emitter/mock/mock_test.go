@@ -0,0 +1,169 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+)
+
+func event(eventType string, trackingNumber string) acmeserverless.ShipmentSent {
+	return acmeserverless.ShipmentSent{
+		Metadata: acmeserverless.Metadata{Type: eventType},
+		Data:     acmeserverless.ShipmentData{TrackingNumber: trackingNumber},
+	}
+}
+
+func TestRecordsEventsWithIDs(t *testing.T) {
+	r := NewRecorder()
+	ids := correlation.IDs{EventID: "event-1", CausationID: "cause-1", CorrelationID: "correlation-1"}
+
+	if err := r.Send(correlation.NewContext(context.Background(), ids), event(acmeserverless.ShipmentSentEventName, "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	records := r.Records()
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	if records[0].Data.TrackingNumber != "1" {
+		t.Errorf("got tracking number %q, want 1", records[0].Data.TrackingNumber)
+	}
+	if records[0].IDs != ids {
+		t.Errorf("got IDs %+v, want %+v", records[0].IDs, ids)
+	}
+}
+
+func TestOfType(t *testing.T) {
+	r := NewRecorder()
+	ctx := context.Background()
+
+	r.Send(ctx, event(acmeserverless.ShipmentSentEventName, "1"))
+	r.Send(ctx, event(acmeserverless.ShipmentDeliveredEventName, "1"))
+	r.Send(ctx, event(acmeserverless.ShipmentSentEventName, "2"))
+
+	tests := []struct {
+		eventType string
+		want      []string
+	}{
+		{acmeserverless.ShipmentSentEventName, []string{"1", "2"}},
+		{acmeserverless.ShipmentDeliveredEventName, []string{"1"}},
+		{"ShipmentCancelled", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.eventType, func(t *testing.T) {
+			got := r.OfType(tt.eventType)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events, want %d", len(got), len(tt.want))
+			}
+			for i, rec := range got {
+				if rec.Data.TrackingNumber != tt.want[i] {
+					t.Errorf("event %d has tracking number %q, want %q", i, rec.Data.TrackingNumber, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFailOn(t *testing.T) {
+	r := NewRecorder()
+	ctx := context.Background()
+	errBroken := errors.New("broken")
+	r.FailOn(2, errBroken)
+
+	for i, want := range []error{nil, errBroken, nil} {
+		if err := r.Send(ctx, event(acmeserverless.ShipmentSentEventName, "1")); err != want {
+			t.Errorf("call %d returned %v, want %v", i+1, err, want)
+		}
+	}
+
+	if r.Calls() != 3 {
+		t.Errorf("got %d calls, want 3", r.Calls())
+	}
+	if len(r.Records()) != 2 {
+		t.Errorf("got %d records, want 2", len(r.Records()))
+	}
+}
+
+func TestLatency(t *testing.T) {
+	r := NewRecorder()
+	r.SetLatency(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := r.Send(context.Background(), event(acmeserverless.ShipmentSentEventName, "1")); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("Send took %s, want at least 50ms", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := r.Send(ctx, event(acmeserverless.ShipmentSentEventName, "2")); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(r.Records()) != 1 {
+		t.Errorf("got %d records, want 1", len(r.Records()))
+	}
+}
+
+func TestWait(t *testing.T) {
+	r := NewRecorder()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Send(context.Background(), event(acmeserverless.ShipmentSentEventName, "1"))
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	records, err := r.Wait(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 10 {
+		t.Errorf("got %d records, want 10", len(records))
+	}
+	wg.Wait()
+}
+
+func TestWaitForTypeTimesOut(t *testing.T) {
+	r := NewRecorder()
+	r.Send(context.Background(), event(acmeserverless.ShipmentSentEventName, "1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	records, err := r.WaitForType(ctx, acmeserverless.ShipmentDeliveredEventName, 1)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(records) != 0 {
+		t.Errorf("got %d records, want 0", len(records))
+	}
+}
+
+func TestReset(t *testing.T) {
+	r := NewRecorder()
+	r.FailOn(1, errors.New("broken"))
+	r.Send(context.Background(), event(acmeserverless.ShipmentSentEventName, "1"))
+
+	r.Reset()
+
+	if err := r.Send(context.Background(), event(acmeserverless.ShipmentSentEventName, "1")); err != nil {
+		t.Errorf("got %v after Reset, want nil", err)
+	}
+	if r.Calls() != 1 {
+		t.Errorf("got %d calls, want 1", r.Calls())
+	}
+}
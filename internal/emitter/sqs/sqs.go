@@ -4,55 +4,139 @@
 package sqs
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"log/slog"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
 	"github.com/retgits/acme-serverless-shipment/internal/emitter"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
 )
 
-// responder is an empty struct that implements the methods of the
+// responder is a struct that implements the methods of the
 // EventEmitter interface.
-type responder struct{}
+type responder struct {
+	svc   *sqs.SQS
+	queue string
+}
 
 // New creates a new instance of the EventEmitter with SQS
-// as the messaging layer.
-func New() emitter.EventEmitter {
-	return responder{}
+// as the messaging layer. The region determines the AWS region
+// this code looks in to find the queue and queueARN is the ARN
+// of the queue events are sent to. The method returns an error
+// if the ARN is malformed or the AWS session can't be created.
+func New(region string, queueARN string) (emitter.EventEmitter, error) {
+	return NewWithConfig(&aws.Config{
+		Region: aws.String(region),
+	}, queueARN)
 }
 
-// Send sends the event to an SQS queue. The SQS queue is determined
-// by the environment variable RESPONSEQUEUE. The AWS region this code
-// looks in to find the queue is determined by the environment
-// variable REGION. The method returns an error if anything goes wrong.
-func (r responder) Send(e acmeserverless.ShipmentSent) error {
-	payload, err := e.Marshal()
+// NewWithConfig is like New, but creates the AWS session with the
+// configuration, like to send events to another endpoint than the
+// one of the region.
+func NewWithConfig(awsConfig *aws.Config, queueARN string) (emitter.EventEmitter, error) {
+	queue, err := QueueURL(queueARN)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	awsSession, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %s", err.Error())
 	}
 
-	awsSession := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(os.Getenv("REGION")),
-	}))
+	return responder{
+		svc:   sqs.New(awsSession),
+		queue: queue,
+	}, nil
+}
 
-	svc := sqs.New(awsSession)
+// dnsSuffixes are the domains of the AWS endpoints, by partition.
+var dnsSuffixes = map[string]string{
+	"aws":        "amazonaws.com",
+	"aws-cn":     "amazonaws.com.cn",
+	"aws-us-gov": "amazonaws.com",
+}
 
-	urlParts := strings.Split(os.Getenv("RESPONSEQUEUE"), ":")
-	queue := fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", urlParts[3], urlParts[4], urlParts[5])
+// QueueURL converts the ARN of an SQS queue (like arn:aws:sqs:us-west-2:123456789012:queue)
+// to the URL of that queue (like https://sqs.us-west-2.amazonaws.com/123456789012/queue).
+func QueueURL(arn string) (string, error) {
+	urlParts := strings.Split(arn, ":")
+	if len(urlParts) != 6 || urlParts[0] != "arn" || urlParts[2] != "sqs" {
+		return "", fmt.Errorf("invalid SQS queue ARN %q, expected arn:aws:sqs:<region>:<account>:<queue>", arn)
+	}
+
+	for _, p := range urlParts[3:] {
+		if p == "" {
+			return "", fmt.Errorf("invalid SQS queue ARN %q, expected arn:aws:sqs:<region>:<account>:<queue>", arn)
+		}
+	}
+
+	suffix, ok := dnsSuffixes[urlParts[1]]
+	if !ok {
+		return "", fmt.Errorf("invalid SQS queue ARN %q, unknown partition %q", arn, urlParts[1])
+	}
+
+	return fmt.Sprintf("https://sqs.%s.%s/%s/%s", urlParts[3], suffix, urlParts[4], urlParts[5]), nil
+}
+
+// Send sends the event to the SQS queue the responder was created
+// with. The trace context and the correlation IDs of ctx are sent as
+// message attributes. The method returns an error if anything goes
+// wrong.
+func (r responder) Send(ctx context.Context, e acmeserverless.ShipmentSent) error {
+	payload, err := e.Marshal()
+	if err != nil {
+		return err
+	}
 
 	sendMessageInput := &sqs.SendMessageInput{
-		QueueUrl:    aws.String(queue),
-		MessageBody: aws.String(string(payload)),
+		QueueUrl:          aws.String(r.queue),
+		MessageBody:       aws.String(string(payload)),
+		MessageAttributes: messageAttributes(tracing.Inject(ctx), correlation.FromContext(ctx).Attributes()),
 	}
 
-	_, err = svc.SendMessage(sendMessageInput)
+	out, err := r.svc.SendMessageWithContext(ctx, sendMessageInput)
 	if err != nil {
 		return err
 	}
 
+	slog.DebugContext(ctx, "event sent to SQS queue", "queue", r.queue, "messageId", aws.StringValue(out.MessageId))
+
 	return nil
 }
+
+// messageAttributes converts the trace context and the correlation IDs to SQS message
+// attributes, so the receiver of the message can continue the trace and correlate the
+// message with the ones it causes.
+func messageAttributes(values ...map[string]string) map[string]*sqs.MessageAttributeValue {
+	attrs := make(map[string]*sqs.MessageAttributeValue)
+	for _, m := range values {
+		for k, v := range m {
+			attrs[k] = &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(v),
+			}
+		}
+	}
+
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	return attrs
+}
+
+// Check verifies that the SQS queue exists and can be reached.
+func (r responder) Check(ctx context.Context) error {
+	_, err := r.svc.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(r.queue),
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameQueueArn)},
+	})
+	return err
+}
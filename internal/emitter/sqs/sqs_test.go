@@ -0,0 +1,226 @@
+package sqs
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+)
+
+const queueARN = "arn:aws:sqs:us-west-2:123456789012:shipments"
+
+// sqsAPI is a stand-in of the query API of SQS that keeps the messages it receives.
+type sqsAPI struct {
+	mu       sync.Mutex
+	requests []map[string]string
+	fail     bool
+}
+
+func (a *sqsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := make(map[string]string)
+	for k := range r.PostForm {
+		params[k] = r.PostForm.Get(k)
+	}
+
+	a.mu.Lock()
+	a.requests = append(a.requests, params)
+	fail := a.fail
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/xml")
+
+	if fail {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AWS.SimpleQueueService.NonExistentQueue</Code>`+
+			`<Message>The specified queue does not exist.</Message></Error><RequestId>request-1</RequestId></ErrorResponse>`)
+		return
+	}
+
+	switch params["Action"] {
+	case "SendMessage":
+		sum := md5.Sum([]byte(params["MessageBody"]))
+		fmt.Fprintf(w, `<SendMessageResponse><SendMessageResult><MessageId>message-1</MessageId>`+
+			`<MD5OfMessageBody>%s</MD5OfMessageBody></SendMessageResult>`+
+			`<ResponseMetadata><RequestId>request-1</RequestId></ResponseMetadata></SendMessageResponse>`, hex.EncodeToString(sum[:]))
+	case "GetQueueAttributes":
+		fmt.Fprintf(w, `<GetQueueAttributesResponse><GetQueueAttributesResult><Attribute><Name>QueueArn</Name>`+
+			`<Value>%s</Value></Attribute></GetQueueAttributesResult>`+
+			`<ResponseMetadata><RequestId>request-1</RequestId></ResponseMetadata></GetQueueAttributesResponse>`, queueARN)
+	default:
+		http.Error(w, "unknown action "+params["Action"], http.StatusBadRequest)
+	}
+}
+
+func (a *sqsAPI) last(t *testing.T) map[string]string {
+	t.Helper()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.requests) == 0 {
+		t.Fatal("SQS received no requests")
+	}
+	return a.requests[len(a.requests)-1]
+}
+
+func newEmitter(t *testing.T, api *sqsAPI) responder {
+	t.Helper()
+
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	em, err := NewWithConfig(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}, queueARN)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return em.(responder)
+}
+
+func shipmentSent() acmeserverless.ShipmentSent {
+	return acmeserverless.ShipmentSent{
+		Metadata: acmeserverless.Metadata{
+			Domain: acmeserverless.ShipmentDomain,
+			Source: "SendShipment",
+			Type:   acmeserverless.ShipmentSentEventName,
+			Status: acmeserverless.DefaultSuccessStatus,
+		},
+		Data: acmeserverless.ShipmentData{
+			TrackingNumber: "tracking-1",
+			OrderNumber:    "order-1",
+			Status:         "shipped - pending delivery",
+		},
+	}
+}
+
+func TestQueueURL(t *testing.T) {
+	tests := []struct {
+		arn     string
+		want    string
+		wantErr bool
+	}{
+		{arn: "arn:aws:sqs:us-west-2:123456789012:queue", want: "https://sqs.us-west-2.amazonaws.com/123456789012/queue"},
+		{arn: "arn:aws-cn:sqs:cn-north-1:123456789012:queue", want: "https://sqs.cn-north-1.amazonaws.com.cn/123456789012/queue"},
+		{arn: "arn:aws-us-gov:sqs:us-gov-west-1:123456789012:queue", want: "https://sqs.us-gov-west-1.amazonaws.com/123456789012/queue"},
+		{arn: "", wantErr: true},
+		{arn: "queue", wantErr: true},
+		{arn: "https://sqs.us-west-2.amazonaws.com/123456789012/queue", wantErr: true},
+		{arn: "arn:aws:sqs:us-west-2:123456789012", wantErr: true},
+		{arn: "arn:aws:sqs:us-west-2:123456789012:queue:extra", wantErr: true},
+		{arn: "arn:aws:sns:us-west-2:123456789012:topic", wantErr: true},
+		{arn: "arn:aws:sqs::123456789012:queue", wantErr: true},
+		{arn: "arn:aws:sqs:us-west-2::queue", wantErr: true},
+		{arn: "arn:aws:sqs:us-west-2:123456789012:", wantErr: true},
+		{arn: "arn:other:sqs:us-west-2:123456789012:queue", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			got, err := QueueURL(tt.arn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidARN(t *testing.T) {
+	if _, err := New("us-west-2", "queue"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestSend(t *testing.T) {
+	api := &sqsAPI{}
+	em := newEmitter(t, api)
+
+	ids := correlation.IDs{EventID: "event-1", CausationID: "cause-1", CorrelationID: "correlation-1"}
+	evt := shipmentSent()
+
+	if err := em.Send(correlation.NewContext(context.Background(), ids), evt); err != nil {
+		t.Fatal(err)
+	}
+
+	req := api.last(t)
+
+	payload, err := evt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		param string
+		want  string
+	}{
+		{"Action", "SendMessage"},
+		{"QueueUrl", "https://sqs.us-west-2.amazonaws.com/123456789012/shipments"},
+		{"MessageBody", string(payload)},
+	}
+
+	for _, tt := range tests {
+		if got := req[tt.param]; got != tt.want {
+			t.Errorf("got %s %q, want %q", tt.param, got, tt.want)
+		}
+	}
+
+	attrs := make(map[string]string)
+	for i := 1; req[fmt.Sprintf("MessageAttribute.%d.Name", i)] != ""; i++ {
+		attrs[req[fmt.Sprintf("MessageAttribute.%d.Name", i)]] = req[fmt.Sprintf("MessageAttribute.%d.Value.StringValue", i)]
+	}
+
+	for k, v := range ids.Attributes() {
+		if attrs[k] != v {
+			t.Errorf("got message attribute %s %q, want %q", k, attrs[k], v)
+		}
+	}
+}
+
+func TestSendError(t *testing.T) {
+	api := &sqsAPI{fail: true}
+	em := newEmitter(t, api)
+
+	if err := em.Send(context.Background(), shipmentSent()); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    bool
+		wantErr bool
+	}{
+		{name: "queue exists"},
+		{name: "queue doesn't exist", fail: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			em := newEmitter(t, &sqsAPI{fail: tt.fail})
+
+			if err := em.Check(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Package webhook sends events as HTTP POST requests to a URL, like the
+// update endpoint of the order service. This is the messaging layer used
+// when the Shipment service runs as a container, outside of AWS.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+)
+
+// responder is a struct that implements the methods of the
+// EventEmitter interface.
+type responder struct {
+	client *http.Client
+	url    *url.URL
+	host   string
+}
+
+// New creates a new instance of the EventEmitter with HTTP as
+// the messaging layer. Events are sent to target, with the host
+// header set to host when it isn't empty. The client determines
+// the timeout of each call. The method returns an error if the
+// target isn't a valid HTTP URL.
+func New(target string, host string, client *http.Client) (emitter.EventEmitter, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q, expected an absolute http or https URL", target)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return responder{
+		client: client,
+		url:    u,
+		host:   host,
+	}, nil
+}
+
+// Send posts the event to the URL the responder was created with.
+// The trace context and the correlation IDs of ctx are sent as HTTP
+// headers. The method returns an error if anything goes wrong,
+// including a response with a status code other than 2xx.
+func (r responder) Send(ctx context.Context, e acmeserverless.ShipmentSent) error {
+	payload, err := e.Marshal()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.url.String(), bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error building http request: %s", err.Error())
+	}
+
+	req.Header.Add("content-type", "application/json")
+	for k, v := range tracing.Inject(ctx) {
+		req.Header.Set(k, v)
+	}
+	for k, v := range correlation.FromContext(ctx).Headers() {
+		req.Header.Set(k, v)
+	}
+	if r.host != "" {
+		req.Host = r.host
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status: %s", res.Status)
+	}
+
+	slog.DebugContext(ctx, "event posted to webhook", "url", r.url.String(), "status", res.StatusCode)
+
+	return nil
+}
+
+// Check verifies that a connection can be opened to the host of the URL.
+func (r responder) Check(ctx context.Context) error {
+	port := r.url.Port()
+	if port == "" {
+		port = "80"
+		if r.url.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(r.url.Hostname(), port))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
@@ -0,0 +1,110 @@
+package label
+
+import (
+	"fmt"
+)
+
+const (
+	// code128ShiftC switches from code set B to code set C.
+	code128ShiftC = 99
+
+	// code128ShiftB switches from code set C to code set B.
+	code128ShiftB = 100
+
+	// code128StartB and code128StartC start a barcode in code set B or C.
+	code128StartB = 104
+	code128StartC = 105
+
+	// code128Stop ends every barcode.
+	code128Stop = 106
+)
+
+// code128Patterns are the widths of the bars and spaces of every Code 128 symbol,
+// starting with a bar. Every symbol is 11 modules wide, except the stop symbol.
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+// code128 encodes the value as a Code 128 barcode and returns its modules, where
+// true is a bar. Runs of digits are encoded in code set C, which fits two digits in
+// a symbol, and everything else in code set B. The quiet zones aren't included.
+func code128(value string) ([]bool, error) {
+	if value == "" {
+		return nil, fmt.Errorf("nothing to encode")
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < ' ' || value[i] > '~' {
+			return nil, fmt.Errorf("%q can't be encoded in Code 128 code set B", value[i])
+		}
+	}
+
+	var codes []int
+	setC := digits(value, 0) >= 4 && digits(value, 0)%2 == 0
+	if setC {
+		codes = append(codes, code128StartC)
+	} else {
+		codes = append(codes, code128StartB)
+	}
+
+	for i := 0; i < len(value); {
+		if setC {
+			if digits(value, i) >= 2 {
+				codes = append(codes, int(value[i]-'0')*10+int(value[i+1]-'0'))
+				i += 2
+				continue
+			}
+			codes = append(codes, code128ShiftB)
+			setC = false
+		}
+
+		// Switch to code set C for at least four digits at the end or six in the
+		// middle, encoding an odd digit in code set B first
+		if run := digits(value, i); run >= 6 || (run >= 4 && i+run == len(value)) {
+			if run%2 == 1 {
+				codes = append(codes, int(value[i]-' '))
+				i++
+			}
+			codes = append(codes, code128ShiftC)
+			setC = true
+			continue
+		}
+
+		codes = append(codes, int(value[i]-' '))
+		i++
+	}
+
+	sum := codes[0]
+	for i, c := range codes[1:] {
+		sum += (i + 1) * c
+	}
+	codes = append(codes, sum%103, code128Stop)
+
+	var modules []bool
+	for _, c := range codes {
+		for i, w := range code128Patterns[c] {
+			for j := 0; j < int(w-'0'); j++ {
+				modules = append(modules, i%2 == 0)
+			}
+		}
+	}
+	return modules, nil
+}
+
+// digits returns the number of digits in s from index i.
+func digits(s string, i int) int {
+	n := 0
+	for i+n < len(s) && s[i+n] >= '0' && s[i+n] <= '9' {
+		n++
+	}
+	return n
+}
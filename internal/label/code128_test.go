@@ -0,0 +1,134 @@
+package label
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// decode128 decodes the modules of a Code 128 barcode in code sets B and C, and
+// checks its start, check and stop symbols.
+func decode128(t *testing.T, modules []bool) string {
+	t.Helper()
+
+	symbols := make(map[string]int, len(code128Patterns))
+	for i, p := range code128Patterns {
+		symbols[p] = i
+	}
+
+	// Turn the modules in the widths of the bars and spaces
+	var widths []byte
+	for i := 0; i < len(modules); {
+		j := i
+		for j < len(modules) && modules[j] == modules[i] {
+			j++
+		}
+		widths = append(widths, byte('0'+j-i))
+		i = j
+	}
+
+	var codes []int
+	for len(widths) > 7 {
+		c, ok := symbols[string(widths[:6])]
+		if !ok {
+			t.Fatalf("unknown symbol %s", widths[:6])
+		}
+		codes = append(codes, c)
+		widths = widths[6:]
+	}
+	if string(widths) != code128Patterns[code128Stop] {
+		t.Fatalf("got stop symbol %s", widths)
+	}
+
+	sum := codes[0]
+	for i, c := range codes[1 : len(codes)-1] {
+		sum += (i + 1) * c
+	}
+	if sum%103 != codes[len(codes)-1] {
+		t.Fatalf("got check symbol %d, want %d", codes[len(codes)-1], sum%103)
+	}
+
+	var value strings.Builder
+	setC := codes[0] == code128StartC
+	for _, c := range codes[1 : len(codes)-1] {
+		switch {
+		case c == code128ShiftB && setC:
+			setC = false
+		case c == code128ShiftC && !setC:
+			setC = true
+		case setC:
+			if c < 10 {
+				value.WriteByte('0')
+			}
+			value.WriteString(strconv.Itoa(c))
+		default:
+			value.WriteByte(byte(c + ' '))
+		}
+	}
+	return value.String()
+}
+
+func TestCode128Patterns(t *testing.T) {
+	seen := make(map[string]bool)
+	for i, p := range code128Patterns {
+		want := 11
+		if i == code128Stop {
+			want = 13
+		}
+
+		sum := 0
+		for _, w := range p {
+			sum += int(w - '0')
+		}
+		if sum != want || seen[p] {
+			t.Errorf("symbol %d with pattern %s is %d modules wide or a duplicate", i, p, sum)
+		}
+		seen[p] = true
+	}
+}
+
+func TestCode128(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "letters", value: "ACME"},
+		{name: "digits", value: "123456"},
+		{name: "odd digits", value: "12345"},
+		{name: "digits at the end", value: "1Z999AA10123456784"},
+		{name: "digits in the middle", value: "AB1234567CD"},
+		{name: "uuid", value: "0d0e1f2a-3b4c-4d5e-8f60-718293a4b5c6"},
+		{name: "empty", value: "", wantErr: true},
+		{name: "not ASCII", value: "café", wantErr: true},
+		{name: "control character", value: "A\tB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modules, err := code128(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := decode128(t, modules); got != tt.value {
+				t.Errorf("got %q, want %q", got, tt.value)
+			}
+		})
+	}
+}
+
+func TestCode128UsesCodeSetC(t *testing.T) {
+	digits, err := code128("12345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start, four pairs of digits, check and stop
+	if want := 6*11 + 13; len(digits) != want {
+		t.Errorf("got %d modules, want %d", len(digits), want)
+	}
+}
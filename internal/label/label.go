@@ -0,0 +1,261 @@
+// Package label renders the shipping labels of the Shipment service in the ACME
+// Serverless Fitness Shop. A label is 4x6 inches and shows the sender, the recipient,
+// the carrier and the service level, a Code 128 barcode of the tracking number and a
+// QR code. Labels are rendered as PDF, for office printers, or as ZPL, for Zebra
+// label printers in the warehouse.
+package label
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+)
+
+const (
+	// FormatPDF renders a label as a PDF document.
+	FormatPDF = "pdf"
+
+	// FormatZPL renders a label in the Zebra Programming Language.
+	FormatZPL = "zpl"
+)
+
+const (
+	// width and height are the size of a label, in points of 1/72 inch.
+	width  = 4 * 72
+	height = 6 * 72
+
+	// margin is the space between the edge of a label and its content, in points.
+	margin = 12
+)
+
+// Party is the sender or the recipient of a shipment.
+type Party struct {
+	// Name is the name of the person or company.
+	Name string
+
+	// Address is the address of the party, which can be nil when it isn't known.
+	Address *address.Address
+}
+
+// Label is the content of a shipping label.
+type Label struct {
+	// TrackingNumber is the tracking number of the shipment, which is encoded in the barcode.
+	TrackingNumber string
+
+	// OrderNumber is the order the shipment is part of.
+	OrderNumber string
+
+	// Carrier is the carrier that delivers the shipment.
+	Carrier string
+
+	// ServiceLevel is the service level of the shipment, like ground.
+	ServiceLevel string
+
+	// ShippedAt is the moment the shipment was handed to the carrier.
+	ShippedAt time.Time
+
+	// From is the sender of the shipment.
+	From Party
+
+	// To is the recipient of the shipment.
+	To Party
+}
+
+// Sender is the sender of every shipment of the ACME Serverless Fitness Shop.
+var Sender = Party{
+	Name: "ACME Serverless Fitness Shop",
+	Address: &address.Address{
+		Street:  "1 Market St",
+		City:    "San Francisco",
+		Zip:     "94105",
+		State:   "CA",
+		Country: "US",
+	},
+}
+
+// Origin returns the sender of the shipment: the warehouse it is shipped from, or the
+// Sender when the warehouse isn't one of the warehouses.
+func Origin(s shipper.Shipment, warehouses []warehouse.Warehouse) Party {
+	w, ok := warehouse.Find(warehouses, s.Warehouse)
+	if !ok {
+		return Sender
+	}
+
+	a := w.Address
+	return Party{Name: w.Name, Address: &a}
+}
+
+// ForShipment returns the label of the shipment, sent by the sender. The ShipmentRequested
+// event has no service level, so every shipment is labeled as ground.
+func ForShipment(s shipper.Shipment, from Party) Label {
+	return Label{
+		TrackingNumber: s.TrackingNumber,
+		OrderNumber:    s.OrderNumber,
+		Carrier:        s.Carrier,
+		ServiceLevel:   shipper.ServiceGround,
+		ShippedAt:      s.CreatedAt,
+		From:           from,
+		To:             Party{Name: "Order " + s.OrderNumber, Address: s.Address},
+	}
+}
+
+// ForReturn returns the label of the return leg of a shipment, sent by the customer to the
+// warehouse. The RMA number is part of the recipient, so the warehouse knows what it receives.
+func ForReturn(s shipper.Shipment, to Party) Label {
+	l := ForShipment(s, Party{Name: "Order " + s.OrderNumber, Address: s.Address})
+	l.To = to
+	if s.Return != nil {
+		l.To.Name = fmt.Sprintf("%s (%s)", to.Name, s.Return.RMA)
+	}
+	return l
+}
+
+// For returns the label of the shipment, from the warehouse it is shipped from to the
+// customer, or from the customer to the warehouse when it is the return leg of a shipment.
+func For(s shipper.Shipment, warehouses []warehouse.Warehouse) Label {
+	if s.Return != nil {
+		return ForReturn(s, Origin(s, warehouses))
+	}
+	return ForShipment(s, Origin(s, warehouses))
+}
+
+// ContentType returns the media type of labels in the format.
+func ContentType(format string) string {
+	switch format {
+	case FormatPDF:
+		return "application/pdf"
+	case FormatZPL:
+		return "application/zpl"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Render renders the label in the format, either FormatPDF or FormatZPL.
+func Render(l Label, format string) ([]byte, error) {
+	elements, err := layout(l)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering label for %s: %s", l.TrackingNumber, err.Error())
+	}
+
+	switch format {
+	case FormatPDF:
+		return renderPDF(elements), nil
+	case FormatZPL:
+		return renderZPL(elements), nil
+	default:
+		return nil, fmt.Errorf("unknown label format %q, use %s or %s", format, FormatPDF, FormatZPL)
+	}
+}
+
+// element is a part of a label, positioned from the top left corner of the label
+// in points. Exactly one of the content fields is set.
+type element struct {
+	x, y float64
+
+	// text is a line of text, in the font size and weight.
+	text string
+	size float64
+	bold bool
+
+	// box is a filled rectangle, like a line between the parts of the label.
+	box           bool
+	width, height float64
+
+	// barcode is the value of a Code 128 barcode with the modules, the width of a module
+	// and the height of the bars.
+	barcode string
+	bars    []bool
+	module  float64
+
+	// qr is the data of a QR code, with its modules and the width of a module.
+	qr     *qrCode
+	qrData string
+}
+
+// layout returns the elements of the label. The label is split in four parts: the
+// sender, the recipient, the carrier with the QR code and the barcode.
+func layout(l Label) ([]element, error) {
+	bars, err := code128(l.TrackingNumber)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding barcode: %s", err.Error())
+	}
+
+	data := qrData(l)
+	code, err := qr([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("error encoding QR code: %s", err.Error())
+	}
+
+	var e []element
+	text := func(x, y, size float64, bold bool, s string) float64 {
+		e = append(e, element{x: x, y: y, text: s, size: size, bold: bold})
+		return y + size*1.25
+	}
+	rule := func(y float64) {
+		e = append(e, element{x: margin, y: y, box: true, width: width - 2*margin, height: 1.5})
+	}
+
+	// Sender
+	y := text(margin, margin, 7, true, "FROM")
+	for _, line := range lines(l.From) {
+		y = text(margin, y, 9, false, line)
+	}
+	rule(84)
+
+	// Recipient
+	y = text(margin, 92, 7, true, "SHIP TO")
+	for i, line := range lines(l.To) {
+		y = text(margin+12, y, 12, i == 0, line)
+	}
+	rule(180)
+
+	// Carrier and service level, next to the QR code
+	y = text(margin, 192, 20, true, strings.ToUpper(l.Carrier))
+	y = text(margin, y, 14, true, strings.ToUpper(l.ServiceLevel))
+	y = text(margin, y+4, 9, false, "Order "+l.OrderNumber)
+	if !l.ShippedAt.IsZero() {
+		text(margin, y, 9, false, "Shipped "+l.ShippedAt.UTC().Format("2006-01-02"))
+	}
+	qrModule := float64(int(84/float64(code.size)*4)) / 4
+	qrSize := qrModule * float64(code.size)
+	e = append(e, element{x: width - margin - qrSize, y: 188, qr: code, qrData: data, module: qrModule})
+	rule(282)
+
+	// Barcode of the tracking number, centered with its quiet zones
+	module := min(2, float64(int((width-2*margin)/float64(len(bars)+20)*4))/4)
+	y = text(margin, 290, 7, true, "TRACKING #")
+	e = append(e, element{x: (width - module*float64(len(bars))) / 2, y: y + 4, barcode: l.TrackingNumber, bars: bars, module: module, height: 80})
+	text(margin, y+92, 12, true, l.TrackingNumber)
+
+	return e, nil
+}
+
+// lines returns the lines of the name and the address of the party.
+func lines(p Party) []string {
+	result := []string{p.Name}
+	if p.Address == nil {
+		return append(result, "Address not provided")
+	}
+
+	a := p.Address
+	result = append(result, a.Street)
+	city := a.City
+	if a.State != "" {
+		city += ", " + a.State
+	}
+	if a.Zip != "" {
+		city += " " + a.Zip
+	}
+	return append(result, city, a.Country)
+}
+
+// qrData returns the data of the QR code of the label: the carrier, the tracking
+// number and the order, on separate lines.
+func qrData(l Label) string {
+	return fmt.Sprintf("CARRIER:%s\nTRACKING:%s\nORDER:%s", l.Carrier, l.TrackingNumber, l.OrderNumber)
+}
@@ -0,0 +1,138 @@
+package label
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+)
+
+// shipment is a shipment to an address with characters that need escaping in both formats.
+var shipment = shipper.Shipment{
+	ShipmentData: acmeserverless.ShipmentData{TrackingNumber: "1Z999AA10123456784", OrderNumber: "order_1", Status: shipper.StatusShipped},
+	Carrier:      "UPS",
+	CreatedAt:    time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC),
+	Address:      &address.Address{Street: "1 Rue (Haute)", City: "Liège", Zip: "4000", Country: "BE"},
+}
+
+func TestRenderPDF(t *testing.T) {
+	b, err := Render(ForShipment(shipment, Sender), FormatPDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(b, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(b, []byte("%%EOF\n")) {
+		t.Fatalf("got no PDF document: %q", b)
+	}
+
+	// Every entry of the cross-reference table points at its object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(b)
+	if m == nil {
+		t.Fatal("got no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(b[xref:], -1)
+	if len(entries) != 6 {
+		t.Fatalf("got %d objects, want 6", len(entries))
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(b[off:], []byte(want)) {
+			t.Errorf("object %d is not at offset %d", i+1, off)
+		}
+	}
+
+	for _, want := range []string{"(1Z999AA10123456784) Tj", "(1 Rue \\(Haute\\)) Tj", "(Li\\350ge 4000) Tj", "(GROUND) Tj", "re f"} {
+		if !bytes.Contains(b, []byte(want)) {
+			t.Errorf("got no %s in the PDF", want)
+		}
+	}
+}
+
+func TestRenderZPL(t *testing.T) {
+	b, err := Render(ForShipment(shipment, Sender), FormatZPL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(b, []byte("^XA\n")) || !bytes.HasSuffix(b, []byte("^XZ\n")) {
+		t.Fatalf("got no ZPL label: %q", b)
+	}
+
+	for _, want := range []string{"^PW812\n^LL1218", "^BCN,226,N,N,N,A^FH^FD1Z999AA10123456784^FS", "^BQN,2,", "ORDER:order_5F1^FS", "^FDLiège 4000^FS", "^FDOrder order_5F1^FS"} {
+		if !bytes.Contains(b, []byte(want)) {
+			t.Errorf("got no %s in the ZPL", want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		label   Label
+		format  string
+		wantErr bool
+	}{
+		{name: "no address", label: ForShipment(shipper.Shipment{ShipmentData: acmeserverless.ShipmentData{TrackingNumber: "ABC123", OrderNumber: "1"}, Carrier: "FedEx"}, Sender), format: FormatPDF},
+		{name: "unknown format", label: ForShipment(shipment, Sender), format: "png", wantErr: true},
+		{name: "no tracking number", label: Label{Carrier: "UPS"}, format: FormatZPL, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Render(tt.label, tt.format); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	warehouses := []warehouse.Warehouse{{ID: "rno", Name: "Reno", Address: address.Address{City: "Sparks", Country: "US"}}}
+
+	tests := []struct {
+		name      string
+		warehouse string
+		want      string
+	}{
+		{name: "known warehouse", warehouse: "rno", want: "Reno"},
+		{name: "unknown warehouse", warehouse: "lax", want: Sender.Name},
+		{name: "no warehouse", want: Sender.Name},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := shipment
+			s.Warehouse = tt.warehouse
+			if got := Origin(s, warehouses); got.Name != tt.want || got.Address == nil {
+				t.Errorf("got origin %+v, want %s with an address", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFor(t *testing.T) {
+	warehouses := []warehouse.Warehouse{{ID: "rno", Name: "Reno", Address: address.Address{City: "Sparks", Country: "US"}}}
+
+	s := shipment
+	s.Warehouse = "rno"
+	if l := For(s, warehouses); l.From.Name != "Reno" || l.To.Name != "Order "+s.OrderNumber {
+		t.Errorf("got label from %q to %q, want from Reno to the order", l.From.Name, l.To.Name)
+	}
+
+	s.Return = &shipper.Return{RMA: "RMA123456789"}
+	l := For(s, warehouses)
+	if l.From.Name != "Order "+s.OrderNumber || l.From.Address != s.Address {
+		t.Errorf("got return label from %+v, want the customer", l.From)
+	}
+	if l.To.Name != "Reno (RMA123456789)" || l.To.Address == nil {
+		t.Errorf("got return label to %+v, want Reno with the RMA", l.To)
+	}
+}
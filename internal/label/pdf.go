@@ -0,0 +1,108 @@
+package label
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// renderPDF renders the elements as a PDF document with a single page of the size of
+// a label. Text is set in the standard Helvetica fonts, so no fonts are embedded, and
+// the barcodes are drawn as rectangles.
+func renderPDF(elements []element) []byte {
+	var content bytes.Buffer
+	for _, e := range elements {
+		switch {
+		case e.text != "":
+			font := "F1"
+			if e.bold {
+				font = "F2"
+			}
+			fmt.Fprintf(&content, "BT /%s %s Tf %s %s Td (%s) Tj ET\n", font, num(e.size), num(e.x), num(height-e.y-e.size*0.8), pdfString(e.text))
+		case e.box:
+			rect(&content, e.x, e.y, e.width, e.height)
+		case e.bars != nil:
+			runs(e.bars, func(start, n int) {
+				rect(&content, e.x+float64(start)*e.module, e.y, float64(n)*e.module, e.height)
+			})
+		case e.qr != nil:
+			for row, modules := range e.qr.modules {
+				runs(modules, func(start, n int) {
+					rect(&content, e.x+float64(start)*e.module, e.y+float64(row)*e.module, float64(n)*e.module, e.module)
+				})
+			}
+		}
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", width, height),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return b.Bytes()
+}
+
+// rect draws a filled rectangle, positioned from the top left corner of the label.
+func rect(b *bytes.Buffer, x float64, y float64, w float64, h float64) {
+	fmt.Fprintf(b, "%s %s %s %s re f\n", num(x), num(height-y-h), num(w), num(h))
+}
+
+// runs calls fn with the start and the length of every run of dark modules.
+func runs(modules []bool, fn func(start int, n int)) {
+	for i := 0; i < len(modules); {
+		if !modules[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(modules) && modules[i] {
+			i++
+		}
+		fn(start, i-start)
+	}
+}
+
+// num formats a number of points with at most two decimals.
+func num(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// pdfString escapes the text for a PDF string in the WinAnsi encoding of the fonts.
+// Characters the encoding doesn't have are replaced by a question mark.
+func pdfString(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,457 @@
+package label
+
+import (
+	"fmt"
+)
+
+const (
+	// qrMaxVersion is the largest QR code version the encoder creates, which holds
+	// 213 bytes at error correction level M.
+	qrMaxVersion = 10
+)
+
+// qrECCPerBlock and qrBlocks are the number of error correction codewords per block
+// and the number of blocks of every version at error correction level M.
+var (
+	qrECCPerBlock = [qrMaxVersion + 1]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	qrBlocks      = [qrMaxVersion + 1]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+)
+
+// qrCode is a QR code, with the modules indexed by row and then column. A true
+// module is dark.
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// qr encodes the data as a QR code in byte mode with error correction level M, in
+// the smallest version that fits it. The quiet zone isn't included.
+func qr(data []byte) (*qrCode, error) {
+	version := 0
+	for v := 1; v <= qrMaxVersion; v++ {
+		if qrBits(v, len(data)) <= qrDataCodewords(v)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("%d bytes don't fit in a QR code of version %d", len(data), qrMaxVersion)
+	}
+
+	// Put the mode, the length and the data in codewords, padded to the capacity
+	capacity := qrDataCodewords(version) * 8
+	var bits []bool
+	bits = appendBits(bits, 0x4, 4)
+	bits = appendBits(bits, len(data), qrCountBits(version))
+	for _, b := range data {
+		bits = appendBits(bits, int(b), 8)
+	}
+	bits = appendBits(bits, 0, min(4, capacity-len(bits)))
+	bits = appendBits(bits, 0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits = appendBits(bits, pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 1 << (7 - uint(i%8))
+		}
+	}
+
+	q := newQRCode(version)
+	q.drawCodewords(qrInterleave(version, codewords))
+
+	// Use the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+
+	return q, nil
+}
+
+// qrBits returns the number of bits n bytes need in a QR code of the version.
+func qrBits(version int, n int) int {
+	return 4 + qrCountBits(version) + 8*n
+}
+
+// qrCountBits returns the number of bits of the length of the data in byte mode.
+func qrCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// qrRawModules returns the number of modules of the version that hold codewords,
+// which is all modules except the function patterns and the format and version bits.
+func qrRawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// qrDataCodewords returns the number of data codewords of the version at error
+// correction level M.
+func qrDataCodewords(version int) int {
+	return qrRawModules(version)/8 - qrECCPerBlock[version]*qrBlocks[version]
+}
+
+// qrInterleave splits the data codewords in blocks, adds the error correction
+// codewords to every block and interleaves the blocks.
+func qrInterleave(version int, data []byte) []byte {
+	numBlocks := qrBlocks[version]
+	eccLen := qrECCPerBlock[version]
+	raw := qrRawModules(version) / 8
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+
+	divisor := rsDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	var result []byte
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			// Short blocks have a placeholder after their data
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// newQRCode creates a QR code of the version with its function patterns.
+func newQRCode(version int) *qrCode {
+	size := version*4 + 17
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+
+	// Timing patterns
+	for i := 0; i < size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns, with their separators
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					d := max(abs(dx), abs(dy))
+					q.setFunction(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+
+	// Alignment patterns, except where they overlap the finder patterns
+	pos := qrAlignmentPositions(version)
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == len(pos)-1) || (i == len(pos)-1 && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(pos[i]+dx, pos[j]+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format bits, which are drawn after the mask is chosen
+	q.drawFormatBits(0)
+
+	// Version bits
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			bit := bits>>uint(i)&1 != 0
+			a, b := size-11+i%3, i/3
+			q.setFunction(a, b, bit)
+			q.setFunction(b, a, bit)
+		}
+	}
+
+	return q
+}
+
+// qrAlignmentPositions returns the centers of the alignment patterns of the version,
+// which are used as both the rows and the columns.
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	n := version/7 + 2
+	step := (version*4 + n*2 + 1) / (n*2 - 2) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, version*4+17-7; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+// setFunction sets the module of a function pattern in column x and row y.
+func (q *qrCode) setFunction(x int, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// drawFormatBits draws both copies of the format bits of error correction level M
+// and the mask, and the dark module.
+func (q *qrCode) drawFormatBits(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return bits>>uint(i)&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.size-15+i, bit(i))
+	}
+	q.setFunction(8, q.size-8, true)
+}
+
+// qrFormatBits returns the 15 format bits of error correction level M and the mask,
+// with their BCH error correction.
+func qrFormatBits(mask int) int {
+	// The format bits of level M are 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawCodewords draws the codewords in the zigzag order of QR codes, from the bottom
+// right corner, in columns of two modules that skip the vertical timing pattern.
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if upward {
+				y = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if q.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				q.modules[y][x] = data[i/8]>>uint(7-i%8)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips the modules that aren't part of a function pattern where the mask
+// pattern is true. Applying the same mask twice removes it again.
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.function[y][x] {
+				continue
+			}
+
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			q.modules[y][x] = q.modules[y][x] != flip
+		}
+	}
+}
+
+// penalty scores how hard the QR code is to scan: long runs of the same color, blocks
+// of the same color, patterns that look like finder patterns and an unbalanced number
+// of dark modules all add to the score.
+func (q *qrCode) penalty() int {
+	n := q.size
+	score := 0
+	finder := []bool{true, false, true, true, true, false, true, false, false, false, false}
+
+	for _, transpose := range []bool{false, true} {
+		at := func(i, j int) bool {
+			if transpose {
+				return q.modules[j][i]
+			}
+			return q.modules[i][j]
+		}
+
+		for i := 0; i < n; i++ {
+			run := 1
+			for j := 1; j <= n; j++ {
+				if j < n && at(i, j) == at(i, j-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+
+			for j := 0; j+len(finder) <= n; j++ {
+				forward, backward := true, true
+				for k, dark := range finder {
+					forward = forward && at(i, j+k) == dark
+					backward = backward && at(i, j+len(finder)-1-k) == dark
+				}
+				if forward {
+					score += 40
+				}
+				if backward {
+					score += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x < n-1 && y < n-1 {
+				c := q.modules[y][x]
+				if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+
+	total := n * n
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return score + k*10
+}
+
+// rsDivisor returns the generator polynomial of a Reed-Solomon code with the degree,
+// with the coefficients from the highest to the lowest power, leaving out the leading 1.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords of the data.
+func rsRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMul(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies two elements of GF(2^8) with the reducing polynomial of QR codes.
+func gfMul(x byte, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= (int(y) >> uint(i) & 1) * int(x)
+	}
+	return byte(z)
+}
+
+// appendBits appends the n lowest bits of the value, from the highest to the lowest.
+func appendBits(bits []bool, value int, n int) []bool {
+	for i := n - 1; i >= 0; i-- {
+		bits = append(bits, value>>uint(i)&1 != 0)
+	}
+	return bits
+}
+
+// abs returns the absolute value of n.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
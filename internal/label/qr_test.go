@@ -0,0 +1,179 @@
+package label
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// The data and error correction codewords of HELLO WORLD in a version 1-M QR code
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if got := rsRemainder(data, rsDivisor(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestQRFormatBits(t *testing.T) {
+	want := []string{
+		"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+		"100010111111001", "100000011001110", "100111110010111", "100101010100000",
+	}
+
+	for mask, w := range want {
+		if got := fmt.Sprintf("%015b", qrFormatBits(mask)); got != w {
+			t.Errorf("mask %d: got %s, want %s", mask, got, w)
+		}
+	}
+}
+
+// readQR reads the data back from the QR code: it removes the mask of the format bits,
+// reads the codewords, removes the interleaving and decodes the data in byte mode.
+func readQR(t *testing.T, q *qrCode) []byte {
+	t.Helper()
+
+	// Read the copy of the format bits next to the top right and bottom left finder patterns
+	var format int
+	for i := 0; i < 15; i++ {
+		dark := i >= 8 && q.modules[q.size-15+i][8]
+		if i < 8 {
+			dark = q.modules[8][q.size-1-i]
+		}
+		if dark {
+			format |= 1 << uint(i)
+		}
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if qrFormatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("got unknown format bits %015b", format)
+	}
+
+	code := &qrCode{size: q.size, modules: make([][]bool, q.size), function: q.function}
+	for i := range q.modules {
+		code.modules[i] = append([]bool(nil), q.modules[i]...)
+	}
+	code.applyMask(mask)
+
+	var bits []bool
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				if !q.function[y][right-j] {
+					bits = append(bits, code.modules[y][right-j])
+				}
+			}
+		}
+	}
+
+	version := (q.size - 17) / 4
+	interleaved := make([]byte, qrRawModules(version)/8)
+	for i := range interleaved {
+		for j := 0; j < 8; j++ {
+			if bits[i*8+j] {
+				interleaved[i] |= 1 << uint(7-j)
+			}
+		}
+	}
+
+	// The data codewords come first, with the ones of every block in turn
+	numBlocks := qrBlocks[version]
+	numShort := numBlocks - len(interleaved)%numBlocks
+	shortData := len(interleaved)/numBlocks - qrECCPerBlock[version]
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortData; i++ {
+		for j := range blocks {
+			if i < shortData || j >= numShort {
+				blocks[j] = append(blocks[j], interleaved[k])
+				k++
+			}
+		}
+	}
+
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, block...)
+	}
+
+	var stream []bool
+	for _, b := range data {
+		stream = appendBits(stream, int(b), 8)
+	}
+	read := func(n int) int {
+		v := 0
+		for _, bit := range stream[:n] {
+			v <<= 1
+			if bit {
+				v |= 1
+			}
+		}
+		stream = stream[n:]
+		return v
+	}
+
+	if mode := read(4); mode != 0x4 {
+		t.Fatalf("got mode %b, want byte mode", mode)
+	}
+	result := make([]byte, read(qrCountBits(version)))
+	for i := range result {
+		result[i] = byte(read(8))
+	}
+	return result
+}
+
+func TestQR(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantVersion int
+		wantErr     bool
+	}{
+		{name: "short", data: "1Z999AA10123456784", wantVersion: 2},
+		{name: "label", data: "CARRIER:UPS/FedEx\nTRACKING:0d0e1f2a-3b4c-4d5e-8f60-718293a4b5c6\nORDER:5e9c1b6e2d6f5a0001a6c2b1", wantVersion: 6},
+		{name: "version with version bits", data: strings.Repeat("x", 130), wantVersion: 8},
+		{name: "largest", data: strings.Repeat("x", 213), wantVersion: 10},
+		{name: "too long", data: strings.Repeat("x", 214), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := qr([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := (q.size - 17) / 4; got != tt.wantVersion {
+				t.Errorf("got version %d, want %d", got, tt.wantVersion)
+			}
+
+			// Every finder pattern has a dark center and border, and a light ring
+			for _, c := range [][2]int{{3, 3}, {q.size - 4, 3}, {3, q.size - 4}} {
+				if !q.modules[c[1]][c[0]] || !q.modules[c[1]-3][c[0]] || q.modules[c[1]-2][c[0]] {
+					t.Errorf("got no finder pattern at %v", c)
+				}
+			}
+
+			if got := readQR(t, q); string(got) != tt.data {
+				t.Errorf("got data %q, want %q", got, tt.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package label
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+const (
+	// dpi is the resolution of the label printers, in dots per inch.
+	dpi = 203
+)
+
+// renderZPL renders the elements as a ZPL label for printers with a resolution of 203
+// dpi. The printer draws the barcodes itself, so only their size and position are
+// sent, and text is set in the scalable font 0.
+func renderZPL(elements []element) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "^XA\n^CI28\n^PW%d\n^LL%d\n", dots(width), dots(height))
+
+	for _, e := range elements {
+		switch {
+		case e.text != "":
+			size := dots(e.size)
+			fmt.Fprintf(&b, "^FO%d,%d^A0N,%d,%d^FH^FD%s^FS\n", dots(e.x), dots(e.y), size, size, zplString(e.text))
+		case e.box:
+			w, h := max(1, dots(e.width)), max(1, dots(e.height))
+			fmt.Fprintf(&b, "^FO%d,%d^GB%d,%d,%d^FS\n", dots(e.x), dots(e.y), w, h, min(w, h))
+		case e.bars != nil:
+			// Keep the barcode centered where it is, as the width of a module is rounded
+			module := max(1, dots(e.module))
+			center := dots(e.x + e.module*float64(len(e.bars))/2)
+			h := dots(e.height)
+			fmt.Fprintf(&b, "^BY%d,3,%d^FO%d,%d^BCN,%d,N,N,N,A^FH^FD%s^FS\n", module, h, center-module*len(e.bars)/2, dots(e.y), h, zplString(e.barcode))
+		case e.qr != nil:
+			// Keep the QR code aligned to the right, as the width of a module is rounded
+			module := min(10, max(1, dots(e.module)))
+			right := dots(e.x + e.module*float64(e.qr.size))
+			fmt.Fprintf(&b, "^FO%d,%d^BQN,2,%d^FH^FDMA,%s^FS\n", right-module*e.qr.size, dots(e.y), module, zplString(e.qrData))
+		}
+	}
+
+	b.WriteString("^XZ\n")
+	return b.Bytes()
+}
+
+// dots converts points to printer dots.
+func dots(points float64) int {
+	return int(math.Round(points * dpi / 72))
+}
+
+// zplString escapes the characters that are commands in ZPL, and control characters,
+// as hexadecimal for the ^FH command.
+func zplString(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || c == '^' || c == '~' || c < ' ' {
+			fmt.Fprintf(&b, "_%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
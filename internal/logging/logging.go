@@ -0,0 +1,149 @@
+// Package logging configures the structured, leveled logger of the Shipment service.
+// Log records are written as JSON, so they can be queried in CloudWatch Logs Insights
+// or Cloud Logging, and are tagged with the fields that identify the shipment they are
+// about, like the order number and the tracking number. Those fields are added to the
+// context once, by the entrypoint or the workflow, and every record logged with that
+// context carries them.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/retgits/acme-serverless-shipment/internal/redact"
+)
+
+// The names of the fields that identify a shipment in the log records.
+const (
+	// OrderNumber is the field with the order number of the shipment.
+	OrderNumber = "orderNumber"
+
+	// TrackingNumber is the field with the tracking number of the shipment.
+	TrackingNumber = "trackingNumber"
+
+	// Carrier is the field with the carrier of the shipment.
+	Carrier = "carrier"
+
+	// CorrelationID is the field with the correlation ID of the order.
+	CorrelationID = "correlationId"
+
+	// RequestID is the field with the ID of the Lambda invocation.
+	RequestID = "requestId"
+)
+
+// Options configure the logger.
+type Options struct {
+	// Level is the minimum level of the records that are logged: debug, info,
+	// warn or error.
+	Level string
+
+	// Format is the format of the records: json or text.
+	Format string
+
+	// Redactor, when set, removes personally identifiable information from the
+	// fields of the records.
+	Redactor *redact.Redactor
+}
+
+// New creates a logger that writes records to w and adds the fields of the context
+// to every record.
+func New(w io.Writer, o Options) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(o.Level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q, use debug, info, warn or error", o.Level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if o.Redactor != nil {
+		opts.ReplaceAttr = o.Redactor.ReplaceAttr
+	}
+
+	var h slog.Handler
+	switch strings.ToLower(o.Format) {
+	case "", "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use json or text", o.Format)
+	}
+
+	return slog.New(contextHandler{h}), nil
+}
+
+// Init creates a logger that writes to stderr and makes it the default logger, which
+// is also used by the log package.
+func Init(o Options) error {
+	logger, err := New(os.Stderr, o)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(logger)
+	return nil
+}
+
+// Fatal logs the message at error level and stops the binary.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Err returns the field for an error.
+func Err(err error) slog.Attr {
+	return slog.String("error", err.Error())
+}
+
+type contextKey struct{}
+
+// With returns a copy of ctx with the fields, as key-value pairs like the arguments
+// of slog.Info, added to the fields of ctx. A field that is already set is replaced.
+func With(ctx context.Context, args ...any) context.Context {
+	r := slog.Record{}
+	r.Add(args...)
+
+	attrs := append([]slog.Attr{}, fields(ctx)...)
+	r.Attrs(func(a slog.Attr) bool {
+		for i := range attrs {
+			if attrs[i].Key == a.Key {
+				attrs[i] = a
+				return true
+			}
+		}
+		attrs = append(attrs, a)
+		return true
+	})
+
+	return context.WithValue(ctx, contextKey{}, attrs)
+}
+
+// fields returns the fields of ctx.
+func fields(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	return attrs
+}
+
+// contextHandler adds the fields of the context to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(fields(ctx)...)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,96 @@
+// Package metrics contains the interfaces that the Shipment service
+// in the ACME Serverless Fitness Shop uses to record metrics about the
+// shipment workflow. In order to add a new metrics backend, the Recorder
+// interface needs to be implemented.
+package metrics
+
+import "time"
+
+// OtherCarrier is the carrier the metrics of shipments are recorded for when the service
+// doesn't know their carrier.
+const OtherCarrier = "other"
+
+// Recorder is the interface that describes the methods a metrics
+// backend needs to implement to be able to record the metrics of
+// the shipment workflow.
+type Recorder interface {
+	// ShipmentCreated counts a shipment handed to the carrier, which is one of the carriers
+	// the service knows or OtherCarrier.
+	ShipmentCreated(carrier string)
+
+	// DeliveryDuration records how long the delivery of a shipment
+	// took, from the moment it was handed to the carrier.
+	DeliveryDuration(carrier string, d time.Duration)
+
+	// EmitFailed counts an event the EventEmitter failed to send.
+	EmitFailed(emitter string)
+
+	// StatusTransition counts a shipment moving from one status to
+	// another. The from status is empty for new shipments.
+	StatusTransition(from string, to string)
+
+	// DeliveryQueue records the state of the delivery queue of long
+	// running services.
+	DeliveryQueue(depth int, size int, busy int, workers int)
+}
+
+// Nop is a Recorder that doesn't record anything.
+type Nop struct{}
+
+// ShipmentCreated does nothing.
+func (Nop) ShipmentCreated(carrier string) {}
+
+// DeliveryDuration does nothing.
+func (Nop) DeliveryDuration(carrier string, d time.Duration) {}
+
+// EmitFailed does nothing.
+func (Nop) EmitFailed(emitter string) {}
+
+// StatusTransition does nothing.
+func (Nop) StatusTransition(from string, to string) {}
+
+// DeliveryQueue does nothing.
+func (Nop) DeliveryQueue(depth int, size int, busy int, workers int) {}
+
+// multi records metrics with every Recorder it contains.
+type multi []Recorder
+
+// Multi returns a Recorder that records every metric with all recorders.
+func Multi(recorders ...Recorder) Recorder {
+	return multi(recorders)
+}
+
+// ShipmentCreated records the metric with all recorders.
+func (m multi) ShipmentCreated(carrier string) {
+	for _, r := range m {
+		r.ShipmentCreated(carrier)
+	}
+}
+
+// DeliveryDuration records the metric with all recorders.
+func (m multi) DeliveryDuration(carrier string, d time.Duration) {
+	for _, r := range m {
+		r.DeliveryDuration(carrier, d)
+	}
+}
+
+// EmitFailed records the metric with all recorders.
+func (m multi) EmitFailed(emitter string) {
+	for _, r := range m {
+		r.EmitFailed(emitter)
+	}
+}
+
+// StatusTransition records the metric with all recorders.
+func (m multi) StatusTransition(from string, to string) {
+	for _, r := range m {
+		r.StatusTransition(from, to)
+	}
+}
+
+// DeliveryQueue records the metric with all recorders.
+func (m multi) DeliveryQueue(depth int, size int, busy int, workers int) {
+	for _, r := range m {
+		r.DeliveryQueue(depth, size, busy, workers)
+	}
+}
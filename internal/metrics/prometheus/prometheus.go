@@ -0,0 +1,125 @@
+// Package prometheus records the metrics of the shipment workflow in a
+// Prometheus registry, so they can be scraped from the /metrics route of
+// the HTTP service.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/retgits/acme-serverless-shipment/internal/metrics"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+const (
+	namespace = "shipment"
+)
+
+// recorder is a struct that implements the methods of the
+// Recorder interface.
+type recorder struct {
+	created      *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	emitFailures *prometheus.CounterVec
+	transitions  *prometheus.CounterVec
+	queueDepth   prometheus.Gauge
+	queueSize    prometheus.Gauge
+	workersBusy  prometheus.Gauge
+	workers      prometheus.Gauge
+}
+
+// New creates a new instance of the Recorder with Prometheus as
+// the metrics backend. The metrics are registered with reg, and
+// the method returns an error if that fails.
+func New(reg prometheus.Registerer) (metrics.Recorder, error) {
+	r := recorder{
+		created: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "shipments_created_total",
+			Help:      "Number of shipments handed to a carrier.",
+		}, []string{"carrier"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "delivery_duration_seconds",
+			Help:      "Time between handing a shipment to a carrier and its delivery.",
+			Buckets:   []float64{5, 10, 20, 30, 45, 60, 90, 120, 180, 300},
+		}, []string{"carrier"}),
+		emitFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "emit_failures_total",
+			Help:      "Number of events the EventEmitter failed to send.",
+		}, []string{"emitter"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "status_transitions_total",
+			Help:      "Number of shipments that moved from one status to another.",
+		}, []string{"from", "to"}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "delivery_queue_depth",
+			Help:      "Number of outstanding deliveries.",
+		}),
+		queueSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "delivery_queue_size",
+			Help:      "Maximum number of outstanding deliveries.",
+		}),
+		workersBusy: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "delivery_workers_busy",
+			Help:      "Number of delivery workers handling a delivery.",
+		}),
+		workers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "delivery_workers",
+			Help:      "Number of delivery workers.",
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{r.created, r.duration, r.emitFailures, r.transitions, r.queueDepth, r.queueSize, r.workersBusy, r.workers} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Handler returns a fasthttp handler that serves the metrics in the
+// registry in the Prometheus exposition format.
+func Handler(g prometheus.Gatherer) fasthttp.RequestHandler {
+	return fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+}
+
+// ShipmentCreated increments the shipments counter of the carrier.
+func (r recorder) ShipmentCreated(carrier string) {
+	r.created.WithLabelValues(carrier).Inc()
+}
+
+// DeliveryDuration observes the duration in the histogram of the carrier.
+func (r recorder) DeliveryDuration(carrier string, d time.Duration) {
+	r.duration.WithLabelValues(carrier).Observe(d.Seconds())
+}
+
+// EmitFailed increments the failures counter of the emitter.
+func (r recorder) EmitFailed(emitter string) {
+	r.emitFailures.WithLabelValues(emitter).Inc()
+}
+
+// StatusTransition increments the counter of the transition.
+func (r recorder) StatusTransition(from string, to string) {
+	if from == "" {
+		from = "none"
+	}
+	r.transitions.WithLabelValues(from, to).Inc()
+}
+
+// DeliveryQueue sets the gauges of the delivery queue.
+func (r recorder) DeliveryQueue(depth int, size int, busy int, workers int) {
+	r.queueDepth.Set(float64(depth))
+	r.queueSize.Set(float64(size))
+	r.workersBusy.Set(float64(busy))
+	r.workers.Set(float64(workers))
+}
@@ -0,0 +1,97 @@
+// Package wavefront sends the metrics of the shipment workflow directly to
+// Wavefront, like the request metrics the gcr-wavefront wrapper sends for the
+// HTTP service. When the sender is nil, the metrics are logged at debug level
+// instead, which matches the debug mode of the wrapper.
+package wavefront
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/metrics"
+	wavefront "github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+// recorder is a struct that implements the methods of the
+// Recorder interface.
+type recorder struct {
+	sender wavefront.Sender
+	prefix string
+	source string
+}
+
+// New creates a new instance of the Recorder with Wavefront as the
+// metrics backend. All metric names start with prefix, and are sent
+// with source as the source of the metric.
+func New(sender wavefront.Sender, prefix string, source string) metrics.Recorder {
+	return recorder{
+		sender: sender,
+		prefix: prefix,
+		source: source,
+	}
+}
+
+// ShipmentCreated sends a delta counter, tagged with the carrier.
+func (r recorder) ShipmentCreated(carrier string) {
+	r.counter("shipments.created", map[string]string{"carrier": carrier})
+}
+
+// DeliveryDuration sends the duration in seconds, tagged with the carrier.
+func (r recorder) DeliveryDuration(carrier string, d time.Duration) {
+	r.metric("delivery.duration", d.Seconds(), map[string]string{"carrier": carrier})
+}
+
+// EmitFailed sends a delta counter, tagged with the emitter.
+func (r recorder) EmitFailed(emitter string) {
+	r.counter("emit.failures", map[string]string{"emitter": emitter})
+}
+
+// StatusTransition sends a delta counter, tagged with both statuses.
+func (r recorder) StatusTransition(from string, to string) {
+	if from == "" {
+		from = "none"
+	}
+	r.counter("status.transitions", map[string]string{"from": from, "to": to})
+}
+
+// DeliveryQueue sends the depth and saturation of the delivery queue and the
+// utilization of the workers.
+func (r recorder) DeliveryQueue(depth int, size int, busy int, workers int) {
+	r.metric("delivery.queue.depth", float64(depth), nil)
+	r.metric("delivery.queue.saturation", ratio(depth, size), nil)
+	r.metric("delivery.workers.busy", float64(busy), nil)
+	r.metric("delivery.workers.utilization", ratio(busy, workers), nil)
+}
+
+// counter sends a delta counter of one.
+func (r recorder) counter(name string, tags map[string]string) {
+	if r.sender == nil {
+		slog.Debug("counter", "metric", r.prefix+"."+name, "tags", tags)
+		return
+	}
+
+	if err := r.sender.SendDeltaCounter(r.prefix+"."+name, 1, r.source, tags); err != nil {
+		slog.Error("error sending metric", "metric", name, logging.Err(err))
+	}
+}
+
+// metric sends a single value.
+func (r recorder) metric(name string, value float64, tags map[string]string) {
+	if r.sender == nil {
+		slog.Debug("metric", "metric", r.prefix+"."+name, "value", value, "tags", tags)
+		return
+	}
+
+	if err := r.sender.SendMetric(r.prefix+"."+name, value, time.Now().Unix(), r.source, tags); err != nil {
+		slog.Error("error sending metric", "metric", name, logging.Err(err))
+	}
+}
+
+// ratio returns part divided by total, or zero when total is zero.
+func ratio(part int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
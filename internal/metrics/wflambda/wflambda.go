@@ -0,0 +1,67 @@
+// Package wflambda records the metrics of the shipment workflow in the
+// go-metrics registry of the Wavefront Lambda wrapper. The wrapper reports
+// the registry to Wavefront, together with the standard Lambda metrics, at
+// the end of every invocation and clears it afterwards.
+package wflambda
+
+import (
+	"time"
+
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/retgits/acme-serverless-shipment/internal/metrics"
+	wavefront "github.com/wavefronthq/go-metrics-wavefront"
+)
+
+// recorder is a struct that implements the methods of the
+// Recorder interface.
+type recorder struct {
+	prefix string
+}
+
+// New creates a new instance of the Recorder with the Wavefront
+// Lambda wrapper as the metrics backend. All metric names start
+// with prefix.
+func New(prefix string) metrics.Recorder {
+	return recorder{
+		prefix: prefix,
+	}
+}
+
+// ShipmentCreated increments a delta counter, tagged with the carrier.
+func (r recorder) ShipmentCreated(carrier string) {
+	r.counter("shipments.created", map[string]string{"carrier": carrier})
+}
+
+// DeliveryDuration updates a gauge with the duration in seconds, tagged
+// with the carrier.
+func (r recorder) DeliveryDuration(carrier string, d time.Duration) {
+	r.gauge("delivery.duration", d.Seconds(), map[string]string{"carrier": carrier})
+}
+
+// EmitFailed increments a delta counter, tagged with the emitter.
+func (r recorder) EmitFailed(emitter string) {
+	r.counter("emit.failures", map[string]string{"emitter": emitter})
+}
+
+// StatusTransition increments a delta counter, tagged with both statuses.
+func (r recorder) StatusTransition(from string, to string) {
+	if from == "" {
+		from = "none"
+	}
+	r.counter("status.transitions", map[string]string{"from": from, "to": to})
+}
+
+// DeliveryQueue does nothing, because Lambda functions don't queue deliveries.
+func (r recorder) DeliveryQueue(depth int, size int, busy int, workers int) {}
+
+// counter increments the delta counter with the name and tags.
+func (r recorder) counter(name string, tags map[string]string) {
+	c := wavefront.GetOrRegisterMetric(wavefront.DeltaCounterName(r.prefix+"."+name), gometrics.NewCounter(), tags).(gometrics.Counter)
+	c.Inc(1)
+}
+
+// gauge updates the gauge with the name and tags.
+func (r recorder) gauge(name string, value float64, tags map[string]string) {
+	g := wavefront.GetOrRegisterMetric(r.prefix+"."+name, gometrics.NewGaugeFloat64(), tags).(gometrics.GaugeFloat64)
+	g.Update(value)
+}
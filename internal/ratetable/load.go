@@ -0,0 +1,205 @@
+package ratetable
+
+import (
+	"bytes"
+	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaults are the built-in rate tables, which are used when no rate tables are configured.
+//
+//go:embed defaults
+var defaults embed.FS
+
+// Default returns the built-in rate tables of the simulated UPS and FedEx carriers.
+func Default() ([]Table, error) {
+	fsys, err := fs.Sub(defaults, "defaults")
+	if err != nil {
+		return nil, err
+	}
+	return LoadFS(fsys)
+}
+
+// Load reads and validates every rate table in the directory. See LoadFS.
+func Load(dir string) ([]Table, error) {
+	tables, err := LoadFS(os.DirFS(dir))
+	if err != nil {
+		return nil, fmt.Errorf("error loading rate tables from %s: %s", dir, err.Error())
+	}
+	return tables, nil
+}
+
+// LoadFS reads and validates every rate table in the root of the file system. Every
+// YAML (.yaml or .yml) or JSON (.json) file is a table, and CSV files contain the
+// weight breaks of the tables that refer to them. The tables are only returned when
+// all of them are valid and no service level of a carrier is defined twice.
+func LoadFS(fsys fs.FS) ([]Table, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []Table
+	defined := make(map[string]string)
+
+	for _, e := range entries {
+		if e.IsDir() || !isTableFile(e.Name()) {
+			continue
+		}
+
+		t, err := readTable(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		key := strings.ToLower(t.Carrier) + "/" + t.ServiceLevel
+		if other, ok := defined[key]; ok {
+			return nil, fmt.Errorf("%s %s is defined in both %s and %s", t.Carrier, t.ServiceLevel, other, e.Name())
+		}
+		defined[key] = e.Name()
+
+		tables = append(tables, t)
+	}
+
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no rate tables found, add .yaml, .yml or .json files")
+	}
+
+	return tables, nil
+}
+
+// isTableFile returns whether the file, based on its extension, is a rate table.
+func isTableFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	default:
+		return false
+	}
+}
+
+// readTable reads and validates the rate table in the file, together with the CSV
+// file with its weight breaks.
+func readTable(fsys fs.FS, name string) (Table, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return Table{}, fmt.Errorf("error reading rate table %s: %s", name, err.Error())
+	}
+
+	var t Table
+	if strings.ToLower(path.Ext(name)) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&t)
+	} else {
+		err = yaml.UnmarshalStrict(b, &t)
+	}
+	if err != nil {
+		return Table{}, fmt.Errorf("error parsing rate table %s: %s", name, err.Error())
+	}
+
+	if t.RatesFile != "" {
+		if len(t.Rates) > 0 {
+			return Table{}, fmt.Errorf("invalid rate table %s: set either rates or ratesFile", name)
+		}
+
+		csvName := path.Join(path.Dir(name), t.RatesFile)
+		f, err := fsys.Open(csvName)
+		if err != nil {
+			return Table{}, fmt.Errorf("error reading rates of %s: %s", name, err.Error())
+		}
+		defer f.Close()
+
+		if t.Rates, err = readRates(f); err != nil {
+			return Table{}, fmt.Errorf("error parsing rates %s: %s", csvName, err.Error())
+		}
+	}
+
+	t.ServiceLevel = strings.ToLower(strings.TrimSpace(t.ServiceLevel))
+	t.Currency = strings.ToUpper(t.Currency)
+
+	if err := t.Validate(); err != nil {
+		return Table{}, fmt.Errorf("invalid rate table %s: %s", name, err.Error())
+	}
+
+	return t, nil
+}
+
+// readRates reads weight breaks from CSV. The header has maxKg followed by the zones,
+// and every row has the maximum weight of the break followed by the price in every
+// zone. Lines that start with # are comments.
+func readRates(r io.Reader) ([]WeightBreak, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("need a header and at least one weight break")
+	}
+
+	header := rows[0]
+	if !strings.EqualFold(strings.TrimSpace(header[0]), "maxKg") || len(header) < 2 {
+		return nil, fmt.Errorf("header must be maxKg followed by the zones")
+	}
+
+	zones := make([]int, len(header)-1)
+	for i, h := range header[1:] {
+		if zones[i], err = strconv.Atoi(strings.TrimSpace(h)); err != nil {
+			return nil, fmt.Errorf("zone %q in the header is not a number", h)
+		}
+	}
+
+	breaks := make([]WeightBreak, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		values := make([]float64, len(row))
+		for i, v := range row {
+			if values[i], err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				return nil, fmt.Errorf("line %d: %q is not a number", n+2, v)
+			}
+		}
+
+		b := WeightBreak{MaxKg: values[0], Prices: make(map[int]float64, len(zones))}
+		for i, z := range zones {
+			b.Prices[z] = values[i+1]
+		}
+		breaks = append(breaks, b)
+	}
+
+	return breaks, nil
+}
+
+// Fingerprint returns a value that changes when a file in the directory is added,
+// removed or changed, to tell when the rate tables need to be loaded again.
+func Fingerprint(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("%s:%d:%d", e.Name(), info.Size(), info.ModTime().UnixNano()))
+	}
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n"), nil
+}
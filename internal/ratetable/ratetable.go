@@ -0,0 +1,298 @@
+// Package ratetable contains the zone-based rate tables of the simulated carriers
+// of the Shipment service in the ACME Serverless Fitness Shop. A rate table prices
+// one service level of a carrier: the origin and destination of a package determine
+// its zone, and the zone and the billable weight of the package determine the base
+// rate, on top of which come the fuel and residential surcharges.
+package ratetable
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+const (
+	// Wildcard matches every country in a zone rule.
+	Wildcard = "*"
+)
+
+const (
+	// ChargeBase is the name of the charge of the base rate.
+	ChargeBase = "base"
+
+	// ChargeFuel is the name of the fuel surcharge.
+	ChargeFuel = "fuel"
+
+	// ChargeResidential is the name of the surcharge for deliveries to homes.
+	ChargeResidential = "residential"
+)
+
+// ZoneRule assigns a zone to packages between an origin and a destination. The
+// rules of a table are matched in order and the first rule that matches wins.
+type ZoneRule struct {
+	// Origin is the country code of the origin, or * for every country.
+	Origin string `yaml:"origin" json:"origin"`
+
+	// Destination is the country code of the destination, or * for every country.
+	Destination string `yaml:"destination" json:"destination"`
+
+	// PostalPrefixes limits the rule to destination postal codes that start with
+	// one of the prefixes. The rule matches every postal code when it's empty.
+	PostalPrefixes []string `yaml:"postalPrefixes,omitempty" json:"postalPrefixes,omitempty"`
+
+	// Zone is the zone of the packages that match the rule.
+	Zone int `yaml:"zone" json:"zone"`
+}
+
+// matches returns whether the rule applies to a package from the origin to the destination.
+func (z ZoneRule) matches(origin shipper.Location, destination shipper.Location) bool {
+	if z.Origin != Wildcard && !strings.EqualFold(z.Origin, origin.Country) {
+		return false
+	}
+	if z.Destination != Wildcard && !strings.EqualFold(z.Destination, destination.Country) {
+		return false
+	}
+	if len(z.PostalPrefixes) == 0 {
+		return true
+	}
+
+	postalCode := strings.ToUpper(strings.ReplaceAll(destination.PostalCode, " ", ""))
+	for _, p := range z.PostalPrefixes {
+		if strings.HasPrefix(postalCode, strings.ToUpper(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// WeightBreak is the base rate, by zone, of packages up to a billable weight.
+type WeightBreak struct {
+	// MaxKg is the highest billable weight, in kilograms, the break applies to.
+	MaxKg float64 `yaml:"maxKg" json:"maxKg"`
+
+	// Prices are the base rates of the break, keyed by zone.
+	Prices map[int]float64 `yaml:"prices" json:"prices"`
+}
+
+// Table is the rate table of a service level of a carrier.
+type Table struct {
+	// Carrier is the name of the carrier.
+	Carrier string `yaml:"carrier" json:"carrier"`
+
+	// ServiceLevel is the service level the table prices, like ground.
+	ServiceLevel string `yaml:"serviceLevel" json:"serviceLevel"`
+
+	// Currency is the ISO 4217 code of the currency of the prices.
+	Currency string `yaml:"currency" json:"currency"`
+
+	// DimDivisor converts the volume of a package, in cubic centimeters, to its
+	// dimensional weight in kilograms, like 5000. The dimensional weight is charged
+	// when it's more than the actual weight. Zero disables dimensional weight.
+	DimDivisor float64 `yaml:"dimDivisor" json:"dimDivisor"`
+
+	// FuelSurcharge is the fraction of the base rate that is added as fuel surcharge, like 0.12.
+	FuelSurcharge float64 `yaml:"fuelSurcharge" json:"fuelSurcharge"`
+
+	// ResidentialSurcharge is the amount that is added for deliveries to homes.
+	ResidentialSurcharge float64 `yaml:"residentialSurcharge" json:"residentialSurcharge"`
+
+	// Zones are the rules that assign a zone to packages, matched in order.
+	Zones []ZoneRule `yaml:"zones" json:"zones"`
+
+	// TransitDays is the number of business days it takes to deliver a package, keyed by zone.
+	TransitDays map[int]int `yaml:"transitDays" json:"transitDays"`
+
+	// Rates are the weight breaks of the table, from light to heavy. They are read
+	// from RatesFile when it's set.
+	Rates []WeightBreak `yaml:"rates,omitempty" json:"rates,omitempty"`
+
+	// RatesFile is the CSV file with the weight breaks, relative to the file of the
+	// table. The header has maxKg followed by the zones, every row a weight break.
+	RatesFile string `yaml:"ratesFile,omitempty" json:"ratesFile,omitempty"`
+}
+
+// Validate checks that the table is complete: every zone a rule assigns has a transit
+// time and a price in every weight break, and the weight breaks go from light to heavy.
+func (t Table) Validate() error {
+	switch {
+	case strings.TrimSpace(t.Carrier) == "":
+		return fmt.Errorf("carrier is required")
+	case strings.TrimSpace(t.ServiceLevel) == "":
+		return fmt.Errorf("serviceLevel of %s is required", t.Carrier)
+	case len(t.Currency) != 3:
+		return fmt.Errorf("currency of %s %s must be a three-letter currency code", t.Carrier, t.ServiceLevel)
+	case t.DimDivisor < 0:
+		return fmt.Errorf("dimDivisor of %s %s must be 0 or more", t.Carrier, t.ServiceLevel)
+	case t.FuelSurcharge < 0 || t.FuelSurcharge > 1:
+		return fmt.Errorf("fuelSurcharge of %s %s must be between 0 and 1", t.Carrier, t.ServiceLevel)
+	case t.ResidentialSurcharge < 0:
+		return fmt.Errorf("residentialSurcharge of %s %s must be 0 or more", t.Carrier, t.ServiceLevel)
+	case len(t.Zones) == 0:
+		return fmt.Errorf("%s %s has no zones", t.Carrier, t.ServiceLevel)
+	case len(t.Rates) == 0:
+		return fmt.Errorf("%s %s has no rates", t.Carrier, t.ServiceLevel)
+	}
+
+	for i, z := range t.Zones {
+		switch {
+		case z.Origin == "" || z.Destination == "":
+			return fmt.Errorf("zone rule %d of %s %s needs an origin and a destination", i+1, t.Carrier, t.ServiceLevel)
+		case z.Zone < 1:
+			return fmt.Errorf("zone rule %d of %s %s must assign a zone of 1 or more", i+1, t.Carrier, t.ServiceLevel)
+		}
+		if days, ok := t.TransitDays[z.Zone]; !ok || days < 1 {
+			return fmt.Errorf("zone %d of %s %s must take at least 1 transit day", z.Zone, t.Carrier, t.ServiceLevel)
+		}
+	}
+
+	for i, b := range t.Rates {
+		if b.MaxKg <= 0 || (i > 0 && b.MaxKg <= t.Rates[i-1].MaxKg) {
+			return fmt.Errorf("weight breaks of %s %s must go up from more than 0 kg, break %d is %g kg", t.Carrier, t.ServiceLevel, i+1, b.MaxKg)
+		}
+		for _, z := range t.Zones {
+			price, ok := b.Prices[z.Zone]
+			if !ok {
+				return fmt.Errorf("weight break of %g kg of %s %s has no price for zone %d", b.MaxKg, t.Carrier, t.ServiceLevel, z.Zone)
+			}
+			if price < 0 {
+				return fmt.Errorf("weight break of %g kg of %s %s has a negative price for zone %d", b.MaxKg, t.Carrier, t.ServiceLevel, z.Zone)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Zone returns the zone of a package from the origin to the destination, or false
+// when the carrier doesn't ship between them.
+func (t Table) Zone(origin shipper.Location, destination shipper.Location) (int, bool) {
+	for _, z := range t.Zones {
+		if z.matches(origin, destination) {
+			return z.Zone, true
+		}
+	}
+	return 0, false
+}
+
+// BillableWeight returns the weight the carrier charges for: the actual weight or,
+// when it's more, the dimensional weight of the package.
+func (t Table) BillableWeight(p shipper.Package) float64 {
+	weight := p.WeightKg
+	if t.DimDivisor > 0 {
+		if dim := p.LengthCm * p.WidthCm * p.HeightCm / t.DimDivisor; dim > weight {
+			weight = dim
+		}
+	}
+	return weight
+}
+
+// Quote prices the package of the request for a package handed to the carrier at the
+// moment now. It returns false when the carrier doesn't ship between the origin and
+// destination, or when the package is heavier than the heaviest weight break.
+func (t Table) Quote(r shipper.RateRequest, now time.Time) (shipper.Quote, bool) {
+	zone, ok := t.Zone(r.Origin, r.Destination)
+	if !ok {
+		return shipper.Quote{}, false
+	}
+
+	weight := t.BillableWeight(r.Package)
+	i := sort.Search(len(t.Rates), func(i int) bool { return t.Rates[i].MaxKg >= weight })
+	if i == len(t.Rates) {
+		return shipper.Quote{}, false
+	}
+
+	base := t.Rates[i].Prices[zone]
+	charges := []shipper.Charge{
+		{Name: ChargeBase, Amount: cents(base)},
+	}
+	if t.FuelSurcharge > 0 {
+		charges = append(charges, shipper.Charge{Name: ChargeFuel, Amount: cents(base * t.FuelSurcharge)})
+	}
+	if r.Destination.Residential && t.ResidentialSurcharge > 0 {
+		charges = append(charges, shipper.Charge{Name: ChargeResidential, Amount: cents(t.ResidentialSurcharge)})
+	}
+
+	price := 0.0
+	for _, c := range charges {
+		price += c.Amount
+	}
+
+	return shipper.Quote{
+		Carrier:           t.Carrier,
+		ServiceLevel:      t.ServiceLevel,
+		Price:             cents(price),
+		Currency:          t.Currency,
+		EstimatedDelivery: addBusinessDays(now, t.TransitDays[zone]),
+		Zone:              zone,
+		BillableWeightKg:  math.Round(weight*100) / 100,
+		Charges:           charges,
+	}, true
+}
+
+// carrier is a simulated carrier that quotes from the rate tables of its service levels.
+type carrier struct {
+	name   string
+	tables []Table
+}
+
+// Carriers creates a simulated carrier for every carrier in the tables, which
+// quotes the service levels of its tables.
+func Carriers(tables []Table) []shipper.Carrier {
+	byName := make(map[string]*carrier)
+	var carriers []shipper.Carrier
+
+	for _, t := range tables {
+		c, ok := byName[t.Carrier]
+		if !ok {
+			c = &carrier{name: t.Carrier}
+			byName[t.Carrier] = c
+			carriers = append(carriers, c)
+		}
+		c.tables = append(c.tables, t)
+	}
+
+	return carriers
+}
+
+func (c *carrier) Name() string {
+	return c.name
+}
+
+func (c *carrier) Rates(ctx context.Context, r shipper.RateRequest, now time.Time) ([]shipper.Quote, error) {
+	quotes := make([]shipper.Quote, 0, len(c.tables))
+	for _, t := range c.tables {
+		if r.ServiceLevel != "" && !strings.EqualFold(r.ServiceLevel, t.ServiceLevel) {
+			continue
+		}
+		if q, ok := t.Quote(r, now); ok {
+			quotes = append(quotes, q)
+		}
+	}
+	return quotes, nil
+}
+
+// cents rounds the amount to cents.
+func cents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// addBusinessDays returns the day, in UTC and without the time of day, that is the
+// number of business days after t. Saturdays and Sundays are not business days.
+func addBusinessDays(t time.Time, days int) time.Time {
+	t = t.UTC()
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	for days > 0 {
+		d = d.AddDate(0, 0, 1)
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			days--
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,269 @@
+package ratetable
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// wednesday is a moment on a Wednesday, so transit times of more than two days
+// span a weekend.
+var wednesday = time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+
+// dhlExpress loads the DHL express table of the valid test tables.
+func dhlExpress(t *testing.T) Table {
+	t.Helper()
+
+	tables, err := Load(filepath.Join("testdata", "valid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if table.Carrier == "DHL" {
+			return table
+		}
+	}
+	t.Fatal("no DHL table")
+	return Table{}
+}
+
+func request(destination shipper.Location, p shipper.Package) shipper.RateRequest {
+	return shipper.RateRequest{
+		Origin:      shipper.Location{Country: "NL", PostalCode: "1011 AB"},
+		Destination: destination,
+		Package:     p,
+	}
+}
+
+func TestTableQuote(t *testing.T) {
+	table := dhlExpress(t)
+
+	tests := []struct {
+		name          string
+		req           shipper.RateRequest
+		wantOK        bool
+		wantZone      int
+		wantWeight    float64
+		wantPrice     float64
+		wantCharges   string
+		wantDelivered time.Time
+	}{
+		{
+			name:          "domestic",
+			req:           request(shipper.Location{Country: "NL", PostalCode: "3511 AA"}, shipper.Package{WeightKg: 0.8}),
+			wantOK:        true,
+			wantZone:      1,
+			wantWeight:    0.8,
+			wantPrice:     11,
+			wantCharges:   "base:10 fuel:1",
+			wantDelivered: time.Date(2020, 4, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "postal prefix",
+			req:           request(shipper.Location{Country: "BE", PostalCode: "1000"}, shipper.Package{WeightKg: 3}),
+			wantOK:        true,
+			wantZone:      2,
+			wantWeight:    3,
+			wantPrice:     27.5,
+			wantCharges:   "base:25 fuel:2.5",
+			wantDelivered: time.Date(2020, 4, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "dimensional weight",
+			req:           request(shipper.Location{Country: "DE", PostalCode: "10115"}, shipper.Package{WeightKg: 1, LengthCm: 40, WidthCm: 30, HeightCm: 30}),
+			wantOK:        true,
+			wantZone:      2,
+			wantWeight:    7.2,
+			wantPrice:     33,
+			wantCharges:   "base:30 fuel:3",
+			wantDelivered: time.Date(2020, 4, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "residential",
+			req:           request(shipper.Location{Country: "DE", PostalCode: "80331", Residential: true}, shipper.Package{WeightKg: 6, LengthCm: 40, WidthCm: 30, HeightCm: 20}),
+			wantOK:        true,
+			wantZone:      3,
+			wantWeight:    6,
+			wantPrice:     47,
+			wantCharges:   "base:40 fuel:4 residential:3",
+			wantDelivered: time.Date(2020, 4, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "no zone",
+			req:  request(shipper.Location{Country: "FR", PostalCode: "75001"}, shipper.Package{WeightKg: 1}),
+		},
+		{
+			name: "heavier than the heaviest break",
+			req:  request(shipper.Location{Country: "NL", PostalCode: "3511 AA"}, shipper.Package{WeightKg: 10.5}),
+		},
+		{
+			name: "dimensional weight over the heaviest break",
+			req:  request(shipper.Location{Country: "NL", PostalCode: "3511 AA"}, shipper.Package{WeightKg: 1, LengthCm: 50, WidthCm: 40, HeightCm: 30}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := table.Quote(tt.req, wednesday)
+			if ok != tt.wantOK {
+				t.Fatalf("got quote %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+
+			var charges []string
+			for _, c := range got.Charges {
+				charges = append(charges, c.Name+":"+strconv.FormatFloat(c.Amount, 'f', -1, 64))
+			}
+
+			if got.Zone != tt.wantZone || got.BillableWeightKg != tt.wantWeight || got.Price != tt.wantPrice || strings.Join(charges, " ") != tt.wantCharges {
+				t.Errorf("got zone %d, weight %g, price %g and charges %v, want zone %d, weight %g, price %g and charges %s",
+					got.Zone, got.BillableWeightKg, got.Price, charges, tt.wantZone, tt.wantWeight, tt.wantPrice, tt.wantCharges)
+			}
+			if got.Carrier != "DHL" || got.ServiceLevel != "express" || got.Currency != "EUR" {
+				t.Errorf("got %s %s in %s, want DHL express in EUR", got.Carrier, got.ServiceLevel, got.Currency)
+			}
+			if !got.EstimatedDelivery.Equal(tt.wantDelivered) {
+				t.Errorf("got delivery on %s, want %s", got.EstimatedDelivery, tt.wantDelivered)
+			}
+		})
+	}
+}
+
+func TestCarriers(t *testing.T) {
+	tables, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := shipper.NewQuoter(Carriers(tables)...)
+	if got := strings.Join(q.Carriers(), ","); got != "FedEx,UPS" {
+		t.Fatalf("got carriers %s, want FedEx,UPS", got)
+	}
+
+	tests := []struct {
+		name        string
+		destination shipper.Location
+		level       string
+		want        int
+	}{
+		{"all service levels", shipper.Location{Country: "US", PostalCode: "10001"}, "", 6},
+		{"one service level", shipper.Location{Country: "US", PostalCode: "94105"}, "Express", 2},
+		{"no ground abroad", shipper.Location{Country: "NL", PostalCode: "1011 AB"}, shipper.ServiceGround, 0},
+		{"abroad", shipper.Location{Country: "NL", PostalCode: "1011 AB"}, "", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := shipper.RateRequest{
+				Origin:       shipper.Location{Country: "US", PostalCode: "94105"},
+				Destination:  tt.destination,
+				Package:      shipper.Package{WeightKg: 2.2, LengthCm: 30, WidthCm: 20, HeightCm: 10},
+				ServiceLevel: tt.level,
+			}
+
+			got, err := q.Quote(context.Background(), r, wednesday)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d quotes, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		dir     string
+		want    string
+		wantErr string
+	}{
+		{dir: "valid", want: "DHL express, PostNL ground"},
+		{dir: "duplicate", wantErr: "defined in both"},
+		{dir: "negative", wantErr: "negative price"},
+		{dir: "unknown", wantErr: "field fuel not found"},
+		{dir: "missingzone", wantErr: "no price for zone 2"},
+		{dir: "empty", wantErr: "no rate tables found"},
+		{dir: "missing", wantErr: "no such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			tables, err := Load(filepath.Join("testdata", tt.dir))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want an error about %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, table := range tables {
+				got = append(got, table.Carrier+" "+table.ServiceLevel)
+			}
+			if strings.Join(got, ", ") != tt.want {
+				t.Errorf("got tables %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(t *Table)
+		wantErr bool
+	}{
+		{name: "valid", change: func(t *Table) {}},
+		{name: "no carrier", change: func(t *Table) { t.Carrier = "" }, wantErr: true},
+		{name: "currency name", change: func(t *Table) { t.Currency = "euro" }, wantErr: true},
+		{name: "fuel percentage", change: func(t *Table) { t.FuelSurcharge = 12 }, wantErr: true},
+		{name: "negative dim divisor", change: func(t *Table) { t.DimDivisor = -1 }, wantErr: true},
+		{name: "no transit days", change: func(t *Table) { delete(t.TransitDays, 3) }, wantErr: true},
+		{name: "breaks out of order", change: func(t *Table) { t.Rates[0], t.Rates[1] = t.Rates[1], t.Rates[0] }, wantErr: true},
+		{name: "zone 0", change: func(t *Table) { t.Zones[0].Zone = 0 }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := dhlExpress(t)
+			tt.change(&table)
+
+			if err := table.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddBusinessDays(t *testing.T) {
+	tests := []struct {
+		name string
+		from time.Time
+		days int
+		want time.Time
+	}{
+		{"same week", wednesday, 1, time.Date(2020, 4, 2, 0, 0, 0, 0, time.UTC)},
+		{"over the weekend", wednesday, 3, time.Date(2020, 4, 6, 0, 0, 0, 0, time.UTC)},
+		{"from a saturday", time.Date(2020, 4, 4, 9, 0, 0, 0, time.UTC), 1, time.Date(2020, 4, 6, 0, 0, 0, 0, time.UTC)},
+		{"other time zone", time.Date(2020, 4, 2, 22, 0, 0, 0, time.FixedZone("PDT", -7*60*60)), 1, time.Date(2020, 4, 6, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addBusinessDays(tt.from, tt.days); !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
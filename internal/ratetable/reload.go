@@ -0,0 +1,73 @@
+package ratetable
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// Reloader keeps the carriers of a Quoter in sync with the rate tables in a directory.
+type Reloader struct {
+	dir    string
+	quoter *shipper.Quoter
+
+	mu          sync.Mutex
+	fingerprint string
+}
+
+// NewReloader creates a Reloader that loads the rate tables in the directory into
+// the carriers of the Quoter.
+func NewReloader(dir string, q *shipper.Quoter) *Reloader {
+	return &Reloader{dir: dir, quoter: q}
+}
+
+// Reload loads the rate tables when a file in the directory changed since the last
+// time they were loaded, and replaces the carriers of the Quoter with them. It returns
+// whether the carriers were replaced. When the tables are invalid, the Quoter keeps its
+// carriers and the tables are only loaded again after the next change.
+func (r *Reloader) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fp, err := Fingerprint(r.dir)
+	if err != nil {
+		return false, err
+	}
+	if fp == r.fingerprint {
+		return false, nil
+	}
+	r.fingerprint = fp
+
+	tables, err := Load(r.dir)
+	if err != nil {
+		return false, err
+	}
+
+	r.quoter.Replace(Carriers(tables)...)
+	return true, nil
+}
+
+// Watch calls Reload every interval, until ctx is done, and logs the outcome.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			switch {
+			case err != nil:
+				slog.ErrorContext(ctx, "error reloading rate tables, keeping the current rate tables", "dir", r.dir, logging.Err(err))
+			case reloaded:
+				slog.InfoContext(ctx, "reloaded rate tables", "dir", r.dir, "carriers", r.quoter.Carriers())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package ratetable
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// ground is a rate table of a single zone, with the carrier and base rate left out.
+const ground = `carrier: %s
+serviceLevel: ground
+currency: EUR
+zones:
+  - {origin: "*", destination: "*", zone: 1}
+transitDays: {1: 2}
+rates:
+  - {maxKg: 30, prices: {1: %s}}
+`
+
+// writeTable writes a ground rate table for the carrier to the directory, modified
+// at the moment at. Every write uses another moment, so changes are seen on file
+// systems with a coarse timestamp resolution.
+func writeTable(t *testing.T, dir string, carrier string, price string, at time.Time) {
+	t.Helper()
+
+	name := filepath.Join(dir, strings.ToLower(carrier)+".yaml")
+	if err := os.WriteFile(name, []byte(fmt.Sprintf(ground, carrier, price)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// prices returns the carriers and prices the Quoter quotes, like DHL:5.
+func prices(t *testing.T, q *shipper.Quoter) string {
+	t.Helper()
+
+	quotes, err := q.Quote(context.Background(), shipper.RateRequest{
+		Origin:      shipper.Location{Country: "NL"},
+		Destination: shipper.Location{Country: "NL"},
+		Package:     shipper.Package{WeightKg: 1},
+	}, wednesday)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := make([]string, 0, len(quotes))
+	for _, quote := range quotes {
+		parts = append(parts, quote.Carrier+":"+strconv.FormatFloat(quote.Price, 'f', -1, 64))
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	writeTable(t, dir, "DHL", "5", start)
+
+	q := shipper.NewQuoter()
+	r := NewReloader(dir, q)
+
+	steps := []struct {
+		name         string
+		change       func()
+		wantReloaded bool
+		wantErr      bool
+		want         string
+	}{
+		{
+			name:         "first load",
+			change:       func() {},
+			wantReloaded: true,
+			want:         "DHL:5",
+		},
+		{
+			name:   "nothing changed",
+			change: func() {},
+			want:   "DHL:5",
+		},
+		{
+			name:         "price changed",
+			change:       func() { writeTable(t, dir, "DHL", "6", start.Add(time.Minute)) },
+			wantReloaded: true,
+			want:         "DHL:6",
+		},
+		{
+			name:         "carrier added",
+			change:       func() { writeTable(t, dir, "PostNL", "4", start.Add(2*time.Minute)) },
+			wantReloaded: true,
+			want:         "PostNL:4,DHL:6",
+		},
+		{
+			name:    "invalid table keeps the carriers",
+			change:  func() { writeTable(t, dir, "DHL", "-1", start.Add(3*time.Minute)) },
+			wantErr: true,
+			want:    "PostNL:4,DHL:6",
+		},
+		{
+			name:   "invalid table is not loaded again",
+			change: func() {},
+			want:   "PostNL:4,DHL:6",
+		},
+		{
+			name: "carrier removed",
+			change: func() {
+				if err := os.Remove(filepath.Join(dir, "dhl.yaml")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantReloaded: true,
+			want:         "PostNL:4",
+		},
+	}
+
+	for _, s := range steps {
+		s.change()
+
+		reloaded, err := r.Reload()
+		if (err != nil) != s.wantErr {
+			t.Fatalf("%s: got error %v, want error %v", s.name, err, s.wantErr)
+		}
+		if reloaded != s.wantReloaded {
+			t.Errorf("%s: got reloaded %v, want %v", s.name, reloaded, s.wantReloaded)
+		}
+		if got := prices(t, q); got != s.want {
+			t.Errorf("%s: got prices %s, want %s", s.name, got, s.want)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	writeTable(t, dir, "DHL", "5", start)
+
+	q := shipper.NewQuoter()
+	r := NewReloader(dir, q)
+	if _, err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Watch(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	writeTable(t, dir, "DHL", "7.5", start.Add(time.Minute))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for prices(t, q) != "DHL:7.5" {
+		if time.Now().After(deadline) {
+			t.Fatalf("got prices %s, want DHL:7.5", prices(t, q))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
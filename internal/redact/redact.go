@@ -0,0 +1,305 @@
+// Package redact removes personally identifiable information, like the names and
+// addresses of customers, from the data the Shipment service sends to third parties
+// and writes to its logs. A Redactor applies rules to fields by name: the value of
+// a field is dropped, replaced by a keyed hash, which still allows to group events of
+// the same customer, or masked, which keeps the last characters. The Redactor plugs
+// into the hooks of the Sentry client, for breadcrumbs and captured exceptions, and
+// into the structured logger.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Action is what happens to the value of a field that matches a rule.
+type Action string
+
+const (
+	// Drop removes the field. In free text, where the field can't be removed, the
+	// value is replaced by Redacted.
+	Drop Action = "drop"
+
+	// Hash replaces the value by a keyed hash of the value.
+	Hash Action = "hash"
+
+	// Mask replaces all but the last characters of the value by asterisks.
+	Mask Action = "mask"
+)
+
+const (
+	// Redacted replaces the value of a dropped field in free text.
+	Redacted = "[redacted]"
+
+	// maskKeep is the number of characters a masked value keeps.
+	maskKeep = 4
+)
+
+// Rule applies an action to all fields with a name.
+type Rule struct {
+	// Field is the name of the field. Names match regardless of case, underscores
+	// and dashes, so postalCode matches postal_code and Postal-Code.
+	Field string
+
+	// Action is what happens to the value of the field.
+	Action Action
+}
+
+// DefaultRules are the rules for the fields with customer data that shipment requests
+// and events can carry. Addresses are dropped, names and email addresses are hashed so
+// events of the same customer can still be grouped, and phone numbers are masked.
+var DefaultRules = []Rule{
+	{Field: "address", Action: Drop},
+	{Field: "address1", Action: Drop},
+	{Field: "address2", Action: Drop},
+	{Field: "street", Action: Drop},
+	{Field: "street1", Action: Drop},
+	{Field: "street2", Action: Drop},
+	{Field: "line1", Action: Drop},
+	{Field: "line2", Action: Drop},
+	{Field: "city", Action: Drop},
+	{Field: "state", Action: Drop},
+	{Field: "province", Action: Drop},
+	{Field: "zip", Action: Drop},
+	{Field: "zipCode", Action: Drop},
+	{Field: "postalCode", Action: Drop},
+	{Field: "postcode", Action: Drop},
+	{Field: "name", Action: Hash},
+	{Field: "firstName", Action: Hash},
+	{Field: "lastName", Action: Hash},
+	{Field: "fullName", Action: Hash},
+	{Field: "email", Action: Hash},
+	{Field: "phone", Action: Mask},
+	{Field: "phoneNumber", Action: Mask},
+}
+
+// ParseRules parses rules written as a comma separated list of field:action pairs,
+// like "address:drop,email:hash,phone:mask".
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid redaction rule %q, expected field:action", pair)
+		}
+
+		action := Action(strings.ToLower(strings.TrimSpace(parts[1])))
+		switch action {
+		case Drop, Hash, Mask:
+		default:
+			return nil, fmt.Errorf("invalid action %q in redaction rule %q, use drop, hash or mask", parts[1], pair)
+		}
+
+		rules = append(rules, Rule{Field: strings.TrimSpace(parts[0]), Action: action})
+	}
+
+	return rules, nil
+}
+
+// jsonField matches a field with a scalar value in JSON, like "city":"Springfield".
+var jsonField = regexp.MustCompile(`"([^"\\]+)"\s*:\s*("(?:[^"\\]|\\.)*"|-?[0-9][0-9.eE+-]*|true|false)`)
+
+// Redactor applies rules to maps, free text, Sentry events and log records.
+type Redactor struct {
+	rules map[string]Action
+	key   []byte
+}
+
+// New creates a Redactor with the rules. Later rules for the same field replace
+// earlier ones, so custom rules can be appended to DefaultRules. The key is used
+// for the hashes, so values can't be recovered by hashing guesses without it.
+func New(rules []Rule, key string) *Redactor {
+	r := &Redactor{
+		rules: make(map[string]Action, len(rules)),
+		key:   []byte(key),
+	}
+
+	for _, rule := range rules {
+		r.rules[normalize(rule.Field)] = rule.Action
+	}
+
+	return r
+}
+
+// action returns the action for the field and whether a rule matches.
+func (r *Redactor) action(field string) (Action, bool) {
+	if r == nil {
+		return "", false
+	}
+	a, ok := r.rules[normalize(field)]
+	return a, ok
+}
+
+// Value returns the value of a field after applying the action.
+func (r *Redactor) Value(a Action, value string) string {
+	switch a {
+	case Hash:
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write([]byte(value))
+		return "hash:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	case Mask:
+		runes := []rune(value)
+		keep := 0
+		if len(runes) > 2*maskKeep {
+			keep = maskKeep
+		}
+		return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+	default:
+		return Redacted
+	}
+}
+
+// Map returns a copy of the map with the rules applied, including to nested maps
+// and slices and to JSON in string values. Fields that are dropped are removed.
+func (r *Redactor) Map(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		a, ok := r.action(k)
+		switch {
+		case !ok:
+			out[k] = r.any(v)
+		case a == Drop:
+		default:
+			out[k] = r.Value(a, fmt.Sprint(v))
+		}
+	}
+
+	return out
+}
+
+// any applies the rules to a value that is not a field itself.
+func (r *Redactor) any(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return r.Map(t)
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i := range t {
+			out[i] = r.any(t[i])
+		}
+		return out
+	case string:
+		return r.String(t)
+	default:
+		return v
+	}
+}
+
+// String applies the rules to the fields of the JSON in free text, like a payload
+// in an error message. The text is kept as is, but the values of matching fields
+// are replaced.
+func (r *Redactor) String(s string) string {
+	if r == nil || len(r.rules) == 0 || !strings.Contains(s, `"`) {
+		return s
+	}
+
+	return jsonField.ReplaceAllStringFunc(s, func(match string) string {
+		parts := jsonField.FindStringSubmatch(match)
+		a, ok := r.action(parts[1])
+		if !ok {
+			return match
+		}
+
+		value := parts[2]
+		if strings.HasPrefix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		return fmt.Sprintf("%q:%q", parts[1], r.Value(a, value))
+	})
+}
+
+// BeforeBreadcrumb applies the rules to the data and message of a Sentry breadcrumb,
+// for the BeforeBreadcrumb option of the Sentry client.
+func (r *Redactor) BeforeBreadcrumb(b *sentry.Breadcrumb, _ *sentry.BreadcrumbHint) *sentry.Breadcrumb {
+	b.Data = r.Map(b.Data)
+	b.Message = r.String(b.Message)
+	return b
+}
+
+// BeforeSend applies the rules to an event, like a captured exception, before it is
+// sent to Sentry, for the BeforeSend option of the Sentry client.
+func (r *Redactor) BeforeSend(e *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+	e.Message = r.String(e.Message)
+	e.Extra = r.Map(e.Extra)
+	e.Contexts = r.Map(e.Contexts)
+
+	for i := range e.Exception {
+		e.Exception[i].Value = r.String(e.Exception[i].Value)
+	}
+
+	// The breadcrumbs are shared with the scope and with other events, so they are
+	// replaced by redacted copies instead of being changed
+	breadcrumbs := make([]*sentry.Breadcrumb, len(e.Breadcrumbs))
+	for i, b := range e.Breadcrumbs {
+		c := *b
+		breadcrumbs[i] = r.BeforeBreadcrumb(&c, nil)
+	}
+	e.Breadcrumbs = breadcrumbs
+
+	for k, v := range e.Tags {
+		if a, ok := r.action(k); ok {
+			if a == Drop {
+				delete(e.Tags, k)
+				continue
+			}
+			e.Tags[k] = r.Value(a, v)
+		}
+	}
+
+	if e.User.Email != "" {
+		if a, ok := r.action("email"); ok {
+			e.User.Email = r.Value(a, e.User.Email)
+		}
+	}
+
+	if e.Request != nil {
+		e.Request.Data = r.String(e.Request.Data)
+		e.Request.QueryString = r.String(e.Request.QueryString)
+	}
+
+	return e
+}
+
+// ReplaceAttr applies the rules to an attribute of a log record, for the ReplaceAttr
+// option of a slog handler.
+func (r *Redactor) ReplaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if action, ok := r.action(a.Key); ok {
+		if action == Drop {
+			return slog.Attr{}
+		}
+		return slog.String(a.Key, r.Value(action, a.Value.String()))
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.String(a.Value.String()))
+	case slog.KindAny:
+		if m, ok := a.Value.Any().(map[string]interface{}); ok {
+			return slog.Any(a.Key, r.Map(m))
+		}
+	}
+
+	return a
+}
+
+// normalize returns the name of a field in lower case without underscores and dashes.
+func normalize(field string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(field))
+}
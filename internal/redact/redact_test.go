@@ -0,0 +1,192 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// customer is a shipment request with the customer data that must not escape.
+const customer = `{"_id":"order-1","delivery":"UPS","name":"Jane Doe","email":"jane@example.com","phone":"+1-555-123-4567",` +
+	`"address":{"street":"1 Main St","city":"Springfield","zipCode":"12345"},"street_2":"Apt 4","postal-code":"12345"}`
+
+// secrets are the values of customer that must not appear in any output.
+var secrets = []string{"Jane Doe", "jane@example.com", "555-123", "1 Main St", "Springfield", "12345", "Apt 4"}
+
+func newRedactor(t *testing.T) *Redactor {
+	t.Helper()
+	return New(DefaultRules, "test-key")
+}
+
+func assertNoSecrets(t *testing.T, where string, out string) {
+	t.Helper()
+	for _, s := range secrets {
+		if strings.Contains(out, s) {
+			t.Errorf("%s contains %q: %s", where, s, out)
+		}
+	}
+}
+
+func customerMap(t *testing.T) map[string]interface{} {
+	t.Helper()
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(customer), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMap(t *testing.T) {
+	r := newRedactor(t)
+
+	out := r.Map(customerMap(t))
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoSecrets(t, "map", string(b))
+
+	tests := []struct {
+		field string
+		want  func(v interface{}, ok bool) bool
+	}{
+		{"_id", func(v interface{}, ok bool) bool { return v == "order-1" }},
+		{"delivery", func(v interface{}, ok bool) bool { return v == "UPS" }},
+		{"address", func(v interface{}, ok bool) bool { return !ok }},
+		{"street_2", func(v interface{}, ok bool) bool { return !ok }},
+		{"postal-code", func(v interface{}, ok bool) bool { return !ok }},
+		{"name", func(v interface{}, ok bool) bool { return strings.HasPrefix(v.(string), "hash:") }},
+		{"email", func(v interface{}, ok bool) bool { return strings.HasPrefix(v.(string), "hash:") }},
+		{"phone", func(v interface{}, ok bool) bool { return v == "***********4567" }},
+	}
+
+	for _, tt := range tests {
+		v, ok := out[tt.field]
+		if !tt.want(v, ok) {
+			t.Errorf("field %s: got %v (present %t)", tt.field, v, ok)
+		}
+	}
+}
+
+func TestHashIsStable(t *testing.T) {
+	r := newRedactor(t)
+
+	if r.Value(Hash, "Jane Doe") != r.Value(Hash, "Jane Doe") {
+		t.Error("hash of the same value differs")
+	}
+	if r.Value(Hash, "Jane Doe") == New(DefaultRules, "other-key").Value(Hash, "Jane Doe") {
+		t.Error("hash doesn't depend on the key")
+	}
+}
+
+func TestString(t *testing.T) {
+	r := newRedactor(t)
+
+	out := r.String("error shipping " + customer)
+
+	assertNoSecrets(t, "string", out)
+	if !strings.Contains(out, `"delivery":"UPS"`) {
+		t.Errorf("string lost fields without a rule: %s", out)
+	}
+}
+
+func TestBeforeBreadcrumb(t *testing.T) {
+	r := newRedactor(t)
+
+	b := r.BeforeBreadcrumb(&sentry.Breadcrumb{
+		Category: "ShipmentRequested",
+		Message:  customer,
+		Data:     customerMap(t),
+	}, nil)
+
+	out, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoSecrets(t, "breadcrumb", string(out))
+}
+
+func TestBeforeSend(t *testing.T) {
+	r := newRedactor(t)
+
+	event := sentry.NewEvent()
+	event.Message = "error in SendShipment::Save " + customer
+	event.Exception = []sentry.Exception{{Type: "error", Value: errors.New("invalid request " + customer).Error()}}
+	event.Extra = customerMap(t)
+	event.Breadcrumbs = []*sentry.Breadcrumb{{Data: customerMap(t)}}
+	event.Tags = map[string]string{"email": "jane@example.com", "city": "Springfield"}
+	event.User = sentry.User{Email: "jane@example.com"}
+	event.Request = &sentry.Request{Data: customer}
+
+	out, err := json.Marshal(r.BeforeSend(event, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoSecrets(t, "event", string(out))
+}
+
+func TestReplaceAttr(t *testing.T) {
+	r := newRedactor(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{ReplaceAttr: r.ReplaceAttr}))
+
+	logger.Info("shipment requested",
+		"payload", customer,
+		"name", "Jane Doe",
+		"phone", "+1-555-123-4567",
+		"request", customerMap(t),
+		slog.Group("address", "street", "1 Main St", "city", "Springfield", "zip", "12345"),
+	)
+
+	assertNoSecrets(t, "log record", buf.String())
+	if !strings.Contains(buf.String(), `"msg":"shipment requested"`) {
+		t.Errorf("log record lost the message: %s", buf.String())
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []Rule
+		wantErr bool
+	}{
+		{spec: "", want: nil},
+		{spec: "address:drop, email:HASH,phone:mask", want: []Rule{{"address", Drop}, {"email", Hash}, {"phone", Mask}}},
+		{spec: "address", wantErr: true},
+		{spec: ":drop", wantErr: true},
+		{spec: "address:delete", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRules(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRules(%q) error = %v, want error %t", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseRules(%q) = %v, want %v", tt.spec, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParseRules(%q)[%d] = %v, want %v", tt.spec, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestLaterRulesReplaceDefaults(t *testing.T) {
+	r := New(append(append([]Rule{}, DefaultRules...), Rule{Field: "city", Action: Mask}), "")
+
+	out := r.Map(map[string]interface{}{"city": "Springfield"})
+	if out["city"] != "*******ield" {
+		t.Errorf("city = %v, want it masked", out["city"])
+	}
+}
@@ -0,0 +1,257 @@
+// Package setup creates the EventEmitter and Store that are selected in the
+// configuration, so every binary that lets the user choose the messaging and
+// storage layer builds them the same way. It also creates the Redactor, the
+// Quoter, the address Provider, the carrier Adapter, the secrets of the carrier
+// webhooks, the carrier Trackers and the warehouses, which every binary configures
+// the same way, and configures the logger, the exporter of the spans, Sentry and
+// the shipment workflow of every binary.
+package setup
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/address/rules"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/pull"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/simulated"
+	carrierwebhook "github.com/retgits/acme-serverless-shipment/internal/carrier/webhook"
+	"github.com/retgits/acme-serverless-shipment/internal/config"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/eventbridge"
+	fileemitter "github.com/retgits/acme-serverless-shipment/internal/emitter/file"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/mock"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/sqs"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/webhook"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/metrics"
+	"github.com/retgits/acme-serverless-shipment/internal/ratetable"
+	"github.com/retgits/acme-serverless-shipment/internal/redact"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/store/dynamodb"
+	"github.com/retgits/acme-serverless-shipment/internal/store/file"
+	"github.com/retgits/acme-serverless-shipment/internal/store/memory"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+	"github.com/retgits/acme-serverless-shipment/internal/workflow"
+)
+
+// emitterRequires lists, for every EventEmitter, the configuration values it needs.
+var emitterRequires = map[string][]string{
+	"webhook":     {"ORDER_URL"},
+	"sqs":         {"REGION", "RESPONSEQUEUE"},
+	"eventbridge": {"REGION", "EVENTBUS"},
+	"mock":        {},
+	"file":        {"EMITTER_PATH"},
+}
+
+// EmitterRequires returns the names of the configuration values the EventEmitter
+// with the name needs, or an error if there is no EventEmitter with that name.
+func EmitterRequires(name string) ([]string, error) {
+	req, ok := emitterRequires[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown emitter %q, use one of %s", name, strings.Join(emitterNames(), ", "))
+	}
+	return req, nil
+}
+
+// NewEmitter creates the EventEmitter selected by cfg.Emitter. The client is used by
+// EventEmitters that send events over HTTP.
+func NewEmitter(cfg *config.Config, client *http.Client) (emitter.EventEmitter, error) {
+	switch cfg.Emitter {
+	case "webhook":
+		return webhook.New(cfg.OrderURL, cfg.OrderHost, client)
+	case "sqs":
+		return sqs.New(cfg.Region, cfg.ResponseQueue)
+	case "eventbridge":
+		return eventbridge.New(cfg.Region, cfg.EventBus)
+	case "mock":
+		return mock.New(), nil
+	case "file":
+		return fileemitter.New(cfg.EmitterPath)
+	default:
+		_, err := EmitterRequires(cfg.Emitter)
+		return nil, err
+	}
+}
+
+// NewStore creates the Store selected by cfg.Store.
+func NewStore(cfg *config.Config) (store.Store, error) {
+	switch cfg.Store {
+	case "memory":
+		return memory.New(), nil
+	case "file":
+		return file.New(cfg.StorePath)
+	case "dynamodb":
+		return dynamodb.New(cfg.Region, cfg.StoreTable)
+	default:
+		return nil, fmt.Errorf("unknown store %q, use memory, file or dynamodb", cfg.Store)
+	}
+}
+
+// emitterNames returns the sorted names of all EventEmitters.
+func emitterNames() []string {
+	names := make([]string, 0, len(emitterRequires))
+	for name := range emitterRequires {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewQuoter creates a Quoter with a simulated carrier for every carrier in the rate
+// tables in the directory cfg.RateTables, or in the built-in rate tables when it isn't
+// set. The Reloader, which is nil for the built-in rate tables, loads the rate tables
+// again after they changed.
+func NewQuoter(cfg *config.Config) (*shipper.Quoter, *ratetable.Reloader, error) {
+	q := shipper.NewQuoter()
+
+	if cfg.RateTables == "" {
+		tables, err := ratetable.Default()
+		if err != nil {
+			return nil, nil, err
+		}
+		q.Replace(ratetable.Carriers(tables)...)
+		return q, nil, nil
+	}
+
+	r := ratetable.NewReloader(cfg.RateTables, q)
+	if _, err := r.Reload(); err != nil {
+		return nil, nil, err
+	}
+	return q, r, nil
+}
+
+// NewAddressProvider creates the address Provider with the name in cfg.AddressProvider.
+func NewAddressProvider(cfg *config.Config) (address.Provider, error) {
+	switch cfg.AddressProvider {
+	case "rules":
+		return rules.New(), nil
+	case "none":
+		return address.Nop{}, nil
+	default:
+		return nil, fmt.Errorf("unknown address provider %q, use rules or none", cfg.AddressProvider)
+	}
+}
+
+// NewCarrierAdapter creates the carrier Adapter with the name in cfg.CarrierAdapter.
+func NewCarrierAdapter(cfg *config.Config) (carrier.Adapter, error) {
+	switch cfg.CarrierAdapter {
+	case "simulated":
+		return simulated.New(), nil
+	case "none":
+		return carrier.Nop{}, nil
+	default:
+		return nil, fmt.Errorf("unknown carrier adapter %q, use simulated or none", cfg.CarrierAdapter)
+	}
+}
+
+// NewWebhookSecrets parses the secrets of the tracking webhooks of the carriers in
+// cfg.CarrierWebhookSecrets.
+func NewWebhookSecrets(cfg *config.Config) (map[string]string, error) {
+	return carrierwebhook.ParseSecrets(cfg.CarrierWebhookSecrets)
+}
+
+// NewTrackers creates the carrier Trackers of the tracking APIs in cfg.CarrierTrackingURLs.
+// The client determines the timeout of each call to a tracking API.
+func NewTrackers(cfg *config.Config, client *http.Client) ([]carrier.Tracker, error) {
+	return pull.NewTrackers(cfg.CarrierTrackingURLs, client)
+}
+
+// NewWarehouses loads the warehouses in the file cfg.Warehouses, or returns the built-in
+// warehouses when it's empty.
+func NewWarehouses(cfg *config.Config) ([]warehouse.Warehouse, error) {
+	if cfg.Warehouses == "" {
+		return warehouse.Default()
+	}
+	return warehouse.Load(cfg.Warehouses)
+}
+
+// NewRedactor creates the Redactor with the default rules and the rules in
+// cfg.RedactRules, which replace the default rule for the same field.
+func NewRedactor(cfg *config.Config) (*redact.Redactor, error) {
+	rules, err := redact.ParseRules(cfg.RedactRules)
+	if err != nil {
+		return nil, err
+	}
+
+	return redact.New(append(append([]redact.Rule{}, redact.DefaultRules...), rules...), cfg.RedactKey), nil
+}
+
+// NewLogger creates the Redactor of cfg and configures the logger with the level and
+// format of cfg, so no log record contains the personally identifiable information of
+// customers.
+func NewLogger(cfg *config.Config) (*redact.Redactor, error) {
+	red, err := NewRedactor(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := logging.Init(logging.Options{Level: cfg.LogLevel, Format: cfg.LogFormat, Redactor: red}); err != nil {
+		return nil, err
+	}
+
+	return red, nil
+}
+
+// NewTracing configures the exporter of the spans of the service with the name.
+func NewTracing(cfg *config.Config, service string) (*tracing.Provider, error) {
+	return tracing.Init(tracing.Options{
+		Exporter: cfg.TracingExporter,
+		Endpoint: cfg.OTLPEndpoint,
+		Service:  service,
+		Version:  cfg.Version,
+	})
+}
+
+// InitSentry initializes the connection to Sentry, which captures the errors and traces
+// of the service with the name. The Redactor removes the personally identifiable
+// information before it leaves the service.
+func InitSentry(cfg *config.Config, service string, red *redact.Redactor) error {
+	return sentry.Init(sentry.ClientOptions{
+		Dsn: cfg.SentryDSN,
+		Transport: &sentry.HTTPSyncTransport{
+			Timeout: time.Second * 3,
+		},
+		ServerName:  service,
+		Release:     cfg.Version,
+		Environment: cfg.Stage,
+
+		BeforeSend:       red.BeforeSend,
+		BeforeBreadcrumb: red.BeforeBreadcrumb,
+	})
+}
+
+// NewWorkflow creates the shipment workflow that sends events with the EventEmitter,
+// with the name emitterName in the metrics, and records metrics with the Recorder. It
+// validates addresses with the Provider of cfg, ships orders from the warehouses of cfg
+// and talks to the carriers with the Adapter of cfg.
+func NewWorkflow(cfg *config.Config, em emitter.EventEmitter, emitterName string, rec metrics.Recorder) (*workflow.Workflow, error) {
+	wf := workflow.New(em, emitterName, rec)
+
+	addresses, err := NewAddressProvider(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring address provider: %s", err.Error())
+	}
+	wf.SetAddressProvider(addresses, cfg.AddressRequired)
+
+	warehouses, err := NewWarehouses(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error loading warehouses: %s", err.Error())
+	}
+	wf.SetWarehouses(warehouses)
+
+	carriers, err := NewCarrierAdapter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring carrier adapter: %s", err.Error())
+	}
+	wf.SetCarrierAdapter(carriers)
+
+	return wf, nil
+}
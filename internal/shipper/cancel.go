@@ -0,0 +1,87 @@
+package shipper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrPickedUp is returned when a shipment is cancelled after the carrier picked it up.
+	ErrPickedUp = errors.New("the shipment has been picked up by the carrier")
+
+	// ErrCancelled is returned when a shipment is cancelled that already is.
+	ErrCancelled = errors.New("the shipment has already been cancelled")
+)
+
+// ValidateCancellation checks that the cancellation of the shipments of an order has the
+// order and that its fields are printable text of a limited length. The tracking number,
+// to cancel a single shipment of the order, and the reason are optional.
+func ValidateCancellation(orderID string, trackingNumber string, reason string) error {
+	if strings.TrimSpace(orderID) == "" {
+		return fmt.Errorf("_id is required")
+	}
+
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"_id", orderID},
+		{"trackingNumber", trackingNumber},
+		{"reason", reason},
+	}
+	for _, f := range fields {
+		if err := validateText(f.name, f.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Done returns whether nothing happens to the shipment anymore, because it has been
+// delivered or cancelled, or because it is a return that has been received.
+func Done(s Shipment) bool {
+	return s.Status == StatusDelivered || s.Status == StatusCancelled || s.Status == StatusReturnReceived
+}
+
+// Cancellable returns ErrCancelled when the shipment is cancelled and ErrPickedUp when the
+// carrier picked it up at the moment, or nil when it can still be cancelled. A shipment
+// without a pickup moment, like one that was stored before shipments had one, can't be
+// cancelled.
+func Cancellable(s Shipment, now time.Time) error {
+	switch {
+	case s.Status == StatusCancelled:
+		return ErrCancelled
+	case s.Status != StatusShipped || s.PickupAt.IsZero() || !now.Before(s.PickupAt):
+		return ErrPickedUp
+	}
+	return nil
+}
+
+// CancelAt cancels the shipment, and its parcels, at the moment for the reason, which may
+// be empty. It returns the error of Cancellable when the shipment can't be cancelled.
+func CancelAt(s Shipment, reason string, now time.Time) (Shipment, error) {
+	if err := Cancellable(s, now); err != nil {
+		return s, err
+	}
+
+	if len(s.Parcels) > 0 {
+		parcels := append([]Parcel(nil), s.Parcels...)
+		for i := range parcels {
+			parcels[i].Status = StatusCancelled
+		}
+		s.Parcels = parcels
+	}
+
+	s.Status = StatusCancelled
+	s.CancelReason = reason
+	return s, nil
+}
+
+// pickupAt returns the moment the carrier picks up a shipment that was created at the
+// moment and is delivered at deliverAt, which is halfway in between.
+func pickupAt(created time.Time, deliverAt time.Time) time.Time {
+	return created.Add(deliverAt.Sub(created) / 2)
+}
@@ -0,0 +1,63 @@
+package shipper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCancelAt(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	shipment := func(status string, pickupIn time.Duration) Shipment {
+		s := Shipment{PickupAt: now.Add(pickupIn), DeliverAt: now.Add(2 * pickupIn)}
+		s.Status = status
+		s.Parcels = []Parcel{{TrackingNumber: "1", Status: status}, {TrackingNumber: "2", Status: status}}
+		return s
+	}
+
+	tests := []struct {
+		name     string
+		shipment Shipment
+		wantErr  error
+	}{
+		{name: "before pickup", shipment: shipment(StatusShipped, time.Minute)},
+		{name: "at pickup", shipment: shipment(StatusShipped, 0), wantErr: ErrPickedUp},
+		{name: "after pickup", shipment: shipment(StatusShipped, -time.Minute), wantErr: ErrPickedUp},
+		{name: "delivered", shipment: shipment(StatusDelivered, time.Minute), wantErr: ErrPickedUp},
+		{name: "cancelled", shipment: shipment(StatusCancelled, time.Minute), wantErr: ErrCancelled},
+		{name: "without pickup", shipment: Shipment{}, wantErr: ErrPickedUp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CancelAt(tt.shipment, "order cancelled", now)
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if got.Status != tt.shipment.Status {
+					t.Errorf("got status %q, want it unchanged %q", got.Status, tt.shipment.Status)
+				}
+				return
+			}
+
+			if got.Status != StatusCancelled || got.CancelReason != "order cancelled" {
+				t.Errorf("got status %q and reason %q, want %q with the reason", got.Status, got.CancelReason, StatusCancelled)
+			}
+			for _, p := range got.Parcels {
+				if p.Status != StatusCancelled {
+					t.Errorf("got status %q for parcel %s, want %q", p.Status, p.TrackingNumber, StatusCancelled)
+				}
+			}
+			if tt.shipment.Parcels[0].Status == StatusCancelled {
+				t.Errorf("the parcels of the original shipment were cancelled too")
+			}
+			if !Done(got) {
+				t.Errorf("a cancelled shipment isn't done")
+			}
+
+			if s, parcels := DeliverDue(got, now.Add(time.Hour)); s.Status != StatusCancelled || len(parcels) != 0 {
+				t.Errorf("got status %q and parcels %+v after the delivery, want a cancelled shipment", s.Status, parcels)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package shipper
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxItems is the maximum number of line items of a shipment request.
+	MaxItems = 100
+)
+
+// LineItem is an item of the order that is shipped, with the warehouse it is shipped
+// from when the order service assigned it.
+type LineItem struct {
+	// ID is the ID of the item, like in the order.
+	ID string `json:"id"`
+
+	// Name is the name of the item.
+	Name string `json:"name,omitempty"`
+
+	// Quantity is how many of the item are shipped.
+	Quantity int64 `json:"quantity"`
+
+	// Warehouse is the ID of the warehouse the item is shipped from, or empty to let
+	// the shipper pick one based on the stock of the warehouses.
+	Warehouse string `json:"warehouse,omitempty"`
+}
+
+// ValidateItems checks that there are at most MaxItems line items, that every item has
+// an ID and a quantity and that their fields are printable text of a limited length.
+func ValidateItems(items []LineItem) error {
+	if len(items) > MaxItems {
+		return fmt.Errorf("a shipment has at most %d items", MaxItems)
+	}
+
+	for i, item := range items {
+		name := fmt.Sprintf("items[%d]", i)
+		if strings.TrimSpace(item.ID) == "" {
+			return fmt.Errorf("%s.id is required", name)
+		}
+		if item.Quantity < 1 {
+			return fmt.Errorf("%s.quantity must be 1 or more", name)
+		}
+
+		fields := []struct {
+			name  string
+			value string
+		}{
+			{"id", item.ID},
+			{"name", item.Name},
+			{"warehouse", item.Warehouse},
+		}
+		for _, f := range fields {
+			if err := validateText(name+"."+f.name, f.value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// NextDue returns the index of the shipment that isn't done yet with the earliest
+// DeliverAt, like the next part of an order that is shipped from more than one warehouse,
+// or -1 when all shipments are done.
+func NextDue(shipments []Shipment) int {
+	next := -1
+	for i, s := range shipments {
+		if Done(s) {
+			continue
+		}
+		if next == -1 || s.DeliverAt.Before(shipments[next].DeliverAt) {
+			next = i
+		}
+	}
+	return next
+}
@@ -0,0 +1,66 @@
+package shipper
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateItems(t *testing.T) {
+	tests := []struct {
+		name    string
+		items   []LineItem
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "valid", items: []LineItem{{ID: "rack-1", Name: "Squat rack", Quantity: 1}, {ID: "mat-1", Quantity: 2, Warehouse: "sfo"}}},
+		{name: "too many", items: make([]LineItem, MaxItems+1), wantErr: "at most 100 items"},
+		{name: "no id", items: []LineItem{{Quantity: 1}}, wantErr: "items[0].id is required"},
+		{name: "no quantity", items: []LineItem{{ID: "mat-1"}}, wantErr: "items[0].quantity must be 1 or more"},
+		{name: "long name", items: []LineItem{{ID: "mat-1", Name: strings.Repeat("a", MaxFieldLength+1), Quantity: 1}}, wantErr: "items[0].name is longer"},
+		{name: "control characters", items: []LineItem{{ID: "mat-1", Quantity: 1, Warehouse: "sfo\n"}}, wantErr: "items[0].warehouse contains control characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateItems(tt.items)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %q, want none", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNextDue(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	shipment := func(status string, in time.Duration) Shipment {
+		s := Shipment{DeliverAt: now.Add(in)}
+		s.Status = status
+		return s
+	}
+
+	tests := []struct {
+		name      string
+		shipments []Shipment
+		want      int
+	}{
+		{name: "none", want: -1},
+		{name: "single", shipments: []Shipment{shipment(StatusShipped, time.Hour)}, want: 0},
+		{name: "earliest", shipments: []Shipment{shipment(StatusShipped, 2*time.Hour), shipment(StatusPartiallyDelivered, time.Hour)}, want: 1},
+		{name: "skips delivered", shipments: []Shipment{shipment(StatusDelivered, 0), shipment(StatusShipped, time.Hour)}, want: 1},
+		{name: "all delivered", shipments: []Shipment{shipment(StatusDelivered, 0), shipment(StatusDelivered, time.Hour)}, want: -1},
+		{name: "skips cancelled", shipments: []Shipment{shipment(StatusCancelled, 0), shipment(StatusShipped, time.Hour)}, want: 1},
+	}
+
+	for _, tt := range tests {
+		if got := NextDue(tt.shipments); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
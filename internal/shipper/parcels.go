@@ -0,0 +1,166 @@
+package shipper
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+)
+
+const (
+	// MaxParcels is the maximum number of parcels of a shipment.
+	MaxParcels = 20
+)
+
+// ParcelRequest is a box that is shipped as part of a shipment.
+type ParcelRequest struct {
+	// Package is the weight and dimensions of the parcel.
+	Package
+
+	// Contents describe what is in the parcel, like the items of the order.
+	Contents []string `json:"contents,omitempty"`
+
+	// Warehouse is the ID of the warehouse the parcel is shipped from, which is needed
+	// when the order is shipped from more than one warehouse.
+	Warehouse string `json:"warehouse,omitempty"`
+}
+
+// Parcel is a box of a shipment, with its own tracking number and status.
+type Parcel struct {
+	ParcelRequest
+
+	// TrackingNumber is the tracking number of the parcel.
+	TrackingNumber string `json:"trackingNumber"`
+
+	// Status is the status of the parcel, either StatusShipped or StatusDelivered.
+	Status string `json:"status"`
+
+	// DeliverAt is the moment the parcel will be delivered to the customer.
+	DeliverAt time.Time `json:"deliverAt"`
+}
+
+// ValidateParcels checks that there are at most MaxParcels parcels, that every parcel
+// has a weight and that their contents and warehouse are printable text of a limited
+// length.
+func ValidateParcels(parcels []ParcelRequest) error {
+	if len(parcels) > MaxParcels {
+		return fmt.Errorf("a shipment has at most %d parcels", MaxParcels)
+	}
+
+	for i, p := range parcels {
+		name := fmt.Sprintf("parcels[%d]", i)
+		if err := validatePackage(name, p.Package); err != nil {
+			return err
+		}
+
+		for j, c := range p.Contents {
+			if err := validateText(fmt.Sprintf("%s.contents[%d]", name, j), c); err != nil {
+				return err
+			}
+		}
+
+		if err := validateText(name+".warehouse", p.Warehouse); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ShipParcelsAt is like ShipAt, but ships the parcels, each with its own tracking number
+// and simulated delivery. The first parcel has the tracking number of the shipment. A
+// shipment without parcels is shipped like ShipAt does.
+func ShipParcelsAt(ctx context.Context, r acmeserverless.ShipmentRequest, parcels []ParcelRequest, now time.Time) Shipment {
+	s := ShipAt(ctx, r, now)
+	if len(parcels) == 0 {
+		return s
+	}
+
+	s.Parcels = make([]Parcel, len(parcels))
+	for i, p := range parcels {
+		trackingNumber := s.TrackingNumber
+		if i > 0 {
+			trackingNumber = NewTrackingNumber(r.Delivery)
+		}
+
+		s.Parcels[i] = Parcel{
+			ParcelRequest:  p,
+			TrackingNumber: trackingNumber,
+			Status:         StatusShipped,
+			DeliverAt:      s.CreatedAt.Add(DeliveryTime()),
+		}
+	}
+	s.DeliverAt = nextDelivery(s.Parcels)
+	s.PickupAt = pickupAt(s.CreatedAt, s.DeliverAt)
+
+	return s
+}
+
+// DeliverDue delivers the parcels of the shipment that are due at the moment, and returns
+// the shipment with the status derived from its parcels together with the parcels that
+// were delivered. The delivery of a shipment is due at DeliverAt, so the parcels due then
+// are delivered even when the moment is earlier. A shipment without parcels is delivered
+// as a whole, and a cancelled shipment or a return leg isn't delivered at all.
+func DeliverDue(s Shipment, at time.Time) (Shipment, []Parcel) {
+	if s.Status == StatusCancelled || s.Return != nil {
+		return s, nil
+	}
+
+	if s.DeliverAt.After(at) {
+		at = s.DeliverAt
+	}
+
+	if len(s.Parcels) == 0 {
+		s.Status = StatusDelivered
+		return s, nil
+	}
+
+	parcels := append([]Parcel(nil), s.Parcels...)
+	var delivered []Parcel
+	for i, p := range parcels {
+		if p.Status != StatusDelivered && !p.DeliverAt.After(at) {
+			parcels[i].Status = StatusDelivered
+			delivered = append(delivered, parcels[i])
+		}
+	}
+
+	s.Parcels = parcels
+	s.Status = ParcelStatus(parcels)
+	if next := nextDelivery(parcels); !next.IsZero() {
+		s.DeliverAt = next
+	}
+	return s, delivered
+}
+
+// ParcelStatus returns the status of a shipment with the parcels: delivered when all
+// parcels are delivered, partially delivered when some are, and shipped otherwise.
+func ParcelStatus(parcels []Parcel) string {
+	delivered := 0
+	for _, p := range parcels {
+		if p.Status == StatusDelivered {
+			delivered++
+		}
+	}
+
+	switch {
+	case delivered == 0:
+		return StatusShipped
+	case delivered < len(parcels):
+		return StatusPartiallyDelivered
+	default:
+		return StatusDelivered
+	}
+}
+
+// nextDelivery returns the moment the next parcel that isn't delivered yet will be
+// delivered, or the zero time when all parcels are delivered.
+func nextDelivery(parcels []Parcel) time.Time {
+	var next time.Time
+	for _, p := range parcels {
+		if p.Status != StatusDelivered && (next.IsZero() || p.DeliverAt.Before(next)) {
+			next = p.DeliverAt
+		}
+	}
+	return next
+}
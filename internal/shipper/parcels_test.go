@@ -0,0 +1,120 @@
+package shipper
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+)
+
+func TestValidateParcels(t *testing.T) {
+	box := Package{WeightKg: 1, LengthCm: 30, WidthCm: 20, HeightCm: 10}
+
+	tests := []struct {
+		name    string
+		parcels []ParcelRequest
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "valid", parcels: []ParcelRequest{{Package: box, Contents: []string{"Yoga mat"}}, {Package: box}}},
+		{name: "too many", parcels: make([]ParcelRequest, MaxParcels+1), wantErr: "at most 20 parcels"},
+		{name: "no weight", parcels: []ParcelRequest{{Package: box}, {Package: Package{LengthCm: 1}}}, wantErr: "parcels[1]"},
+		{name: "long contents", parcels: []ParcelRequest{{Package: box, Contents: []string{strings.Repeat("a", MaxFieldLength+1)}}}, wantErr: "parcels[0].contents[0] is longer"},
+		{name: "control characters", parcels: []ParcelRequest{{Package: box, Contents: []string{"mat\n"}}}, wantErr: "control characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateParcels(tt.parcels)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %q, want none", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShipParcelsAt(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	req := acmeserverless.ShipmentRequest{OrderID: "order-1", Delivery: "UPS"}
+	parcels := []ParcelRequest{{Package: Package{WeightKg: 1}}, {Package: Package{WeightKg: 2}}, {Package: Package{WeightKg: 3}}}
+
+	got := ShipParcelsAt(context.Background(), req, parcels, now)
+
+	if len(got.Parcels) != len(parcels) {
+		t.Fatalf("got %d parcels, want %d", len(got.Parcels), len(parcels))
+	}
+	if got.Parcels[0].TrackingNumber != got.TrackingNumber {
+		t.Errorf("got tracking number %s for the first parcel, want the one of the shipment %s", got.Parcels[0].TrackingNumber, got.TrackingNumber)
+	}
+
+	seen := make(map[string]bool)
+	first := got.Parcels[0].DeliverAt
+	for i, p := range got.Parcels {
+		if seen[p.TrackingNumber] {
+			t.Errorf("parcel %d has the tracking number %s of another parcel", i, p.TrackingNumber)
+		}
+		seen[p.TrackingNumber] = true
+
+		if _, err := ParseTrackingNumber(p.TrackingNumber); err != nil {
+			t.Errorf("parcel %d has tracking number %s: %s", i, p.TrackingNumber, err.Error())
+		}
+		if p.Status != StatusShipped {
+			t.Errorf("got status %q for parcel %d, want %q", p.Status, i, StatusShipped)
+		}
+		if p.WeightKg != parcels[i].WeightKg {
+			t.Errorf("got weight %v for parcel %d, want %v", p.WeightKg, i, parcels[i].WeightKg)
+		}
+		if p.DeliverAt.Before(first) {
+			first = p.DeliverAt
+		}
+	}
+	if !got.DeliverAt.Equal(first) {
+		t.Errorf("got delivery at %s, want the first parcel at %s", got.DeliverAt, first)
+	}
+}
+
+func TestDeliverDue(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	parcel := func(tn string, in time.Duration) Parcel {
+		return Parcel{TrackingNumber: tn, Status: StatusShipped, DeliverAt: now.Add(in)}
+	}
+	s := Shipment{Parcels: []Parcel{parcel("1", time.Hour), parcel("2", 3*time.Hour), parcel("3", time.Hour)}}
+	s.Status = StatusShipped
+	s.DeliverAt = now.Add(time.Hour)
+
+	// Early, so the parcels that are due at DeliverAt are delivered
+	s, delivered := DeliverDue(s, now)
+	if len(delivered) != 2 || delivered[0].TrackingNumber != "1" || delivered[1].TrackingNumber != "3" {
+		t.Fatalf("got delivered parcels %+v, want 1 and 3", delivered)
+	}
+	if s.Status != StatusPartiallyDelivered {
+		t.Errorf("got status %q, want %q", s.Status, StatusPartiallyDelivered)
+	}
+	if !s.DeliverAt.Equal(now.Add(3 * time.Hour)) {
+		t.Errorf("got next delivery at %s, want %s", s.DeliverAt, now.Add(3*time.Hour))
+	}
+
+	s, delivered = DeliverDue(s, now.Add(4*time.Hour))
+	if len(delivered) != 1 || delivered[0].TrackingNumber != "2" {
+		t.Fatalf("got delivered parcels %+v, want 2", delivered)
+	}
+	if s.Status != StatusDelivered {
+		t.Errorf("got status %q, want %q", s.Status, StatusDelivered)
+	}
+
+	// A shipment without parcels is delivered as a whole
+	single := Shipment{DeliverAt: now}
+	single.Status = StatusShipped
+	single, delivered = DeliverDue(single, now)
+	if single.Status != StatusDelivered || len(delivered) != 0 {
+		t.Errorf("got status %q and parcels %+v, want %q without parcels", single.Status, delivered, StatusDelivered)
+	}
+}
@@ -0,0 +1,268 @@
+package shipper
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+)
+
+const (
+	// ServiceGround is the cheapest and slowest service level.
+	ServiceGround = "ground"
+
+	// ServiceExpress is the service level that delivers in a few days.
+	ServiceExpress = "express"
+
+	// ServiceOvernight is the service level that delivers the next business day.
+	ServiceOvernight = "overnight"
+)
+
+// Location is the origin or destination of a package, precise enough to quote rates.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country, like US.
+	Country string `json:"country"`
+
+	// PostalCode is the postal or ZIP code.
+	PostalCode string `json:"postalCode"`
+
+	// Residential is set when the location is a home rather than a business.
+	Residential bool `json:"residential,omitempty"`
+}
+
+// Package is the weight and dimensions of a package.
+type Package struct {
+	// WeightKg is the weight of the package in kilograms.
+	WeightKg float64 `json:"weightKg"`
+
+	// LengthCm is the length of the package in centimeters.
+	LengthCm float64 `json:"lengthCm"`
+
+	// WidthCm is the width of the package in centimeters.
+	WidthCm float64 `json:"widthCm"`
+
+	// HeightCm is the height of the package in centimeters.
+	HeightCm float64 `json:"heightCm"`
+}
+
+// RateRequest is a request for the price of shipping a package.
+type RateRequest struct {
+	// Origin is where the package is picked up.
+	Origin Location `json:"origin"`
+
+	// Destination is where the package is delivered.
+	Destination Location `json:"destination"`
+
+	// Package is the package that is shipped.
+	Package Package `json:"package"`
+
+	// ServiceLevel is the service level to quote, or empty to quote all service levels.
+	ServiceLevel string `json:"serviceLevel,omitempty"`
+}
+
+// Quote is the price a carrier charges to ship a package with a service level.
+type Quote struct {
+	// Carrier is the name of the carrier.
+	Carrier string `json:"carrier"`
+
+	// ServiceLevel is the service level of the quote.
+	ServiceLevel string `json:"serviceLevel"`
+
+	// Price is the price of the shipment, rounded to cents.
+	Price float64 `json:"price"`
+
+	// Currency is the ISO 4217 code of the currency of the price, like USD.
+	Currency string `json:"currency"`
+
+	// EstimatedDelivery is the day the package is expected to be delivered.
+	EstimatedDelivery time.Time `json:"estimatedDelivery"`
+
+	// Zone is the zone of the carrier the package is shipped to, if the carrier
+	// prices by zone.
+	Zone int `json:"zone,omitempty"`
+
+	// BillableWeightKg is the weight the carrier charges for, which can be more than
+	// the weight of the package when the carrier charges for its size.
+	BillableWeightKg float64 `json:"billableWeightKg,omitempty"`
+
+	// Charges are the parts the price is made of, like the base rate and surcharges.
+	Charges []Charge `json:"charges,omitempty"`
+}
+
+// Charge is a part of the price of a quote.
+type Charge struct {
+	// Name is the name of the charge, like fuel.
+	Name string `json:"name"`
+
+	// Amount is the amount of the charge, in the currency of the quote.
+	Amount float64 `json:"amount"`
+}
+
+// Carrier is the interface that describes the methods a carrier needs to implement
+// to quote the price of shipping a package. In order to add a new carrier, the Carrier
+// interface needs to be implemented and the carrier registered with a Quoter.
+type Carrier interface {
+	// Name returns the name of the carrier, like UPS.
+	Name() string
+
+	// Rates returns the quotes of the carrier for the request, for packages handed
+	// to the carrier at the moment now. A carrier that doesn't offer the requested
+	// service level returns no quotes.
+	Rates(ctx context.Context, r RateRequest, now time.Time) ([]Quote, error)
+}
+
+// ValidateRateRequest checks that the request has an origin and destination country
+// and a package with a weight, so carriers don't quote packages that can't be shipped.
+func ValidateRateRequest(r RateRequest) error {
+	locations := []struct {
+		name     string
+		location Location
+	}{
+		{"origin", r.Origin},
+		{"destination", r.Destination},
+	}
+
+	for _, l := range locations {
+		if len(l.location.Country) != 2 {
+			return fmt.Errorf("%s.country must be a two-letter country code", l.name)
+		}
+		if len(l.location.PostalCode) > MaxFieldLength {
+			return fmt.Errorf("%s.postalCode is longer than %d bytes", l.name, MaxFieldLength)
+		}
+	}
+
+	if err := validatePackage("package", r.Package); err != nil {
+		return err
+	}
+
+	if len(r.ServiceLevel) > MaxFieldLength {
+		return fmt.Errorf("serviceLevel is longer than %d bytes", MaxFieldLength)
+	}
+
+	return nil
+}
+
+// Quoter quotes the price of shipping a package with every registered carrier.
+// It is safe for concurrent use.
+type Quoter struct {
+	mu       sync.RWMutex
+	carriers map[string]Carrier
+}
+
+// validatePackage checks that the package has a weight and no negative dimensions. The
+// name is the name of the package in the error, like package.
+func validatePackage(name string, p Package) error {
+	dimensions := []struct {
+		name  string
+		value float64
+	}{
+		{"lengthCm", p.LengthCm},
+		{"widthCm", p.WidthCm},
+		{"heightCm", p.HeightCm},
+	}
+
+	switch {
+	case math.IsNaN(p.WeightKg) || p.WeightKg <= 0:
+		return fmt.Errorf("%s.weightKg must be more than 0", name)
+	case math.IsInf(p.WeightKg, 0):
+		return fmt.Errorf("%s.weightKg must be finite", name)
+	}
+
+	for _, d := range dimensions {
+		if math.IsNaN(d.value) || math.IsInf(d.value, 0) || d.value < 0 {
+			return fmt.Errorf("%s.%s must be 0 or more", name, d.name)
+		}
+	}
+
+	return nil
+}
+
+// NewQuoter creates a Quoter with the carriers registered.
+func NewQuoter(carriers ...Carrier) *Quoter {
+	q := &Quoter{carriers: make(map[string]Carrier)}
+	for _, c := range carriers {
+		q.Register(c)
+	}
+	return q
+}
+
+// Register adds the carrier to the Quoter, or replaces the carrier with the same name.
+func (q *Quoter) Register(c Carrier) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.carriers[c.Name()] = c
+}
+
+// Replace replaces all registered carriers with the carriers, at once, so quotes
+// never mix the old and new carriers.
+func (q *Quoter) Replace(carriers ...Carrier) {
+	m := make(map[string]Carrier, len(carriers))
+	for _, c := range carriers {
+		m[c.Name()] = c
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.carriers = m
+}
+
+// Carriers returns the sorted names of the registered carriers.
+func (q *Quoter) Carriers() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	names := make([]string, 0, len(q.carriers))
+	for name := range q.carriers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Quote validates the request and returns the quotes of every registered carrier,
+// from cheapest to most expensive. A carrier that fails to quote is logged and left
+// out, so one carrier can't keep the others from quoting.
+func (q *Quoter) Quote(ctx context.Context, r RateRequest, now time.Time) ([]Quote, error) {
+	if err := ValidateRateRequest(r); err != nil {
+		return nil, err
+	}
+
+	r.Origin.Country = strings.ToUpper(r.Origin.Country)
+	r.Destination.Country = strings.ToUpper(r.Destination.Country)
+	r.ServiceLevel = strings.ToLower(r.ServiceLevel)
+
+	q.mu.RLock()
+	carriers := make([]Carrier, 0, len(q.carriers))
+	for _, c := range q.carriers {
+		carriers = append(carriers, c)
+	}
+	q.mu.RUnlock()
+
+	quotes := make([]Quote, 0, len(carriers))
+	for _, c := range carriers {
+		cq, err := c.Rates(ctx, r, now)
+		if err != nil {
+			slog.WarnContext(ctx, "error quoting rates", logging.Carrier, c.Name(), logging.Err(err))
+			continue
+		}
+		quotes = append(quotes, cq...)
+	}
+
+	sort.Slice(quotes, func(i, j int) bool {
+		if quotes[i].Price != quotes[j].Price {
+			return quotes[i].Price < quotes[j].Price
+		}
+		if quotes[i].Carrier != quotes[j].Carrier {
+			return quotes[i].Carrier < quotes[j].Carrier
+		}
+		return quotes[i].ServiceLevel < quotes[j].ServiceLevel
+	})
+
+	return quotes, nil
+}
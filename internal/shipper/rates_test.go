@@ -0,0 +1,138 @@
+package shipper
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wednesday is the moment the packages of the tests are handed to the carriers.
+var wednesday = time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+
+// fixedCarrier is a Carrier that quotes a fixed price for every service level.
+type fixedCarrier struct {
+	name   string
+	prices map[string]float64
+}
+
+func (c fixedCarrier) Name() string {
+	return c.name
+}
+
+func (c fixedCarrier) Rates(ctx context.Context, r RateRequest, now time.Time) ([]Quote, error) {
+	var quotes []Quote
+	for level, price := range c.prices {
+		if r.ServiceLevel != "" && r.ServiceLevel != level {
+			continue
+		}
+		quotes = append(quotes, Quote{Carrier: c.name, ServiceLevel: level, Price: price, Currency: "USD", EstimatedDelivery: now})
+	}
+	return quotes, nil
+}
+
+// failingCarrier is a Carrier that can't quote.
+type failingCarrier struct{}
+
+func (failingCarrier) Name() string {
+	return "Broken"
+}
+
+func (failingCarrier) Rates(ctx context.Context, r RateRequest, now time.Time) ([]Quote, error) {
+	return nil, errors.New("carrier unavailable")
+}
+
+func rateRequest(serviceLevel string) RateRequest {
+	return RateRequest{
+		Origin:       Location{Country: "US", PostalCode: "94105"},
+		Destination:  Location{Country: "US", PostalCode: "10001"},
+		Package:      Package{WeightKg: 2.2, LengthCm: 30, WidthCm: 20, HeightCm: 10},
+		ServiceLevel: serviceLevel,
+	}
+}
+
+// describe returns the carrier and service level of the quotes, in order.
+func describe(quotes []Quote) string {
+	parts := make([]string, 0, len(quotes))
+	for _, q := range quotes {
+		parts = append(parts, q.Carrier+" "+q.ServiceLevel)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestQuote(t *testing.T) {
+	q := NewQuoter(
+		fixedCarrier{"UPS", map[string]float64{ServiceGround: 12.10, ServiceExpress: 24.30}},
+		fixedCarrier{"FedEx", map[string]float64{ServiceGround: 12.10, ServiceExpress: 24}},
+		failingCarrier{},
+	)
+
+	tests := []struct {
+		name string
+		req  RateRequest
+		want string
+	}{
+		{"all service levels", rateRequest(""), "FedEx ground, UPS ground, FedEx express, UPS express"},
+		{"one service level", rateRequest("Express"), "FedEx express, UPS express"},
+		{"unknown service level", rateRequest("drone"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := q.Quote(context.Background(), tt.req, wednesday)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if describe(got) != tt.want {
+				t.Errorf("got quotes %q, want %q", describe(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoterReplace(t *testing.T) {
+	q := NewQuoter(fixedCarrier{"UPS", map[string]float64{ServiceGround: 10}})
+	q.Replace(fixedCarrier{"DHL", map[string]float64{ServiceGround: 8}}, fixedCarrier{"PostNL", map[string]float64{ServiceGround: 6}})
+
+	if got := strings.Join(q.Carriers(), ","); got != "DHL,PostNL" {
+		t.Errorf("got carriers %s, want DHL,PostNL", got)
+	}
+
+	got, err := q.Quote(context.Background(), rateRequest(""), wednesday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if describe(got) != "PostNL ground, DHL ground" {
+		t.Errorf("got quotes %q, want PostNL and DHL", describe(got))
+	}
+}
+
+func TestValidateRateRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(r *RateRequest)
+		wantErr bool
+	}{
+		{name: "valid", change: func(r *RateRequest) {}},
+		{name: "no dimensions", change: func(r *RateRequest) { r.Package = Package{WeightKg: 1} }},
+		{name: "no origin", change: func(r *RateRequest) { r.Origin = Location{} }, wantErr: true},
+		{name: "country name", change: func(r *RateRequest) { r.Destination.Country = "Netherlands" }, wantErr: true},
+		{name: "no weight", change: func(r *RateRequest) { r.Package.WeightKg = 0 }, wantErr: true},
+		{name: "NaN weight", change: func(r *RateRequest) { r.Package.WeightKg = math.NaN() }, wantErr: true},
+		{name: "infinite weight", change: func(r *RateRequest) { r.Package.WeightKg = math.Inf(1) }, wantErr: true},
+		{name: "negative length", change: func(r *RateRequest) { r.Package.LengthCm = -1 }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rateRequest("")
+			tt.change(&r)
+
+			if err := ValidateRateRequest(r); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package shipper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+)
+
+const (
+	// StatusReturnRequested is the status of the return leg of a shipment that waits for
+	// the carrier to pick it up at the customer.
+	StatusReturnRequested = "return requested"
+
+	// StatusReturnInTransit is the status of the return leg of a shipment that the carrier
+	// picked up and brings back to the warehouse.
+	StatusReturnInTransit = "return in transit"
+
+	// StatusReturnReceived is the status of the return leg of a shipment that arrived at
+	// the warehouse.
+	StatusReturnReceived = "return received"
+)
+
+// ErrNotDelivered is returned when a return is requested for a shipment that hasn't been
+// delivered, or that is a return itself.
+var ErrNotDelivered = errors.New("only delivered shipments can be returned")
+
+// ReturnRequest is a request of the customer to return items of a delivered shipment.
+type ReturnRequest struct {
+	// Reason is why the items are returned.
+	Reason string `json:"reason"`
+
+	// Items are the line items that are returned, or empty to return the whole shipment.
+	Items []LineItem `json:"items,omitempty"`
+}
+
+// Return is what makes a shipment the return leg of another shipment, also known as the
+// return merchandise authorization or RMA.
+type Return struct {
+	// RMA is the number of the return merchandise authorization, which the customer
+	// and the warehouse refer to.
+	RMA string `json:"rma"`
+
+	// Shipment is the tracking number of the shipment that is returned.
+	Shipment string `json:"shipment"`
+
+	// Reason is why the items are returned.
+	Reason string `json:"reason"`
+
+	// ReceiveAt is the moment the return arrives at the warehouse.
+	ReceiveAt time.Time `json:"receiveAt"`
+}
+
+// ValidateReturn checks that the return can be requested for the shipment: the shipment
+// is delivered, the return has a reason and its line items are valid. When the shipment
+// lists its line items, only those can be returned, at most as many as were shipped. It
+// returns ErrNotDelivered when the shipment can't be returned at all.
+func ValidateReturn(s Shipment, r ReturnRequest) error {
+	if s.Status != StatusDelivered || s.Return != nil {
+		return ErrNotDelivered
+	}
+
+	if strings.TrimSpace(r.Reason) == "" {
+		return fmt.Errorf("reason is required")
+	}
+	if err := validateText("reason", r.Reason); err != nil {
+		return err
+	}
+
+	if err := ValidateItems(r.Items); err != nil {
+		return err
+	}
+	if len(s.Items) == 0 {
+		return nil
+	}
+
+	shipped := make(map[string]int64)
+	for _, item := range s.Items {
+		shipped[item.ID] += item.Quantity
+	}
+	for i, item := range r.Items {
+		switch n, ok := shipped[item.ID]; {
+		case !ok:
+			return fmt.Errorf("items[%d] %s isn't part of the shipment", i, item.ID)
+		case item.Quantity > n:
+			return fmt.Errorf("items[%d] returns %d of %s, but %d were shipped", i, item.Quantity, item.ID, n)
+		}
+	}
+
+	return nil
+}
+
+// ReturnAt creates the return leg of the delivered shipment at the moment, with the
+// tracking number the carrier issued for it. The return leg is picked up at the address
+// of the shipment and brought back to its warehouse by the same carrier, with a simulated
+// pickup and arrival. Until it is received, DeliverAt is the moment of its next step.
+func ReturnAt(ctx context.Context, s Shipment, r ReturnRequest, trackingNumber string, now time.Time) Shipment {
+	now = now.UTC()
+	receiveAt := now.Add(DeliveryTime())
+	pickup := pickupAt(now, receiveAt)
+
+	items := r.Items
+	if len(items) == 0 {
+		items = s.Items
+	}
+
+	leg := Shipment{
+		Carrier:       s.Carrier,
+		CreatedAt:     now,
+		PickupAt:      pickup,
+		DeliverAt:     pickup,
+		CorrelationID: s.CorrelationID,
+		Address:       s.Address,
+		Warehouse:     s.Warehouse,
+		Items:         items,
+		Return: &Return{
+			RMA:       "RMA" + randomDigits(9),
+			Shipment:  s.TrackingNumber,
+			Reason:    r.Reason,
+			ReceiveAt: receiveAt,
+		},
+	}
+	leg.TrackingNumber = trackingNumber
+	leg.OrderNumber = s.OrderNumber
+	leg.Status = StatusReturnRequested
+
+	ctx = logging.With(ctx, logging.OrderNumber, s.OrderNumber, logging.Carrier, s.Carrier, logging.TrackingNumber, trackingNumber)
+	slog.InfoContext(ctx, "return requested", "rma", leg.Return.RMA, "shipment", s.TrackingNumber)
+
+	return leg
+}
+
+// AdvanceReturn moves the return leg to the step that is due at the moment: in transit
+// once the carrier picked it up and received once it arrived at the warehouse. The next
+// step is due at DeliverAt, so it is taken even when the moment is earlier.
+func AdvanceReturn(s Shipment, at time.Time) Shipment {
+	if s.Return == nil {
+		return s
+	}
+	if s.DeliverAt.After(at) {
+		at = s.DeliverAt
+	}
+
+	if s.Status == StatusReturnRequested && !at.Before(s.PickupAt) {
+		s.Status = StatusReturnInTransit
+		s.DeliverAt = s.Return.ReceiveAt
+	}
+	if s.Status == StatusReturnInTransit && !at.Before(s.Return.ReceiveAt) {
+		s.Status = StatusReturnReceived
+	}
+
+	return s
+}
@@ -0,0 +1,96 @@
+package shipper
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateReturn(t *testing.T) {
+	delivered := Shipment{Items: []LineItem{{ID: "mat-1", Quantity: 2}, {ID: "rack-1", Quantity: 1}}}
+	delivered.Status = StatusDelivered
+	shipped := delivered
+	shipped.Status = StatusShipped
+	leg := delivered
+	leg.Return = &Return{RMA: "RMA123456789"}
+	withoutItems := Shipment{}
+	withoutItems.Status = StatusDelivered
+
+	tests := []struct {
+		name     string
+		shipment Shipment
+		request  ReturnRequest
+		wantErr  string
+	}{
+		{name: "whole shipment", shipment: delivered, request: ReturnRequest{Reason: "damaged"}},
+		{name: "some items", shipment: delivered, request: ReturnRequest{Reason: "too heavy", Items: []LineItem{{ID: "mat-1", Quantity: 1}}}},
+		{name: "any items without shipped items", shipment: withoutItems, request: ReturnRequest{Reason: "damaged", Items: []LineItem{{ID: "mat-1", Quantity: 5}}}},
+		{name: "not delivered", shipment: shipped, request: ReturnRequest{Reason: "damaged"}, wantErr: ErrNotDelivered.Error()},
+		{name: "return leg", shipment: leg, request: ReturnRequest{Reason: "damaged"}, wantErr: ErrNotDelivered.Error()},
+		{name: "no reason", shipment: delivered, request: ReturnRequest{Reason: " "}, wantErr: "reason is required"},
+		{name: "control characters", shipment: delivered, request: ReturnRequest{Reason: "damaged\n"}, wantErr: "control characters"},
+		{name: "not shipped", shipment: delivered, request: ReturnRequest{Reason: "damaged", Items: []LineItem{{ID: "bike-1", Quantity: 1}}}, wantErr: "isn't part of the shipment"},
+		{name: "more than shipped", shipment: delivered, request: ReturnRequest{Reason: "damaged", Items: []LineItem{{ID: "mat-1", Quantity: 3}}}, wantErr: "but 2 were shipped"},
+		{name: "invalid item", shipment: delivered, request: ReturnRequest{Reason: "damaged", Items: []LineItem{{ID: "mat-1"}}}, wantErr: "quantity must be 1 or more"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateReturn(tt.shipment, tt.request)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %q, want none", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReturnAt(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	s := Shipment{Carrier: "UPS", Warehouse: "rno", CorrelationID: "correlation", Items: []LineItem{{ID: "mat-1", Quantity: 1}}}
+	s.TrackingNumber = "1"
+	s.OrderNumber = "order-1"
+	s.Status = StatusDelivered
+
+	leg := ReturnAt(context.Background(), s, ReturnRequest{Reason: "damaged"}, "2", now)
+
+	if leg.TrackingNumber != "2" || leg.OrderNumber != "order-1" || leg.Status != StatusReturnRequested {
+		t.Errorf("got return leg %+v, want tracking number 2 of order-1 with status %q", leg.ShipmentData, StatusReturnRequested)
+	}
+	if leg.Return == nil || leg.Return.Shipment != "1" || !strings.HasPrefix(leg.Return.RMA, "RMA") || leg.Return.Reason != "damaged" {
+		t.Fatalf("got return %+v, want an RMA of shipment 1", leg.Return)
+	}
+	if leg.Warehouse != "rno" || leg.CorrelationID != "correlation" || len(leg.Items) != 1 {
+		t.Errorf("got return leg %+v, want the warehouse, correlation and items of the shipment", leg)
+	}
+
+	// The return is picked up, then received
+	steps := []struct {
+		at   time.Time
+		want string
+	}{
+		{now, StatusReturnInTransit},
+		{now, StatusReturnReceived},
+		{now, StatusReturnReceived},
+	}
+	for i, step := range steps {
+		leg = AdvanceReturn(leg, step.at)
+		if leg.Status != step.want {
+			t.Fatalf("step %d: got status %q, want %q", i, leg.Status, step.want)
+		}
+	}
+	if !Done(leg) {
+		t.Error("a received return isn't done")
+	}
+
+	// A return leg isn't delivered like a shipment
+	if got, _ := DeliverDue(leg, now); got.Status != StatusReturnReceived {
+		t.Errorf("got status %q after the delivery, want %q", got.Status, StatusReturnReceived)
+	}
+}
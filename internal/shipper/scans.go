@@ -0,0 +1,174 @@
+package shipper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// ScanPickedUp is the scan of the carrier picking the shipment up.
+	ScanPickedUp = "picked up"
+
+	// ScanInTransit is the scan of the shipment moving through the network of the carrier.
+	ScanInTransit = "in transit"
+
+	// ScanOutForDelivery is the scan of the shipment being on the truck to the recipient.
+	ScanOutForDelivery = "out for delivery"
+
+	// ScanDelivered is the scan of the shipment being delivered to the recipient.
+	ScanDelivered = "delivered"
+
+	// ScanException is the scan of a problem with the shipment, like a failed delivery
+	// attempt or a damaged parcel.
+	ScanException = "exception"
+)
+
+const (
+	// MaxScans is the maximum number of scans that is kept for a shipment. The oldest
+	// scans are dropped first.
+	MaxScans = 100
+)
+
+// ScanEvents are all events a carrier can report for a shipment.
+var ScanEvents = []string{ScanPickedUp, ScanInTransit, ScanOutForDelivery, ScanDelivered, ScanException}
+
+// Scan is a tracking event a carrier reported for a shipment or one of its parcels, like
+// in a webhook.
+type Scan struct {
+	// TrackingNumber is the tracking number of the shipment or the parcel that was scanned.
+	TrackingNumber string `json:"trackingNumber"`
+
+	// Event is what happened, one of the ScanEvents.
+	Event string `json:"event"`
+
+	// At is the moment of the scan.
+	At time.Time `json:"at"`
+
+	// Location is where the scan happened, like the city, if the carrier reported it.
+	Location string `json:"location,omitempty"`
+
+	// Description is the description of the carrier, like the reason of an exception.
+	Description string `json:"description,omitempty"`
+}
+
+// ValidateScan checks that the scan has a tracking number, a known event and a moment,
+// and that its fields are printable text of a limited length.
+func ValidateScan(scan Scan) error {
+	if strings.TrimSpace(scan.TrackingNumber) == "" {
+		return fmt.Errorf("trackingNumber is required")
+	}
+	if scan.At.IsZero() {
+		return fmt.Errorf("the moment of the scan is required")
+	}
+
+	known := false
+	for _, e := range ScanEvents {
+		known = known || scan.Event == e
+	}
+	if !known {
+		return fmt.Errorf("unknown scan event %q", scan.Event)
+	}
+
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"trackingNumber", scan.TrackingNumber},
+		{"location", scan.Location},
+		{"description", scan.Description},
+	}
+	for _, f := range fields {
+		if err := validateText(f.name, f.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HasScan returns whether the scan was applied to the shipment before, like when the
+// carrier sends the same webhook again.
+func HasScan(s Shipment, scan Scan) bool {
+	for _, seen := range s.Scans {
+		if seen.TrackingNumber == scan.TrackingNumber && seen.Event == scan.Event && seen.At.Equal(scan.At) {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyScan adds the scan to the shipment and moves the shipment, or the parcel that was
+// scanned, to the status the scan reports. It returns the shipment together with the
+// parcels that were delivered by the scan. Any scan after the pickup makes the shipment
+// picked up, so it can't be cancelled anymore, a delivered scan delivers the shipment or
+// the parcel, and the return leg of a shipment goes through the steps of the return. A
+// scan that was applied before, or of a shipment that is done, changes no status.
+func ApplyScan(s Shipment, scan Scan) (Shipment, []Parcel) {
+	if HasScan(s, scan) {
+		return s, nil
+	}
+
+	scan.At = scan.At.UTC()
+	scans := append(append([]Scan(nil), s.Scans...), scan)
+	if len(scans) > MaxScans {
+		scans = scans[len(scans)-MaxScans:]
+	}
+	s.Scans = scans
+
+	if Done(s) || scan.Event == ScanException {
+		return s, nil
+	}
+
+	if scan.Event != ScanDelivered {
+		if s.PickupAt.IsZero() || scan.At.Before(s.PickupAt) {
+			s.PickupAt = scan.At
+		}
+		if s.Status == StatusReturnRequested {
+			s.Status = StatusReturnInTransit
+			s.DeliverAt = s.Return.ReceiveAt
+		}
+		return s, nil
+	}
+
+	switch {
+	case s.Return != nil:
+		s.Status = StatusReturnReceived
+		return s, nil
+	case len(s.Parcels) == 0:
+		if scan.TrackingNumber == s.TrackingNumber {
+			s.Status = StatusDelivered
+		}
+		return s, nil
+	}
+
+	parcels := append([]Parcel(nil), s.Parcels...)
+	var delivered []Parcel
+	for i, p := range parcels {
+		if p.TrackingNumber == scan.TrackingNumber && p.Status != StatusDelivered {
+			parcels[i].Status = StatusDelivered
+			delivered = append(delivered, parcels[i])
+		}
+	}
+
+	s.Parcels = parcels
+	s.Status = ParcelStatus(parcels)
+	if next := nextDelivery(parcels); !next.IsZero() {
+		s.DeliverAt = next
+	}
+	return s, delivered
+}
+
+// HasTrackingNumber returns whether the tracking number is the one of the shipment or of
+// one of its parcels.
+func HasTrackingNumber(s Shipment, trackingNumber string) bool {
+	if s.TrackingNumber == trackingNumber {
+		return true
+	}
+	for _, p := range s.Parcels {
+		if p.TrackingNumber == trackingNumber {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package shipper
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateScan(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		scan    Scan
+		wantErr string
+	}{
+		{name: "valid", scan: Scan{TrackingNumber: "1", Event: ScanInTransit, At: now, Location: "Reno, NV"}},
+		{name: "no tracking number", scan: Scan{Event: ScanDelivered, At: now}, wantErr: "trackingNumber is required"},
+		{name: "no moment", scan: Scan{TrackingNumber: "1", Event: ScanDelivered}, wantErr: "moment of the scan"},
+		{name: "unknown event", scan: Scan{TrackingNumber: "1", Event: "lost", At: now}, wantErr: `unknown scan event "lost"`},
+		{name: "control characters", scan: Scan{TrackingNumber: "1", Event: ScanException, At: now, Description: "damaged\n"}, wantErr: "description contains control characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateScan(tt.scan)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %q, want none", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyScan(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	shipment := func(status string) Shipment {
+		s := Shipment{PickupAt: now.Add(time.Hour), DeliverAt: now.Add(2 * time.Hour)}
+		s.TrackingNumber = "1"
+		s.Status = status
+		return s
+	}
+	leg := shipment(StatusReturnRequested)
+	leg.Return = &Return{ReceiveAt: now.Add(5 * time.Hour)}
+	parcels := shipment(StatusShipped)
+	parcels.Parcels = []Parcel{
+		{TrackingNumber: "1", Status: StatusShipped, DeliverAt: now.Add(2 * time.Hour)},
+		{TrackingNumber: "2", Status: StatusShipped, DeliverAt: now.Add(3 * time.Hour)},
+	}
+	scan := func(tn string, event string) Scan {
+		return Scan{TrackingNumber: tn, Event: event, At: now}
+	}
+
+	tests := []struct {
+		name          string
+		shipment      Shipment
+		scan          Scan
+		wantStatus    string
+		wantPickupAt  time.Time
+		wantDelivered int
+	}{
+		{name: "picked up early", shipment: shipment(StatusShipped), scan: scan("1", ScanPickedUp), wantStatus: StatusShipped, wantPickupAt: now},
+		{name: "out for delivery", shipment: shipment(StatusShipped), scan: scan("1", ScanOutForDelivery), wantStatus: StatusShipped, wantPickupAt: now},
+		{name: "exception", shipment: shipment(StatusShipped), scan: scan("1", ScanException), wantStatus: StatusShipped, wantPickupAt: now.Add(time.Hour)},
+		{name: "delivered", shipment: shipment(StatusShipped), scan: scan("1", ScanDelivered), wantStatus: StatusDelivered, wantPickupAt: now.Add(time.Hour)},
+		{name: "cancelled", shipment: shipment(StatusCancelled), scan: scan("1", ScanDelivered), wantStatus: StatusCancelled, wantPickupAt: now.Add(time.Hour)},
+		{name: "parcel delivered", shipment: parcels, scan: scan("2", ScanDelivered), wantStatus: StatusPartiallyDelivered, wantPickupAt: now.Add(time.Hour), wantDelivered: 1},
+		{name: "unknown parcel", shipment: parcels, scan: scan("3", ScanDelivered), wantStatus: StatusShipped, wantPickupAt: now.Add(time.Hour)},
+		{name: "return picked up", shipment: leg, scan: scan("1", ScanPickedUp), wantStatus: StatusReturnInTransit, wantPickupAt: now},
+		{name: "return received", shipment: leg, scan: scan("1", ScanDelivered), wantStatus: StatusReturnReceived, wantPickupAt: now.Add(time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, delivered := ApplyScan(tt.shipment, tt.scan)
+			if got.Status != tt.wantStatus {
+				t.Errorf("got status %q, want %q", got.Status, tt.wantStatus)
+			}
+			if !got.PickupAt.Equal(tt.wantPickupAt) {
+				t.Errorf("got pickup at %s, want %s", got.PickupAt, tt.wantPickupAt)
+			}
+			if len(delivered) != tt.wantDelivered {
+				t.Errorf("got delivered parcels %+v, want %d", delivered, tt.wantDelivered)
+			}
+			if len(got.Scans) != 1 {
+				t.Fatalf("got scans %+v, want the scan", got.Scans)
+			}
+
+			// The carrier sends the same scan again
+			again, delivered := ApplyScan(got, tt.scan)
+			if len(again.Scans) != 1 || again.Status != got.Status || len(delivered) != 0 {
+				t.Errorf("got scans %+v and status %q after the same scan, want no change", again.Scans, again.Status)
+			}
+		})
+	}
+}
+
+func TestApplyScanKeepsLatest(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	s := Shipment{}
+	s.Status = StatusShipped
+
+	for i := 0; i < MaxScans+10; i++ {
+		s, _ = ApplyScan(s, Scan{TrackingNumber: "1", Event: ScanInTransit, At: now.Add(time.Duration(i) * time.Minute)})
+	}
+
+	if len(s.Scans) != MaxScans {
+		t.Fatalf("got %d scans, want %d", len(s.Scans), MaxScans)
+	}
+	if last := s.Scans[MaxScans-1].At; !last.Equal(now.Add(time.Duration(MaxScans+9) * time.Minute)) {
+		t.Errorf("got last scan at %s, want the latest scan", last)
+	}
+}
@@ -1,12 +1,18 @@
 package shipper
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"math/rand"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/gofrs/uuid"
 	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
 )
 
 const (
@@ -14,17 +20,180 @@ const (
 	maxDeliveryTime = 120
 )
 
+const (
+	// MaxFieldLength is the maximum length, in bytes, of the fields of a shipment request.
+	MaxFieldLength = 256
+)
+
+const (
+	// StatusShipped is the status of a shipment that has been handed to the shipper.
+	StatusShipped = "shipped - pending delivery"
+
+	// StatusPartiallyDelivered is the status of a shipment of which some, but not all,
+	// parcels have been delivered to the customer.
+	StatusPartiallyDelivered = "partially delivered"
+
+	// StatusDelivered is the status of a shipment that has been delivered to the customer.
+	StatusDelivered = "delivered"
+
+	// StatusCancelled is the status of a shipment that was cancelled before the carrier
+	// picked it up.
+	StatusCancelled = "cancelled"
+)
+
+// Statuses are all statuses a shipment can have, in the order a shipment goes through them.
+// A cancelled shipment skips the statuses of the delivery, and the return leg of a shipment
+// only goes through the statuses of the return.
+var Statuses = []string{
+	StatusShipped, StatusPartiallyDelivered, StatusDelivered, StatusCancelled,
+	StatusReturnRequested, StatusReturnInTransit, StatusReturnReceived,
+}
+
+// Shipment is a shipment as it is tracked by the Shipment service. Next to the
+// data that is sent to other services, it contains the shipper that was used and
+// the moment the simulated delivery is due.
+type Shipment struct {
+	acmeserverless.ShipmentData
+
+	// Carrier is the delivery method that is used for the shipment.
+	Carrier string `json:"carrier"`
+
+	// CreatedAt is the moment the shipment was handed to the shipper.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// PickupAt is the moment the carrier picks the shipment up at the warehouse. Until
+	// then, the shipment can be cancelled.
+	PickupAt time.Time `json:"pickupAt"`
+
+	// DeliverAt is the moment the shipment will be delivered to the customer. For a
+	// shipment with parcels, it is the moment the next parcel will be delivered, and for
+	// a return leg the moment of the next step of the return.
+	DeliverAt time.Time `json:"deliverAt"`
+
+	// TraceContext is the trace context of the request that created the shipment,
+	// so the delivery, which happens later, is part of the same trace.
+	TraceContext map[string]string `json:"traceContext,omitempty"`
+
+	// CausationID is the ID of the message that requested the shipment.
+	CausationID string `json:"causationId,omitempty"`
+
+	// CorrelationID is the ID shared by all messages of the order of the shipment.
+	CorrelationID string `json:"correlationId,omitempty"`
+
+	// Address is the normalized address the shipment is delivered to, if the request
+	// had one.
+	Address *address.Address `json:"address,omitempty"`
+
+	// Parcels are the boxes of the shipment, if the request had more than one. The
+	// tracking number of the shipment is the one of the first parcel.
+	Parcels []Parcel `json:"parcels,omitempty"`
+
+	// Warehouse is the ID of the warehouse the shipment is shipped from, if known.
+	Warehouse string `json:"warehouse,omitempty"`
+
+	// Items are the line items of the order that are in the shipment, if the request
+	// listed them.
+	Items []LineItem `json:"items,omitempty"`
+
+	// Parts are the tracking numbers of all shipments of an order that is shipped from
+	// more than one warehouse, including this one.
+	Parts []string `json:"parts,omitempty"`
+
+	// CancelReason is why the shipment was cancelled, if it was and a reason was given.
+	CancelReason string `json:"cancelReason,omitempty"`
+
+	// Return is set when the shipment is the return leg of another shipment, which is
+	// shipped from the customer back to the warehouse.
+	Return *Return `json:"return,omitempty"`
+
+	// Returns are the tracking numbers of the return legs of the shipment.
+	Returns []string `json:"returns,omitempty"`
+
+	// Scans are the tracking events the carrier reported for the shipment and its parcels,
+	// in the order they arrived.
+	Scans []Scan `json:"scans,omitempty"`
+
+	// PollAt is the moment the tracking poller asks the carrier for the scans of the
+	// shipment again, when the carrier is polled.
+	PollAt time.Time `json:"pollAt"`
+
+	// Version is the number of times the shipment was stored, which the store uses to
+	// detect that the shipment was stored by someone else since it was loaded.
+	Version int64 `json:"version,omitempty"`
+}
+
+// Validate checks that the shipment request has an order and a delivery method, so
+// a request that decoded to zero values isn't handed to the shipper. The fields end
+// up in logs, headers and tags, so they must be printable text of a limited length.
+func Validate(r acmeserverless.ShipmentRequest) error {
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"_id", r.OrderID},
+		{"delivery", r.Delivery},
+	}
+
+	for _, f := range fields {
+		if strings.TrimSpace(f.value) == "" {
+			return fmt.Errorf("%s is required", f.name)
+		}
+		if err := validateText(f.name, f.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateText checks that the value of the field is printable text of at most
+// MaxFieldLength bytes.
+func validateText(name string, value string) error {
+	switch {
+	case len(value) > MaxFieldLength:
+		return fmt.Errorf("%s is longer than %d bytes", name, MaxFieldLength)
+	case !utf8.ValidString(value):
+		return fmt.Errorf("%s is not valid UTF-8", name)
+	case strings.IndexFunc(value, unicode.IsControl) >= 0:
+		return fmt.Errorf("%s contains control characters", name)
+	}
+
+	return nil
+}
+
+// Ship hands the shipment to the shipper and returns the shipment together with the
+// moment it will be delivered to the customer.
+func Ship(ctx context.Context, r acmeserverless.ShipmentRequest) Shipment {
+	return ShipAt(ctx, r, time.Now())
+}
+
+// ShipAt is like Ship, but hands the shipment to the shipper at the given moment,
+// like the current time of a simulated clock.
+func ShipAt(ctx context.Context, r acmeserverless.ShipmentRequest, now time.Time) Shipment {
+	now = now.UTC()
+	deliverAt := now.Add(DeliveryTime())
+
+	return Shipment{
+		ShipmentData: Sent(ctx, r),
+		Carrier:      r.Delivery,
+		CreatedAt:    now,
+		PickupAt:     pickupAt(now, deliverAt),
+		DeliverAt:    deliverAt,
+	}
+}
+
 // Sent takes care of sending the shipment to the customer. This would be the interface between
 // the ACME Serverless Fitness Shop and the shipper.
-func Sent(r acmeserverless.ShipmentRequest) acmeserverless.ShipmentData {
-	log.Printf("Hello, this is %s... We'll take care of your package!", r.Delivery)
+func Sent(ctx context.Context, r acmeserverless.ShipmentRequest) acmeserverless.ShipmentData {
+	trackingnumber := NewTrackingNumber(r.Delivery)
 
-	trackingnumber := uuid.Must(uuid.NewV4()).String()
+	ctx = logging.With(ctx, logging.OrderNumber, r.OrderID, logging.Carrier, r.Delivery, logging.TrackingNumber, trackingnumber)
+	slog.InfoContext(ctx, "shipment handed to carrier")
 
 	res := acmeserverless.ShipmentData{
 		TrackingNumber: trackingnumber,
 		OrderNumber:    r.OrderID,
-		Status:         "shipped - pending delivery",
+		Status:         StatusShipped,
 	}
 
 	return res
@@ -32,17 +201,23 @@ func Sent(r acmeserverless.ShipmentRequest) acmeserverless.ShipmentData {
 
 // Delivered takes care of alerting the ACME Serverless Fitness Shop that the order has
 // been delivered to the customer.
-func Delivered(s acmeserverless.ShipmentData) acmeserverless.ShipmentData {
-	d := deliveryTime(minDeliveryTime, maxDeliveryTime)
-	log.Printf("Simulating delivery by sleeping for %d seconds", d)
+func Delivered(ctx context.Context, s acmeserverless.ShipmentData) acmeserverless.ShipmentData {
+	d := DeliveryTime()
+	ctx = logging.With(ctx, logging.OrderNumber, s.OrderNumber, logging.TrackingNumber, s.TrackingNumber)
+	slog.InfoContext(ctx, "simulating delivery", "delay", d.String())
 
-	time.Sleep(time.Duration(d) * time.Second)
+	time.Sleep(d)
 
-	s.Status = "delivered"
+	s.Status = StatusDelivered
 
 	return s
 }
 
+// DeliveryTime returns how long the simulated delivery of a shipment takes.
+func DeliveryTime() time.Duration {
+	return time.Duration(deliveryTime(minDeliveryTime, maxDeliveryTime)) * time.Second
+}
+
 // deliveryTime generates a random number between the min and max values, to
 // determine how long a go routine will sleep to simulate delivery. The min
 // and max values are set during the initialization of the shipper.
@@ -0,0 +1,127 @@
+package shipper
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+)
+
+func TestSent(t *testing.T) {
+	tests := []struct {
+		name string
+		req  acmeserverless.ShipmentRequest
+	}{
+		{"UPS", acmeserverless.ShipmentRequest{OrderID: "order-1", Delivery: "UPS/FedEx"}},
+		{"no carrier", acmeserverless.ShipmentRequest{OrderID: "order-2"}},
+		{"no order", acmeserverless.ShipmentRequest{Delivery: "UPS/FedEx"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Sent(context.Background(), tt.req)
+
+			if got.TrackingNumber == "" {
+				t.Error("got an empty tracking number")
+			}
+			if got.OrderNumber != tt.req.OrderID {
+				t.Errorf("got order number %q, want %q", got.OrderNumber, tt.req.OrderID)
+			}
+			if got.Status != StatusShipped {
+				t.Errorf("got status %q, want %q", got.Status, StatusShipped)
+			}
+		})
+	}
+}
+
+func TestSentCreatesUniqueTrackingNumbers(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		tn := Sent(context.Background(), acmeserverless.ShipmentRequest{OrderID: "order-1"}).TrackingNumber
+		if seen[tn] {
+			t.Fatalf("tracking number %s was created twice", tn)
+		}
+		seen[tn] = true
+	}
+}
+
+func TestShipAt(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+	}{
+		{"UTC", time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)},
+		{"other time zone", time.Date(2020, 4, 1, 12, 0, 0, 0, time.FixedZone("PDT", -7*60*60))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := acmeserverless.ShipmentRequest{OrderID: "order-1", Delivery: "UPS/FedEx"}
+			got := ShipAt(context.Background(), req, tt.now)
+
+			if got.Carrier != req.Delivery {
+				t.Errorf("got carrier %q, want %q", got.Carrier, req.Delivery)
+			}
+			if !got.CreatedAt.Equal(tt.now) || got.CreatedAt.Location() != time.UTC {
+				t.Errorf("got created at %s, want %s in UTC", got.CreatedAt, tt.now)
+			}
+
+			d := got.DeliverAt.Sub(got.CreatedAt)
+			if d < minDeliveryTime*time.Second || d >= maxDeliveryTime*time.Second {
+				t.Errorf("got delivery after %s, want between %ds and %ds", d, minDeliveryTime, maxDeliveryTime)
+			}
+			if !got.PickupAt.After(got.CreatedAt) || !got.PickupAt.Before(got.DeliverAt) {
+				t.Errorf("got pickup at %s, want between %s and %s", got.PickupAt, got.CreatedAt, got.DeliverAt)
+			}
+		})
+	}
+}
+
+func TestDeliveryTime(t *testing.T) {
+	tests := []struct {
+		min int
+		max int
+	}{
+		{minDeliveryTime, maxDeliveryTime},
+		{0, 1},
+		{10, 11},
+		{1, 1000},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := deliveryTime(tt.min, tt.max); got < tt.min || got >= tt.max {
+				t.Fatalf("deliveryTime(%d, %d) = %d, want at least %d and less than %d", tt.min, tt.max, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     acmeserverless.ShipmentRequest
+		wantErr bool
+	}{
+		{name: "valid", req: acmeserverless.ShipmentRequest{OrderID: "order-1", Delivery: "UPS/FedEx"}},
+		{name: "unicode", req: acmeserverless.ShipmentRequest{OrderID: "bestelling-ü", Delivery: "PostNL"}},
+		{name: "zero value", req: acmeserverless.ShipmentRequest{}, wantErr: true},
+		{name: "no order", req: acmeserverless.ShipmentRequest{Delivery: "UPS/FedEx"}, wantErr: true},
+		{name: "blank order", req: acmeserverless.ShipmentRequest{OrderID: " \t", Delivery: "UPS/FedEx"}, wantErr: true},
+		{name: "no delivery", req: acmeserverless.ShipmentRequest{OrderID: "order-1"}, wantErr: true},
+		{name: "long order", req: acmeserverless.ShipmentRequest{OrderID: strings.Repeat("1", MaxFieldLength+1), Delivery: "UPS/FedEx"}, wantErr: true},
+		{name: "longest order", req: acmeserverless.ShipmentRequest{OrderID: strings.Repeat("1", MaxFieldLength), Delivery: "UPS/FedEx"}},
+		{name: "invalid UTF-8", req: acmeserverless.ShipmentRequest{OrderID: "order-\xff", Delivery: "UPS/FedEx"}, wantErr: true},
+		{name: "newline", req: acmeserverless.ShipmentRequest{OrderID: "order-1\nlevel=ERROR", Delivery: "UPS/FedEx"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
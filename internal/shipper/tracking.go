@@ -0,0 +1,201 @@
+package shipper
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+)
+
+// ErrUnknownTrackingNumber is returned when a tracking number isn't in the format of
+// any carrier.
+var ErrUnknownTrackingNumber = errors.New("tracking number is not in the format of a known carrier")
+
+// upsShipperNumber is the UPS account of the ACME Serverless Fitness Shop, which is
+// part of every UPS tracking number.
+const upsShipperNumber = "A1C3E5"
+
+// TrackingNumber is a tracking number together with the carrier that issued it.
+type TrackingNumber struct {
+	// Carrier is the carrier that issued the tracking number, like UPS.
+	Carrier string `json:"carrier"`
+
+	// Number is the tracking number, in upper case without spaces.
+	Number string `json:"number"`
+}
+
+// trackingFormat is the format of the tracking numbers of a carrier.
+type trackingFormat struct {
+	// carrier is the name of the carrier.
+	carrier string
+
+	// pattern matches the tracking numbers of the carrier, including the check digit.
+	pattern *regexp.Regexp
+
+	// generate returns a new tracking number without the check digit.
+	generate func() string
+
+	// check returns the check digit of a tracking number without its check digit.
+	check func(s string) byte
+}
+
+// trackingFormats are the formats of the tracking numbers of the simulated carriers.
+// The lengths of the formats differ, so a tracking number matches one format at most.
+var trackingFormats = []trackingFormat{
+	{
+		// 1Z, the shipper number, the service (03 is ground) and the package number
+		carrier:  "UPS",
+		pattern:  regexp.MustCompile(`^1Z[0-9A-Z]{16}$`),
+		generate: func() string { return "1Z" + upsShipperNumber + "03" + randomDigits(7) },
+		check:    upsCheck,
+	},
+	{
+		// Intelligent Mail package barcode, with the GS1 check digit
+		carrier:  "USPS",
+		pattern:  regexp.MustCompile(`^9[1-5]\d{20}$`),
+		generate: func() string { return "9400" + randomDigits(17) },
+		check:    mod10Check,
+	},
+	{
+		// FedEx Express, with the check digit of weights 1, 3 and 7 modulo 11
+		carrier:  "FedEx",
+		pattern:  regexp.MustCompile(`^\d{12}$`),
+		generate: func() string { return randomDigits(11) },
+		check:    fedexCheck,
+	},
+	{
+		// DHL Express waybill, with the check digit modulo 7
+		carrier:  "DHL",
+		pattern:  regexp.MustCompile(`^\d{10}$`),
+		generate: func() string { return randomDigits(9) },
+		check:    dhlCheck,
+	},
+}
+
+// NewTrackingNumber returns a new tracking number in the format of the carrier of the
+// delivery method. A delivery method with more carriers, like UPS/FedEx, uses the first
+// carrier that has a format. Carriers without a format get a UUID.
+func NewTrackingNumber(delivery string) string {
+	f, ok := formatFor(delivery)
+	if !ok {
+		return uuid.Must(uuid.NewV4()).String()
+	}
+
+	s := f.generate()
+	return s + string(f.check(s))
+}
+
+// ParseTrackingNumber identifies the carrier of the tracking number and validates its
+// check digit. Spaces and dashes, which are often used to group the digits, are ignored.
+func ParseTrackingNumber(s string) (TrackingNumber, error) {
+	number := strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(s))
+
+	for _, f := range trackingFormats {
+		if !f.pattern.MatchString(number) {
+			continue
+		}
+
+		body, digit := number[:len(number)-1], number[len(number)-1]
+		if want := f.check(body); digit != want {
+			return TrackingNumber{}, fmt.Errorf("invalid %s tracking number %s: check digit is %c, want %c", f.carrier, number, digit, want)
+		}
+		return TrackingNumber{Carrier: f.carrier, Number: number}, nil
+	}
+
+	return TrackingNumber{}, ErrUnknownTrackingNumber
+}
+
+// CarrierOf returns the name of the first carrier of the delivery method that has a tracking
+// number format, like UPS for ups/FedEx, and false when none of its carriers has one.
+func CarrierOf(delivery string) (string, bool) {
+	f, ok := formatFor(delivery)
+	return f.carrier, ok
+}
+
+// formatFor returns the tracking number format of the first carrier of the delivery
+// method that has one.
+func formatFor(delivery string) (trackingFormat, bool) {
+	for _, carrier := range strings.Split(delivery, "/") {
+		for _, f := range trackingFormats {
+			if strings.EqualFold(strings.TrimSpace(carrier), f.carrier) {
+				return f, true
+			}
+		}
+	}
+	return trackingFormat{}, false
+}
+
+// upsCheck returns the check digit of a UPS tracking number, computed over the characters
+// after 1Z. Letters count as digits, with A as 2, B as 3 and so on, and the characters in
+// even positions count double.
+func upsCheck(s string) byte {
+	sum := 0
+	for i, c := range s[2:] {
+		v := int(c - '0')
+		if c >= 'A' && c <= 'Z' {
+			v = int(c-'A'+2) % 10
+		}
+		if i%2 == 1 {
+			v *= 2
+		}
+		sum += v
+	}
+	return digit((10 - sum%10) % 10)
+}
+
+// mod10Check returns the GS1 check digit of the digits: from the right, the digits are
+// weighted 3 and 1 in turn.
+func mod10Check(s string) byte {
+	sum := 0
+	for i := 0; i < len(s); i++ {
+		v := int(s[len(s)-1-i] - '0')
+		if i%2 == 0 {
+			v *= 3
+		}
+		sum += v
+	}
+	return digit((10 - sum%10) % 10)
+}
+
+// fedexCheck returns the check digit of a FedEx Express tracking number: from the right,
+// the digits are weighted 1, 3 and 7 in turn, and the sum is taken modulo 11.
+func fedexCheck(s string) byte {
+	weights := []int{1, 3, 7}
+	sum := 0
+	for i := 0; i < len(s); i++ {
+		sum += int(s[len(s)-1-i]-'0') * weights[i%3]
+	}
+	return digit(sum % 11 % 10)
+}
+
+// dhlCheck returns the check digit of a DHL Express waybill number, which is the number
+// modulo 7.
+func dhlCheck(s string) byte {
+	n, _ := strconv.Atoi(s)
+	return digit(n % 7)
+}
+
+// digit returns the character of the digit.
+func digit(n int) byte {
+	return byte('0' + n)
+}
+
+// randomDigits returns n random digits from crypto/rand, so a tracking number can't be
+// guessed from the ones issued before it.
+func randomDigits(n int) string {
+	ten := big.NewInt(10)
+	b := make([]byte, n)
+	for i := range b {
+		d, err := rand.Int(rand.Reader, ten)
+		if err != nil {
+			panic(fmt.Sprintf("error reading random digits: %s", err.Error()))
+		}
+		b[i] = digit(int(d.Int64()))
+	}
+	return string(b)
+}
@@ -0,0 +1,97 @@
+package shipper
+
+import (
+	"testing"
+)
+
+func TestNewTrackingNumber(t *testing.T) {
+	tests := []struct {
+		delivery    string
+		wantCarrier string
+	}{
+		{"UPS", "UPS"},
+		{"UPS/FedEx", "UPS"},
+		{"fedex", "FedEx"},
+		{"Pigeon/USPS", "USPS"},
+		{"DHL", "DHL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.delivery, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				number := NewTrackingNumber(tt.delivery)
+
+				got, err := ParseTrackingNumber(number)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Carrier != tt.wantCarrier || got.Number != number {
+					t.Fatalf("got %+v for %s, want carrier %s", got, number, tt.wantCarrier)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTrackingNumberUnknownCarrier(t *testing.T) {
+	number := NewTrackingNumber("Pigeon")
+	if _, err := ParseTrackingNumber(number); err != ErrUnknownTrackingNumber {
+		t.Errorf("got error %v for %s, want ErrUnknownTrackingNumber", err, number)
+	}
+}
+
+func TestCarrierOf(t *testing.T) {
+	tests := []struct {
+		delivery    string
+		wantCarrier string
+		wantOK      bool
+	}{
+		{"UPS", "UPS", true},
+		{"ups/FedEx", "UPS", true},
+		{"Pigeon/fedex", "FedEx", true},
+		{" DHL ", "DHL", true},
+		{"Pigeon", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := CarrierOf(tt.delivery)
+		if got != tt.wantCarrier || ok != tt.wantOK {
+			t.Errorf("%q: got %q, %t, want %q, %t", tt.delivery, got, ok, tt.wantCarrier, tt.wantOK)
+		}
+	}
+}
+
+func TestParseTrackingNumber(t *testing.T) {
+	tests := []struct {
+		name        string
+		number      string
+		wantCarrier string
+		wantNumber  string
+		wantErr     bool
+	}{
+		{name: "UPS", number: "1Z999AA10123456784", wantCarrier: "UPS", wantNumber: "1Z999AA10123456784"},
+		{name: "UPS lower case with spaces", number: "1z 999 aa1 01 2345 6784", wantCarrier: "UPS", wantNumber: "1Z999AA10123456784"},
+		{name: "UPS wrong check digit", number: "1Z999AA10123456785", wantErr: true},
+		{name: "USPS", number: "9400 1118 9922 3197 4284 97", wantCarrier: "USPS", wantNumber: "9400111899223197428497"},
+		{name: "USPS wrong check digit", number: "9400111899223197428490", wantErr: true},
+		{name: "FedEx", number: "1234-5678-9012", wantCarrier: "FedEx", wantNumber: "123456789012"},
+		{name: "FedEx wrong check digit", number: "123456789013", wantErr: true},
+		{name: "DHL", number: "1234567891", wantCarrier: "DHL", wantNumber: "1234567891"},
+		{name: "DHL wrong check digit", number: "1234567890", wantErr: true},
+		{name: "UUID", number: "0d0e1f2a-3b4c-4d5e-8f60-718293a4b5c6", wantErr: true},
+		{name: "empty", number: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTrackingNumber(tt.number)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got.Carrier != tt.wantCarrier || got.Number != tt.wantNumber {
+				t.Errorf("got %+v, want carrier %s and number %s", got, tt.wantCarrier, tt.wantNumber)
+			}
+		})
+	}
+}
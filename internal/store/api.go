@@ -0,0 +1,117 @@
+// Package store contains the interfaces that the Shipment service
+// in the ACME Serverless Fitness Shop needs to keep track of the
+// shipments it created. In order to add a new storage layer, the
+// Store interface needs to be implemented.
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// ErrNotFound is returned when a shipment doesn't exist in the store.
+var ErrNotFound = errors.New("shipment not found")
+
+// ErrConflict is returned when a shipment was stored by someone else since it was
+// loaded, or already exists when it is created.
+var ErrConflict = errors.New("shipment was changed since it was loaded")
+
+// maxAttempts is the number of times Update loads, changes and stores a shipment before
+// it gives up.
+const maxAttempts = 5
+
+// Store is the interface that describes the methods the storage
+// layer needs to implement to be able to work with the Shipment
+// service.
+type Store interface {
+	// Save creates the shipment when its version is 0, or replaces
+	// the stored shipment with the same tracking number when that
+	// still has the version of the shipment, and stores it with the
+	// next version. It returns ErrConflict otherwise. A shipment
+	// stored before shipments had versions has version 0.
+	Save(s shipper.Shipment) error
+
+	// Get returns the shipment with the tracking number, or
+	// ErrNotFound if the shipment doesn't exist.
+	Get(trackingNumber string) (shipper.Shipment, error)
+
+	// List returns all shipments, ordered by the moment they
+	// were created.
+	List() ([]shipper.Shipment, error)
+
+	// Delete removes the shipment with the tracking number, like
+	// a shipment that couldn't be handed to the shipper after all.
+	// Deleting a shipment that doesn't exist isn't an error.
+	Delete(trackingNumber string) error
+}
+
+// Checker is the interface that Stores can implement to report
+// whether the storage layer can be used, like for readiness checks.
+// Stores that don't implement it are considered to be always usable.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// Finder is the interface that Stores can implement to look up the
+// shipment a parcel belongs to with an index of the tracking numbers
+// of parcels. Stores that don't implement it are searched with List.
+type Finder interface {
+	// FindParcel returns the shipment with the parcel with the
+	// tracking number, or ErrNotFound if no shipment has it.
+	FindParcel(trackingNumber string) (shipper.Shipment, error)
+}
+
+// Find returns the shipment with the tracking number, which is the one of the shipment or
+// of one of its parcels, or ErrNotFound if no shipment has it.
+func Find(st Store, trackingNumber string) (shipper.Shipment, error) {
+	s, err := st.Get(trackingNumber)
+	if err != ErrNotFound {
+		return s, err
+	}
+
+	if f, ok := st.(Finder); ok {
+		return f.FindParcel(trackingNumber)
+	}
+
+	list, err := st.List()
+	if err != nil {
+		return shipper.Shipment{}, err
+	}
+	for _, s := range list {
+		if shipper.HasTrackingNumber(s, trackingNumber) {
+			return s, nil
+		}
+	}
+	return shipper.Shipment{}, ErrNotFound
+}
+
+// Update loads the shipment with the tracking number, changes it and stores it. When
+// someone else stored the shipment in the meantime, it starts over with the shipment
+// they stored, so neither change is lost. An error of change stops the update without
+// storing the shipment and is returned as is. Update returns the stored shipment.
+func Update(st Store, trackingNumber string, change func(s shipper.Shipment) (shipper.Shipment, error)) (shipper.Shipment, error) {
+	for attempt := 1; ; attempt++ {
+		s, err := st.Get(trackingNumber)
+		if err != nil {
+			return s, err
+		}
+
+		s, err = change(s)
+		if err != nil {
+			return s, err
+		}
+
+		err = st.Save(s)
+		switch {
+		case err == nil:
+			s.Version++
+			return s, nil
+		case err == ErrConflict && attempt < maxAttempts:
+			continue
+		default:
+			return s, err
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package dynamodb keeps all shipments in an Amazon DynamoDB table, so every instance
+// of a service, like the Lambda functions that handle the events of an order, sees the
+// shipments the others created. Every shipment is an item with the tracking number as
+// partition key and the shipment as JSON, the same document the file storage layer
+// writes, and its version, so a shipment is only replaced when no other instance stored
+// it since it was loaded. The parcels of a shipment have an item as well, with the
+// tracking number of the parcel behind parcelKeyPrefix as partition key and the tracking
+// number of the shipment, so the shipment of a parcel is found without a scan.
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/store/memory"
+)
+
+const (
+	// KeyAttribute is the name of the partition key of the table, which holds the
+	// tracking number of the shipment.
+	KeyAttribute = "trackingNumber"
+
+	// shipmentAttribute is the name of the attribute with the shipment as JSON.
+	shipmentAttribute = "shipment"
+
+	// versionAttribute is the name of the attribute with the version of the shipment,
+	// which the condition of a write compares.
+	versionAttribute = "version"
+
+	// parcelAttribute is the name of the attribute of the item of a parcel with the
+	// tracking number of its shipment.
+	parcelAttribute = "parcelOf"
+
+	// parcelKeyPrefix is the prefix of the partition key of the item of a parcel.
+	parcelKeyPrefix = "parcel#"
+)
+
+// manager is a struct that implements the methods of the
+// Store interface.
+type manager struct {
+	svc   *dynamodb.DynamoDB
+	table string
+}
+
+// New creates a new instance of the Store with DynamoDB as the
+// storage layer. The region determines the AWS region this code
+// looks in to find the table and table is the name of the table
+// with the shipments. The method returns an error if the AWS
+// session can't be created.
+func New(region string, table string) (store.Store, error) {
+	return NewWithConfig(&aws.Config{
+		Region: aws.String(region),
+	}, table)
+}
+
+// NewWithConfig is like New, but creates the AWS session with the
+// configuration, like to use another endpoint than the one of the
+// region.
+func NewWithConfig(awsConfig *aws.Config, table string) (store.Store, error) {
+	if table == "" {
+		return nil, fmt.Errorf("the name of the DynamoDB table can't be empty")
+	}
+
+	awsSession, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %s", err.Error())
+	}
+
+	return manager{
+		svc:   dynamodb.New(awsSession),
+		table: table,
+	}, nil
+}
+
+// Save creates or replaces the shipment, when the stored item has the version of the
+// shipment. A shipment with version 0 is only stored when there is no item, or the item
+// was stored before shipments had versions.
+func (m manager) Save(s shipper.Shipment) error {
+	condition := "attribute_not_exists(#version)"
+	var values map[string]*dynamodb.AttributeValue
+	if s.Version > 0 {
+		condition = "#version = :version"
+		values = map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(strconv.FormatInt(s.Version, 10))},
+		}
+	}
+
+	s.Version++
+	b, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("error encoding shipment: %s", err.Error())
+	}
+
+	_, err = m.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(m.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			KeyAttribute:      {S: aws.String(s.TrackingNumber)},
+			shipmentAttribute: {S: aws.String(string(b))},
+			versionAttribute:  {N: aws.String(strconv.FormatInt(s.Version, 10))},
+		},
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  map[string]*string{"#version": aws.String(versionAttribute)},
+		ExpressionAttributeValues: values,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return store.ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("error storing shipment in DynamoDB: %s", err.Error())
+	}
+
+	// Index the parcels, which is done on every write so shipments stored before the
+	// index existed are indexed too
+	for _, p := range s.Parcels {
+		if p.TrackingNumber == s.TrackingNumber {
+			continue
+		}
+		_, err := m.svc.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(m.table),
+			Item: map[string]*dynamodb.AttributeValue{
+				KeyAttribute:    {S: aws.String(parcelKeyPrefix + p.TrackingNumber)},
+				parcelAttribute: {S: aws.String(s.TrackingNumber)},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error indexing parcel %s in DynamoDB: %s", p.TrackingNumber, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Get returns the shipment with the tracking number. The read is strongly consistent,
+// so it sees the changes of other instances that completed before.
+func (m manager) Get(trackingNumber string) (shipper.Shipment, error) {
+	res, err := m.svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(m.table),
+		Key:            key(trackingNumber),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return shipper.Shipment{}, fmt.Errorf("error loading shipment from DynamoDB: %s", err.Error())
+	}
+
+	if len(res.Item) == 0 {
+		return shipper.Shipment{}, store.ErrNotFound
+	}
+
+	return decode(res.Item)
+}
+
+// List returns all shipments, ordered by the moment they were created.
+func (m manager) List() ([]shipper.Shipment, error) {
+	shipments := make(map[string]shipper.Shipment)
+
+	var derr error
+	err := m.svc.ScanPages(&dynamodb.ScanInput{
+		TableName:      aws.String(m.table),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			if _, ok := item[parcelAttribute]; ok {
+				continue
+			}
+			s, err := decode(item)
+			if err != nil {
+				derr = err
+				return false
+			}
+			shipments[s.TrackingNumber] = s
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing shipments in DynamoDB: %s", err.Error())
+	}
+	if derr != nil {
+		return nil, derr
+	}
+
+	return memory.Sorted(shipments), nil
+}
+
+// Delete removes the shipment with the tracking number and the items of its parcels.
+func (m manager) Delete(trackingNumber string) error {
+	res, err := m.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:    aws.String(m.table),
+		Key:          key(trackingNumber),
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting shipment from DynamoDB: %s", err.Error())
+	}
+
+	if len(res.Attributes) == 0 {
+		return nil
+	}
+	s, err := decode(res.Attributes)
+	if err != nil {
+		return err
+	}
+	for _, p := range s.Parcels {
+		if p.TrackingNumber == s.TrackingNumber {
+			continue
+		}
+		_, err := m.svc.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(m.table),
+			Key:       key(parcelKeyPrefix + p.TrackingNumber),
+		})
+		if err != nil {
+			return fmt.Errorf("error deleting parcel %s from DynamoDB: %s", p.TrackingNumber, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// FindParcel returns the shipment with the parcel with the tracking number, using the item
+// of the parcel.
+func (m manager) FindParcel(trackingNumber string) (shipper.Shipment, error) {
+	res, err := m.svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(m.table),
+		Key:            key(parcelKeyPrefix + trackingNumber),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return shipper.Shipment{}, fmt.Errorf("error loading parcel from DynamoDB: %s", err.Error())
+	}
+
+	value, ok := res.Item[parcelAttribute]
+	if !ok || value.S == nil {
+		return shipper.Shipment{}, store.ErrNotFound
+	}
+
+	return m.Get(*value.S)
+}
+
+// Check verifies that the table exists and can be used.
+func (m manager) Check(ctx context.Context) error {
+	res, err := m.svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(m.table),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing DynamoDB table: %s", err.Error())
+	}
+
+	if status := aws.StringValue(res.Table.TableStatus); status != dynamodb.TableStatusActive && status != dynamodb.TableStatusUpdating {
+		return fmt.Errorf("DynamoDB table %s is %s", m.table, status)
+	}
+
+	return nil
+}
+
+// key returns the key of the item of the shipment with the tracking number.
+func key(trackingNumber string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		KeyAttribute: {S: aws.String(trackingNumber)},
+	}
+}
+
+// decode returns the shipment of the item.
+func decode(item map[string]*dynamodb.AttributeValue) (shipper.Shipment, error) {
+	var s shipper.Shipment
+
+	value, ok := item[shipmentAttribute]
+	if !ok || value.S == nil {
+		return s, fmt.Errorf("item %s in DynamoDB has no shipment", aws.StringValue(item[KeyAttribute].S))
+	}
+
+	if err := json.Unmarshal([]byte(*value.S), &s); err != nil {
+		return s, fmt.Errorf("error decoding shipment %s from DynamoDB: %s", aws.StringValue(item[KeyAttribute].S), err.Error())
+	}
+
+	return s, nil
+}
@@ -0,0 +1,192 @@
+// Package file keeps all shipments in a JSON file on disk, so shipments
+// survive a restart of the service. The file is rewritten on every change,
+// which makes it suitable for local development and small deployments with
+// a persistent volume.
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/store/memory"
+)
+
+// manager is a struct that implements the methods of the
+// Store interface.
+type manager struct {
+	mu        sync.RWMutex
+	path      string
+	shipments map[string]shipper.Shipment
+
+	// parcels is the tracking number of the shipment of every parcel.
+	parcels map[string]string
+}
+
+// New creates a new instance of the Store with a JSON file
+// as the storage layer. Existing shipments are read from the
+// file at path, which is created when the first shipment is
+// saved. The method returns an error if the file can't be read.
+func New(path string) (store.Store, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the path of the store file can't be empty")
+	}
+
+	m := &manager{
+		path:      path,
+		shipments: make(map[string]shipper.Shipment),
+		parcels:   make(map[string]string),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return m, nil
+	case err != nil:
+		return nil, fmt.Errorf("error reading store file: %s", err.Error())
+	}
+
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &m.shipments); err != nil {
+			return nil, fmt.Errorf("error parsing store file %s: %s", path, err.Error())
+		}
+	}
+
+	for _, s := range m.shipments {
+		memory.IndexParcels(m.parcels, s)
+	}
+
+	return m, nil
+}
+
+// Save creates or replaces the shipment, when the stored shipment has its version, and
+// writes all shipments to disk.
+func (m *manager) Save(s shipper.Shipment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev, existed := m.shipments[s.TrackingNumber]
+	if prev.Version != s.Version {
+		return store.ErrConflict
+	}
+
+	s.Version++
+	m.shipments[s.TrackingNumber] = s
+
+	if err := m.write(); err != nil {
+		// Keep memory and disk consistent when the write fails
+		if existed {
+			m.shipments[s.TrackingNumber] = prev
+		} else {
+			delete(m.shipments, s.TrackingNumber)
+		}
+		return err
+	}
+	memory.IndexParcels(m.parcels, s)
+
+	return nil
+}
+
+// Get returns the shipment with the tracking number.
+func (m *manager) Get(trackingNumber string) (shipper.Shipment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.shipments[trackingNumber]
+	if !ok {
+		return shipper.Shipment{}, store.ErrNotFound
+	}
+
+	return s, nil
+}
+
+// List returns all shipments, ordered by the moment they were created.
+func (m *manager) List() ([]shipper.Shipment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return memory.Sorted(m.shipments), nil
+}
+
+// Delete removes the shipment with the tracking number and writes all shipments to disk.
+func (m *manager) Delete(trackingNumber string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev, existed := m.shipments[trackingNumber]
+	if !existed {
+		return nil
+	}
+	delete(m.shipments, trackingNumber)
+
+	if err := m.write(); err != nil {
+		// Keep memory and disk consistent when the write fails
+		m.shipments[trackingNumber] = prev
+		return err
+	}
+	memory.UnindexParcels(m.parcels, prev)
+
+	return nil
+}
+
+// FindParcel returns the shipment with the parcel with the tracking number.
+func (m *manager) FindParcel(trackingNumber string) (shipper.Shipment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.shipments[m.parcels[trackingNumber]]
+	if !ok {
+		return shipper.Shipment{}, store.ErrNotFound
+	}
+
+	return s, nil
+}
+
+// Check verifies that the directory of the store file is writable.
+func (m *manager) Check(ctx context.Context) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(m.path), filepath.Base(m.path)+".check.*")
+	if err != nil {
+		return fmt.Errorf("store directory is not writable: %s", err.Error())
+	}
+
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// write writes all shipments to a temporary file and renames it, so a
+// crash while writing never leaves a partial file behind.
+func (m *manager) write() error {
+	b, err := json.MarshalIndent(m.shipments, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(m.path), filepath.Base(m.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error writing store file: %s", err.Error())
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing store file: %s", err.Error())
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing store file: %s", err.Error())
+	}
+
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing store file: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package memory keeps all shipments in memory. This is useful for
+// testing and for services that don't need to survive a restart, but
+// all shipments are lost when the service stops.
+package memory
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+)
+
+// manager is a struct that implements the methods of the
+// Store interface.
+type manager struct {
+	mu        sync.RWMutex
+	shipments map[string]shipper.Shipment
+
+	// parcels is the tracking number of the shipment of every parcel.
+	parcels map[string]string
+}
+
+// New creates a new instance of the Store with memory
+// as the storage layer.
+func New() store.Store {
+	return &manager{
+		shipments: make(map[string]shipper.Shipment),
+		parcels:   make(map[string]string),
+	}
+}
+
+// Save creates or replaces the shipment, when the stored shipment has its version.
+func (m *manager) Save(s shipper.Shipment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.shipments[s.TrackingNumber].Version != s.Version {
+		return store.ErrConflict
+	}
+
+	s.Version++
+	m.shipments[s.TrackingNumber] = s
+	IndexParcels(m.parcels, s)
+
+	return nil
+}
+
+// Get returns the shipment with the tracking number.
+func (m *manager) Get(trackingNumber string) (shipper.Shipment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.shipments[trackingNumber]
+	if !ok {
+		return shipper.Shipment{}, store.ErrNotFound
+	}
+
+	return s, nil
+}
+
+// List returns all shipments, ordered by the moment they were created.
+func (m *manager) List() ([]shipper.Shipment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return Sorted(m.shipments), nil
+}
+
+// Delete removes the shipment with the tracking number.
+func (m *manager) Delete(trackingNumber string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	UnindexParcels(m.parcels, m.shipments[trackingNumber])
+	delete(m.shipments, trackingNumber)
+
+	return nil
+}
+
+// FindParcel returns the shipment with the parcel with the tracking number.
+func (m *manager) FindParcel(trackingNumber string) (shipper.Shipment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.shipments[m.parcels[trackingNumber]]
+	if !ok {
+		return shipper.Shipment{}, store.ErrNotFound
+	}
+
+	return s, nil
+}
+
+// Sorted returns the shipments in the map ordered by the moment they were
+// created, so other storage layers that keep a map have the same ordering.
+func Sorted(shipments map[string]shipper.Shipment) []shipper.Shipment {
+	res := make([]shipper.Shipment, 0, len(shipments))
+	for _, s := range shipments {
+		res = append(res, s)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].CreatedAt.Equal(res[j].CreatedAt) {
+			return res[i].TrackingNumber < res[j].TrackingNumber
+		}
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+
+	return res
+}
+
+// IndexParcels adds the parcels of the shipment to the index of the tracking numbers of
+// parcels, so other storage layers that keep a map have the same index.
+func IndexParcels(parcels map[string]string, s shipper.Shipment) {
+	for _, p := range s.Parcels {
+		parcels[p.TrackingNumber] = s.TrackingNumber
+	}
+}
+
+// UnindexParcels removes the parcels of the shipment from the index of the tracking
+// numbers of parcels.
+func UnindexParcels(parcels map[string]string, s shipper.Shipment) {
+	for _, p := range s.Parcels {
+		if parcels[p.TrackingNumber] == s.TrackingNumber {
+			delete(parcels, p.TrackingNumber)
+		}
+	}
+}
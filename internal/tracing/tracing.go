@@ -0,0 +1,147 @@
+// Package tracing configures OpenTelemetry for the Shipment service and propagates
+// the trace context across the queues and buses between the services of the ACME
+// Serverless Fitness Shop. The trace context travels as a W3C traceparent in a plain
+// map, which every transport converts to its own format, like SQS message attributes,
+// a field in the EventBridge detail or HTTP headers.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// instrumentation is the name of the tracer used for all spans of the service.
+	instrumentation = "github.com/retgits/acme-serverless-shipment"
+
+	// ContextField is the name of the field that carries the trace context in
+	// the JSON payload of events that have no headers, like the EventBridge detail.
+	ContextField = "traceContext"
+)
+
+// Options configure the exporter of the spans.
+type Options struct {
+	// Exporter is the exporter of the spans: none, stdout or otlp.
+	Exporter string
+
+	// Endpoint is the URL of the OTLP/HTTP collector. When it is empty, the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable or the default of the
+	// OpenTelemetry SDK is used.
+	Endpoint string
+
+	// Service is the name of the service in the spans.
+	Service string
+
+	// Version is the version of the service in the spans.
+	Version string
+}
+
+// Provider flushes and stops the exporter of the spans.
+type Provider struct {
+	tp *sdktrace.TracerProvider
+}
+
+// Init configures the global OpenTelemetry tracer provider and the W3C trace context
+// propagator. When the exporter is none, spans are created to propagate the trace
+// context but they are not exported.
+func Init(o Options) (*Provider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch o.Exporter {
+	case "", "none":
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if o.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(o.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, use none, stdout or otlp", o.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s exporter: %s", o.Exporter, err.Error())
+	}
+
+	res := resource.NewSchemaless(
+		semconv.ServiceName(o.Service),
+		semconv.ServiceVersion(o.Version),
+	)
+
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+
+	return &Provider{tp: tp}, nil
+}
+
+// Flush exports all spans that have ended, like at the end of a Lambda invocation.
+func (p *Provider) Flush(ctx context.Context) error {
+	return p.tp.ForceFlush(ctx)
+}
+
+// Shutdown flushes all spans and stops the exporter.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	return p.tp.Shutdown(ctx)
+}
+
+// Start creates a span with the name that is a child of the span in ctx, if any.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartKind is like Start, but sets the kind of the span, like producer or consumer.
+func StartKind(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject returns the trace context of ctx as a map, with keys like traceparent.
+// The map is empty when ctx has no span.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// Extract returns a copy of ctx with the trace context from the map, so new spans
+// become children of the remote span.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// Fields returns the names of the keys the propagator uses, like traceparent.
+func Fields() []string {
+	return otel.GetTextMapPropagator().Fields()
+}
@@ -0,0 +1,352 @@
+// Package tracking contains the poller that asks the carriers that don't send tracking
+// webhooks for the scans of the shipments that are in flight. A shipment is polled again
+// sooner when it moved recently than when it sits in a warehouse, the requests to every
+// carrier are paced and a carrier that fails is left alone for a while, longer after
+// every failure.
+package tracking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/carrier"
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+)
+
+// Update applies the new scans of the shipment with the tracking number, which the carrier
+// reported, and stores the shipment with the moment it is polled again. It is called after
+// every poll of a shipment, also when there are no new scans.
+type Update func(ctx context.Context, trackingNumber string, scans []shipper.Scan, pollAt time.Time) error
+
+// Options are the intervals and limits of the Poller.
+type Options struct {
+	// MinInterval is the shortest time between two polls of a shipment, like the one of
+	// a shipment that is out for delivery, and the first backoff of a carrier that fails.
+	MinInterval time.Duration
+
+	// MaxInterval is the longest time between two polls of a shipment.
+	MaxInterval time.Duration
+
+	// Rate is the maximum number of requests per second the Poller sends to a carrier.
+	Rate float64
+
+	// MaxBackoff is the longest the Poller leaves a carrier alone after it failed.
+	MaxBackoff time.Duration
+}
+
+// Result is the outcome of a poll of the shipments that are in flight.
+type Result struct {
+	// Polled is the number of shipments the carriers were asked about.
+	Polled int `json:"polled"`
+
+	// Updated is the number of shipments with new scans.
+	Updated int `json:"updated"`
+
+	// Skipped is the number of shipments that were due, but of which the carrier failed
+	// or is left alone after it failed.
+	Skipped int `json:"skipped"`
+
+	// Failed is the number of polled shipments the Update failed for.
+	Failed int `json:"failed"`
+}
+
+// Poller asks the carriers for the scans of the shipments that are in flight.
+type Poller struct {
+	trackers  map[string]carrier.Tracker
+	shipments store.Store
+	update    Update
+	opts      Options
+	clock     clock.Clock
+
+	// mu makes sure only one poll runs at a time, which is the only one to use the limiters.
+	mu       sync.Mutex
+	limiters map[string]*limiter
+}
+
+// limiter paces the requests to a carrier and keeps track of its failures.
+type limiter struct {
+	// next is the moment the next request can be sent.
+	next time.Time
+
+	// until is the moment the carrier is asked again after it failed.
+	until time.Time
+
+	// failures is the number of times in a row the carrier failed.
+	failures int
+}
+
+// New creates a new Poller that asks the Trackers for the scans of the shipments of their
+// carriers in the Store and passes the scans to update.
+func New(trackers []carrier.Tracker, shipments store.Store, update Update, opts Options) *Poller {
+	p := &Poller{
+		trackers:  make(map[string]carrier.Tracker, len(trackers)),
+		shipments: shipments,
+		update:    update,
+		opts:      opts,
+		clock:     clock.Real{},
+		limiters:  make(map[string]*limiter, len(trackers)),
+	}
+
+	for _, t := range trackers {
+		p.trackers[t.Carrier()] = t
+		p.limiters[t.Carrier()] = &limiter{}
+	}
+
+	return p
+}
+
+// SetClock replaces the clock of the Poller, which is the real clock by default.
+func (p *Poller) SetClock(c clock.Clock) {
+	p.clock = c
+}
+
+// Run polls the shipments every interval until ctx is done. A poll that fails is logged
+// and tried again at the next interval.
+func (p *Poller) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res, err := p.Poll(ctx)
+			if err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "error polling carriers", logging.Err(err), "polled", res.Polled, "failed", res.Failed)
+				continue
+			}
+			slog.DebugContext(ctx, "carriers polled", "polled", res.Polled, "updated", res.Updated, "skipped", res.Skipped)
+		}
+	}
+}
+
+// Poll asks the carriers for the scans of the shipments that are in flight and due to be
+// polled, oldest first, and passes them to the Update. A shipment that can't be updated
+// doesn't stop the poll of the others, it is polled again at the next poll. It returns an
+// error when the shipments can't be loaded, when ctx is done before all of them are polled,
+// or with the errors of the shipments that couldn't be updated.
+func (p *Poller) Poll(ctx context.Context) (Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var res Result
+
+	list, err := p.shipments.List()
+	if err != nil {
+		return res, err
+	}
+
+	now := p.clock.Now()
+	var due []shipper.Shipment
+	for _, s := range list {
+		if _, ok := p.trackers[carrierOf(s)]; ok && !shipper.Done(s) && !s.PollAt.After(now) {
+			due = append(due, s)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].PollAt.Before(due[j].PollAt)
+	})
+
+	var failed []string
+	for _, s := range due {
+		name := carrierOf(s)
+		sctx := logging.With(ctx, logging.Carrier, name, logging.TrackingNumber, s.TrackingNumber)
+
+		l := p.limiters[name]
+		if l.until.After(p.clock.Now()) {
+			res.Skipped++
+			continue
+		}
+
+		scans, err := p.track(sctx, p.trackers[name], l, s)
+		switch {
+		case ctx.Err() != nil:
+			return res, ctx.Err()
+		case err != nil:
+			p.backoff(sctx, l, err)
+			res.Skipped++
+			continue
+		}
+		l.failures = 0
+
+		res.Polled++
+
+		next := s
+		next.Scans = append(append([]shipper.Scan(nil), s.Scans...), scans...)
+		pollAt := p.clock.Now().Add(interval(next, p.clock.Now(), p.opts))
+
+		if err := p.update(sctx, s.TrackingNumber, scans, pollAt); err != nil {
+			slog.ErrorContext(sctx, "error updating polled shipment", logging.Err(err))
+			res.Failed++
+			failed = append(failed, s.TrackingNumber+": "+err.Error())
+			continue
+		}
+		if len(scans) > 0 {
+			res.Updated++
+		}
+	}
+
+	if len(failed) > 0 {
+		return res, fmt.Errorf("error updating %d of %d shipments: %s", len(failed), res.Polled, strings.Join(failed, "; "))
+	}
+	return res, nil
+}
+
+// track asks the carrier for the scans of the shipment, or of its parcels that aren't
+// delivered yet, and returns the valid scans that weren't applied to the shipment before.
+func (p *Poller) track(ctx context.Context, t carrier.Tracker, l *limiter, s shipper.Shipment) ([]shipper.Scan, error) {
+	var scans []shipper.Scan
+	seen := s
+
+	for _, tn := range trackingNumbers(s) {
+		if err := p.wait(ctx, l); err != nil {
+			return nil, err
+		}
+
+		reported, err := t.Track(ctx, tn)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, scan := range reported {
+			// Only keep the scans of the tracking number, in the format of the shipment
+			if parsed, err := shipper.ParseTrackingNumber(scan.TrackingNumber); err == nil {
+				scan.TrackingNumber = parsed.Number
+			}
+			if scan.TrackingNumber != tn {
+				continue
+			}
+
+			if err := shipper.ValidateScan(scan); err != nil {
+				slog.WarnContext(ctx, "ignoring invalid scan of carrier", logging.Err(err))
+				continue
+			}
+			if shipper.HasScan(seen, scan) {
+				continue
+			}
+
+			scans = append(scans, scan)
+			seen.Scans = append(seen.Scans, scan)
+		}
+	}
+
+	return scans, nil
+}
+
+// wait waits until the next request can be sent to the carrier of the limiter, so no more
+// than Rate requests are sent every second.
+func (p *Poller) wait(ctx context.Context, l *limiter) error {
+	now := p.clock.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	if p.opts.Rate > 0 {
+		l.next = at.Add(time.Duration(float64(time.Second) / p.opts.Rate))
+	}
+
+	if !at.After(now) {
+		return nil
+	}
+
+	t := p.clock.NewTimer(at.Sub(now))
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff leaves the carrier of the limiter alone after it failed, twice as long as after
+// the previous failure, up to MaxBackoff, or as long as the carrier asked when it is longer.
+func (p *Poller) backoff(ctx context.Context, l *limiter, err error) {
+	l.failures++
+
+	d := p.opts.MinInterval
+	for i := 1; i < l.failures && d < p.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.opts.MaxBackoff {
+		d = p.opts.MaxBackoff
+	}
+
+	var rerr *carrier.RateLimitError
+	if errors.As(err, &rerr) && rerr.RetryAfter > d {
+		d = rerr.RetryAfter
+	}
+
+	l.until = p.clock.Now().Add(d)
+	slog.WarnContext(ctx, "carrier failed, backing off", "failures", l.failures, "backoff", d.String(), logging.Err(err))
+}
+
+// interval returns how long to wait before the shipment is polled again: MinInterval when
+// it is out for delivery, until its pickup when it wasn't scanned yet, and otherwise a
+// quarter of the time since it was last scanned, or handed to the shipper, within
+// MinInterval and MaxInterval.
+func interval(s shipper.Shipment, now time.Time, opts Options) time.Duration {
+	last := s.CreatedAt
+	var event string
+	for _, scan := range s.Scans {
+		if !scan.At.Before(last) {
+			last = scan.At
+			event = scan.Event
+		}
+	}
+
+	var d time.Duration
+	switch {
+	case event == shipper.ScanOutForDelivery:
+		d = opts.MinInterval
+	case len(s.Scans) == 0 && s.PickupAt.After(now):
+		d = s.PickupAt.Sub(now)
+	default:
+		d = now.Sub(last) / 4
+	}
+
+	if d < opts.MinInterval {
+		d = opts.MinInterval
+	}
+	if d > opts.MaxInterval {
+		d = opts.MaxInterval
+	}
+	return d
+}
+
+// trackingNumbers returns the tracking numbers the carrier is asked about: the ones of the
+// parcels that aren't delivered yet, or the one of the shipment when it has no parcels.
+func trackingNumbers(s shipper.Shipment) []string {
+	if len(s.Parcels) == 0 {
+		return []string{s.TrackingNumber}
+	}
+
+	var numbers []string
+	for _, p := range s.Parcels {
+		if p.Status != shipper.StatusDelivered {
+			numbers = append(numbers, p.TrackingNumber)
+		}
+	}
+	return numbers
+}
+
+// carrierOf returns the carrier that issued the tracking number of the shipment, or an
+// empty string when it isn't the format of a known carrier.
+func carrierOf(s shipper.Shipment) string {
+	tn, err := shipper.ParseTrackingNumber(s.TrackingNumber)
+	if err != nil {
+		return ""
+	}
+	return tn.Carrier
+}
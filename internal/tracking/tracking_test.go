@@ -0,0 +1,276 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/retgits/acme-serverless-shipment/internal/carrier"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/pull"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/webhook"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/store/memory"
+)
+
+var t0 = time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+
+var opts = Options{MinInterval: time.Minute, MaxInterval: time.Hour, Rate: 2, MaxBackoff: 15 * time.Minute}
+
+// fakeClock is a clock that jumps forward instead of waiting.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) *time.Timer {
+	c.Advance(d)
+	return time.NewTimer(0)
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeUSPS is a fake tracking API of USPS that responds with the events of the tracking
+// numbers it knows, or with the status when it is set.
+type fakeUSPS struct {
+	clock  *fakeClock
+	events map[string]string
+
+	mu       sync.Mutex
+	status   int
+	requests []time.Time
+}
+
+func (f *fakeUSPS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, f.clock.Now())
+
+	tn := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case f.status == http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", "600")
+		w.WriteHeader(f.status)
+	case f.status != 0:
+		w.WriteHeader(f.status)
+	case f.events[tn] != "":
+		w.Write([]byte(`{"trackingNumber":"` + tn + `","trackingEvents":[` + f.events[tn] + `]}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newPoller creates a Poller of the shipments in a memory store that asks the fake USPS
+// API, and applies the scans to the store.
+func newPoller(t *testing.T, f *fakeUSPS, shipments ...shipper.Shipment) (*Poller, store.Store) {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	p, _ := webhook.Find("usps")
+	tr, err := pull.New(p, server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := memory.New()
+	for _, s := range shipments {
+		if err := st.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update := func(ctx context.Context, trackingNumber string, scans []shipper.Scan, pollAt time.Time) error {
+		s, err := st.Get(trackingNumber)
+		if err != nil {
+			return err
+		}
+		for _, scan := range scans {
+			s, _ = shipper.ApplyScan(s, scan)
+		}
+		s.PollAt = pollAt
+		return st.Save(s)
+	}
+
+	poller := New([]carrier.Tracker{tr}, st, update, opts)
+	poller.SetClock(f.clock)
+	return poller, st
+}
+
+// newShipment returns a shipment of the carrier that was handed to the shipper an hour ago.
+func newShipment(name string, pollAt time.Time) shipper.Shipment {
+	s := shipper.Shipment{Carrier: name, CreatedAt: t0.Add(-time.Hour), PickupAt: t0.Add(-30 * time.Minute), DeliverAt: t0.Add(time.Hour), PollAt: pollAt}
+	s.TrackingNumber = shipper.NewTrackingNumber(name)
+	s.OrderNumber = "order-" + s.TrackingNumber
+	s.Status = shipper.StatusShipped
+	return s
+}
+
+func TestPoll(t *testing.T) {
+	c := &fakeClock{now: t0}
+
+	moving := newShipment("USPS", t0.Add(-2*time.Minute))
+	waiting := newShipment("USPS", t0.Add(-time.Minute))
+	waiting.PickupAt = t0.Add(30 * time.Minute)
+	delivered := newShipment("USPS", time.Time{})
+	delivered.Status = shipper.StatusDelivered
+	later := newShipment("USPS", t0.Add(10*time.Minute))
+	other := newShipment("FedEx", time.Time{})
+
+	f := &fakeUSPS{clock: c, events: map[string]string{
+		moving.TrackingNumber:    `{"eventCode":"03","eventTimestamp":"2020-04-01T11:40:00Z"},{"eventCode":"OF","eventTimestamp":"2020-04-01T11:50:00Z","eventCity":"RENO","eventState":"NV"}`,
+		delivered.TrackingNumber: `{"eventCode":"01","eventTimestamp":"2020-04-01T11:50:00Z"}`,
+	}}
+	poller, st := newPoller(t, f, moving, waiting, delivered, later, other)
+
+	res, err := poller.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (Result{Polled: 2, Updated: 1}) {
+		t.Errorf("got result %+v, want 2 polled and 1 updated", res)
+	}
+
+	// The requests were paced by the rate of the carrier
+	want := []time.Time{t0, t0.Add(500 * time.Millisecond)}
+	if len(f.requests) != len(want) || !f.requests[0].Equal(want[0]) || !f.requests[1].Equal(want[1]) {
+		t.Errorf("got requests at %v, want %v", f.requests, want)
+	}
+
+	// The shipment that is out for delivery is polled again soon, the one that wasn't
+	// picked up yet when it is
+	got, _ := st.Get(moving.TrackingNumber)
+	if len(got.Scans) != 2 || !got.PollAt.Equal(t0.Add(time.Minute)) {
+		t.Errorf("got shipment with scans %+v polled at %s, want 2 scans polled at %s", got.Scans, got.PollAt, t0.Add(time.Minute))
+	}
+	got, _ = st.Get(waiting.TrackingNumber)
+	if len(got.Scans) != 0 || !got.PollAt.Equal(waiting.PickupAt) {
+		t.Errorf("got shipment with scans %+v polled at %s, want none polled at the pickup", got.Scans, got.PollAt)
+	}
+	for _, s := range []shipper.Shipment{delivered, later, other} {
+		if got, _ := st.Get(s.TrackingNumber); !got.PollAt.Equal(s.PollAt) {
+			t.Errorf("got shipment %s polled, want it left alone", s.TrackingNumber)
+		}
+	}
+
+	// The scans that were applied before are not applied again
+	c.Advance(time.Minute)
+	if res, err := poller.Poll(context.Background()); err != nil || res != (Result{Polled: 1}) {
+		t.Errorf("got result %+v and error %v, want 1 polled without updates", res, err)
+	}
+}
+
+func TestPollUpdateFails(t *testing.T) {
+	c := &fakeClock{now: t0}
+
+	failing := newShipment("USPS", t0.Add(-2*time.Minute))
+	moving := newShipment("USPS", t0.Add(-time.Minute))
+
+	f := &fakeUSPS{clock: c, events: map[string]string{
+		failing.TrackingNumber: `{"eventCode":"03","eventTimestamp":"2020-04-01T11:40:00Z"}`,
+		moving.TrackingNumber:  `{"eventCode":"03","eventTimestamp":"2020-04-01T11:40:00Z"}`,
+	}}
+	poller, st := newPoller(t, f, failing, moving)
+
+	update := poller.update
+	poller.update = func(ctx context.Context, trackingNumber string, scans []shipper.Scan, pollAt time.Time) error {
+		if trackingNumber == failing.TrackingNumber {
+			return errors.New("store down")
+		}
+		return update(ctx, trackingNumber, scans, pollAt)
+	}
+
+	// The shipment after the one that failed is still updated, and the poll reports the
+	// failure
+	res, err := poller.Poll(context.Background())
+	if err == nil || !strings.Contains(err.Error(), failing.TrackingNumber) {
+		t.Errorf("got error %v, want the error of %s", err, failing.TrackingNumber)
+	}
+	if res != (Result{Polled: 2, Updated: 1, Failed: 1}) {
+		t.Errorf("got result %+v, want 2 polled, 1 updated and 1 failed", res)
+	}
+	if got, _ := st.Get(moving.TrackingNumber); len(got.Scans) != 1 {
+		t.Errorf("got shipment with scans %+v, want the polled scan", got.Scans)
+	}
+	if got, _ := st.Get(failing.TrackingNumber); len(got.Scans) != 0 || !got.PollAt.Equal(failing.PollAt) {
+		t.Errorf("got shipment with scans %+v polled at %s, want it unchanged", got.Scans, got.PollAt)
+	}
+}
+
+func TestPollBackoff(t *testing.T) {
+	c := &fakeClock{now: t0}
+	f := &fakeUSPS{clock: c}
+	poller, _ := newPoller(t, f, newShipment("USPS", time.Time{}), newShipment("USPS", time.Time{}))
+
+	// The carrier is left alone longer after every failure, and as long as it asked when it
+	// was rate limited
+	steps := []struct {
+		advance      time.Duration
+		status       int
+		wantRequests int
+		wantResult   Result
+	}{
+		{advance: 0, status: http.StatusInternalServerError, wantRequests: 1, wantResult: Result{Skipped: 2}},
+		{advance: 59 * time.Second, status: 0, wantRequests: 0, wantResult: Result{Skipped: 2}},
+		{advance: time.Second, status: http.StatusInternalServerError, wantRequests: 1, wantResult: Result{Skipped: 2}},
+		{advance: 2 * time.Minute, status: http.StatusTooManyRequests, wantRequests: 1, wantResult: Result{Skipped: 2}},
+		{advance: 9 * time.Minute, status: 0, wantRequests: 0, wantResult: Result{Skipped: 2}},
+		{advance: time.Minute, status: 0, wantRequests: 2, wantResult: Result{Polled: 2}},
+	}
+
+	for i, step := range steps {
+		c.Advance(step.advance)
+		f.mu.Lock()
+		f.status = step.status
+		f.requests = nil
+		f.mu.Unlock()
+
+		res, err := poller.Poll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != step.wantResult || len(f.requests) != step.wantRequests {
+			t.Errorf("step %d: got result %+v after %d requests, want %+v after %d", i, res, len(f.requests), step.wantResult, step.wantRequests)
+		}
+	}
+}
+
+func TestInterval(t *testing.T) {
+	scan := func(event string, at time.Time) []shipper.Scan {
+		return []shipper.Scan{{Event: event, At: at}}
+	}
+
+	tests := []struct {
+		name string
+		s    shipper.Shipment
+		want time.Duration
+	}{
+		{name: "just created", s: shipper.Shipment{CreatedAt: t0.Add(-time.Minute), PickupAt: t0.Add(-time.Second)}, want: time.Minute},
+		{name: "before pickup", s: shipper.Shipment{CreatedAt: t0, PickupAt: t0.Add(20 * time.Minute)}, want: 20 * time.Minute},
+		{name: "scanned recently", s: shipper.Shipment{CreatedAt: t0.Add(-24 * time.Hour), Scans: scan(shipper.ScanInTransit, t0.Add(-20*time.Minute))}, want: 5 * time.Minute},
+		{name: "not scanned for long", s: shipper.Shipment{CreatedAt: t0.Add(-48 * time.Hour), Scans: scan(shipper.ScanPickedUp, t0.Add(-24*time.Hour))}, want: time.Hour},
+		{name: "out for delivery", s: shipper.Shipment{CreatedAt: t0.Add(-48 * time.Hour), Scans: scan(shipper.ScanOutForDelivery, t0.Add(-8*time.Hour))}, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interval(tt.s, t0, opts); got != tt.want {
+				t.Errorf("got interval %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package warehouse
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaults are the built-in warehouses, which are used when no warehouses are configured.
+//
+//go:embed defaults/warehouses.yaml
+var defaults []byte
+
+// Default returns the built-in warehouses in Reno and San Francisco.
+func Default() ([]Warehouse, error) {
+	return Parse("warehouses.yaml", defaults)
+}
+
+// Load reads and validates the warehouses in the YAML or JSON file.
+func Load(file string) ([]Warehouse, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading warehouses: %s", err.Error())
+	}
+	return Parse(file, b)
+}
+
+// Parse parses and validates the warehouses in the file with the name, which is a list
+// of warehouses in JSON when the name ends with .json and in YAML otherwise. The
+// warehouses are only returned when all of them are valid and no ID is used twice.
+func Parse(name string, b []byte) ([]Warehouse, error) {
+	var warehouses []Warehouse
+	var err error
+	if strings.ToLower(path.Ext(name)) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&warehouses)
+	} else {
+		err = yaml.UnmarshalStrict(b, &warehouses)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing warehouses %s: %s", name, err.Error())
+	}
+
+	if len(warehouses) == 0 {
+		return nil, fmt.Errorf("no warehouses found in %s", name)
+	}
+
+	seen := make(map[string]bool)
+	for i, w := range warehouses {
+		if err := w.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid warehouse %d in %s: %s", i+1, name, err.Error())
+		}
+		if seen[w.ID] {
+			return nil, fmt.Errorf("warehouse %s is defined twice in %s", w.ID, name)
+		}
+		seen[w.ID] = true
+	}
+
+	return warehouses, nil
+}
@@ -0,0 +1,175 @@
+// Package warehouse contains the warehouses the Shipment service in the ACME Serverless
+// Fitness Shop ships orders from. Every warehouse has stock rules, patterns of the IDs of
+// the items it stocks, which the shipper uses to pick the warehouse of the items the order
+// service didn't assign to one. An order with items from more than one warehouse is split
+// into a shipment per warehouse.
+package warehouse
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+// Warehouse is a warehouse orders are shipped from.
+type Warehouse struct {
+	// ID is the unique ID of the warehouse, which line items refer to.
+	ID string `yaml:"id" json:"id"`
+
+	// Name is the name of the warehouse, like on the shipping labels.
+	Name string `yaml:"name" json:"name"`
+
+	// Address is the address of the warehouse.
+	Address address.Address `yaml:"address" json:"address"`
+
+	// Stock are the patterns of the IDs of the items the warehouse stocks, like
+	// kettlebell-* or * for every item.
+	Stock []string `yaml:"stock" json:"stock"`
+}
+
+// Stocks returns whether the warehouse stocks the item.
+func (w Warehouse) Stocks(itemID string) bool {
+	for _, pattern := range w.Stock {
+		if ok, _ := path.Match(pattern, itemID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the warehouse has an ID and that its stock rules are valid patterns.
+func (w Warehouse) Validate() error {
+	if strings.TrimSpace(w.ID) == "" {
+		return fmt.Errorf("id is required")
+	}
+	for _, pattern := range w.Stock {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("stock rule %q is not a valid pattern", pattern)
+		}
+	}
+	return nil
+}
+
+// Find returns the warehouse with the ID.
+func Find(warehouses []Warehouse, id string) (Warehouse, bool) {
+	for _, w := range warehouses {
+		if w.ID == id {
+			return w, true
+		}
+	}
+	return Warehouse{}, false
+}
+
+// AssignmentError is returned when the line items or the parcels of an order can't be
+// assigned to the warehouses.
+type AssignmentError struct {
+	// Problem explains why they can't be assigned, like that no warehouse stocks an item.
+	Problem string
+}
+
+// Error returns a message with the problem.
+func (e *AssignmentError) Error() string {
+	return "error assigning warehouses: " + e.Problem
+}
+
+// Part are the line items of an order that are shipped from a single warehouse.
+type Part struct {
+	// Warehouse is the ID of the warehouse.
+	Warehouse string
+
+	// Items are the line items that are shipped from the warehouse, with the
+	// warehouse set.
+	Items []shipper.LineItem
+
+	// Parcels are the parcels that are shipped from the warehouse.
+	Parcels []shipper.ParcelRequest
+}
+
+// Assign assigns the line items to the warehouses and returns a Part per warehouse, in
+// the order of the warehouses. Items the order service assigned keep their warehouse.
+// The other items go to the first warehouse the order service assigned items to that
+// stocks them, or else to the first warehouse that stocks them, which ships the other
+// items it stocks too. The order of the items doesn't change the outcome.
+//
+// When the items are shipped from a single warehouse, all parcels are shipped from it.
+// Otherwise, every parcel needs the warehouse of one of the parts.
+func Assign(warehouses []Warehouse, items []shipper.LineItem, parcels []shipper.ParcelRequest) ([]Part, error) {
+	used := make(map[string]bool)
+	for _, item := range items {
+		if item.Warehouse == "" {
+			continue
+		}
+		if _, ok := Find(warehouses, item.Warehouse); !ok {
+			return nil, &AssignmentError{Problem: fmt.Sprintf("item %s is assigned to unknown warehouse %s", item.ID, item.Warehouse)}
+		}
+		used[item.Warehouse] = true
+	}
+
+	// Add the first warehouse that stocks an item none of the assigned warehouses stock
+	added := make(map[string]bool)
+	for _, item := range items {
+		if item.Warehouse != "" || first(warehouses, item.ID, used) != "" {
+			continue
+		}
+		w := first(warehouses, item.ID, nil)
+		if w == "" {
+			return nil, &AssignmentError{Problem: fmt.Sprintf("no warehouse stocks item %s", item.ID)}
+		}
+		added[w] = true
+	}
+	for w := range added {
+		used[w] = true
+	}
+
+	assigned := make(map[string][]shipper.LineItem)
+	for _, item := range items {
+		if item.Warehouse == "" {
+			item.Warehouse = first(warehouses, item.ID, used)
+		}
+		assigned[item.Warehouse] = append(assigned[item.Warehouse], item)
+	}
+
+	var parts []Part
+	for _, w := range warehouses {
+		if len(assigned[w.ID]) > 0 {
+			parts = append(parts, Part{Warehouse: w.ID, Items: assigned[w.ID]})
+		}
+	}
+
+	if len(parts) == 1 {
+		parts[0].Parcels = parcels
+		return parts, nil
+	}
+
+	for i, p := range parcels {
+		part := -1
+		for j := range parts {
+			if parts[j].Warehouse == p.Warehouse {
+				part = j
+			}
+		}
+		switch {
+		case p.Warehouse == "":
+			return nil, &AssignmentError{Problem: fmt.Sprintf("parcels[%d] needs the warehouse it is shipped from, the order is split", i)}
+		case part == -1:
+			return nil, &AssignmentError{Problem: fmt.Sprintf("parcels[%d] is shipped from %s, which ships none of the items", i, p.Warehouse)}
+		}
+		parts[part].Parcels = append(parts[part].Parcels, p)
+	}
+
+	return parts, nil
+}
+
+// first returns the ID of the first warehouse that stocks the item and is in the set,
+// or of the first warehouse that stocks the item when the set is nil.
+func first(warehouses []Warehouse, itemID string, set map[string]bool) string {
+	for _, w := range warehouses {
+		if (set == nil || set[w.ID]) && w.Stocks(itemID) {
+			return w.ID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+package warehouse
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+)
+
+func TestDefault(t *testing.T) {
+	warehouses, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warehouses) != 2 || warehouses[0].ID != "rno" || warehouses[1].ID != "sfo" {
+		t.Fatalf("got warehouses %+v, want rno and sfo", warehouses)
+	}
+	if warehouses[1].Address.City != "San Francisco" {
+		t.Errorf("got address %+v for sfo, want San Francisco", warehouses[1].Address)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		wantErr string
+	}{
+		{name: "yaml", file: "w.yaml", data: "- {id: a, stock: ['*']}\n- {id: b}"},
+		{name: "json", file: "w.json", data: `[{"id":"a","name":"A","stock":["mat-*"]}]`},
+		{name: "unknown field", file: "w.json", data: `[{"id":"a","stocks":["*"]}]`, wantErr: "error parsing"},
+		{name: "empty", file: "w.yaml", data: "[]", wantErr: "no warehouses"},
+		{name: "no id", file: "w.yaml", data: "- {name: A}", wantErr: "id is required"},
+		{name: "duplicate", file: "w.yaml", data: "- {id: a}\n- {id: a}", wantErr: "defined twice"},
+		{name: "bad pattern", file: "w.yaml", data: "- {id: a, stock: ['[']}", wantErr: "not a valid pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.file, []byte(tt.data))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %q, want none", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAssign(t *testing.T) {
+	warehouses := []Warehouse{
+		{ID: "rno", Stock: []string{"rack-*", "kettlebell-*"}},
+		{ID: "sfo", Stock: []string{"*"}},
+		{ID: "nyc", Stock: []string{"mat-*", "rack-*"}},
+	}
+	item := func(id string, warehouse string) shipper.LineItem {
+		return shipper.LineItem{ID: id, Quantity: 1, Warehouse: warehouse}
+	}
+
+	tests := []struct {
+		name    string
+		items   []shipper.LineItem
+		want    map[string][]string
+		wantErr string
+	}{
+		{
+			name:  "single warehouse",
+			items: []shipper.LineItem{item("mat-1", ""), item("shirt-1", "")},
+			want:  map[string][]string{"sfo": {"mat-1", "shirt-1"}},
+		},
+		{
+			name:  "split by stock",
+			items: []shipper.LineItem{item("mat-1", ""), item("rack-1", "")},
+			want:  map[string][]string{"rno": {"rack-1"}, "sfo": {"mat-1"}},
+		},
+		{
+			name:  "order of the items doesn't matter",
+			items: []shipper.LineItem{item("rack-1", ""), item("mat-1", "")},
+			want:  map[string][]string{"rno": {"rack-1"}, "sfo": {"mat-1"}},
+		},
+		{
+			name:  "prefers a warehouse that ships other items",
+			items: []shipper.LineItem{item("mat-1", "nyc"), item("rack-1", "")},
+			want:  map[string][]string{"nyc": {"mat-1", "rack-1"}},
+		},
+		{
+			name:  "assigned by the order service",
+			items: []shipper.LineItem{item("rack-1", "sfo"), item("kettlebell-1", "")},
+			want:  map[string][]string{"sfo": {"rack-1", "kettlebell-1"}},
+		},
+		{
+			name:    "unknown warehouse",
+			items:   []shipper.LineItem{item("mat-1", "lax")},
+			wantErr: "unknown warehouse lax",
+		},
+		{
+			name:  "not stocked",
+			items: []shipper.LineItem{item("mat-1", "rno"), item("dumbbell-1", "")},
+			want:  map[string][]string{"rno": {"mat-1"}, "sfo": {"dumbbell-1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := Assign(warehouses, tt.items, nil)
+			if tt.wantErr != "" {
+				var aerr *AssignmentError
+				if !errors.As(err, &aerr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string][]string)
+			for _, p := range parts {
+				for _, item := range p.Items {
+					if item.Warehouse != p.Warehouse {
+						t.Errorf("got item %s with warehouse %q in the part of %s", item.ID, item.Warehouse, p.Warehouse)
+					}
+					got[p.Warehouse] = append(got[p.Warehouse], item.ID)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got parts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssignNotStocked(t *testing.T) {
+	_, err := Assign([]Warehouse{{ID: "rno", Stock: []string{"rack-*"}}}, []shipper.LineItem{{ID: "mat-1", Quantity: 1}}, nil)
+
+	var aerr *AssignmentError
+	if !errors.As(err, &aerr) || !strings.Contains(err.Error(), "no warehouse stocks item mat-1") {
+		t.Fatalf("got error %v, want that no warehouse stocks mat-1", err)
+	}
+}
+
+func TestAssignParcels(t *testing.T) {
+	warehouses := []Warehouse{{ID: "rno", Stock: []string{"rack-*"}}, {ID: "sfo", Stock: []string{"*"}}}
+	single := []shipper.LineItem{{ID: "mat-1", Quantity: 1}}
+	split := []shipper.LineItem{{ID: "mat-1", Quantity: 1}, {ID: "rack-1", Quantity: 1}}
+	parcel := func(warehouse string) shipper.ParcelRequest {
+		return shipper.ParcelRequest{Package: shipper.Package{WeightKg: 1}, Warehouse: warehouse}
+	}
+
+	tests := []struct {
+		name    string
+		items   []shipper.LineItem
+		parcels []shipper.ParcelRequest
+		want    []int
+		wantErr string
+	}{
+		{name: "single warehouse", items: single, parcels: []shipper.ParcelRequest{parcel(""), parcel("")}, want: []int{2}},
+		{name: "split", items: split, parcels: []shipper.ParcelRequest{parcel("sfo"), parcel("rno"), parcel("sfo")}, want: []int{1, 2}},
+		{name: "split without parcels", items: split, want: []int{0, 0}},
+		{name: "split without warehouse", items: split, parcels: []shipper.ParcelRequest{parcel("sfo"), parcel("")}, wantErr: "parcels[1] needs the warehouse"},
+		{name: "split from other warehouse", items: split, parcels: []shipper.ParcelRequest{parcel("lax")}, wantErr: "ships none of the items"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := Assign(warehouses, tt.items, tt.parcels)
+			if tt.wantErr != "" {
+				var aerr *AssignmentError
+				if !errors.As(err, &aerr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []int
+			for _, p := range parts {
+				got = append(got, len(p.Parcels))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got parcels per part %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+)
+
+// errSkip stops the update of a shipment that doesn't need to change.
+var errSkip = errors.New("shipment is done")
+
+// maxShipAttempts is the number of times ShipAndStore ships an order again when one of
+// the tracking numbers it issued is taken.
+const maxShipAttempts = 5
+
+// ShipStoredOrder runs the first steps of the shipment workflow for the ShipmentRequested
+// event, which the entrypoints that handle one message at a time share: it validates the
+// address, hands the shipments of the order to the shipper, stores them and sends their
+// events. It returns the stored shipments, which DeliverOrder delivers.
+func (w *Workflow) ShipStoredOrder(ctx context.Context, st store.Store, req Request, cause correlation.IDs) ([]shipper.Shipment, error) {
+	// Record the shipment request
+	w.Requested(req)
+
+	// Validate and normalize the address the shipment is delivered to
+	data, err := w.ValidateAddress(ctx, req.Data)
+	if err != nil {
+		return nil, fmt.Errorf("error validating address: %s", err.Error())
+	}
+
+	// Hand the shipments to the shipper, one for every warehouse the order is shipped
+	// from, keep track of them, so they can be cancelled until they are picked up, and
+	// send the events
+	parts, events, err := w.ShipAndStore(ctx, st, data, cause)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.Emit(ctx, events...); err != nil {
+		return nil, fmt.Errorf("error sending event: %s", err.Error())
+	}
+
+	return parts, nil
+}
+
+// ShipAndStore hands the shipments of the order to the shipper with ShipOrder and stores
+// them. A shipment is only created when its tracking number isn't taken, so when one of
+// them is, the shipments that were stored are removed and the order is shipped again with
+// new tracking numbers. The error of ShipOrder is returned as is.
+func (w *Workflow) ShipAndStore(ctx context.Context, st store.Store, data RequestData, cause correlation.IDs) ([]shipper.Shipment, []Event, error) {
+	for attempt := 1; ; attempt++ {
+		parts, events, err := w.ShipOrder(ctx, data, cause)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = saveNew(ctx, st, parts)
+		switch {
+		case err == nil:
+			return parts, events, nil
+		case err == store.ErrConflict && attempt < maxShipAttempts:
+			slog.WarnContext(ctx, "tracking number is taken, shipping order again", "attempt", attempt)
+		default:
+			return nil, nil, fmt.Errorf("error storing shipment: %s", err.Error())
+		}
+	}
+}
+
+// saveNew creates the shipments in the store, and removes the ones it created when one
+// of them can't be created.
+func saveNew(ctx context.Context, st store.Store, parts []shipper.Shipment) error {
+	for i, s := range parts {
+		if err := st.Save(s); err != nil {
+			for _, p := range parts[:i] {
+				if derr := st.Delete(p.TrackingNumber); derr != nil {
+					slog.ErrorContext(WithShipment(ctx, p), "error removing shipment that wasn't shipped", logging.Err(derr))
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// DeliverOrder waits for the deliveries of the shipments of an order, one for every parcel
+// or part of the order that is delivered on its own day, and sends the events with the new
+// status of the shipments. A shipment that was cancelled, or that the carrier reported
+// delivered, while it waited is not delivered again, and the scans that were stored in the
+// meantime are kept. When another instance stores the shipment while it is delivered, the
+// delivery starts over with the shipment it stored. When all parts of an order that was
+// split are delivered, the OrderDelivered event follows.
+func (w *Workflow) DeliverOrder(ctx context.Context, st store.Store, parts []shipper.Shipment) error {
+	for i := shipper.NextDue(parts); i >= 0; i = shipper.NextDue(parts) {
+		sctx := WithShipment(ctx, parts[i])
+
+		if err := w.WaitForDelivery(sctx, parts[i]); err != nil {
+			return fmt.Errorf("error waiting for delivery: %s", err.Error())
+		}
+
+		stored, err := store.Update(st, parts[i].TrackingNumber, func(s shipper.Shipment) (shipper.Shipment, error) {
+			if shipper.Done(s) {
+				slog.InfoContext(sctx, "skipping delivery of "+s.Status+" shipment")
+				return s, errSkip
+			}
+
+			s, events := w.Delivered(sctx, s)
+			if err := w.Emit(sctx, events...); err != nil {
+				return s, fmt.Errorf("error sending event: %s", err.Error())
+			}
+			return s, nil
+		})
+		switch err {
+		case nil, errSkip:
+			parts[i] = stored
+		default:
+			return fmt.Errorf("error delivering shipment: %s", err.Error())
+		}
+	}
+
+	// Tell the order service all parts of an order that was split are delivered
+	if evt, ok := w.OrderDelivered(ctx, parts); ok {
+		if err := w.Emit(ctx, evt); err != nil {
+			return fmt.Errorf("error sending event: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// CancelStoredOrder cancels the stored shipments of the order in the OrderCancelled event
+// that weren't picked up yet, stores them and sends their ShipmentCancelled events. When
+// another instance stored one of the shipments since they were listed, the cancellation
+// is applied to the shipment it stored. An order of which the shipments can't be cancelled, or of which no shipments are stored,
+// isn't an error, because handling the event again doesn't change that, and is only
+// logged and reported to Sentry.
+func (w *Workflow) CancelStoredOrder(ctx context.Context, st store.Store, c Cancellation, cause correlation.IDs) error {
+	list, err := st.List()
+	if err != nil {
+		return fmt.Errorf("error loading shipments: %s", err.Error())
+	}
+
+	// Store and announce the shipments that were cancelled, also when cancelling one of
+	// the others failed, so they aren't delivered
+	cancelled, events, err := w.CancelOrder(ctx, list, c.Data, cause)
+	for _, s := range cancelled {
+		if _, serr := store.Update(st, s.TrackingNumber, func(stored shipper.Shipment) (shipper.Shipment, error) {
+			if stored.Version == s.Version {
+				return s, nil
+			}
+			return shipper.CancelAt(stored, c.Data.Reason, w.clock.Now())
+		}); serr != nil {
+			return fmt.Errorf("error storing shipment: %s", serr.Error())
+		}
+	}
+	if eerr := w.Emit(ctx, events...); eerr != nil {
+		return fmt.Errorf("error sending event: %s", eerr.Error())
+	}
+
+	switch err {
+	case nil:
+		hub(ctx).CaptureMessage(fmt.Sprintf("order %s successfully cancelled", c.Data.OrderID))
+		return nil
+	case ErrUnknownOrder, shipper.ErrPickedUp, shipper.ErrCancelled:
+		slog.WarnContext(ctx, "shipments of cancelled order not cancelled", logging.Err(err))
+		hub(ctx).CaptureMessage(fmt.Sprintf("shipments of order %s not cancelled: %s", c.Data.OrderID, err.Error()))
+		return nil
+	default:
+		return fmt.Errorf("error cancelling shipments: %s", err.Error())
+	}
+}
+
+// hub returns the Sentry hub of the message that is handled, or the current hub when the
+// message has none.
+func hub(ctx context.Context) *sentry.Hub {
+	if h := sentry.GetHubFromContext(ctx); h != nil {
+		return h
+	}
+	return sentry.CurrentHub()
+}
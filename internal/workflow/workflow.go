@@ -0,0 +1,821 @@
+// Package workflow contains the steps of the shipment workflow that every entrypoint
+// of the Shipment service shares. A shipment is requested, handed to the shipper and,
+// after the simulated delivery or when the carrier reports it, delivered to the customer,
+// unless it is cancelled before the carrier picks it up. A delivered shipment can be
+// returned, which ships a return leg from the customer back to the warehouse. Each step
+// creates the event that is sent to the other services of the ACME Serverless Fitness
+// Shop, leaves a breadcrumb in Sentry and records the metrics of the workflow.
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/address/rules"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier/simulated"
+	"github.com/retgits/acme-serverless-shipment/internal/clock"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter"
+	"github.com/retgits/acme-serverless-shipment/internal/logging"
+	"github.com/retgits/acme-serverless-shipment/internal/metrics"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/tracing"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// source is the source of all events the workflow creates.
+	source = "SendShipment"
+)
+
+const (
+	// ParcelDeliveredEventName is the type of the event that is sent when a parcel of a
+	// shipment with parcels is delivered. The data has the tracking number of the parcel.
+	ParcelDeliveredEventName = "ParcelDelivered"
+
+	// ShipmentPartiallyDeliveredEventName is the type of the event that is sent when some,
+	// but not all, parcels of a shipment are delivered.
+	ShipmentPartiallyDeliveredEventName = "ShipmentPartiallyDelivered"
+
+	// OrderSplitEventName is the type of the event that is sent when an order is shipped
+	// from more than one warehouse. The tracking number of its data has the tracking
+	// numbers of all shipments of the order, separated by commas.
+	OrderSplitEventName = "OrderSplit"
+
+	// OrderDeliveredEventName is the type of the event that is sent when all shipments
+	// of an order that was split are delivered. The data is like the one of OrderSplit.
+	OrderDeliveredEventName = "OrderDelivered"
+
+	// ShipmentCancelledEventName is the type of the event that is sent when a shipment is
+	// cancelled before the carrier picked it up.
+	ShipmentCancelledEventName = "ShipmentCancelled"
+
+	// OrderCancelledEventName is the type of the event the order service sends when an
+	// order is cancelled, which cancels its shipments.
+	OrderCancelledEventName = "OrderCancelled"
+
+	// ReturnRequestedEventName is the type of the event that is sent when the customer
+	// returns a delivered shipment. The data has the tracking number of the return leg.
+	ReturnRequestedEventName = "ReturnRequested"
+
+	// ReturnReceivedEventName is the type of the event that is sent when the return leg
+	// arrives at the warehouse, so the payment service can refund the customer.
+	ReturnReceivedEventName = "ReturnReceived"
+)
+
+var (
+	// ErrUnknownOrder is returned when the shipments of an order are cancelled, but there
+	// are no shipments of the order.
+	ErrUnknownOrder = errors.New("no shipments found for the order")
+)
+
+const (
+	// StatusSplit is the status in the data of the OrderSplit event.
+	StatusSplit = "split"
+)
+
+// Request is a ShipmentRequested event, together with the address the shipment is
+// delivered to when the sender knows it.
+type Request struct {
+	// Metadata for the event.
+	Metadata acmeserverless.Metadata `json:"metadata"`
+
+	// Data contains the payload data for the event.
+	Data RequestData `json:"data"`
+}
+
+// RequestData is the data of a ShipmentRequested event.
+type RequestData struct {
+	acmeserverless.ShipmentRequest
+
+	// Address is the address the shipment is delivered to, if the sender set it.
+	Address *address.Address `json:"address,omitempty"`
+
+	// Parcels are the boxes the shipment is shipped in, if there is more than one.
+	Parcels []shipper.ParcelRequest `json:"parcels,omitempty"`
+
+	// Items are the line items of the order, which are used to pick the warehouses the
+	// order is shipped from.
+	Items []shipper.LineItem `json:"items,omitempty"`
+}
+
+// DecodeRequest unmarshals and validates the ShipmentRequested event in the payload,
+// which every entrypoint receives from an untrusted sender. A payload that doesn't
+// request a shipment for an order, like an empty object, returns an error. The address
+// is only decoded here, it is validated by ValidateAddress.
+func DecodeRequest(payload []byte) (Request, error) {
+	var req Request
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return Request{}, fmt.Errorf("invalid ShipmentRequested event: %s", err.Error())
+	}
+
+	if req.Metadata.Type != "" && req.Metadata.Type != acmeserverless.ShipmentRequestedEventName {
+		return Request{}, fmt.Errorf("invalid ShipmentRequested event: unexpected type %q", req.Metadata.Type)
+	}
+
+	if err := shipper.Validate(req.Data.ShipmentRequest); err != nil {
+		return Request{}, fmt.Errorf("invalid ShipmentRequested event: %s", err.Error())
+	}
+
+	if err := shipper.ValidateParcels(req.Data.Parcels); err != nil {
+		return Request{}, fmt.Errorf("invalid ShipmentRequested event: %s", err.Error())
+	}
+
+	if err := shipper.ValidateItems(req.Data.Items); err != nil {
+		return Request{}, fmt.Errorf("invalid ShipmentRequested event: %s", err.Error())
+	}
+
+	return req, nil
+}
+
+// Cancellation is an OrderCancelled event, which the order service sends when an order is
+// cancelled.
+type Cancellation struct {
+	// Metadata for the event.
+	Metadata acmeserverless.Metadata `json:"metadata"`
+
+	// Data contains the payload data for the event.
+	Data CancellationData `json:"data"`
+}
+
+// CancellationData is the data of an OrderCancelled event.
+type CancellationData struct {
+	// OrderID is the ID of the order that is cancelled.
+	OrderID string `json:"_id"`
+
+	// TrackingNumber is the tracking number of the shipment of the order that is cancelled,
+	// or empty to cancel all shipments of the order.
+	TrackingNumber string `json:"trackingNumber,omitempty"`
+
+	// Reason is why the order is cancelled, if the order service knows.
+	Reason string `json:"reason,omitempty"`
+}
+
+// EventType returns the type in the metadata of the event in the payload, or an empty
+// string when the payload has none, so an entrypoint that receives more than one type of
+// event knows how to decode it.
+func EventType(payload []byte) string {
+	var evt struct {
+		Metadata acmeserverless.Metadata `json:"metadata"`
+	}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return ""
+	}
+	return evt.Metadata.Type
+}
+
+// DecodeCancellation unmarshals and validates the OrderCancelled event in the payload.
+func DecodeCancellation(payload []byte) (Cancellation, error) {
+	var c Cancellation
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Cancellation{}, fmt.Errorf("invalid OrderCancelled event: %s", err.Error())
+	}
+
+	if c.Metadata.Type != OrderCancelledEventName {
+		return Cancellation{}, fmt.Errorf("invalid OrderCancelled event: unexpected type %q", c.Metadata.Type)
+	}
+
+	if err := shipper.ValidateCancellation(c.Data.OrderID, c.Data.TrackingNumber, c.Data.Reason); err != nil {
+		return Cancellation{}, fmt.Errorf("invalid OrderCancelled event: %s", err.Error())
+	}
+
+	return c, nil
+}
+
+// WithShipment returns a copy of ctx with the fields that identify the shipment, so
+// every record logged with it is tagged with the shipment.
+func WithShipment(ctx context.Context, s shipper.Shipment) context.Context {
+	return logging.With(ctx,
+		logging.OrderNumber, s.OrderNumber,
+		logging.TrackingNumber, s.TrackingNumber,
+		logging.Carrier, s.Carrier,
+		logging.CorrelationID, s.CorrelationID,
+	)
+}
+
+// Event is an event the workflow creates, together with the IDs that tie it to the
+// message that caused it.
+type Event struct {
+	acmeserverless.ShipmentSent
+
+	// IDs are the event ID, causation ID and correlation ID of the event.
+	IDs correlation.IDs
+}
+
+// Workflow ties the shipper, the EventEmitter and the metrics together.
+type Workflow struct {
+	emitter     emitter.EventEmitter
+	emitterName string
+	metrics     metrics.Recorder
+	clock       clock.Clock
+
+	addresses       address.Provider
+	addressRequired bool
+
+	warehouses []warehouse.Warehouse
+	carrier    carrier.Adapter
+}
+
+// New creates a new Workflow that sends events with the EventEmitter and records
+// metrics with the Recorder. The name of the EventEmitter is used in the metrics.
+func New(em emitter.EventEmitter, emitterName string, rec metrics.Recorder) *Workflow {
+	if rec == nil {
+		rec = metrics.Nop{}
+	}
+
+	// The built-in warehouses are valid, which their tests make sure of
+	warehouses, _ := warehouse.Default()
+
+	return &Workflow{
+		emitter:     em,
+		emitterName: emitterName,
+		metrics:     rec,
+		clock:       clock.Real{},
+		addresses:   rules.New(),
+		warehouses:  warehouses,
+		carrier:     simulated.New(),
+	}
+}
+
+// SetClock replaces the clock of the workflow, which is the real clock by default.
+// The clock determines when shipments are shipped and when they are delivered.
+func (w *Workflow) SetClock(c clock.Clock) {
+	w.clock = c
+}
+
+// SetAddressProvider replaces the Provider that validates the addresses shipments are
+// delivered to, which are the built-in rules by default. When required is set, shipments
+// can only be requested with an address.
+func (w *Workflow) SetAddressProvider(p address.Provider, required bool) {
+	w.addresses = p
+	w.addressRequired = required
+}
+
+// SetWarehouses replaces the warehouses orders are shipped from, which are the built-in
+// warehouses by default.
+func (w *Workflow) SetWarehouses(warehouses []warehouse.Warehouse) {
+	w.warehouses = warehouses
+}
+
+// SetCarrierAdapter replaces the Adapter that talks to the carriers, which is the one of
+// the simulated carriers by default.
+func (w *Workflow) SetCarrierAdapter(a carrier.Adapter) {
+	w.carrier = a
+}
+
+// Addresses returns the Provider that validates the addresses shipments are delivered to.
+func (w *Workflow) Addresses() address.Provider {
+	return w.addresses
+}
+
+// Warehouses returns the warehouses orders are shipped from.
+func (w *Workflow) Warehouses() []warehouse.Warehouse {
+	return w.warehouses
+}
+
+// Now returns the current time of the clock of the workflow, like the moment a
+// shipment that is handed to the shipper now is shipped.
+func (w *Workflow) Now() time.Time {
+	return w.clock.Now()
+}
+
+// Requested records that a shipment was requested.
+func (w *Workflow) Requested(req Request) {
+	// Send a breadcrumb to Sentry with the shipment request
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category:  acmeserverless.ShipmentRequestedEventName,
+		Timestamp: time.Now(),
+		Level:     sentry.LevelInfo,
+		Data:      acmeserverless.ToSentryMap(req.Data.ShipmentRequest),
+	})
+}
+
+// ValidateAddress validates the address of the request and returns the request with the
+// normalized address. An address that can't be delivered to, or a missing address when
+// addresses are required, returns an *address.ValidationError. Any other error means the
+// address couldn't be validated, like when the provider is unavailable.
+func (w *Workflow) ValidateAddress(ctx context.Context, r RequestData) (RequestData, error) {
+	if r.Address == nil {
+		if w.addressRequired {
+			return r, &address.ValidationError{Problems: []address.Problem{{Field: "address", Message: "is required"}}}
+		}
+		return r, nil
+	}
+
+	ctx, span := tracing.Start(ctx, "address.Validate", attribute.String("order.id", r.OrderID))
+	res, err := w.addresses.Validate(ctx, *r.Address)
+	tracing.End(span, err)
+	if err != nil {
+		return r, fmt.Errorf("error validating address: %s", err.Error())
+	}
+
+	if !res.Valid {
+		return r, &address.ValidationError{Problems: res.Problems}
+	}
+
+	r.Address = &res.Address
+	return r, nil
+}
+
+// ShipOrder assigns the line items of the request to the warehouses and hands a shipment
+// for every warehouse to the shipper with Ship. A request without line items is a single
+// shipment. It returns the shipments with their ShipmentSent events, preceded by an
+// OrderSplit event when the order is shipped from more than one warehouse, and all of
+// them are correlated with the cause. Items or parcels that can't be assigned to a
+// warehouse return a *warehouse.AssignmentError.
+func (w *Workflow) ShipOrder(ctx context.Context, r RequestData, cause correlation.IDs) ([]shipper.Shipment, []Event, error) {
+	if len(r.Items) == 0 {
+		shipment, evt := w.Ship(ctx, r, cause)
+		return []shipper.Shipment{shipment}, []Event{evt}, nil
+	}
+
+	parts, err := warehouse.Assign(w.warehouses, r.Items, r.Parcels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// All shipments of the order share the correlation ID
+	if cause.CorrelationID == "" {
+		cause.CorrelationID = correlation.NewID()
+	}
+
+	shipments := make([]shipper.Shipment, len(parts))
+	events := make([]Event, len(parts))
+	trackingNumbers := make([]string, len(parts))
+	for i, p := range parts {
+		part := r
+		part.Items = p.Items
+		part.Parcels = p.Parcels
+		shipments[i], events[i] = w.Ship(ctx, part, cause)
+		trackingNumbers[i] = shipments[i].TrackingNumber
+	}
+
+	if len(parts) == 1 {
+		return shipments, events, nil
+	}
+
+	for i := range shipments {
+		shipments[i].Parts = trackingNumbers
+	}
+
+	evt := newEvent(OrderSplitEventName, acmeserverless.ShipmentData{
+		TrackingNumber: strings.Join(trackingNumbers, ","),
+		OrderNumber:    r.OrderID,
+		Status:         StatusSplit,
+	}, cause.Caused())
+
+	slog.InfoContext(ctx, "order split", logging.OrderNumber, r.OrderID, "parts", trackingNumbers)
+
+	// Send a breadcrumb to Sentry with the parts of the order
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category:  OrderSplitEventName,
+		Timestamp: time.Now(),
+		Level:     sentry.LevelInfo,
+		Data:      breadcrumbData(evt),
+	})
+
+	return shipments, append([]Event{evt}, events...), nil
+}
+
+// Ship hands the shipment to the shipper and returns the shipment together with the
+// ShipmentSent event. The cause is the IDs of the message that requested the shipment.
+// The shipment carries the trace context of ctx and the correlation of the cause, so
+// the delivery can continue the trace and is correlated with the same message. The
+// shipment is shipped from the warehouse of its line items, if it has any.
+func (w *Workflow) Ship(ctx context.Context, r RequestData, cause correlation.IDs) (shipper.Shipment, Event) {
+	ctx, span := tracing.Start(ctx, "shipper.Sent", attribute.String("shipment.carrier", r.Delivery), attribute.String("order.id", r.OrderID))
+	defer span.End()
+
+	shipment := shipper.ShipParcelsAt(ctx, r.ShipmentRequest, r.Parcels, w.clock.Now())
+	shipment.Address = r.Address
+	shipment.Items = r.Items
+	if len(r.Items) > 0 {
+		shipment.Warehouse = r.Items[0].Warehouse
+	}
+	shipment.TraceContext = tracing.Inject(ctx)
+	span.SetAttributes(attribute.String("shipment.tracking_number", shipment.TrackingNumber))
+
+	ids := cause.Caused()
+	shipment.CausationID = ids.CausationID
+	shipment.CorrelationID = ids.CorrelationID
+
+	w.metrics.ShipmentCreated(carrierLabel(shipment.Carrier))
+	w.metrics.StatusTransition("", shipment.Status)
+
+	evt := newEvent(acmeserverless.ShipmentSentEventName, shipment.ShipmentData, ids)
+
+	// Send a breadcrumb to Sentry with the shipment status
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category:  acmeserverless.ShipmentSentEventName,
+		Timestamp: time.Now(),
+		Level:     sentry.LevelInfo,
+		Data:      breadcrumbData(evt),
+	})
+
+	return shipment, evt
+}
+
+// WaitForDelivery waits until the shipment is due to be delivered. If the context
+// is done before that, the error of the context is returned.
+func (w *Workflow) WaitForDelivery(ctx context.Context, s shipper.Shipment) error {
+	t := w.clock.NewTimer(s.DeliverAt.Sub(w.clock.Now()))
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Delivered delivers the parcels of the shipment that are due, or the whole shipment when
+// it has no parcels, and returns the shipment together with the events of the delivery:
+// a ParcelDelivered event for every parcel that was delivered, followed by a
+// ShipmentPartiallyDelivered or ShipmentDelivered event when the status of the shipment
+// changed. The events have the same causation and correlation as the ShipmentSent event
+// of the shipment. A shipment with parcels that isn't delivered yet is due again at its
+// DeliverAt, and so is the return leg of a shipment, which only has a ReturnReceived event
+// once it arrived at the warehouse.
+func (w *Workflow) Delivered(ctx context.Context, s shipper.Shipment) (shipper.Shipment, []Event) {
+	from := s.Status
+	now := w.clock.Now()
+
+	if s.Return != nil {
+		s = shipper.AdvanceReturn(s, now)
+		return s, w.progressed(ctx, s, from, nil, now)
+	}
+
+	s, parcels := shipper.DeliverDue(s, now)
+	return s, w.progressed(ctx, s, from, parcels, now)
+}
+
+// Scanned applies the scan the carrier reported for the shipment, or for one of its
+// parcels, and returns the shipment together with the events of the scan, which are the
+// ones Delivered creates. Scans that don't change the status of the shipment, like a
+// scan that was reported before, have no events.
+func (w *Workflow) Scanned(ctx context.Context, s shipper.Shipment, scan shipper.Scan) (shipper.Shipment, []Event) {
+	from := s.Status
+	s, parcels := shipper.ApplyScan(s, scan)
+
+	slog.DebugContext(WithShipment(ctx, s), "carrier scan", "scan", scan.TrackingNumber, "event", scan.Event, "at", scan.At)
+	return s, w.progressed(ctx, s, from, parcels, scan.At)
+}
+
+// progressed returns the events of the shipment that moved on from the status at the
+// moment: a ParcelDelivered event for every parcel that was delivered and the event of the
+// new status of the shipment, if it has one.
+func (w *Workflow) progressed(ctx context.Context, s shipper.Shipment, from string, parcels []shipper.Parcel, at time.Time) []Event {
+	var events []Event
+	for _, p := range parcels {
+		slog.InfoContext(WithShipment(ctx, s), "parcel delivered", "parcel", p.TrackingNumber)
+		events = append(events, w.delivered(ParcelDeliveredEventName, acmeserverless.ShipmentData{
+			TrackingNumber: p.TrackingNumber,
+			OrderNumber:    s.OrderNumber,
+			Status:         p.Status,
+		}, s))
+	}
+
+	if s.Status == from {
+		return events
+	}
+
+	w.metrics.StatusTransition(from, s.Status)
+	attrs := []interface{}{"from", from, "to", s.Status}
+	if s.Return != nil {
+		attrs = append(attrs, "rma", s.Return.RMA)
+	}
+	slog.InfoContext(WithShipment(ctx, s), "shipment "+s.Status, attrs...)
+
+	var eventType string
+	switch s.Status {
+	case shipper.StatusPartiallyDelivered:
+		eventType = ShipmentPartiallyDeliveredEventName
+	case shipper.StatusDelivered:
+		eventType = acmeserverless.ShipmentDeliveredEventName
+		w.metrics.DeliveryDuration(carrierLabel(s.Carrier), at.Sub(s.CreatedAt))
+	case shipper.StatusReturnReceived:
+		eventType = ReturnReceivedEventName
+	default:
+		return events
+	}
+
+	return append(events, w.delivered(eventType, s.ShipmentData, s))
+}
+
+// RequestReturn books the return leg of the delivered shipment with the carrier and
+// returns it together with the ReturnRequested event. The return leg has its own tracking
+// number and the correlation of the shipment, and the cause is the IDs of the request, if
+// it had any. A shipment that can't be returned returns shipper.ErrNotDelivered and an
+// invalid request returns a *ReturnError, any other error comes from the carrier.
+func (w *Workflow) RequestReturn(ctx context.Context, s shipper.Shipment, r shipper.ReturnRequest, cause correlation.IDs) (shipper.Shipment, Event, error) {
+	switch err := shipper.ValidateReturn(s, r); err {
+	case nil:
+	case shipper.ErrNotDelivered:
+		return shipper.Shipment{}, Event{}, err
+	default:
+		return shipper.Shipment{}, Event{}, &ReturnError{Problem: err.Error()}
+	}
+
+	ctx, span := tracing.Start(ctx, "carrier.Return", attribute.String("shipment.carrier", s.Carrier), attribute.String("shipment.tracking_number", s.TrackingNumber))
+	trackingNumber, err := w.carrier.Return(ctx, s)
+	tracing.End(span, err)
+	if err != nil {
+		return shipper.Shipment{}, Event{}, fmt.Errorf("error booking return with carrier adapter %s: %s", w.carrier.Name(), err.Error())
+	}
+
+	leg := shipper.ReturnAt(ctx, s, r, trackingNumber, w.clock.Now())
+	leg.TraceContext = tracing.Inject(ctx)
+
+	ids := correlation.IDs{
+		EventID:       correlation.NewID(),
+		CausationID:   s.CausationID,
+		CorrelationID: s.CorrelationID,
+	}
+	if cause.EventID != "" {
+		ids.CausationID = cause.EventID
+	}
+	leg.CausationID = ids.CausationID
+
+	w.metrics.StatusTransition("", leg.Status)
+
+	evt := newEvent(ReturnRequestedEventName, leg.ShipmentData, ids)
+
+	// Send a breadcrumb to Sentry with the return
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category:  ReturnRequestedEventName,
+		Timestamp: time.Now(),
+		Level:     sentry.LevelInfo,
+		Data:      breadcrumbData(evt),
+	})
+
+	return leg, evt, nil
+}
+
+// ReturnError is returned when a return is requested that isn't valid, like one without
+// a reason or with items that weren't shipped.
+type ReturnError struct {
+	// Problem explains what is wrong with the return.
+	Problem string
+}
+
+// Error returns a message with the problem.
+func (e *ReturnError) Error() string {
+	return "invalid return: " + e.Problem
+}
+
+// delivered creates a new event of the delivery of the shipment, or of one of its parcels,
+// and sends a breadcrumb to Sentry with it.
+func (w *Workflow) delivered(eventType string, data acmeserverless.ShipmentData, s shipper.Shipment) Event {
+	evt := newEvent(eventType, data, correlation.IDs{
+		EventID:       correlation.NewID(),
+		CausationID:   s.CausationID,
+		CorrelationID: s.CorrelationID,
+	})
+
+	// Send a breadcrumb to Sentry with the shipment status
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category:  eventType,
+		Timestamp: time.Now(),
+		Level:     sentry.LevelInfo,
+		Data:      breadcrumbData(evt),
+	})
+
+	return evt
+}
+
+// Cancel cancels the shipment with the carrier, when the carrier hasn't picked it up yet,
+// and returns the cancelled shipment together with the ShipmentCancelled event. A shipment
+// that can't be cancelled returns shipper.ErrPickedUp or shipper.ErrCancelled, any other
+// error comes from the carrier. The cause is the IDs of the message that cancelled the
+// shipment, if it had any, and the event has the correlation of the shipment.
+func (w *Workflow) Cancel(ctx context.Context, s shipper.Shipment, reason string, cause correlation.IDs) (shipper.Shipment, Event, error) {
+	now := w.clock.Now()
+	if err := shipper.Cancellable(s, now); err != nil {
+		return s, Event{}, err
+	}
+
+	ctx, span := tracing.Start(ctx, "carrier.Cancel", attribute.String("shipment.carrier", s.Carrier), attribute.String("shipment.tracking_number", s.TrackingNumber))
+	err := w.carrier.Cancel(ctx, s)
+	tracing.End(span, err)
+	if err != nil {
+		return s, Event{}, fmt.Errorf("error cancelling shipment with carrier adapter %s: %s", w.carrier.Name(), err.Error())
+	}
+
+	from := s.Status
+	s, err = shipper.CancelAt(s, reason, now)
+	if err != nil {
+		return s, Event{}, err
+	}
+
+	w.metrics.StatusTransition(from, s.Status)
+	slog.InfoContext(WithShipment(ctx, s), "shipment cancelled", "from", from, "to", s.Status, "reason", reason)
+
+	ids := correlation.IDs{
+		EventID:       correlation.NewID(),
+		CausationID:   s.CausationID,
+		CorrelationID: s.CorrelationID,
+	}
+	if cause.EventID != "" {
+		ids.CausationID = cause.EventID
+	}
+	evt := newEvent(ShipmentCancelledEventName, s.ShipmentData, ids)
+
+	// Send a breadcrumb to Sentry with the shipment status
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category:  ShipmentCancelledEventName,
+		Timestamp: time.Now(),
+		Level:     sentry.LevelInfo,
+		Data:      breadcrumbData(evt),
+	})
+
+	return s, evt, nil
+}
+
+// CancelOrder cancels the shipments of the order in the OrderCancelled event, which are
+// looked up in the shipments, and returns the cancelled shipments with their
+// ShipmentCancelled events. When the event has a tracking number, only that shipment is
+// cancelled. Shipments that are cancelled already are skipped, but when one of the other
+// shipments has been picked up, none are cancelled and shipper.ErrPickedUp is returned.
+// An order without shipments returns ErrUnknownOrder and an order of which all shipments
+// are cancelled returns shipper.ErrCancelled.
+func (w *Workflow) CancelOrder(ctx context.Context, shipments []shipper.Shipment, c CancellationData, cause correlation.IDs) ([]shipper.Shipment, []Event, error) {
+	found := false
+	var todo []shipper.Shipment
+	for _, s := range shipments {
+		if s.OrderNumber != c.OrderID || (c.TrackingNumber != "" && s.TrackingNumber != c.TrackingNumber) {
+			continue
+		}
+		found = true
+
+		switch err := shipper.Cancellable(s, w.clock.Now()); err {
+		case nil:
+			todo = append(todo, s)
+		case shipper.ErrCancelled:
+		default:
+			slog.WarnContext(WithShipment(ctx, s), "shipment of cancelled order can't be cancelled", logging.Err(err))
+			return nil, nil, err
+		}
+	}
+
+	switch {
+	case !found:
+		return nil, nil, ErrUnknownOrder
+	case len(todo) == 0:
+		return nil, nil, shipper.ErrCancelled
+	}
+
+	cancelled := make([]shipper.Shipment, 0, len(todo))
+	events := make([]Event, 0, len(todo))
+	for _, s := range todo {
+		s, evt, err := w.Cancel(ctx, s, c.Reason, cause)
+		if err != nil {
+			return cancelled, events, err
+		}
+		cancelled = append(cancelled, s)
+		events = append(events, evt)
+	}
+
+	return cancelled, events, nil
+}
+
+// OrderDelivered returns the OrderDelivered event of the order that was split into the
+// parts, when all parts are delivered or cancelled and at least one was delivered. The
+// event has the same causation and correlation as the parts.
+func (w *Workflow) OrderDelivered(ctx context.Context, parts []shipper.Shipment) (Event, bool) {
+	if len(parts) < 2 {
+		return Event{}, false
+	}
+
+	delivered := false
+	trackingNumbers := make([]string, len(parts))
+	for i, p := range parts {
+		if !shipper.Done(p) {
+			return Event{}, false
+		}
+		delivered = delivered || p.Status == shipper.StatusDelivered
+		trackingNumbers[i] = p.TrackingNumber
+	}
+	if !delivered {
+		return Event{}, false
+	}
+
+	slog.InfoContext(ctx, "order delivered", logging.OrderNumber, parts[0].OrderNumber, "parts", trackingNumbers)
+
+	return w.delivered(OrderDeliveredEventName, acmeserverless.ShipmentData{
+		TrackingNumber: strings.Join(trackingNumbers, ","),
+		OrderNumber:    parts[0].OrderNumber,
+		Status:         shipper.StatusDelivered,
+	}, parts[0]), true
+}
+
+// Emit sends the events, together with their IDs, in order using the EventEmitter and
+// returns an error if anything goes wrong. The events after the one that failed are
+// not sent.
+func (w *Workflow) Emit(ctx context.Context, events ...Event) error {
+	for _, evt := range events {
+		if err := w.emit(ctx, evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emit sends a single event using the EventEmitter.
+func (w *Workflow) emit(ctx context.Context, evt Event) error {
+	ctx, span := tracing.StartKind(ctx, "EventEmitter.Send", trace.SpanKindProducer,
+		attribute.String("emitter", w.emitterName),
+		attribute.String("event.type", evt.Metadata.Type),
+		attribute.String("event.id", evt.IDs.EventID),
+		attribute.String("event.correlation_id", evt.IDs.CorrelationID),
+		attribute.String("shipment.tracking_number", evt.Data.TrackingNumber),
+	)
+
+	err := w.emitter.Send(correlation.NewContext(ctx, evt.IDs), evt.ShipmentSent)
+	tracing.End(span, err)
+
+	if err != nil {
+		w.metrics.EmitFailed(w.emitterName)
+		slog.ErrorContext(ctx, "error sending event", "emitter", w.emitterName, "eventType", evt.Metadata.Type, "eventId", evt.IDs.EventID, logging.Err(err))
+		return err
+	}
+
+	slog.InfoContext(ctx, "event sent", "emitter", w.emitterName, "eventType", evt.Metadata.Type, "eventId", evt.IDs.EventID, "causationId", evt.IDs.CausationID)
+
+	return nil
+}
+
+// EventFor returns the event that announces the current status of the shipment, with a
+// new event ID and the causation and correlation of the shipment, like to send it again.
+func EventFor(s shipper.Shipment) (Event, error) {
+	var eventType string
+	switch s.Status {
+	case shipper.StatusShipped:
+		eventType = acmeserverless.ShipmentSentEventName
+	case shipper.StatusPartiallyDelivered:
+		eventType = ShipmentPartiallyDeliveredEventName
+	case shipper.StatusDelivered:
+		eventType = acmeserverless.ShipmentDeliveredEventName
+	case shipper.StatusCancelled:
+		eventType = ShipmentCancelledEventName
+	case shipper.StatusReturnRequested:
+		eventType = ReturnRequestedEventName
+	case shipper.StatusReturnReceived:
+		eventType = ReturnReceivedEventName
+	default:
+		return Event{}, fmt.Errorf("no event for shipments with status %q", s.Status)
+	}
+
+	return newEvent(eventType, s.ShipmentData, correlation.IDs{
+		EventID:       correlation.NewID(),
+		CausationID:   s.CausationID,
+		CorrelationID: s.CorrelationID,
+	}), nil
+}
+
+// carrierLabel returns the carrier the metrics of a shipment with the delivery method are
+// recorded for: the carrier that has a tracking number format, or other. The delivery method
+// comes from the client, so it isn't used as the label itself, which would let clients
+// create any number of series.
+func carrierLabel(delivery string) string {
+	if carrier, ok := shipper.CarrierOf(delivery); ok {
+		return carrier
+	}
+	return metrics.OtherCarrier
+}
+
+// newEvent creates a new shipment event of the type with the data and the IDs.
+func newEvent(eventType string, data acmeserverless.ShipmentData, ids correlation.IDs) Event {
+	return Event{
+		ShipmentSent: acmeserverless.ShipmentSent{
+			Metadata: acmeserverless.Metadata{
+				Domain: acmeserverless.ShipmentDomain,
+				Source: source,
+				Type:   eventType,
+				Status: acmeserverless.DefaultSuccessStatus,
+			},
+			Data: data,
+		},
+		IDs: ids,
+	}
+}
+
+// breadcrumbData returns the data of the event and its IDs for a Sentry breadcrumb.
+func breadcrumbData(evt Event) map[string]interface{} {
+	data := acmeserverless.ToSentryMap(evt.Data)
+	for k, v := range evt.IDs.Attributes() {
+		data[k] = v
+	}
+	return data
+}
@@ -0,0 +1,698 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-shipment/internal/address"
+	"github.com/retgits/acme-serverless-shipment/internal/carrier"
+	"github.com/retgits/acme-serverless-shipment/internal/correlation"
+	"github.com/retgits/acme-serverless-shipment/internal/emitter/mock"
+	"github.com/retgits/acme-serverless-shipment/internal/metrics"
+	"github.com/retgits/acme-serverless-shipment/internal/shipper"
+	"github.com/retgits/acme-serverless-shipment/internal/store"
+	"github.com/retgits/acme-serverless-shipment/internal/store/memory"
+	"github.com/retgits/acme-serverless-shipment/internal/warehouse"
+)
+
+// requests are ShipmentRequested events like the ones the order service sends, and
+// payloads that look like them.
+var requests = []struct {
+	name    string
+	payload string
+	wantErr bool
+}{
+	{name: "order service", payload: `{"metadata":{"domain":"Order","source":"CreateOrder","type":"ShipmentRequested","status":"success"},"data":{"_id":"5e9c1b6e2d6f5a0001a6c2b1","delivery":"UPS/FedEx"}}`},
+	{name: "no metadata", payload: `{"metadata":{},"data":{"_id":"12345","delivery":"UPS/FedEx"}}`},
+	{name: "unknown fields", payload: `{"metadata":{"type":"ShipmentRequested"},"data":{"_id":"1","delivery":"UPS","name":"Jane"},"traceContext":{}}`},
+	{name: "address", payload: `{"metadata":{},"data":{"_id":"1","delivery":"UPS","address":{"street":"1 Main Street","city":"Palo Alto","zip":"94301","state":"CA","country":"US"}}}`},
+	{name: "parcels", payload: `{"metadata":{},"data":{"_id":"1","delivery":"UPS","parcels":[{"weightKg":1.5,"lengthCm":30,"widthCm":20,"heightCm":10,"contents":["Yoga mat"]},{"weightKg":0.5}]}}`},
+	{name: "parcel without weight", payload: `{"metadata":{},"data":{"_id":"1","delivery":"UPS","parcels":[{"weightKg":1},{"lengthCm":30}]}}`, wantErr: true},
+	{name: "items", payload: `{"metadata":{},"data":{"_id":"1","delivery":"UPS","items":[{"id":"rack-1","name":"Squat rack","quantity":1},{"id":"mat-1","quantity":2,"warehouse":"sfo"}]}}`},
+	{name: "item without quantity", payload: `{"metadata":{},"data":{"_id":"1","delivery":"UPS","items":[{"id":"rack-1"}]}}`, wantErr: true},
+	{name: "wrong address type", payload: `{"metadata":{},"data":{"_id":"1","delivery":"UPS","address":"1 Main Street"}}`, wantErr: true},
+	{name: "other event type", payload: `{"metadata":{"type":"ShipmentSent"},"data":{"_id":"1","delivery":"UPS"}}`, wantErr: true},
+	{name: "no order", payload: `{"metadata":{},"data":{"delivery":"UPS/FedEx"}}`, wantErr: true},
+	{name: "no delivery", payload: `{"metadata":{},"data":{"_id":"1"}}`, wantErr: true},
+	{name: "empty object", payload: `{}`, wantErr: true},
+	{name: "null", payload: `null`, wantErr: true},
+	{name: "empty", payload: ``, wantErr: true},
+	{name: "array", payload: `[{"data":{"_id":"1","delivery":"UPS"}}]`, wantErr: true},
+	{name: "wrong types", payload: `{"metadata":"x","data":{"_id":1,"delivery":true}}`, wantErr: true},
+	{name: "truncated", payload: `{"metadata":{},"data":{"_id":"1","deliv`, wantErr: true},
+}
+
+func TestDecodeRequest(t *testing.T) {
+	for _, tt := range requests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := DecodeRequest([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !reflect.DeepEqual(req, Request{}) {
+				t.Errorf("got request %+v with the error, want the zero value", req)
+			}
+		})
+	}
+}
+
+func FuzzDecodeRequest(f *testing.F) {
+	for _, tt := range requests {
+		f.Add([]byte(tt.payload))
+	}
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		req, err := DecodeRequest(payload)
+		if err != nil {
+			return
+		}
+
+		// A decoded request is always valid and survives a round trip
+		if err := shipper.Validate(req.Data.ShipmentRequest); err != nil {
+			t.Fatalf("decoded request %+v is invalid: %s", req.Data, err.Error())
+		}
+
+		b, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		again, err := DecodeRequest(b)
+		if err != nil {
+			t.Fatalf("re-encoded request %s is rejected: %s", b, err.Error())
+		}
+		if !reflect.DeepEqual(again, req) {
+			t.Fatalf("got %+v after a round trip, want %+v", again, req)
+		}
+	})
+}
+
+func TestValidateAddress(t *testing.T) {
+	valid := &address.Address{Street: "1 main street", City: "PALO ALTO", Zip: "94301", State: "california", Country: "usa"}
+
+	tests := []struct {
+		name     string
+		address  *address.Address
+		required bool
+		want     *address.Address
+		wantErr  bool
+	}{
+		{name: "no address", address: nil},
+		{name: "no address when required", address: nil, required: true, wantErr: true},
+		{name: "normalized", address: valid, want: &address.Address{Street: "1 Main St", City: "Palo Alto", Zip: "94301", State: "CA", Country: "US"}},
+		{name: "invalid", address: &address.Address{Street: "1 Main St", City: "Palo Alto", Zip: "943", Country: "US"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := New(nil, "test", nil)
+			if tt.required {
+				wf.SetAddressProvider(wf.addresses, true)
+			}
+
+			r := RequestData{Address: tt.address}
+			r.OrderID = "1"
+			r.Delivery = "UPS"
+
+			got, err := wf.ValidateAddress(context.Background(), r)
+			var verr *address.ValidationError
+			if errors.As(err, &verr) != tt.wantErr {
+				t.Fatalf("got error %v, want a validation error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got.Address, tt.want) {
+				t.Errorf("got address %+v, want %+v", got.Address, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelivered(t *testing.T) {
+	now := time.Now().UTC()
+	parcel := func(tn string, at time.Time) shipper.Parcel {
+		return shipper.Parcel{TrackingNumber: tn, Status: shipper.StatusShipped, DeliverAt: at}
+	}
+
+	s := shipper.Shipment{
+		Carrier:       "UPS",
+		CreatedAt:     now.Add(-time.Hour),
+		DeliverAt:     now.Add(-time.Minute),
+		CausationID:   "cause",
+		CorrelationID: "correlation",
+		Parcels:       []shipper.Parcel{parcel("1", now.Add(-time.Minute)), parcel("2", now.Add(time.Hour)), parcel("3", now.Add(-time.Minute))},
+	}
+	s.TrackingNumber = "1"
+	s.OrderNumber = "order-1"
+	s.Status = shipper.StatusShipped
+
+	tests := []struct {
+		name       string
+		wantStatus string
+		wantEvents []string
+	}{
+		{name: "first parcels", wantStatus: shipper.StatusPartiallyDelivered, wantEvents: []string{ParcelDeliveredEventName + " 1", ParcelDeliveredEventName + " 3", ShipmentPartiallyDeliveredEventName + " 1"}},
+		{name: "last parcel", wantStatus: shipper.StatusDelivered, wantEvents: []string{ParcelDeliveredEventName + " 2", acmeserverless.ShipmentDeliveredEventName + " 1"}},
+	}
+
+	wf := New(nil, "test", nil)
+	for _, tt := range tests {
+		var events []Event
+		s, events = wf.Delivered(context.Background(), s)
+
+		if s.Status != tt.wantStatus {
+			t.Errorf("%s: got status %q, want %q", tt.name, s.Status, tt.wantStatus)
+		}
+
+		var got []string
+		for _, evt := range events {
+			got = append(got, evt.Metadata.Type+" "+evt.Data.TrackingNumber)
+			if evt.Data.OrderNumber != "order-1" || evt.IDs.CausationID != "cause" || evt.IDs.CorrelationID != "correlation" {
+				t.Errorf("%s: got event %+v, want the order and IDs of the shipment", tt.name, evt)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.wantEvents) {
+			t.Errorf("%s: got events %v, want %v", tt.name, got, tt.wantEvents)
+		}
+	}
+}
+
+func TestShipOrder(t *testing.T) {
+	item := func(id string) shipper.LineItem {
+		return shipper.LineItem{ID: id, Quantity: 1}
+	}
+
+	tests := []struct {
+		name           string
+		items          []shipper.LineItem
+		wantEvents     []string
+		wantWarehouses []string
+		wantErr        bool
+	}{
+		{name: "no items", wantEvents: []string{acmeserverless.ShipmentSentEventName}, wantWarehouses: []string{""}},
+		{name: "single warehouse", items: []shipper.LineItem{item("mat-1"), item("shirt-1")}, wantEvents: []string{acmeserverless.ShipmentSentEventName}, wantWarehouses: []string{"sfo"}},
+		{
+			name:           "split",
+			items:          []shipper.LineItem{item("mat-1"), item("rack-1")},
+			wantEvents:     []string{OrderSplitEventName, acmeserverless.ShipmentSentEventName, acmeserverless.ShipmentSentEventName},
+			wantWarehouses: []string{"rno", "sfo"},
+		},
+		{name: "unknown warehouse", items: []shipper.LineItem{{ID: "mat-1", Quantity: 1, Warehouse: "lax"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := RequestData{Items: tt.items}
+			r.OrderID = "order-1"
+			r.Delivery = "UPS"
+
+			shipments, events, err := New(nil, "test", nil).ShipOrder(context.Background(), r, correlation.IDs{EventID: "request-1"})
+			var aerr *warehouse.AssignmentError
+			if errors.As(err, &aerr) != tt.wantErr {
+				t.Fatalf("got error %v, want an assignment error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var gotEvents, gotWarehouses, trackingNumbers []string
+			for _, evt := range events {
+				gotEvents = append(gotEvents, evt.Metadata.Type)
+				if evt.IDs.CausationID != "request-1" || evt.IDs.CorrelationID != events[0].IDs.CorrelationID {
+					t.Errorf("got event %s with IDs %+v, want the causation and correlation of the order", evt.Metadata.Type, evt.IDs)
+				}
+			}
+			for _, s := range shipments {
+				gotWarehouses = append(gotWarehouses, s.Warehouse)
+				trackingNumbers = append(trackingNumbers, s.TrackingNumber)
+			}
+			if !reflect.DeepEqual(gotEvents, tt.wantEvents) {
+				t.Errorf("got events %v, want %v", gotEvents, tt.wantEvents)
+			}
+			if !reflect.DeepEqual(gotWarehouses, tt.wantWarehouses) {
+				t.Errorf("got warehouses %v, want %v", gotWarehouses, tt.wantWarehouses)
+			}
+
+			if len(shipments) < 2 {
+				return
+			}
+			if got, want := events[0].Data.TrackingNumber, strings.Join(trackingNumbers, ","); got != want {
+				t.Errorf("got split event with tracking numbers %s, want %s", got, want)
+			}
+			for _, s := range shipments {
+				if !reflect.DeepEqual(s.Parts, trackingNumbers) {
+					t.Errorf("got parts %v, want %v", s.Parts, trackingNumbers)
+				}
+			}
+		})
+	}
+}
+
+func TestCarrierLabel(t *testing.T) {
+	tests := []struct {
+		delivery string
+		want     string
+	}{
+		{"UPS", "UPS"},
+		{"ups/FedEx", "UPS"},
+		{"Pigeon/USPS", "USPS"},
+		{"Pigeon", metrics.OtherCarrier},
+		{"", metrics.OtherCarrier},
+	}
+
+	for _, tt := range tests {
+		if got := carrierLabel(tt.delivery); got != tt.want {
+			t.Errorf("%q: got label %q, want %q", tt.delivery, got, tt.want)
+		}
+	}
+}
+
+func TestOrderDelivered(t *testing.T) {
+	part := func(tn string, status string) shipper.Shipment {
+		s := shipper.Shipment{CausationID: "cause", CorrelationID: "correlation"}
+		s.TrackingNumber = tn
+		s.OrderNumber = "order-1"
+		s.Status = status
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		parts []shipper.Shipment
+		want  bool
+	}{
+		{name: "single shipment", parts: []shipper.Shipment{part("1", shipper.StatusDelivered)}},
+		{name: "part outstanding", parts: []shipper.Shipment{part("1", shipper.StatusDelivered), part("2", shipper.StatusPartiallyDelivered)}},
+		{name: "all parts delivered", parts: []shipper.Shipment{part("1", shipper.StatusDelivered), part("2", shipper.StatusDelivered)}, want: true},
+		{name: "part cancelled", parts: []shipper.Shipment{part("1", shipper.StatusDelivered), part("2", shipper.StatusCancelled)}, want: true},
+		{name: "all parts cancelled", parts: []shipper.Shipment{part("1", shipper.StatusCancelled), part("2", shipper.StatusCancelled)}},
+	}
+
+	for _, tt := range tests {
+		evt, ok := New(nil, "test", nil).OrderDelivered(context.Background(), tt.parts)
+		if ok != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, ok, tt.want)
+			continue
+		}
+		if ok && (evt.Metadata.Type != OrderDeliveredEventName || evt.Data.TrackingNumber != "1,2" || evt.IDs.CorrelationID != "correlation") {
+			t.Errorf("%s: got event %+v, want OrderDelivered of 1,2", tt.name, evt)
+		}
+	}
+}
+
+func TestDecodeCancellation(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		wantErr bool
+	}{
+		{name: "order", payload: `{"metadata":{"domain":"Order","source":"CancelOrder","type":"OrderCancelled","status":"success"},"data":{"_id":"order-1","reason":"customer request"}}`},
+		{name: "shipment", payload: `{"metadata":{"type":"OrderCancelled"},"data":{"_id":"order-1","trackingNumber":"1Z999AA10123456784"}}`},
+		{name: "no type", payload: `{"metadata":{},"data":{"_id":"order-1"}}`, wantErr: true},
+		{name: "shipment request", payload: `{"metadata":{"type":"ShipmentRequested"},"data":{"_id":"order-1","delivery":"UPS"}}`, wantErr: true},
+		{name: "no order", payload: `{"metadata":{"type":"OrderCancelled"},"data":{"reason":"x"}}`, wantErr: true},
+		{name: "control characters", payload: `{"metadata":{"type":"OrderCancelled"},"data":{"_id":"order-1","reason":"x\ny"}}`, wantErr: true},
+		{name: "empty", payload: ``, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeCancellation([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && EventType([]byte(tt.payload)) != OrderCancelledEventName {
+				t.Errorf("got event type %q, want %q", EventType([]byte(tt.payload)), OrderCancelledEventName)
+			}
+		})
+	}
+}
+
+// fakeCarrier is a carrier Adapter that records the shipments it cancels.
+type fakeCarrier struct {
+	carrier.Nop
+	cancelled []string
+	err       error
+}
+
+func (c *fakeCarrier) Cancel(ctx context.Context, s shipper.Shipment) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.cancelled = append(c.cancelled, s.TrackingNumber)
+	return nil
+}
+
+func TestCancelOrder(t *testing.T) {
+	now := time.Now().UTC()
+	shipment := func(order string, tn string, status string, pickupIn time.Duration) shipper.Shipment {
+		s := shipper.Shipment{PickupAt: now.Add(pickupIn), CausationID: "cause", CorrelationID: "correlation"}
+		s.OrderNumber = order
+		s.TrackingNumber = tn
+		s.Status = status
+		return s
+	}
+	shipments := []shipper.Shipment{
+		shipment("order-1", "1", shipper.StatusShipped, time.Hour),
+		shipment("order-1", "2", shipper.StatusShipped, time.Hour),
+		shipment("order-2", "3", shipper.StatusShipped, time.Hour),
+		shipment("order-3", "4", shipper.StatusShipped, time.Hour),
+		shipment("order-3", "5", shipper.StatusShipped, -time.Hour),
+		shipment("order-4", "6", shipper.StatusCancelled, time.Hour),
+		shipment("order-4", "7", shipper.StatusShipped, time.Hour),
+		shipment("order-5", "8", shipper.StatusCancelled, time.Hour),
+	}
+
+	tests := []struct {
+		name    string
+		data    CancellationData
+		want    []string
+		wantErr error
+	}{
+		{name: "order", data: CancellationData{OrderID: "order-1"}, want: []string{"1", "2"}},
+		{name: "single shipment", data: CancellationData{OrderID: "order-1", TrackingNumber: "2"}, want: []string{"2"}},
+		{name: "picked up", data: CancellationData{OrderID: "order-3"}, wantErr: shipper.ErrPickedUp},
+		{name: "skips cancelled", data: CancellationData{OrderID: "order-4"}, want: []string{"7"}},
+		{name: "all cancelled", data: CancellationData{OrderID: "order-5"}, wantErr: shipper.ErrCancelled},
+		{name: "unknown order", data: CancellationData{OrderID: "order-6"}, wantErr: ErrUnknownOrder},
+		{name: "tracking number of other order", data: CancellationData{OrderID: "order-1", TrackingNumber: "3"}, wantErr: ErrUnknownOrder},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := &fakeCarrier{}
+			wf := New(nil, "test", nil)
+			wf.SetCarrierAdapter(fc)
+
+			cancelled, events, err := wf.CancelOrder(context.Background(), shipments, tt.data, correlation.IDs{EventID: "order-cancelled"})
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(fc.cancelled, tt.want) {
+				t.Errorf("got shipments %v cancelled with the carrier, want %v", fc.cancelled, tt.want)
+			}
+			if len(cancelled) != len(tt.want) || len(events) != len(tt.want) {
+				t.Fatalf("got %d shipments and %d events, want %d", len(cancelled), len(events), len(tt.want))
+			}
+			for i, evt := range events {
+				if cancelled[i].Status != shipper.StatusCancelled {
+					t.Errorf("got status %q for shipment %s, want %q", cancelled[i].Status, cancelled[i].TrackingNumber, shipper.StatusCancelled)
+				}
+				if evt.Metadata.Type != ShipmentCancelledEventName || evt.Data.TrackingNumber != tt.want[i] || evt.IDs.CausationID != "order-cancelled" || evt.IDs.CorrelationID != "correlation" {
+					t.Errorf("got event %+v, want ShipmentCancelled of %s caused by the cancellation", evt, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCancelCarrierError(t *testing.T) {
+	wf := New(nil, "test", nil)
+	wf.SetCarrierAdapter(&fakeCarrier{err: errors.New("carrier unavailable")})
+
+	s := shipper.Shipment{PickupAt: time.Now().Add(time.Hour)}
+	s.Status = shipper.StatusShipped
+
+	got, _, err := wf.Cancel(context.Background(), s, "", correlation.IDs{})
+	if err == nil || !strings.Contains(err.Error(), "carrier unavailable") {
+		t.Fatalf("got error %v, want the error of the carrier", err)
+	}
+	if got.Status != shipper.StatusShipped {
+		t.Errorf("got status %q, want the shipment unchanged", got.Status)
+	}
+}
+
+func TestRequestReturn(t *testing.T) {
+	s := shipper.Shipment{Carrier: "UPS", CausationID: "cause", CorrelationID: "correlation"}
+	s.TrackingNumber = "1Z999AA10123456784"
+	s.OrderNumber = "order-1"
+	s.Status = shipper.StatusDelivered
+
+	wf := New(nil, "test", nil)
+	wf.SetCarrierAdapter(carrier.Nop{})
+
+	// A shipment that isn't delivered, or a return without a reason, can't be returned
+	shipped := s
+	shipped.Status = shipper.StatusShipped
+	if _, _, err := wf.RequestReturn(context.Background(), shipped, shipper.ReturnRequest{Reason: "damaged"}, correlation.IDs{}); err != shipper.ErrNotDelivered {
+		t.Errorf("got error %v for a shipped shipment, want %v", err, shipper.ErrNotDelivered)
+	}
+	var rerr *ReturnError
+	if _, _, err := wf.RequestReturn(context.Background(), s, shipper.ReturnRequest{}, correlation.IDs{}); !errors.As(err, &rerr) {
+		t.Errorf("got error %v for a return without reason, want a *ReturnError", err)
+	}
+
+	leg, evt, err := wf.RequestReturn(context.Background(), s, shipper.ReturnRequest{Reason: "damaged"}, correlation.IDs{EventID: "request-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shipper.ParseTrackingNumber(leg.TrackingNumber); err != nil || leg.TrackingNumber == s.TrackingNumber {
+		t.Errorf("got return tracking number %s, want a new UPS tracking number", leg.TrackingNumber)
+	}
+	if evt.Metadata.Type != ReturnRequestedEventName || evt.Data.TrackingNumber != leg.TrackingNumber || evt.IDs.CausationID != "request-1" || evt.IDs.CorrelationID != "correlation" {
+		t.Errorf("got event %+v, want ReturnRequested of %s caused by the request", evt, leg.TrackingNumber)
+	}
+
+	// The return leg is picked up without an event, and received with one
+	var events []Event
+	leg.Return.ReceiveAt = time.Now().Add(-time.Minute)
+	leg.PickupAt = leg.Return.ReceiveAt
+	leg.DeliverAt = leg.PickupAt
+	leg, events = wf.Delivered(context.Background(), leg)
+	if leg.Status != shipper.StatusReturnReceived || len(events) != 1 || events[0].Metadata.Type != ReturnReceivedEventName {
+		t.Fatalf("got status %q and events %+v, want %q with a ReturnReceived event", leg.Status, events, shipper.StatusReturnReceived)
+	}
+	if events[0].IDs.CausationID != "request-1" || events[0].IDs.CorrelationID != "correlation" {
+		t.Errorf("got IDs %+v, want the causation of the return and the correlation of the shipment", events[0].IDs)
+	}
+}
+
+func TestScanned(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	s := shipper.Shipment{
+		Carrier:       "UPS",
+		CreatedAt:     now.Add(-time.Hour),
+		PickupAt:      now.Add(time.Hour),
+		DeliverAt:     now.Add(2 * time.Hour),
+		CausationID:   "cause",
+		CorrelationID: "correlation",
+		Parcels: []shipper.Parcel{
+			{TrackingNumber: "1", Status: shipper.StatusShipped, DeliverAt: now.Add(2 * time.Hour)},
+			{TrackingNumber: "2", Status: shipper.StatusShipped, DeliverAt: now.Add(2 * time.Hour)},
+		},
+	}
+	s.TrackingNumber = "1"
+	s.OrderNumber = "order-1"
+	s.Status = shipper.StatusShipped
+
+	tests := []struct {
+		name       string
+		scan       shipper.Scan
+		wantStatus string
+		wantEvents []string
+	}{
+		{name: "picked up", scan: shipper.Scan{TrackingNumber: "1", Event: shipper.ScanPickedUp, At: now}, wantStatus: shipper.StatusShipped},
+		{name: "first parcel", scan: shipper.Scan{TrackingNumber: "2", Event: shipper.ScanDelivered, At: now.Add(time.Hour)}, wantStatus: shipper.StatusPartiallyDelivered, wantEvents: []string{ParcelDeliveredEventName + " 2", ShipmentPartiallyDeliveredEventName + " 1"}},
+		{name: "same scan again", scan: shipper.Scan{TrackingNumber: "2", Event: shipper.ScanDelivered, At: now.Add(time.Hour)}, wantStatus: shipper.StatusPartiallyDelivered},
+		{name: "last parcel", scan: shipper.Scan{TrackingNumber: "1", Event: shipper.ScanDelivered, At: now.Add(time.Hour)}, wantStatus: shipper.StatusDelivered, wantEvents: []string{ParcelDeliveredEventName + " 1", acmeserverless.ShipmentDeliveredEventName + " 1"}},
+	}
+
+	wf := New(nil, "test", nil)
+	for _, tt := range tests {
+		var events []Event
+		s, events = wf.Scanned(context.Background(), s, tt.scan)
+
+		if s.Status != tt.wantStatus {
+			t.Errorf("%s: got status %q, want %q", tt.name, s.Status, tt.wantStatus)
+		}
+
+		var got []string
+		for _, evt := range events {
+			got = append(got, evt.Metadata.Type+" "+evt.Data.TrackingNumber)
+			if evt.IDs.CausationID != "cause" || evt.IDs.CorrelationID != "correlation" {
+				t.Errorf("%s: got event %+v, want the IDs of the shipment", tt.name, evt)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.wantEvents) {
+			t.Errorf("%s: got events %v, want %v", tt.name, got, tt.wantEvents)
+		}
+	}
+
+	if !s.PickupAt.Equal(now) || len(s.Scans) != 3 {
+		t.Errorf("got pickup at %s and %d scans, want the pickup of the scan and 3 scans", s.PickupAt, len(s.Scans))
+	}
+}
+
+func TestCancelStoredOrder(t *testing.T) {
+	now := time.Now().UTC()
+	shipment := func(order string, tn string, status string, pickupIn time.Duration) shipper.Shipment {
+		s := shipper.Shipment{PickupAt: now.Add(pickupIn)}
+		s.OrderNumber = order
+		s.TrackingNumber = tn
+		s.Status = status
+		return s
+	}
+
+	tests := []struct {
+		name          string
+		order         string
+		wantCancelled []string
+	}{
+		{name: "order", order: "order-1", wantCancelled: []string{"1", "2"}},
+		{name: "picked up", order: "order-2"},
+		{name: "all cancelled", order: "order-3"},
+		{name: "unknown order", order: "order-4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := memory.New()
+			for _, s := range []shipper.Shipment{
+				shipment("order-1", "1", shipper.StatusShipped, time.Hour),
+				shipment("order-1", "2", shipper.StatusShipped, time.Hour),
+				shipment("order-2", "3", shipper.StatusShipped, -time.Hour),
+				shipment("order-3", "4", shipper.StatusCancelled, time.Hour),
+			} {
+				if err := st.Save(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec := mock.NewRecorder()
+			wf := New(rec, "test", nil)
+			wf.SetCarrierAdapter(&fakeCarrier{})
+
+			err := wf.CancelStoredOrder(context.Background(), st, Cancellation{Data: CancellationData{OrderID: tt.order}}, correlation.IDs{})
+			if err != nil {
+				t.Fatalf("got error %v, want the cancellation acknowledged", err)
+			}
+
+			var cancelled []string
+			for _, r := range rec.OfType(ShipmentCancelledEventName) {
+				cancelled = append(cancelled, r.Data.TrackingNumber)
+				if s, err := st.Get(r.Data.TrackingNumber); err != nil || s.Status != shipper.StatusCancelled {
+					t.Errorf("got stored shipment %+v, %v, want it cancelled", s, err)
+				}
+			}
+			if !reflect.DeepEqual(cancelled, tt.wantCancelled) {
+				t.Errorf("got cancelled shipments %v, want %v", cancelled, tt.wantCancelled)
+			}
+		})
+	}
+}
+
+// racingStore is a Store of which the first Save of a shipment is preceded by the change
+// of another instance, which stores the shipment in between.
+type racingStore struct {
+	store.Store
+	change func(s shipper.Shipment) shipper.Shipment
+	raced  bool
+}
+
+func (r *racingStore) Save(s shipper.Shipment) error {
+	if !r.raced && s.Version > 0 {
+		r.raced = true
+		if _, err := store.Update(r.Store, s.TrackingNumber, func(s shipper.Shipment) (shipper.Shipment, error) {
+			return r.change(s), nil
+		}); err != nil {
+			return err
+		}
+	}
+	return r.Store.Save(s)
+}
+
+func TestDeliverOrderKeepsChangesOfOthers(t *testing.T) {
+	scan := shipper.Scan{TrackingNumber: "1", Event: shipper.ScanInTransit, At: time.Now().UTC()}
+	st := &racingStore{Store: memory.New(), change: func(s shipper.Shipment) shipper.Shipment {
+		s.Scans = append(s.Scans, scan)
+		return s
+	}}
+
+	s := shipper.Shipment{}
+	s.OrderNumber = "order-1"
+	s.TrackingNumber = "1"
+	s.Status = shipper.StatusShipped
+	if err := st.Save(s); err != nil {
+		t.Fatal(err)
+	}
+
+	wf := New(mock.NewRecorder(), "test", nil)
+	if err := wf.DeliverOrder(context.Background(), st, []shipper.Shipment{s}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := st.Get(s.TrackingNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != shipper.StatusDelivered || len(stored.Scans) != 1 {
+		t.Errorf("got status %q and scans %v, want the delivered shipment with the scan of the other instance", stored.Status, stored.Scans)
+	}
+}
+
+// takenStore is a Store in which the tracking numbers of the first shipments that are
+// created are taken.
+type takenStore struct {
+	store.Store
+	taken []bool
+}
+
+func (t *takenStore) Save(s shipper.Shipment) error {
+	if s.Version == 0 && len(t.taken) > 0 {
+		taken := t.taken[0]
+		t.taken = t.taken[1:]
+		if taken {
+			return store.ErrConflict
+		}
+	}
+	return t.Store.Save(s)
+}
+
+func TestShipAndStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		taken   []bool
+		wantErr bool
+	}{
+		{name: "free"},
+		{name: "second part taken", taken: []bool{false, true}},
+		{name: "always taken", taken: []bool{true, true, true, true, true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &takenStore{Store: memory.New(), taken: tt.taken}
+
+			r := RequestData{Items: []shipper.LineItem{{ID: "mat-1", Quantity: 1}, {ID: "rack-1", Quantity: 1}}}
+			r.OrderID = "order-1"
+			r.Delivery = "UPS"
+
+			parts, _, err := New(nil, "test", nil).ShipAndStore(context.Background(), st, r, correlation.IDs{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			list, err := st.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stored, shipped []string
+			for _, s := range list {
+				stored = append(stored, s.TrackingNumber)
+			}
+			for _, s := range parts {
+				shipped = append(shipped, s.TrackingNumber)
+			}
+			sort.Strings(stored)
+			sort.Strings(shipped)
+			if !reflect.DeepEqual(stored, shipped) {
+				t.Errorf("got stored shipments %v, want only the shipped parts %v", stored, shipped)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 
+	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/dynamodb"
 	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/iam"
 	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/lambda"
 	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/sqs"
@@ -125,12 +126,32 @@ func main() {
 			return err
 		}
 
+		// Create the DynamoDB table that keeps track of the shipments, so every instance of
+		// the function sees the shipments the others created
+		tableName := fmt.Sprintf("%s-acmeserverless-dynamodb-shipment", ctx.Stack())
+		_, err = dynamodb.NewTable(ctx, tableName, &dynamodb.TableArgs{
+			Name:        pulumi.String(tableName),
+			BillingMode: pulumi.String("PAY_PER_REQUEST"),
+			HashKey:     pulumi.String("trackingNumber"),
+			Attributes: dynamodb.TableAttributeArray{
+				dynamodb.TableAttributeArgs{
+					Name: pulumi.String("trackingNumber"),
+					Type: pulumi.String("S"),
+				},
+			},
+			Tags: pulumi.Map(tagMap),
+		})
+		if err != nil {
+			return err
+		}
+
 		// Create a factory to get policies from
 		iamFactory := sampolicies.NewFactory().WithAccountID(genericConfig.AccountID).WithPartition("aws").WithRegion(genericConfig.Region)
 
 		// Add a policy document to allow the function to use SQS as event source
 		iamFactory.AddSQSSendMessagePolicy(responseQueue.Name)
 		iamFactory.AddSQSPollerPolicy(requestQueue.Name)
+		iamFactory.AddDynamoDBCrudPolicy(tableName)
 		policies, err := iamFactory.GetPolicyStatement()
 		if err != nil {
 			return err
@@ -153,6 +174,8 @@ func main() {
 		variables["VERSION"] = tags.Version
 		variables["STAGE"] = pulumi.String(ctx.Stack())
 		variables["RESPONSEQUEUE"] = pulumi.String(responseQueue.Arn)
+		variables["STORE"] = pulumi.String("dynamodb")
+		variables["STORE_TABLE"] = pulumi.String(tableName)
 		variables["WAVEFRONT_URL"] = pulumi.String(genericConfig.WavefrontURL)
 		variables["WAVEFRONT_API_TOKEN"] = pulumi.String(genericConfig.WavefrontToken)
 
@@ -179,6 +202,9 @@ func main() {
 			return err
 		}
 
+		// The function reports the messages that failed as batch item failures, but this
+		// version of the SDK can't enable ReportBatchItemFailures on the mapping, so every
+		// batch has a single message and a failure doesn't return any other message
 		_, err = lambda.NewEventSourceMapping(ctx, fmt.Sprintf("%s-lambda-shipment", ctx.Stack()), &lambda.EventSourceMappingArgs{
 			BatchSize:      pulumi.Int(1),
 			Enabled:        pulumi.Bool(true),